@@ -0,0 +1,25 @@
+module github.com/esrrhs/go-engine
+
+go 1.25.0
+
+require (
+	github.com/OneOfOne/xxhash v1.2.8
+	github.com/PuerkitoBio/goquery v1.13.0
+	github.com/axgle/mahonia v0.0.0-20180208002826-3358181d7394
+	github.com/go-sql-driver/mysql v1.10.1
+	github.com/golang/protobuf v1.5.4
+	github.com/google/uuid v1.6.0
+	github.com/mattn/go-sqlite3 v1.14.52
+	github.com/oschwald/geoip2-golang v1.13.0
+	github.com/shiyanhui/dht v0.0.0-20201219151056-5a20f3199263
+	golang.org/x/net v0.58.0
+	golang.org/x/sys v0.47.0
+	golang.org/x/text v0.41.0
+)
+
+require (
+	filippo.io/edwards25519 v1.2.0 // indirect
+	github.com/andybalholm/cascadia v1.3.4 // indirect
+	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
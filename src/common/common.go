@@ -62,7 +62,6 @@ func ini() {
 
 	if len(dir) <= 0 {
 		panic("need install go-engine in GOPATH " + GOPATH)
-		return
 	}
 
 	gEngineDir = dir
@@ -75,7 +74,6 @@ func ini() {
 	gNodeDir = filepath.ToSlash(gNodeDir)
 	if _, err := os.Stat(gNodeDir); os.IsNotExist(err) {
 		panic("need install node in go-engine " + gNodeDir)
-		return
 	}
 	loggo.Info("gNodeDir %v", gNodeDir)
 
@@ -84,7 +82,6 @@ func ini() {
 	gDataDir = filepath.ToSlash(gDataDir)
 	if _, err := os.Stat(gDataDir); os.IsNotExist(err) {
 		panic("need install data in go-engine " + gDataDir)
-		return
 	}
 	loggo.Info("gDataDir %v", gDataDir)
 
@@ -93,7 +90,6 @@ func ini() {
 	gSrcDir = filepath.ToSlash(gSrcDir)
 	if _, err := os.Stat(gSrcDir); os.IsNotExist(err) {
 		panic("need install src in go-engine " + gSrcDir)
-		return
 	}
 	loggo.Info("gSrcDir %v", gSrcDir)
 
@@ -162,7 +158,6 @@ func extractFile(file string) {
 		err = decompress(file + ".zip")
 		if err != nil {
 			panic("extractFile file fail " + file)
-			return
 		}
 	}
 	loggo.Info("extractFile %v", file)
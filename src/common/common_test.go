@@ -236,3 +236,24 @@ func Test0013(t *testing.T) {
 		return nil
 	})
 }
+
+func Test0014(t *testing.T) {
+	r := NewRegistry[int]("num")
+	if r.Register("A", 1) != nil || r.Register("b", 2) != nil {
+		t.Error("register fail")
+	}
+	err := r.Register("a", 3)
+	fmt.Println(err)
+	if err == nil || r.Register("", 4) == nil {
+		t.Error("register registered or empty should fail")
+	}
+	v, err := r.Get("a")
+	if err != nil || v != 1 || !r.Has("B") || r.Has("c") {
+		t.Error("get fail", v, err)
+	}
+	_, err = r.Get("c")
+	fmt.Println(err)
+	if err == nil || fmt.Sprint(r.Names()) != "[a b]" {
+		t.Error("names fail", r.Names())
+	}
+}
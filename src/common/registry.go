@@ -0,0 +1,70 @@
+package common
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry is the name to factory map of a plugin kind, the name is case insensitive
+type Registry[T any] struct {
+	kind string
+	lock sync.RWMutex
+	m    map[string]T
+}
+
+// NewRegistry the kind is only used in the error messages, such as "proto" or "obfs"
+func NewRegistry[T any](kind string) *Registry[T] {
+	return &Registry[T]{kind: kind, m: make(map[string]T)}
+}
+
+// Register fail for the empty name or the registered name, the registered one is never replaced
+func (r *Registry[T]) Register(name string, v T) error {
+	name = strings.ToLower(name)
+	if name == "" {
+		return errors.New("empty " + r.kind)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, ok := r.m[name]
+	if ok {
+		return errors.New(r.kind + " already registered " + name)
+	}
+	r.m[name] = v
+	return nil
+}
+
+// Get return error for the name not registered
+func (r *Registry[T]) Get(name string) (T, error) {
+	name = strings.ToLower(name)
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	v, ok := r.m[name]
+	if !ok {
+		return v, errors.New("undefined " + r.kind + " " + name)
+	}
+	return v, nil
+}
+
+func (r *Registry[T]) Has(name string) bool {
+	_, err := r.Get(name)
+	return err == nil
+}
+
+// Names return the registered names in order
+func (r *Registry[T]) Names() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	ret := make([]string, 0, len(r.m))
+	for name := range r.m {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -1,25 +1,26 @@
 package common
 
 import (
+	"sync/atomic"
 	"time"
 )
 
-var gnowsecond time.Time
+var gnowsecond int64
 
 func init() {
-	gnowsecond = time.Now()
+	atomic.StoreInt64(&gnowsecond, time.Now().UnixNano())
 	go updateNowInSecond()
 }
 
 func GetNowUpdateInSecond() time.Time {
-	return gnowsecond
+	return time.Unix(0, atomic.LoadInt64(&gnowsecond))
 }
 
 func updateNowInSecond() {
 	defer CrashLog()
 
 	for {
-		gnowsecond = time.Now()
+		atomic.StoreInt64(&gnowsecond, time.Now().UnixNano())
 		time.Sleep(time.Second)
 	}
 }
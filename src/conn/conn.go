@@ -2,8 +2,8 @@ package conn
 
 import (
 	"errors"
+	"github.com/esrrhs/go-engine/src/common"
 	"io"
-	"strings"
 )
 
 type Conn interface {
@@ -19,14 +19,39 @@ type Conn interface {
 	Accept() (Conn, error)
 }
 
+type ConnFactory func() Conn
+
+var gConnFactory = common.NewRegistry[ConnFactory]("proto")
+
+func init() {
+	Register("tcp", func() Conn { return &tcpConn{} })
+	Register("udp", func() Conn { return &udpConn{} })
+	Register("rudp", func() Conn { return &rudpConn{} })
+	Register("ricmp", func() Conn { return &ricmpConn{} })
+	Register("sim", func() Conn { return newSimConn() })
+}
+
+// Register add a transport, the proxy and the other users pick it by the proto name in NewConn.
+// the builtin protos can not be replaced
+func Register(proto string, factory ConnFactory) error {
+	if factory == nil {
+		return errors.New("nil conn factory " + proto)
+	}
+	return gConnFactory.Register(proto, factory)
+}
+
+func Protocols() []string {
+	return gConnFactory.Names()
+}
+
+func HasProtocol(proto string) bool {
+	return gConnFactory.Has(proto)
+}
+
 func NewConn(proto string) (Conn, error) {
-	proto = strings.ToLower(proto)
-	if proto == "tcp" {
-		return &tcpConn{}, nil
-	} else if proto == "udp" {
-		return &udpConn{}, nil
-	} else if proto == "rudp" {
-		return &rudpConn{}, nil
+	factory, err := gConnFactory.Get(proto)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("undefined proto " + proto)
+	return factory(), nil
 }
@@ -0,0 +1,42 @@
+package conn
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test0001Register(t *testing.T) {
+	fmt.Println(Protocols())
+	for _, proto := range []string{"tcp", "udp", "rudp", "ricmp", "sim"} {
+		c, err := NewConn(proto)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if c.Name() != proto {
+			t.Error("conn name diff", proto, c.Name())
+		}
+	}
+
+	err := Register("RUDP", func() Conn { return &rudpConn{} })
+	if err == nil {
+		t.Error("register same proto should fail")
+	}
+
+	err = Register("myudp", func() Conn { return &udpConn{} })
+	if err != nil {
+		t.Error(err)
+	}
+	if !HasProtocol("MYUDP") {
+		t.Error("register proto fail")
+	}
+	c, err := NewConn("myudp")
+	if err != nil || c == nil {
+		t.Error("NewConn registered proto fail", err)
+	}
+
+	_, err = NewConn("nothing")
+	if err == nil {
+		t.Error("NewConn undefined proto should fail")
+	}
+}
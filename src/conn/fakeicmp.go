@@ -0,0 +1,193 @@
+package conn
+
+import (
+	"errors"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// FakeIcmpNet is an in memory icmp network, used to run the icmp protos without root.
+// Like a raw socket, every socket opened on a host gets all the icmp packets sent to the host ip,
+// and the host answers the echo requests itself as the kernel does.
+type FakeIcmpNet struct {
+	LossRate float64
+	lock     sync.Mutex
+	hosts    map[string]map[*fakeIcmpConn]int
+	rand     *rand.Rand
+}
+
+type fakeIcmpConn struct {
+	net      *FakeIcmpNet
+	addr     *net.IPAddr
+	recv     chan *fakeIcmpPacket
+	die      chan int
+	deadline time.Time
+	lock     sync.Mutex
+	closed   bool
+}
+
+type fakeIcmpPacket struct {
+	src  *net.IPAddr
+	data []byte
+}
+
+type fakeIcmpTimeout struct{}
+
+func (e *fakeIcmpTimeout) Error() string   { return "i/o timeout" }
+func (e *fakeIcmpTimeout) Timeout() bool   { return true }
+func (e *fakeIcmpTimeout) Temporary() bool { return true }
+
+func NewFakeIcmpNet(lossrate float64) *FakeIcmpNet {
+	return &FakeIcmpNet{
+		LossRate: lossrate,
+		hosts:    make(map[string]map[*fakeIcmpConn]int),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Host return the ListenPacket func opening sockets on the ip, set it to RicmpConfig.ListenPacket
+func (n *FakeIcmpNet) Host(ip string) func() (net.PacketConn, error) {
+	return func() (net.PacketConn, error) {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return nil, errors.New("invalid ip " + ip)
+		}
+		c := &fakeIcmpConn{
+			net:  n,
+			addr: &net.IPAddr{IP: addr},
+			recv: make(chan *fakeIcmpPacket, 1024),
+			die:  make(chan int),
+		}
+
+		n.lock.Lock()
+		defer n.lock.Unlock()
+		if n.hosts[ip] == nil {
+			n.hosts[ip] = make(map[*fakeIcmpConn]int)
+		}
+		n.hosts[ip][c]++
+		return c, nil
+	}
+}
+
+func (n *FakeIcmpNet) send(src *net.IPAddr, dst *net.IPAddr, b []byte) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.LossRate > 0 && n.rand.Float64() < n.LossRate {
+		return
+	}
+
+	host, ok := n.hosts[dst.String()]
+	if !ok {
+		return
+	}
+
+	for c := range host {
+		p := &fakeIcmpPacket{src: src, data: make([]byte, len(b))}
+		copy(p.data, b)
+		select {
+		case c.recv <- p:
+		default:
+		}
+	}
+
+	// the kernel reply
+	msg, err := icmp.ParseMessage(1, b)
+	if err != nil || msg.Type != ipv4.ICMPTypeEcho {
+		return
+	}
+	msg.Type = ipv4.ICMPTypeEchoReply
+	rb, err := msg.Marshal(nil)
+	if err != nil {
+		return
+	}
+	host, ok = n.hosts[src.String()]
+	if !ok {
+		return
+	}
+	for c := range host {
+		select {
+		case c.recv <- &fakeIcmpPacket{src: dst, data: rb}:
+		default:
+		}
+	}
+}
+
+func (c *fakeIcmpConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.lock.Lock()
+	deadline := c.deadline
+	c.lock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := deadline.Sub(time.Now())
+		if d <= 0 {
+			return 0, nil, &fakeIcmpTimeout{}
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p := <-c.recv:
+		return copy(b, p.data), p.src, nil
+	case <-c.die:
+		return 0, nil, errors.New("read closed socket")
+	case <-timeout:
+		return 0, nil, &fakeIcmpTimeout{}
+	}
+}
+
+func (c *fakeIcmpConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	dst, ok := addr.(*net.IPAddr)
+	if !ok {
+		return 0, errors.New("not ip addr " + addr.String())
+	}
+	c.lock.Lock()
+	closed := c.closed
+	c.lock.Unlock()
+	if closed {
+		return 0, errors.New("write closed socket")
+	}
+	c.net.send(c.addr, dst, b)
+	return len(b), nil
+}
+
+func (c *fakeIcmpConn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.die)
+
+	c.net.lock.Lock()
+	defer c.net.lock.Unlock()
+	delete(c.net.hosts[c.addr.String()], c)
+	return nil
+}
+
+func (c *fakeIcmpConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *fakeIcmpConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *fakeIcmpConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *fakeIcmpConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,238 @@
+package conn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/esrrhs/go-engine/src/common"
+	mrand "math/rand"
+	"time"
+)
+
+const (
+	OBFS_DEFAULT = "default"
+
+	OBFS_MIMIC_LINUX   = "linux"   // the 56 bytes of iputils ping, timeval then 0x10 0x11 ... 0x37
+	OBFS_MIMIC_WINDOWS = "windows" // the 32 bytes of windows ping, abcdefghijklmnopqrstuvwabcdefghi
+)
+
+// ObfsConfig makes the rudp and ricmp packets not look like protobuf on the wire, both sides must be the same
+type ObfsConfig struct {
+	Type       string // registered by RegisterObfs, empty for OBFS_DEFAULT
+	Key        string // the magic and the scramble derive from it
+	MaxPadding int    // add 0 ~ MaxPadding random bytes to each packet
+	ShapeSize  int    // pad the packet size up to a multiple of ShapeSize, 0 for no shaping
+	RotateSec  int    // the magic changes every RotateSec seconds
+	Mimic      string // OBFS_MIMIC_LINUX, OBFS_MIMIC_WINDOWS or empty
+}
+
+func DefaultObfsConfig() *ObfsConfig {
+	return &ObfsConfig{
+		Type:       OBFS_DEFAULT,
+		MaxPadding: 64,
+		ShapeSize:  0,
+		RotateSec:  60,
+	}
+}
+
+type Obfuscator interface {
+	Obfuscate(data []byte) []byte
+	// Deobfuscate return error for the packets not obfuscated by the same config
+	Deobfuscate(data []byte) ([]byte, error)
+}
+
+type ObfsFactory func(config *ObfsConfig) (Obfuscator, error)
+
+var gObfsFactory = common.NewRegistry[ObfsFactory]("obfs")
+
+func init() {
+	RegisterObfs(OBFS_DEFAULT, newDefaultObfs)
+}
+
+// RegisterObfs add a packet shape for the rudp and ricmp, ObfsConfig.Type picks it.
+// the factory gets the whole ObfsConfig, the fields it does not know can be ignored
+func RegisterObfs(name string, factory ObfsFactory) error {
+	if factory == nil {
+		return errors.New("nil obfs factory " + name)
+	}
+	return gObfsFactory.Register(name, factory)
+}
+
+// NewObfs return nil for nil config
+func NewObfs(config *ObfsConfig) (Obfuscator, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	name := config.Type
+	if name == "" {
+		name = OBFS_DEFAULT
+	}
+	factory, err := gObfsFactory.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return factory(config)
+}
+
+// the packet is
+// [mimic head] [magic 4] [nonce 4] scrambled{[len 2] [data]} [padding]
+// the mimic head is the whole payload of the ping, the padding goes on with its pattern
+type defaultObfs struct {
+	config *ObfsConfig
+	key    []byte
+	block  cipher.Block
+	now    func() time.Time
+}
+
+const (
+	obfsMagicLen = 4
+	obfsNonceLen = 4
+	obfsLenLen   = 2
+
+	obfsLinuxHeadLen    = 56 // the default datalen of iputils ping
+	obfsLinuxTimevalLen = 16
+)
+
+var obfsWindowsPattern = []byte("abcdefghijklmnopqrstuvwabcdefghi")
+
+func newDefaultObfs(config *ObfsConfig) (Obfuscator, error) {
+	switch config.Mimic {
+	case "", OBFS_MIMIC_LINUX, OBFS_MIMIC_WINDOWS:
+	default:
+		return nil, errors.New("no mimic " + config.Mimic)
+	}
+	if config.MaxPadding < 0 || config.ShapeSize < 0 {
+		return nil, errors.New("invalid padding or shape size")
+	}
+
+	key := sha256.Sum256([]byte("obfs key " + config.Key))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return &defaultObfs{config: config, key: key[:], block: block, now: time.Now}, nil
+}
+
+func (o *defaultObfs) headLen() int {
+	switch o.config.Mimic {
+	case OBFS_MIMIC_LINUX:
+		return obfsLinuxHeadLen
+	case OBFS_MIMIC_WINDOWS:
+		return len(obfsWindowsPattern)
+	}
+	return 0
+}
+
+func (o *defaultObfs) magic(window int64) []byte {
+	mac := hmac.New(sha256.New, o.key)
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(window))
+	mac.Write(b)
+	return mac.Sum(nil)[:obfsMagicLen]
+}
+
+func (o *defaultObfs) window() int64 {
+	if o.config.RotateSec <= 0 {
+		return 0
+	}
+	return o.now().Unix() / int64(o.config.RotateSec)
+}
+
+func (o *defaultObfs) stream(nonce []byte) cipher.Stream {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, nonce)
+	return cipher.NewCTR(o.block, iv)
+}
+
+func (o *defaultObfs) Obfuscate(data []byte) []byte {
+	head := o.headLen()
+	size := head + obfsMagicLen + obfsNonceLen + obfsLenLen + len(data)
+	total := size
+	if o.config.MaxPadding > 0 {
+		total += mrand.Intn(o.config.MaxPadding + 1)
+	}
+	if o.config.ShapeSize > 0 && total%o.config.ShapeSize != 0 {
+		total += o.config.ShapeSize - total%o.config.ShapeSize
+	}
+
+	ret := make([]byte, total)
+	if o.config.Mimic == OBFS_MIMIC_LINUX {
+		now := o.now()
+		binary.LittleEndian.PutUint64(ret, uint64(now.Unix()))
+		binary.LittleEndian.PutUint64(ret[8:], uint64(now.Nanosecond()/1000))
+	}
+	o.fillPattern(ret, 0, head)
+
+	cur := head
+	copy(ret[cur:], o.magic(o.window()))
+	cur += obfsMagicLen
+	nonce := ret[cur : cur+obfsNonceLen]
+	rand.Read(nonce)
+	cur += obfsNonceLen
+	binary.BigEndian.PutUint16(ret[cur:], uint16(len(data)))
+	copy(ret[cur+obfsLenLen:], data)
+	o.stream(nonce).XORKeyStream(ret[cur:size], ret[cur:size])
+
+	if o.config.Mimic == "" {
+		rand.Read(ret[size:])
+	} else {
+		o.fillPattern(ret, size, total)
+	}
+	return ret
+}
+
+// fillPattern fill b[begin:end] with the ping pattern at the offsets, the linux timeval is not touched
+func (o *defaultObfs) fillPattern(b []byte, begin int, end int) {
+	switch o.config.Mimic {
+	case OBFS_MIMIC_LINUX:
+		for i := common.MaxOfInt(begin, obfsLinuxTimevalLen); i < end; i++ {
+			b[i] = byte(i)
+		}
+	case OBFS_MIMIC_WINDOWS:
+		for i := begin; i < end; i++ {
+			b[i] = obfsWindowsPattern[i%len(obfsWindowsPattern)]
+		}
+	}
+}
+
+func (o *defaultObfs) Deobfuscate(data []byte) ([]byte, error) {
+	cur := o.headLen()
+	if len(data) < cur+obfsMagicLen+obfsNonceLen+obfsLenLen {
+		return nil, errors.New("obfs packet too short")
+	}
+
+	magic := data[cur : cur+obfsMagicLen]
+	window := o.window()
+	ok := false
+	// the clocks of the two sides may differ a little
+	for _, w := range []int64{window, window - 1, window + 1} {
+		if hmac.Equal(magic, o.magic(w)) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return nil, errors.New("obfs magic not match")
+	}
+	cur += obfsMagicLen
+
+	stream := o.stream(data[cur : cur+obfsNonceLen])
+	cur += obfsNonceLen
+
+	lenb := make([]byte, obfsLenLen)
+	stream.XORKeyStream(lenb, data[cur:cur+obfsLenLen])
+	cur += obfsLenLen
+	n := int(binary.BigEndian.Uint16(lenb))
+	if cur+n > len(data) {
+		return nil, errors.New("obfs packet len invalid")
+	}
+
+	ret := make([]byte, n)
+	stream.XORKeyStream(ret, data[cur:cur+n])
+	return ret, nil
+}
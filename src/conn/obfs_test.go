@@ -0,0 +1,200 @@
+package conn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func Test0001Obfs(t *testing.T) {
+	data := make([]byte, 500)
+	rand.Read(data)
+
+	for _, mimic := range []string{"", OBFS_MIMIC_LINUX, OBFS_MIMIC_WINDOWS} {
+		config := DefaultObfsConfig()
+		config.Key = "123"
+		config.ShapeSize = 128
+		config.Mimic = mimic
+		o, err := NewObfs(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100; i++ {
+			ob := o.Obfuscate(data[:i*5])
+			if len(ob)%128 != 0 {
+				t.Error("not shaped", mimic, len(ob))
+			}
+			if i > 10 && bytes.Contains(ob, data[:i*5]) {
+				t.Error("not scrambled", mimic)
+			}
+			d, err := o.Deobfuscate(ob)
+			if err != nil || !bytes.Equal(d, data[:i*5]) {
+				t.Fatal("roundtrip fail", mimic, i, err)
+			}
+		}
+
+		ob := o.Obfuscate([]byte("hello"))
+		fmt.Println(mimic, ob)
+		switch mimic {
+		case OBFS_MIMIC_LINUX:
+			// the whole 56 bytes of iputils ping
+			sec := int64(binary.LittleEndian.Uint64(ob))
+			if time.Now().Unix()-sec > 1 || ob[len(ob)-1] != byte(len(ob)-1) {
+				t.Error("not like linux ping", ob)
+			}
+			for i := 16; i < 56; i++ {
+				if ob[i] != byte(i) {
+					t.Error("not like linux ping pattern", i, ob[i])
+				}
+			}
+		case OBFS_MIMIC_WINDOWS:
+			if !bytes.Equal(ob[:32], []byte("abcdefghijklmnopqrstuvwabcdefghi")) {
+				t.Error("not like windows ping", ob)
+			}
+		}
+	}
+}
+
+func Test0002Obfs(t *testing.T) {
+	config := DefaultObfsConfig()
+	config.Key = "123"
+	o, _ := NewObfs(config)
+
+	other := DefaultObfsConfig()
+	other.Key = "456"
+	o2, _ := NewObfs(other)
+
+	ob := o.Obfuscate([]byte("hello"))
+	_, err := o2.Deobfuscate(ob)
+	fmt.Println(err)
+	if err == nil {
+		t.Error("other key should fail")
+	}
+	_, err = o.Deobfuscate([]byte("hello"))
+	if err == nil {
+		t.Error("plain should fail")
+	}
+
+	// the magic rotates, the next window is still ok but not the far one
+	now := time.Now()
+	do := o.(*defaultObfs)
+	m1 := do.magic(do.window())
+	do.now = func() time.Time { return now.Add(time.Second * time.Duration(config.RotateSec)) }
+	m2 := do.magic(do.window())
+	if bytes.Equal(m1, m2) {
+		t.Error("magic not rotate")
+	}
+	_, err = o.Deobfuscate(ob)
+	if err != nil {
+		t.Error("next window fail", err)
+	}
+	do.now = func() time.Time { return now.Add(time.Second * time.Duration(config.RotateSec*3)) }
+	_, err = o.Deobfuscate(ob)
+	if err == nil {
+		t.Error("far window should fail")
+	}
+}
+
+type testObfs struct{}
+
+func (o *testObfs) Obfuscate(data []byte) []byte {
+	return append([]byte{'x'}, data...)
+}
+
+func (o *testObfs) Deobfuscate(data []byte) ([]byte, error) {
+	if len(data) <= 0 || data[0] != 'x' {
+		return nil, fmt.Errorf("not x")
+	}
+	return data[1:], nil
+}
+
+func Test0003Obfs(t *testing.T) {
+	err := RegisterObfs("test-x", func(config *ObfsConfig) (Obfuscator, error) {
+		return &testObfs{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if RegisterObfs("TEST-X", nil) == nil || RegisterObfs(OBFS_DEFAULT, newDefaultObfs) == nil {
+		t.Error("register again should fail")
+	}
+
+	o, err := NewObfs(&ObfsConfig{Type: "Test-X"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(o.Obfuscate([]byte("a"))) != "xa" {
+		t.Error("custom obfs fail")
+	}
+
+	_, err = NewObfs(&ObfsConfig{Type: "no-such"})
+	if err == nil {
+		t.Error("undefined obfs should fail")
+	}
+	o, err = NewObfs(nil)
+	if o != nil || err != nil {
+		t.Error("nil config should be no obfs")
+	}
+}
+
+func Test0004Obfs(t *testing.T) {
+	obfs := DefaultObfsConfig()
+	obfs.Key = "123"
+	obfs.Mimic = OBFS_MIMIC_LINUX
+
+	config := DefaultRudpConfig()
+	config.Obfs = obfs
+	config.ConnectTimeoutMs = 1000
+
+	c := &rudpConn{}
+	c.SetConfig(config)
+	cc, err := c.Listen("127.0.0.1:58100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	go func() {
+		for {
+			cc, err := cc.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer cc.Close()
+				io.Copy(cc, cc)
+			}()
+		}
+	}()
+
+	ccc, err := c.Dial("127.0.0.1:58100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ccc.Close()
+
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+	go ccc.Write(data)
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(ccc, buf)
+	if err != nil || !bytes.Equal(buf, data) {
+		t.Error("rudp obfs echo fail", err)
+	}
+
+	// the plain one can not talk to it
+	plain := &rudpConn{}
+	plainconfig := DefaultRudpConfig()
+	plainconfig.ConnectTimeoutMs = 1000
+	plain.SetConfig(plainconfig)
+	_, err = plain.Dial("127.0.0.1:58100")
+	fmt.Println(err)
+	if err == nil {
+		t.Error("plain dial should fail")
+	}
+}
@@ -0,0 +1,83 @@
+package conn
+
+import (
+	"golang.org/x/net/icmp"
+	"net"
+)
+
+// obfsIcmpConn obfuscates the echo data in the icmp packets, the others pass through
+type obfsIcmpConn struct {
+	net.PacketConn
+	obfs Obfuscator
+}
+
+func NewObfsIcmpConn(c net.PacketConn, obfs Obfuscator) net.PacketConn {
+	return &obfsIcmpConn{PacketConn: c, obfs: obfs}
+}
+
+// ObfsListenPacket wrap the sockets opened by listenPacket, listenPacket nil for the raw icmp socket
+func ObfsListenPacket(listenPacket func() (net.PacketConn, error), obfs Obfuscator) func() (net.PacketConn, error) {
+	return func() (net.PacketConn, error) {
+		var c net.PacketConn
+		var err error
+		if listenPacket != nil {
+			c, err = listenPacket()
+		} else {
+			c, err = icmp.ListenPacket("ip4:icmp", "")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return NewObfsIcmpConn(c, obfs), nil
+	}
+}
+
+func (c *obfsIcmpConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	msg, err := icmp.ParseMessage(1, b)
+	if err != nil {
+		return c.PacketConn.WriteTo(b, addr)
+	}
+	echo, ok := msg.Body.(*icmp.Echo)
+	if !ok {
+		return c.PacketConn.WriteTo(b, addr)
+	}
+	echo.Data = c.obfs.Obfuscate(echo.Data)
+	ob, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.PacketConn.WriteTo(ob, addr)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *obfsIcmpConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(b)+1024)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return n, addr, err
+		}
+		msg, err := icmp.ParseMessage(1, buf[:n])
+		if err != nil {
+			return copy(b, buf[:n]), addr, nil
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok {
+			return copy(b, buf[:n]), addr, nil
+		}
+		data, err := c.obfs.Deobfuscate(echo.Data)
+		if err != nil {
+			// not ours, maybe a real ping
+			continue
+		}
+		echo.Data = data
+		ob, err := msg.Marshal(nil)
+		if err != nil {
+			continue
+		}
+		return copy(b, ob), addr, nil
+	}
+}
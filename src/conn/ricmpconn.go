@@ -1,6 +1,7 @@
 package conn
 
 import (
+	"encoding/binary"
 	"errors"
 	"github.com/esrrhs/go-engine/src/common"
 	"github.com/esrrhs/go-engine/src/frame"
@@ -8,11 +9,23 @@ import (
 	"github.com/esrrhs/go-engine/src/loggo"
 	"github.com/golang/protobuf/proto"
 	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"math"
+	"math/rand"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// the frames are carried in echo requests to the listener and echo replies back,
+	// the magic tells them from the kernel replies and other icmp packets
+	RICMP_MAGIC_REQUEST uint32 = 0x7269636d
+	RICMP_MAGIC_REPLY   uint32 = 0x5249434d
+)
+
 type RicmpConfig struct {
 	MaxPacketSize      int
 	CutSize            int
@@ -27,6 +40,10 @@ type RicmpConfig struct {
 	CloseTimeoutMs     int
 	CloseWaitTimeoutMs int
 	AcceptChanLen      int
+	Congestion         string
+	FastResend         int
+	ListenPacket       func() (net.PacketConn, error) // nil for the raw icmp socket, needs root
+	Obfs               *ObfsConfig                    // nil for no obfuscation
 }
 
 func DefaultRicmpConfig() *RicmpConfig {
@@ -44,6 +61,8 @@ func DefaultRicmpConfig() *RicmpConfig {
 		CloseTimeoutMs:     5000,
 		CloseWaitTimeoutMs: 5000,
 		AcceptChanLen:      128,
+		Congestion:         frame.CONGESTION_FIXED,
+		FastResend:         frame.FRAME_FAST_RESEND,
 	}
 }
 
@@ -53,26 +72,31 @@ type ricmpConn struct {
 	dialer        *ricmpConnDialer
 	listenersonny *ricmpConnListenerSonny
 	listener      *ricmpConnListener
-	isclose       bool
+	isclose       int32
+	closing       int32
 	closelock     sync.Mutex
 }
 
 type ricmpConnDialer struct {
 	serveraddr *net.IPAddr
-	conn       *icmp.PacketConn
+	conn       net.PacketConn
+	echoid     int
+	echoseq    int32
 	fm         *frame.FrameMgr
 	wg         *group.Group
 }
 
 type ricmpConnListenerSonny struct {
 	dstaddr    *net.IPAddr
-	fatherconn *icmp.PacketConn
+	fatherconn net.PacketConn
+	echoid     int
+	echoseq    int32
 	fm         *frame.FrameMgr
 	wg         *group.Group
 }
 
 type ricmpConnListener struct {
-	listenerconn *icmp.PacketConn
+	listenerconn net.PacketConn
 	wg           *group.Group
 	sonny        sync.Map
 	accept       *common.Channel
@@ -82,10 +106,14 @@ func (c *ricmpConn) Name() string {
 	return "ricmp"
 }
 
+func (c *ricmpConn) isClosed() bool {
+	return atomic.LoadInt32(&c.isclose) != 0
+}
+
 func (c *ricmpConn) Read(p []byte) (n int, err error) {
 	c.checkConfig()
 
-	if c.isclose {
+	if c.isClosed() {
 		return 0, errors.New("read closed conn")
 	}
 
@@ -107,7 +135,7 @@ func (c *ricmpConn) Read(p []byte) (n int, err error) {
 		return 0, errors.New("empty conn")
 	}
 
-	for !c.isclose {
+	for !c.isClosed() {
 		if fm.GetRecvBufferSize() <= 0 {
 			if wg != nil && wg.IsExit() {
 				return 0, errors.New("closed conn")
@@ -127,7 +155,7 @@ func (c *ricmpConn) Read(p []byte) (n int, err error) {
 func (c *ricmpConn) Write(p []byte) (n int, err error) {
 	c.checkConfig()
 
-	if c.isclose {
+	if c.isClosed() {
 		return 0, errors.New("write closed conn")
 	}
 
@@ -152,7 +180,7 @@ func (c *ricmpConn) Write(p []byte) (n int, err error) {
 	totalsize := len(p)
 	cur := 0
 
-	for !c.isclose {
+	for !c.isClosed() {
 		size := totalsize - cur
 		svleft := fm.GetSendBufferLeft()
 		if size > svleft {
@@ -183,20 +211,23 @@ func (c *ricmpConn) Write(p []byte) (n int, err error) {
 func (c *ricmpConn) Close() error {
 	c.checkConfig()
 
-	if c.isclose {
+	if c.isClosed() {
 		return nil
 	}
 
 	c.closelock.Lock()
 	defer c.closelock.Unlock()
 
+	if c.isClosed() {
+		return nil
+	}
+
 	loggo.Debug("start Close %s", c.Info())
 
 	if c.dialer != nil {
 		if c.dialer.wg != nil {
 			loggo.Debug("start Close dialer %s", c.Info())
-			c.dialer.wg.Stop()
-			c.dialer.wg.Wait()
+			c.closeGraceful(c.dialer.wg)
 		}
 		if c.dialer.conn != nil {
 			c.dialer.conn.Close()
@@ -205,6 +236,7 @@ func (c *ricmpConn) Close() error {
 		if c.listener.wg != nil {
 			loggo.Debug("start Close listener %s", c.Info())
 			c.listener.wg.Stop()
+			c.listener.accept.Close()
 			c.listener.sonny.Range(func(key, value interface{}) bool {
 				u := value.(*ricmpConn)
 				u.Close()
@@ -218,17 +250,27 @@ func (c *ricmpConn) Close() error {
 	} else if c.listenersonny != nil {
 		if c.listenersonny.wg != nil {
 			loggo.Debug("start Close listenersonny %s", c.Info())
-			c.listenersonny.wg.Stop()
-			c.listenersonny.wg.Wait()
+			c.closeGraceful(c.listenersonny.wg)
 		}
 	}
-	c.isclose = true
+	atomic.StoreInt32(&c.isclose, 1)
 
 	loggo.Debug("Close ok %s", c.Info())
 
 	return nil
 }
 
+// closeGraceful let the update loop send the left data and the close frame, then stop
+func (c *ricmpConn) closeGraceful(wg *group.Group) {
+	atomic.StoreInt32(&c.closing, 1)
+	select {
+	case <-wg.Done():
+	case <-time.After(time.Millisecond * time.Duration(c.config.CloseTimeoutMs+c.config.CloseWaitTimeoutMs)):
+	}
+	wg.Stop()
+	wg.Wait()
+}
+
 func (c *ricmpConn) Info() string {
 	c.checkConfig()
 
@@ -255,7 +297,7 @@ func (c *ricmpConn) Dial(dst string) (Conn, error) {
 		return nil, err
 	}
 
-	conn, err := icmp.ListenPacket("ip4:icmp", "")
+	conn, err := c.listenPacket()
 	if err != nil {
 		return nil, err
 	}
@@ -263,8 +305,14 @@ func (c *ricmpConn) Dial(dst string) (Conn, error) {
 	id := common.Guid()
 	fm := frame.NewFrameMgr(c.config.CutSize, c.config.MaxId, c.config.BufferSize, c.config.MaxWin, c.config.ResendTimems, c.config.Compress, c.config.Stat)
 	fm.SetDebugid(id)
+	fm.SetFastResend(c.config.FastResend)
+	err = fm.SetCongestion(c.config.Congestion)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
 
-	dialer := &ricmpConnDialer{serveraddr: addr, conn: conn, fm: fm}
+	dialer := &ricmpConnDialer{serveraddr: addr, conn: conn, echoid: rand.Intn(math.MaxUint16), fm: fm}
 
 	u := &ricmpConn{config: c.config, dialer: dialer}
 
@@ -286,13 +334,13 @@ func (c *ricmpConn) Dial(dst string) (Conn, error) {
 		for e := sendlist.Front(); e != nil; e = e.Next() {
 			f := e.Value.(*frame.Frame)
 			mb, _ := u.dialer.fm.MarshalFrame(f)
-			u.dialer.conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+			u.dialer.conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
 			u.send_icmp(u.dialer.conn, mb, u.dialer.serveraddr)
 		}
 
 		// recv udp
 		u.dialer.conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
-		n, _, _ := u.recv_icmp(u.dialer.conn, buf)
+		n, _, _, _, _ := u.recv_icmp(u.dialer.conn, buf)
 		if n > 0 {
 			f := &frame.Frame{}
 			err := proto.Unmarshal(buf[0:n], f)
@@ -304,7 +352,7 @@ func (c *ricmpConn) Dial(dst string) (Conn, error) {
 			}
 		}
 
-		if c.isclose {
+		if c.isClosed() {
 			loggo.Debug("can not connect remote ricmp %s", u.Info())
 			break
 		}
@@ -320,16 +368,17 @@ func (c *ricmpConn) Dial(dst string) (Conn, error) {
 		time.Sleep(time.Millisecond * 10)
 	}
 
-	if c.isclose {
+	if c.isClosed() {
 		u.Close()
 		return nil, errors.New("closed conn")
 	}
 
-	if u.isclose {
+	if u.isClosed() {
 		return nil, errors.New("closed conn")
 	}
 
 	if !u.dialer.fm.IsConnected() {
+		u.dialer.conn.Close()
 		return nil, errors.New("connect timeout")
 	}
 
@@ -349,7 +398,11 @@ func (c *ricmpConn) Dial(dst string) (Conn, error) {
 func (c *ricmpConn) Listen(dst string) (Conn, error) {
 	c.checkConfig()
 
-	conn, err := icmp.ListenPacket("ip4:icmp", "")
+	err := frame.CheckCongestion(c.config.Congestion)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.listenPacket()
 	if err != nil {
 		return nil, err
 	}
@@ -384,11 +437,11 @@ func (c *ricmpConn) Accept() (Conn, error) {
 			break
 		}
 		sonny := s.(*ricmpConn)
-		_, ok := c.listener.sonny.Load(sonny.listenersonny.dstaddr.String())
+		_, ok := c.listener.sonny.Load(sonny.listenersonny.key())
 		if !ok {
 			continue
 		}
-		if sonny.isclose {
+		if sonny.isClosed() {
 			continue
 		}
 		return sonny, nil
@@ -396,6 +449,10 @@ func (c *ricmpConn) Accept() (Conn, error) {
 	return nil, errors.New("listener close")
 }
 
+func (s *ricmpConnListenerSonny) key() string {
+	return s.dstaddr.String() + "-" + strconv.Itoa(s.echoid)
+}
+
 func (c *ricmpConn) checkConfig() {
 	if c.config == nil {
 		c.config = DefaultRicmpConfig()
@@ -406,28 +463,52 @@ func (c *ricmpConn) SetConfig(config *RicmpConfig) {
 	c.config = config
 }
 
+// NewRicmpConn return a ricmp conn with the config, to Dial or Listen
+func NewRicmpConn(config *RicmpConfig) Conn {
+	return &ricmpConn{config: config}
+}
+
+func (c *ricmpConn) listenPacket() (net.PacketConn, error) {
+	obfs, err := NewObfs(c.config.Obfs)
+	if err != nil {
+		return nil, err
+	}
+	if obfs != nil {
+		return ObfsListenPacket(c.config.ListenPacket, obfs)()
+	}
+	if c.config.ListenPacket != nil {
+		return c.config.ListenPacket()
+	}
+	return icmp.ListenPacket("ip4:icmp", "")
+}
+
 func (c *ricmpConn) loopListenerRecv() error {
 	c.checkConfig()
 
 	buf := make([]byte, c.config.MaxPacketSize)
 	for !c.listener.wg.IsExit() {
 		c.listener.listenerconn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
-		n, srcaddr, err := c.recv_icmp(c.listener.listenerconn, buf)
-		if err != nil {
+		n, srcaddr, echoid, echoseq, err := c.recv_icmp(c.listener.listenerconn, buf)
+		if err != nil || n <= 0 {
 			continue
 		}
 
-		srcaddrstr := srcaddr.String()
+		// many dialers may be behind one ip
+		srcaddrstr := srcaddr.String() + "-" + strconv.Itoa(echoid)
 
 		v, ok := c.listener.sonny.Load(srcaddrstr)
 		if !ok {
 			id := common.Guid()
 			fm := frame.NewFrameMgr(c.config.CutSize, c.config.MaxId, c.config.BufferSize, c.config.MaxWin, c.config.ResendTimems, c.config.Compress, c.config.Stat)
 			fm.SetDebugid(id)
+			fm.SetFastResend(c.config.FastResend)
+			// checked by Listen
+			fm.SetCongestion(c.config.Congestion)
 
 			sonny := &ricmpConnListenerSonny{
 				dstaddr:    srcaddr,
 				fatherconn: c.listener.listenerconn,
+				echoid:     echoid,
 				fm:         fm,
 			}
 
@@ -439,8 +520,12 @@ func (c *ricmpConn) loopListenerRecv() error {
 			})
 
 			loggo.Debug("start accept remote ricmp %s %s", u.Info(), id)
-		} else {
+			v = u
+		}
+
+		{
 			u := v.(*ricmpConn)
+			atomic.StoreInt32(&u.listenersonny.echoseq, int32(echoseq))
 
 			f := &frame.Frame{}
 			err := proto.Unmarshal(buf[0:n], f)
@@ -454,7 +539,7 @@ func (c *ricmpConn) loopListenerRecv() error {
 
 		c.listener.sonny.Range(func(key, value interface{}) bool {
 			u := value.(*ricmpConn)
-			if u.isclose {
+			if u.isClosed() {
 				c.listener.sonny.Delete(key)
 				loggo.Debug("delete sonny from map %s", u.Info())
 			}
@@ -514,8 +599,6 @@ func (c *ricmpConn) accept(u *ricmpConn) error {
 
 	loggo.Debug("server accept ricmp ok %s", u.Info())
 
-	c.listener.accept.Write(u)
-
 	wg := group.NewGroup("ricmpConn ListenerSonny"+" "+u.Info(), c.listener.wg, nil)
 
 	u.listenersonny.wg = wg
@@ -524,6 +607,9 @@ func (c *ricmpConn) accept(u *ricmpConn) error {
 		return u.updateListenerSonny()
 	})
 
+	// after the wg is set, the Read and Write use it
+	c.listener.accept.Write(u)
+
 	loggo.Debug("accept ricmp finish %s", u.Info())
 
 	return nil
@@ -537,41 +623,19 @@ func (c *ricmpConn) updateDialerSonny() error {
 	return c.update_ricmp(c.dialer.wg, c.dialer.fm, c.dialer.conn, c.dialer.serveraddr, true)
 }
 
-func (c *ricmpConn) update_ricmp(wg *group.Group, fm *frame.FrameMgr, conn *icmp.PacketConn, dstaddr *net.IPAddr, readconn bool) error {
+func (c *ricmpConn) update_ricmp(wg *group.Group, fm *frame.FrameMgr, conn net.PacketConn, dstaddr *net.IPAddr, readconn bool) error {
 
 	loggo.Debug("start ricmp conn %s", c.Info())
 
-	stage := "open"
-	wg.Go("ricmpConn update_ricmp send"+" "+c.Info(), func() error {
-		for !wg.IsExit() && stage != "closewait" {
-			// send udp
-			sendlist := fm.GetSendList()
-			if sendlist.Len() > 0 {
-				for e := sendlist.Front(); e != nil; e = e.Next() {
-					f := e.Value.(*frame.Frame)
-					mb, err := fm.MarshalFrame(f)
-					if err != nil {
-						loggo.Error("MarshalFrame fail %s", err)
-						return err
-					}
-					conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
-					c.send_icmp(conn, mb, dstaddr)
-					//loggo.Debug("%s send frame to %s %d", c.Info(), dstaddr, f.Id)
-				}
-			} else {
-				time.Sleep(time.Millisecond * 100)
-			}
-		}
-		return nil
-	})
+	var closewait int32
 
 	if readconn {
 		wg.Go("ricmpConn update_ricmp recv"+" "+c.Info(), func() error {
 			bytes := make([]byte, c.config.MaxPacketSize)
-			for !wg.IsExit() && stage != "closewait" {
-				// recv udp
+			for !wg.IsExit() && atomic.LoadInt32(&closewait) == 0 {
+				// recv icmp
 				conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
-				n, _, _ := c.recv_icmp(conn, bytes)
+				n, _, _, _, _ := c.recv_icmp(conn, bytes)
 				if n > 0 {
 					f := &frame.Frame{}
 					err := proto.Unmarshal(bytes[0:n], f)
@@ -588,27 +652,44 @@ func (c *ricmpConn) update_ricmp(wg *group.Group, fm *frame.FrameMgr, conn *icmp
 		})
 	}
 
-	for !wg.IsExit() {
+	reason := ""
+
+	for !wg.IsExit() && atomic.LoadInt32(&c.closing) == 0 {
 
 		avctive := fm.Update()
 
+		// send icmp
+		sendlist := fm.GetSendList()
+		for e := sendlist.Front(); e != nil; e = e.Next() {
+			f := e.Value.(*frame.Frame)
+			mb, err := fm.MarshalFrame(f)
+			if err != nil {
+				loggo.Error("MarshalFrame fail %s", err)
+				return err
+			}
+			conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
+			c.send_icmp(conn, mb, dstaddr)
+			//loggo.Debug("%s send frame to %s %d", c.Info(), dstaddr, f.Id)
+		}
+
 		// timeout
 		if fm.IsHBTimeout(c.config.HBTimeoutms) {
+			reason = "HBTimeout"
 			loggo.Debug("close inactive conn %s", c.Info())
 			break
 		}
 
 		if fm.IsRemoteClosed() {
+			reason = "RemoteClose"
 			loggo.Debug("closed by remote conn %s", c.Info())
 			break
 		}
 
-		if !avctive {
+		if !avctive && sendlist.Len() <= 0 {
 			time.Sleep(time.Millisecond * 10)
 		}
 	}
 
-	stage = "close"
 	fm.Close()
 	loggo.Debug("close ricmp conn fm %s", c.Info())
 
@@ -618,6 +699,20 @@ func (c *ricmpConn) update_ricmp(wg *group.Group, fm *frame.FrameMgr, conn *icmp
 
 		fm.Update()
 
+		// send icmp
+		sendlist := fm.GetSendList()
+		for e := sendlist.Front(); e != nil; e = e.Next() {
+			f := e.Value.(*frame.Frame)
+			mb, err := fm.MarshalFrame(f)
+			if err != nil {
+				loggo.Error("MarshalFrame fail %s", err)
+				return err
+			}
+			conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
+			c.send_icmp(conn, mb, dstaddr)
+			//loggo.Debug("%s send frame to %s %d", c.Info(), dstaddr, f.Id)
+		}
+
 		diffclose := now.Sub(startCloseTime)
 		if diffclose > time.Millisecond*time.Duration(c.config.CloseTimeoutMs) {
 			loggo.Debug("close conn had timeout %s", c.Info())
@@ -633,7 +728,7 @@ func (c *ricmpConn) update_ricmp(wg *group.Group, fm *frame.FrameMgr, conn *icmp
 		time.Sleep(time.Millisecond * 10)
 	}
 
-	stage = "closewait"
+	atomic.StoreInt32(&closewait, 1)
 	loggo.Debug("close ricmp conn update %s", c.Info())
 
 	startEndTime := time.Now()
@@ -656,14 +751,74 @@ func (c *ricmpConn) update_ricmp(wg *group.Group, fm *frame.FrameMgr, conn *icmp
 
 	loggo.Debug("close ricmp conn %s", c.Info())
 
-	return errors.New("closed")
+	return errors.New("closed " + reason)
 }
 
-func (c *ricmpConn) send_icmp(conn *icmp.PacketConn, data []byte, dst *net.IPAddr) {
-	// TODO
+func (c *ricmpConn) send_icmp(conn net.PacketConn, data []byte, dst *net.IPAddr) {
+	var typ ipv4.ICMPType
+	var magic uint32
+	var id, seq int
+	if c.dialer != nil {
+		typ, magic, id, seq = ipv4.ICMPTypeEcho, RICMP_MAGIC_REQUEST, c.dialer.echoid, int(atomic.AddInt32(&c.dialer.echoseq, 1))
+	} else {
+		typ, magic, id, seq = ipv4.ICMPTypeEchoReply, RICMP_MAGIC_REPLY, c.listenersonny.echoid, int(atomic.LoadInt32(&c.listenersonny.echoseq))
+	}
+
+	body := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(body, magic)
+	copy(body[4:], data)
+
+	msg := &icmp.Message{
+		Type: typ,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   id & 0xffff,
+			Seq:  seq & 0xffff,
+			Data: body,
+		},
+	}
+
+	bytes, err := msg.Marshal(nil)
+	if err != nil {
+		loggo.Error("send_icmp Marshal error %s %s", dst.String(), err)
+		return
+	}
+
+	conn.WriteTo(bytes, dst)
 }
 
-func (c *ricmpConn) recv_icmp(conn *icmp.PacketConn, data []byte) (int, *net.IPAddr, error) {
-	// TODO
-	return 0, nil, nil
+// recv_icmp read one frame sent by the other side into data, return 0 for the others
+func (c *ricmpConn) recv_icmp(conn net.PacketConn, data []byte) (int, *net.IPAddr, int, int, error) {
+	bytes := make([]byte, c.config.MaxPacketSize)
+	n, srcaddr, err := conn.ReadFrom(bytes)
+	if err != nil {
+		return 0, nil, 0, 0, err
+	}
+
+	msg, err := icmp.ParseMessage(1, bytes[:n])
+	if err != nil {
+		return 0, nil, 0, 0, nil
+	}
+	echo, ok := msg.Body.(*icmp.Echo)
+	if !ok || len(echo.Data) < 4 {
+		return 0, nil, 0, 0, nil
+	}
+
+	magic := binary.BigEndian.Uint32(echo.Data)
+	if c.dialer != nil {
+		if msg.Type != ipv4.ICMPTypeEchoReply || magic != RICMP_MAGIC_REPLY || echo.ID != c.dialer.echoid {
+			return 0, nil, 0, 0, nil
+		}
+	} else {
+		if msg.Type != ipv4.ICMPTypeEcho || magic != RICMP_MAGIC_REQUEST {
+			return 0, nil, 0, 0, nil
+		}
+	}
+
+	ipaddr, ok := srcaddr.(*net.IPAddr)
+	if !ok {
+		return 0, nil, 0, 0, nil
+	}
+
+	return copy(data, echo.Data[4:]), ipaddr, echo.ID, echo.Seq, nil
 }
@@ -0,0 +1,179 @@
+package conn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func newFakeRicmpConn(fakenet *FakeIcmpNet, ip string) Conn {
+	config := DefaultRicmpConfig()
+	config.ListenPacket = fakenet.Host(ip)
+	return NewRicmpConn(config)
+}
+
+func Test0001RICMP(t *testing.T) {
+	fakenet := NewFakeIcmpNet(0.1)
+
+	cc, err := newFakeRicmpConn(fakenet, "10.0.0.2").Listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	go func() {
+		for {
+			cc, err := cc.Accept()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Println("accept done")
+			go func() {
+				defer cc.Close()
+				io.Copy(cc, cc)
+			}()
+		}
+	}()
+
+	// two dialers on one host are told by the echo id
+	var conns []Conn
+	for i := 0; i < 2; i++ {
+		ccc, err := newFakeRicmpConn(fakenet, "10.0.0.1").Dial("10.0.0.2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, ccc)
+	}
+
+	for _, ccc := range conns {
+		go func(ccc Conn) {
+			_, err := ccc.Write(data)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}(ccc)
+	}
+
+	for i, ccc := range conns {
+		recv := make(chan []byte)
+		go func(ccc Conn) {
+			buf := make([]byte, len(data))
+			_, err := io.ReadFull(ccc, buf)
+			if err != nil {
+				fmt.Println(err)
+			}
+			recv <- buf
+		}(ccc)
+
+		select {
+		case buf := <-recv:
+			if !bytes.Equal(buf, data) {
+				t.Error("recv data diff", i)
+			}
+		case <-time.After(time.Second * 60):
+			t.Error("recv data timeout", i)
+		}
+		ccc.Close()
+	}
+}
+
+func Test0002RICMP(t *testing.T) {
+	fakenet := NewFakeIcmpNet(0)
+
+	cc, err := newFakeRicmpConn(fakenet, "10.0.0.2").Listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	done := make(chan []byte)
+	go func() {
+		cc, err := cc.Accept()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer cc.Close()
+		buf, _ := io.ReadAll(cc)
+		done <- buf
+	}()
+
+	ccc, err := newFakeRicmpConn(fakenet, "10.0.0.1").Dial("10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the data written before close must be flushed
+	ccc.Write([]byte("hello"))
+	ccc.Close()
+
+	select {
+	case buf := <-done:
+		if string(buf) != "hello" {
+			t.Error("close not flush", string(buf))
+		}
+	case <-time.After(time.Second * 10):
+		t.Error("remote not closed")
+	}
+}
+
+func Test0003RICMP(t *testing.T) {
+	fakenet := NewFakeIcmpNet(0)
+
+	obfs := DefaultObfsConfig()
+	obfs.Key = "123"
+	obfs.Mimic = OBFS_MIMIC_WINDOWS
+
+	config := DefaultRicmpConfig()
+	config.ListenPacket = fakenet.Host("10.0.0.2")
+	config.Obfs = obfs
+	cc, err := NewRicmpConn(config).Listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	go func() {
+		cc, err := cc.Accept()
+		if err != nil {
+			return
+		}
+		defer cc.Close()
+		io.Copy(cc, cc)
+	}()
+
+	config = DefaultRicmpConfig()
+	config.ListenPacket = fakenet.Host("10.0.0.1")
+	config.Obfs = obfs
+	ccc, err := NewRicmpConn(config).Dial("10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ccc.Close()
+
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+	go ccc.Write(data)
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(ccc, buf)
+	if err != nil || !bytes.Equal(buf, data) {
+		t.Error("ricmp obfs echo fail", err)
+	}
+
+	// the plain one can not talk to it
+	config = DefaultRicmpConfig()
+	config.ListenPacket = fakenet.Host("10.0.0.1")
+	config.ConnectTimeoutMs = 1000
+	_, err = NewRicmpConn(config).Dial("10.0.0.2")
+	fmt.Println(err)
+	if err == nil {
+		t.Error("plain dial should fail")
+	}
+}
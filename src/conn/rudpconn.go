@@ -1,7 +1,6 @@
 package conn
 
 import (
-	"context"
 	"errors"
 	"github.com/esrrhs/go-engine/src/common"
 	"github.com/esrrhs/go-engine/src/frame"
@@ -10,6 +9,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,6 +27,63 @@ type RudpConfig struct {
 	CloseTimeoutMs     int
 	CloseWaitTimeoutMs int
 	AcceptChanLen      int
+	Congestion         string
+	FastResend         int
+	Obfs               *ObfsConfig // nil for no obfuscation
+	Net                PacketNet   // the network the packets go, nil for the udp
+}
+
+// PacketNet is the packet network under rudp, such as the udp and the sim network
+type PacketNet interface {
+	Name() string
+	ResolveAddr(addr string) (net.Addr, error)
+	ListenPacket(addr string) (net.PacketConn, error)
+	DialPacket(addr net.Addr) (net.PacketConn, error) // the socket only talks with the addr, as the connected udp socket
+}
+
+type udpNet struct {
+}
+
+func (n *udpNet) Name() string {
+	return "rudp"
+}
+
+func (n *udpNet) ResolveAddr(addr string) (net.Addr, error) {
+	return net.ResolveUDPAddr("udp", addr)
+}
+
+func (n *udpNet) ListenPacket(addr string) (net.PacketConn, error) {
+	ipaddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", ipaddr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (n *udpNet) DialPacket(addr net.Addr) (net.PacketConn, error) {
+	conn, err := net.DialUDP("udp", nil, addr.(*net.UDPAddr))
+	if err != nil {
+		return nil, err
+	}
+	return &udpDialConn{conn}, nil
+}
+
+// udpDialConn is the connected udp socket, the kernel drops the packets from the others
+type udpDialConn struct {
+	*net.UDPConn
+}
+
+func (c *udpDialConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+func (c *udpDialConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
 }
 
 func DefaultRudpConfig() *RudpConfig {
@@ -44,48 +101,62 @@ func DefaultRudpConfig() *RudpConfig {
 		CloseTimeoutMs:     5000,
 		CloseWaitTimeoutMs: 5000,
 		AcceptChanLen:      128,
+		Congestion:         frame.CONGESTION_FIXED,
+		FastResend:         frame.FRAME_FAST_RESEND,
 	}
 }
 
 type rudpConn struct {
-	info          string
 	config        *RudpConfig
 	dialer        *rudpConnDialer
 	listenersonny *rudpConnListenerSonny
 	listener      *rudpConnListener
-	cancel        context.CancelFunc
-	isclose       bool
+	obfs          Obfuscator
+	isclose       int32
 	closelock     sync.Mutex
 }
 
 type rudpConnDialer struct {
-	conn *net.UDPConn
-	fm   *frame.FrameMgr
-	wg   *group.Group
+	conn    net.PacketConn
+	dstaddr net.Addr
+	fm      *frame.FrameMgr
+	wg      *group.Group
 }
 
 type rudpConnListenerSonny struct {
-	dstaddr    *net.UDPAddr
-	fatherconn *net.UDPConn
+	dstaddr    net.Addr
+	fatherconn net.PacketConn
 	fm         *frame.FrameMgr
 	wg         *group.Group
 }
 
 type rudpConnListener struct {
-	listenerconn *net.UDPConn
+	listenerconn net.PacketConn
 	wg           *group.Group
 	sonny        sync.Map
 	accept       *common.Channel
 }
 
 func (c *rudpConn) Name() string {
-	return "rudp"
+	c.checkConfig()
+	return c.net().Name()
+}
+
+func (c *rudpConn) net() PacketNet {
+	if c.config.Net == nil {
+		return &udpNet{}
+	}
+	return c.config.Net
+}
+
+func (c *rudpConn) isClosed() bool {
+	return atomic.LoadInt32(&c.isclose) != 0
 }
 
 func (c *rudpConn) Read(p []byte) (n int, err error) {
 	c.checkConfig()
 
-	if c.isclose {
+	if c.isClosed() {
 		return 0, errors.New("read closed conn")
 	}
 
@@ -107,7 +178,7 @@ func (c *rudpConn) Read(p []byte) (n int, err error) {
 		return 0, errors.New("empty conn")
 	}
 
-	for !c.isclose {
+	for !c.isClosed() {
 		if fm.GetRecvBufferSize() <= 0 {
 			if wg != nil && wg.IsExit() {
 				return 0, errors.New("closed conn")
@@ -127,7 +198,7 @@ func (c *rudpConn) Read(p []byte) (n int, err error) {
 func (c *rudpConn) Write(p []byte) (n int, err error) {
 	c.checkConfig()
 
-	if c.isclose {
+	if c.isClosed() {
 		return 0, errors.New("write closed conn")
 	}
 
@@ -152,7 +223,7 @@ func (c *rudpConn) Write(p []byte) (n int, err error) {
 	totalsize := len(p)
 	cur := 0
 
-	for !c.isclose {
+	for !c.isClosed() {
 		size := totalsize - cur
 		svleft := fm.GetSendBufferLeft()
 		if size > svleft {
@@ -183,7 +254,7 @@ func (c *rudpConn) Write(p []byte) (n int, err error) {
 func (c *rudpConn) Close() error {
 	c.checkConfig()
 
-	if c.isclose {
+	if c.isClosed() {
 		return nil
 	}
 
@@ -192,9 +263,6 @@ func (c *rudpConn) Close() error {
 
 	loggo.Debug("start Close %s", c.Info())
 
-	if c.cancel != nil {
-		c.cancel()
-	}
 	if c.dialer != nil {
 		if c.dialer.wg != nil {
 			loggo.Debug("start Close dialer %s", c.Info())
@@ -208,6 +276,8 @@ func (c *rudpConn) Close() error {
 		if c.listener.wg != nil {
 			loggo.Debug("start Close listener %s", c.Info())
 			c.listener.wg.Stop()
+			// wake up the Accept
+			c.listener.accept.Close()
 			c.listener.sonny.Range(func(key, value interface{}) bool {
 				u := value.(*rudpConn)
 				u.Close()
@@ -225,7 +295,7 @@ func (c *rudpConn) Close() error {
 			c.listenersonny.wg.Wait()
 		}
 	}
-	c.isclose = true
+	atomic.StoreInt32(&c.isclose, 1)
 
 	loggo.Debug("Close ok %s", c.Info())
 
@@ -235,44 +305,46 @@ func (c *rudpConn) Close() error {
 func (c *rudpConn) Info() string {
 	c.checkConfig()
 
-	if c.info != "" {
-		return c.info
-	}
+	name := c.net().Name()
 	if c.dialer != nil {
-		c.info = c.dialer.conn.LocalAddr().String() + "<--rudp-->" + c.dialer.conn.RemoteAddr().String()
+		return c.dialer.conn.LocalAddr().String() + "<--" + name + "-->" + c.dialer.dstaddr.String()
 	} else if c.listener != nil {
-		c.info = "rudp--" + c.listener.listenerconn.LocalAddr().String()
+		return name + "--" + c.listener.listenerconn.LocalAddr().String()
 	} else if c.listenersonny != nil {
-		c.info = c.listenersonny.fatherconn.LocalAddr().String() + "<--rudp-->" + c.listenersonny.dstaddr.String()
-	} else {
-		c.info = "empty rudp conn"
+		return c.listenersonny.fatherconn.LocalAddr().String() + "<--" + name + "-->" + c.listenersonny.dstaddr.String()
 	}
-	return c.info
+	return "empty " + name + " conn"
 }
 
 func (c *rudpConn) Dial(dst string) (Conn, error) {
 	c.checkConfig()
 
-	addr, err := net.ResolveUDPAddr("udp", dst)
+	addr, err := c.net().ResolveAddr(dst)
+	if err != nil {
+		return nil, err
+	}
+	obfs, err := NewObfs(c.config.Obfs)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	c.cancel = cancel
-	var d net.Dialer
-	conn, err := d.DialContext(ctx, "udp", addr.String())
+	conn, err := c.net().DialPacket(addr)
 	if err != nil {
 		return nil, err
 	}
-	c.cancel = nil
 
 	id := common.Guid()
 	fm := frame.NewFrameMgr(c.config.CutSize, c.config.MaxId, c.config.BufferSize, c.config.MaxWin, c.config.ResendTimems, c.config.Compress, c.config.Stat)
 	fm.SetDebugid(id)
+	fm.SetFastResend(c.config.FastResend)
+	err = fm.SetCongestion(c.config.Congestion)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
 
-	dialer := &rudpConnDialer{conn: conn.(*net.UDPConn), fm: fm}
+	dialer := &rudpConnDialer{conn: conn, dstaddr: addr, fm: fm}
 
-	u := &rudpConn{config: c.config, dialer: dialer}
+	u := &rudpConn{config: c.config, dialer: dialer, obfs: obfs}
 
 	loggo.Debug("start connect remote rudp %s %s", u.Info(), id)
 
@@ -292,16 +364,16 @@ func (c *rudpConn) Dial(dst string) (Conn, error) {
 		for e := sendlist.Front(); e != nil; e = e.Next() {
 			f := e.Value.(*frame.Frame)
 			mb, _ := u.dialer.fm.MarshalFrame(f)
-			u.dialer.conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
-			u.dialer.conn.Write(mb)
+			u.dialer.conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
+			u.dialer.conn.WriteTo(u.obfuscate(mb), u.dialer.dstaddr)
 		}
 
 		// recv udp
-		u.dialer.conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
-		n, _ := u.dialer.conn.Read(buf)
-		if n > 0 {
+		n := u.readDialer(buf)
+		data, err := u.deobfuscate(buf[0:n])
+		if n > 0 && err == nil {
 			f := &frame.Frame{}
-			err := proto.Unmarshal(buf[0:n], f)
+			err := proto.Unmarshal(data, f)
 			if err == nil {
 				u.dialer.fm.OnRecvFrame(f)
 			} else {
@@ -310,7 +382,7 @@ func (c *rudpConn) Dial(dst string) (Conn, error) {
 			}
 		}
 
-		if c.isclose {
+		if c.isClosed() {
 			loggo.Debug("can not connect remote rudp %s", u.Info())
 			break
 		}
@@ -326,16 +398,13 @@ func (c *rudpConn) Dial(dst string) (Conn, error) {
 		time.Sleep(time.Millisecond * 10)
 	}
 
-	if c.isclose {
-		u.Close()
-		return nil, errors.New("closed conn")
-	}
-
-	if u.isclose {
+	if c.isClosed() {
+		conn.Close()
 		return nil, errors.New("closed conn")
 	}
 
 	if !u.dialer.fm.IsConnected() {
+		conn.Close()
 		return nil, errors.New("connect timeout")
 	}
 
@@ -355,12 +424,16 @@ func (c *rudpConn) Dial(dst string) (Conn, error) {
 func (c *rudpConn) Listen(dst string) (Conn, error) {
 	c.checkConfig()
 
-	ipaddr, err := net.ResolveUDPAddr("udp", dst)
+	err := frame.CheckCongestion(c.config.Congestion)
+	if err != nil {
+		return nil, err
+	}
+	obfs, err := NewObfs(c.config.Obfs)
 	if err != nil {
 		return nil, err
 	}
 
-	listenerconn, err := net.ListenUDP("udp", ipaddr)
+	listenerconn, err := c.net().ListenPacket(dst)
 	if err != nil {
 		return nil, err
 	}
@@ -375,7 +448,7 @@ func (c *rudpConn) Listen(dst string) (Conn, error) {
 		accept:       ch,
 	}
 
-	u := &rudpConn{config: c.config, listener: listener}
+	u := &rudpConn{config: c.config, listener: listener, obfs: obfs}
 	wg.Go("rudpConn loopListenerRecv"+" "+dst, func() error {
 		return u.loopListenerRecv()
 	})
@@ -399,7 +472,7 @@ func (c *rudpConn) Accept() (Conn, error) {
 		if !ok {
 			continue
 		}
-		if sonny.isclose {
+		if sonny.isClosed() {
 			continue
 		}
 		return sonny, nil
@@ -417,13 +490,41 @@ func (c *rudpConn) SetConfig(config *RudpConfig) {
 	c.config = config
 }
 
+func (c *rudpConn) obfuscate(mb []byte) []byte {
+	if c.obfs == nil {
+		return mb
+	}
+	return c.obfs.Obfuscate(mb)
+}
+
+func (c *rudpConn) deobfuscate(b []byte) ([]byte, error) {
+	if c.obfs == nil {
+		return b, nil
+	}
+	return c.obfs.Deobfuscate(b)
+}
+
+// readDialer read the packet from the remote, the dialer socket drops the others
+func (c *rudpConn) readDialer(buf []byte) int {
+	c.dialer.conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	n, _, err := c.dialer.conn.ReadFrom(buf)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
 func (c *rudpConn) loopListenerRecv() error {
 	c.checkConfig()
 
 	buf := make([]byte, c.config.MaxPacketSize)
 	for !c.listener.wg.IsExit() {
 		c.listener.listenerconn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
-		n, srcaddr, err := c.listener.listenerconn.ReadFromUDP(buf)
+		n, srcaddr, err := c.listener.listenerconn.ReadFrom(buf)
+		if err != nil {
+			continue
+		}
+		data, err := c.deobfuscate(buf[0:n])
 		if err != nil {
 			continue
 		}
@@ -435,6 +536,9 @@ func (c *rudpConn) loopListenerRecv() error {
 			id := common.Guid()
 			fm := frame.NewFrameMgr(c.config.CutSize, c.config.MaxId, c.config.BufferSize, c.config.MaxWin, c.config.ResendTimems, c.config.Compress, c.config.Stat)
 			fm.SetDebugid(id)
+			fm.SetFastResend(c.config.FastResend)
+			// checked by Listen
+			fm.SetCongestion(c.config.Congestion)
 
 			sonny := &rudpConnListenerSonny{
 				dstaddr:    srcaddr,
@@ -442,7 +546,7 @@ func (c *rudpConn) loopListenerRecv() error {
 				fm:         fm,
 			}
 
-			u := &rudpConn{config: c.config, listenersonny: sonny}
+			u := &rudpConn{config: c.config, listenersonny: sonny, obfs: c.obfs}
 			c.listener.sonny.Store(srcaddrstr, u)
 
 			c.listener.wg.Go("rudpConn accept"+" "+u.Info(), func() error {
@@ -454,7 +558,7 @@ func (c *rudpConn) loopListenerRecv() error {
 			u := v.(*rudpConn)
 
 			f := &frame.Frame{}
-			err := proto.Unmarshal(buf[0:n], f)
+			err := proto.Unmarshal(data, f)
 			if err == nil {
 				u.listenersonny.fm.OnRecvFrame(f)
 				//loggo.Debug("%s recv frame %d", u.Info(), f.Id)
@@ -465,7 +569,7 @@ func (c *rudpConn) loopListenerRecv() error {
 
 		c.listener.sonny.Range(func(key, value interface{}) bool {
 			u := value.(*rudpConn)
-			if u.isclose {
+			if u.isClosed() {
 				c.listener.sonny.Delete(key)
 				loggo.Debug("delete sonny from map %s", u.Info())
 			}
@@ -500,7 +604,7 @@ func (c *rudpConn) accept(u *rudpConn) error {
 				break
 			}
 			u.listenersonny.fatherconn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
-			u.listenersonny.fatherconn.WriteToUDP(mb, u.listenersonny.dstaddr)
+			u.listenersonny.fatherconn.WriteTo(u.obfuscate(mb), u.listenersonny.dstaddr)
 		}
 
 		now := time.Now()
@@ -525,8 +629,6 @@ func (c *rudpConn) accept(u *rudpConn) error {
 
 	loggo.Debug("server accept rudp ok %s", u.Info())
 
-	c.listener.accept.Write(u)
-
 	wg := group.NewGroup("rudpConn ListenerSonny"+" "+u.Info(), c.listener.wg, nil)
 
 	u.listenersonny.wg = wg
@@ -535,6 +637,9 @@ func (c *rudpConn) accept(u *rudpConn) error {
 		return u.updateListenerSonny()
 	})
 
+	// after the wg is set, the Read and Write use it
+	c.listener.accept.Write(u)
+
 	loggo.Debug("accept rudp finish %s", u.Info())
 
 	return nil
@@ -545,25 +650,25 @@ func (c *rudpConn) updateListenerSonny() error {
 }
 
 func (c *rudpConn) updateDialerSonny() error {
-	return c.update_rudp(c.dialer.wg, c.dialer.fm, c.dialer.conn, nil, true)
+	return c.update_rudp(c.dialer.wg, c.dialer.fm, c.dialer.conn, c.dialer.dstaddr, true)
 }
 
-func (c *rudpConn) update_rudp(wg *group.Group, fm *frame.FrameMgr, conn *net.UDPConn, dstaddr *net.UDPAddr, readconn bool) error {
+func (c *rudpConn) update_rudp(wg *group.Group, fm *frame.FrameMgr, conn net.PacketConn, dstaddr net.Addr, readconn bool) error {
 
 	loggo.Debug("start rudp conn %s", c.Info())
 
-	stage := "open"
+	var closewait int32
 
 	if readconn {
 		wg.Go("rudpConn update_rudp recv"+" "+c.Info(), func() error {
 			bytes := make([]byte, c.config.MaxPacketSize)
-			for !wg.IsExit() && stage != "closewait" {
+			for !wg.IsExit() && atomic.LoadInt32(&closewait) == 0 {
 				// recv udp
-				conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
-				n, _ := conn.Read(bytes)
-				if n > 0 {
+				n := c.readDialer(bytes)
+				data, err := c.deobfuscate(bytes[0:n])
+				if n > 0 && err == nil {
 					f := &frame.Frame{}
-					err := proto.Unmarshal(bytes[0:n], f)
+					err := proto.Unmarshal(data, f)
 					if err == nil {
 						fm.OnRecvFrame(f)
 						//loggo.Debug("%s recv frame %d", c.Info(), f.Id)
@@ -593,13 +698,8 @@ func (c *rudpConn) update_rudp(wg *group.Group, fm *frame.FrameMgr, conn *net.UD
 				return err
 			}
 			conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
-			if dstaddr != nil {
-				conn.WriteToUDP(mb, dstaddr)
-				//loggo.Debug("%s send frame to %s %d", c.Info(), dstaddr, f.Id)
-			} else {
-				conn.Write(mb)
-				//loggo.Debug("%s send frame %d", c.Info(), f.Id)
-			}
+			conn.WriteTo(c.obfuscate(mb), dstaddr)
+			//loggo.Debug("%s send frame to %s %d", c.Info(), dstaddr, f.Id)
 		}
 
 		// timeout
@@ -620,7 +720,6 @@ func (c *rudpConn) update_rudp(wg *group.Group, fm *frame.FrameMgr, conn *net.UD
 		}
 	}
 
-	stage = "close"
 	fm.Close()
 	loggo.Debug("close rudp conn fm %s", c.Info())
 
@@ -640,13 +739,8 @@ func (c *rudpConn) update_rudp(wg *group.Group, fm *frame.FrameMgr, conn *net.UD
 				return err
 			}
 			conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
-			if dstaddr != nil {
-				conn.WriteToUDP(mb, dstaddr)
-				//loggo.Debug("%s send frame to %s %d", c.Info(), dstaddr, f.Id)
-			} else {
-				conn.Write(mb)
-				//loggo.Debug("%s send frame %d", c.Info(), f.Id)
-			}
+			conn.WriteTo(c.obfuscate(mb), dstaddr)
+			//loggo.Debug("%s send frame to %s %d", c.Info(), dstaddr, f.Id)
 		}
 
 		diffclose := now.Sub(startCloseTime)
@@ -664,7 +758,7 @@ func (c *rudpConn) update_rudp(wg *group.Group, fm *frame.FrameMgr, conn *net.UD
 		time.Sleep(time.Millisecond * 10)
 	}
 
-	stage = "closewait"
+	atomic.StoreInt32(&closewait, 1)
 	loggo.Debug("close rudp conn update %s", c.Info())
 
 	startEndTime := time.Now()
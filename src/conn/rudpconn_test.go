@@ -254,7 +254,6 @@ func Test0005RUDP1(t *testing.T) {
 			fmt.Println(string(buf[0:n]))
 			time.Sleep(time.Millisecond * 100)
 		}
-		fmt.Println("write done")
 	}()
 
 	time.Sleep(time.Second)
@@ -402,7 +401,6 @@ func Test0008RUDP(t *testing.T) {
 				start = time.Now()
 			}
 		}
-		fmt.Println("write done")
 	}()
 
 	ccc, err := c.Dial(":58080")
@@ -433,7 +431,6 @@ func Test0008RUDP(t *testing.T) {
 				start = time.Now()
 			}
 		}
-		fmt.Println("write done")
 	}()
 
 	time.Sleep(time.Second * 60)
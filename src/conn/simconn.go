@@ -0,0 +1,45 @@
+package conn
+
+// SimConfig is a RudpConfig over an in memory network with configurable faults, used to test the reliability of frame
+type SimConfig struct {
+	RudpConfig
+	Seed         int64 // the sockets of the config seed from it in the order they are opened
+	LossRate     float64
+	LatencyMs    int
+	JitterMs     int
+	DupRate      float64
+	ReorderRate  float64
+	BandwidthKB  int
+	RecvQueueLen int
+}
+
+func DefaultSimConfig() *SimConfig {
+	return &SimConfig{
+		RudpConfig:   *DefaultRudpConfig(),
+		Seed:         1,
+		LossRate:     0,
+		LatencyMs:    0,
+		JitterMs:     0,
+		DupRate:      0,
+		ReorderRate:  0,
+		BandwidthKB:  0,
+		RecvQueueLen: 10240,
+	}
+}
+
+// simConn is the rudpConn over the sim network
+type simConn struct {
+	rudpConn
+}
+
+func newSimConn() *simConn {
+	c := &simConn{}
+	c.SetConfig(DefaultSimConfig())
+	return c
+}
+
+func (c *simConn) SetConfig(config *SimConfig) {
+	rc := config.RudpConfig
+	rc.Net = newSimNet(config)
+	c.rudpConn.SetConfig(&rc)
+}
@@ -0,0 +1,295 @@
+package conn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newHostileSimConn() Conn {
+	config := DefaultSimConfig()
+	config.LossRate = 0.1
+	config.LatencyMs = 20
+	config.JitterMs = 10
+	config.DupRate = 0.05
+	config.ReorderRate = 0.05
+	config.BandwidthKB = 4096
+	c := &simConn{}
+	c.SetConfig(config)
+	return c
+}
+
+func Test0001SIM(t *testing.T) {
+	config := DefaultSimConfig()
+	config.LossRate = 0.3
+	config.DupRate = 0.3
+
+	count := func() (int, int) {
+		sn := newSimNet(config)
+		s, _ := sn.ListenPacket("")
+		d, _ := sn.ListenPacket("")
+		defer s.Close()
+		defer d.Close()
+		for i := 0; i < 1000; i++ {
+			s.WriteTo([]byte{byte(i)}, d.LocalAddr())
+		}
+		n := 0
+		sum := 0
+		buf := make([]byte, 16)
+		for {
+			d.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+			l, _, err := d.ReadFrom(buf)
+			if err != nil || l != 1 {
+				break
+			}
+			n++
+			sum += int(buf[0])
+		}
+		return n, sum
+	}
+
+	n1, sum1 := count()
+	n2, sum2 := count()
+	fmt.Println("sim recv ", n1, sum1, n2, sum2)
+	if n1 != n2 || sum1 != sum2 {
+		t.Error("sim socket not reproducible")
+	}
+	if n1 <= 600 || n1 >= 1000 {
+		t.Error("sim socket loss and dup wrong", n1)
+	}
+}
+
+func Test0002SIM(t *testing.T) {
+	c, err := NewConn("sim")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	_, err = c.Dial("sim-no-listener")
+	fmt.Println(err)
+	if err == nil {
+		t.Error("dial no listener should fail")
+	}
+
+	// the listener fails as the dialer does
+	config := DefaultSimConfig()
+	config.Congestion = "cubic"
+	sc := &simConn{}
+	sc.SetConfig(config)
+	_, err = sc.Listen("sim-bad-congestion")
+	fmt.Println(err)
+	if err == nil {
+		t.Error("listen undefined congestion should fail")
+	}
+}
+
+func Test0003SIM(t *testing.T) {
+	c := newHostileSimConn()
+
+	cc, err := c.Listen("sim-test-3")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	go func() {
+		cc.Accept()
+		fmt.Println("accept done")
+	}()
+
+	ccc, err := c.Dial("sim-test-3")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go func() {
+		buf := make([]byte, 100)
+		_, err := ccc.Read(buf)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}()
+
+	time.Sleep(time.Second)
+	fmt.Println("start close listener")
+	cc.Close()
+	fmt.Println("close listener ok")
+	fmt.Println("start close client")
+	ccc.Close()
+	fmt.Println("close client ok")
+}
+
+func Test0005SIM(t *testing.T) {
+	c := newHostileSimConn()
+
+	cc, err := c.Listen("sim-test-5")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	data := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	recv := make(chan []byte)
+	go func() {
+		cc, err := cc.Accept()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer cc.Close()
+		fmt.Println("accept done")
+		buf := make([]byte, len(data))
+		_, err = io.ReadFull(cc, buf)
+		if err != nil {
+			fmt.Println(err)
+		}
+		recv <- buf
+	}()
+
+	ccc, err := c.Dial("sim-test-5")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go func() {
+		_, err := ccc.Write(data)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("write done")
+	}()
+
+	select {
+	case buf := <-recv:
+		if !bytes.Equal(buf, data) {
+			t.Error("recv data diff")
+		}
+	case <-time.After(time.Second * 60):
+		t.Error("recv data timeout")
+	}
+
+	cc.Close()
+	ccc.Close()
+}
+
+func Test0006SIM(t *testing.T) {
+	c := newHostileSimConn()
+
+	cc, err := c.Listen("sim-test-6")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	done := make(chan error)
+	go func() {
+		cc, err := cc.Accept()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer cc.Close()
+		fmt.Println("accept done")
+		buf := make([]byte, 10)
+		_, err = cc.Read(buf)
+		done <- err
+	}()
+
+	ccc, err := c.Dial("sim-test-6")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go func() {
+		time.Sleep(time.Second)
+		ccc.Close()
+		fmt.Println("client close")
+	}()
+
+	select {
+	case err := <-done:
+		fmt.Println("Read done", err)
+		if err == nil {
+			t.Error("read closed conn should fail")
+		}
+	case <-time.After(time.Second * 30):
+		t.Error("remote close timeout")
+	}
+
+	cc.Close()
+}
+
+func Test0007SIM(t *testing.T) {
+	// a socket writing to itself must not deadlock
+	sn := newSimNet(DefaultSimConfig())
+	s, _ := sn.ListenPacket("")
+	defer s.Close()
+	s.WriteTo([]byte{1}, s.LocalAddr())
+	buf := make([]byte, 16)
+	s.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := s.ReadFrom(buf)
+	if err != nil || n != 1 || buf[0] != 1 {
+		t.Error("sim socket write self fail", err)
+	}
+}
+
+func Test0008SIM(t *testing.T) {
+	// the packets in flight arrive in the same order by the virtual clock
+	config := DefaultSimConfig()
+	config.LatencyMs = 20
+	config.JitterMs = 10
+	config.ReorderRate = 0.2
+
+	order := func() []byte {
+		sn := newSimNet(config)
+		sn.clock.manual = true
+		s, _ := sn.ListenPacket("")
+		d, _ := sn.DialPacket(s.LocalAddr())
+		o, _ := sn.ListenPacket("")
+		defer s.Close()
+		defer d.Close()
+		defer o.Close()
+		for i := 0; i < 100; i++ {
+			s.WriteTo([]byte{byte(i)}, d.LocalAddr())
+			o.WriteTo([]byte{byte(i)}, d.LocalAddr())
+			sn.clock.Advance(time.Millisecond)
+		}
+		sn.clock.Advance(time.Second)
+		var ret []byte
+		buf := make([]byte, 16)
+		for {
+			d.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+			n, src, err := d.ReadFrom(buf)
+			if err != nil || n != 1 {
+				break
+			}
+			if src.String() != s.LocalAddr().String() {
+				t.Error("dialer recv from others", src)
+			}
+			ret = append(ret, buf[0])
+		}
+		return ret
+	}
+
+	o1 := order()
+	o2 := order()
+	fmt.Println("sim order ", o1)
+	if len(o1) != 100 || !bytes.Equal(o1, o2) {
+		t.Error("sim clock not reproducible", len(o1), len(o2))
+	}
+	if sort.SliceIsSorted(o1, func(i, j int) bool { return o1[i] < o1[j] }) {
+		t.Error("sim clock not reorder")
+	}
+}
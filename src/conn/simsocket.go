@@ -0,0 +1,372 @@
+package conn
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// in memory packet network used by simConn, every socket has an address and a recv queue,
+// packets written to an address may be lost, delayed, duplicated or reordered according to the sender config
+type simPacket struct {
+	src  string
+	data []byte
+}
+
+type simAddr string
+
+func (a simAddr) Network() string {
+	return "sim"
+}
+
+func (a simAddr) String() string {
+	return string(a)
+}
+
+type simSocket struct {
+	addr         string
+	peer         string // the dialer socket only recv from the peer, as the connected udp socket
+	net          *simNet
+	config       *SimConfig
+	recv         chan *simPacket
+	rand         *rand.Rand
+	lock         sync.Mutex
+	nextsend     time.Duration // the virtual time of the sim clock
+	readdeadline time.Time
+	closed       bool
+}
+
+// simNet is the PacketNet of a SimConfig, it owns the sockets and the clock of the packets in flight.
+// the sockets seed from the SimConfig.Seed in the order they are opened
+type simNet struct {
+	config  *SimConfig
+	lock    sync.Mutex
+	num     int64
+	id      int
+	sockets map[string]*simSocket
+	clock   *simClock
+}
+
+func newSimNet(config *SimConfig) *simNet {
+	return &simNet{
+		config:  config,
+		sockets: make(map[string]*simSocket),
+		clock:   newSimClock(),
+	}
+}
+
+func (n *simNet) Name() string {
+	return "sim"
+}
+
+func (n *simNet) ResolveAddr(addr string) (net.Addr, error) {
+	if n.socket(addr) == nil {
+		return nil, errors.New("connection refused " + addr)
+	}
+	return simAddr(addr), nil
+}
+
+func (n *simNet) ListenPacket(addr string) (net.PacketConn, error) {
+	s, err := n.newSocket(addr, "")
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (n *simNet) DialPacket(addr net.Addr) (net.PacketConn, error) {
+	s, err := n.newSocket("", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (n *simNet) socket(addr string) *simSocket {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.sockets[addr]
+}
+
+func (n *simNet) newSocket(addr string, peer string) (*simSocket, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if addr == "" {
+		n.id++
+		addr = "sim:" + strconv.Itoa(n.id)
+	}
+
+	_, ok := n.sockets[addr]
+	if ok {
+		return nil, errors.New("address already in use " + addr)
+	}
+
+	s := &simSocket{
+		addr:   addr,
+		peer:   peer,
+		net:    n,
+		config: n.config,
+		recv:   make(chan *simPacket, n.config.RecvQueueLen),
+		rand:   rand.New(rand.NewSource(n.config.Seed + n.num)),
+	}
+	n.num++
+	n.sockets[addr] = s
+	return s, nil
+}
+
+func (n *simNet) removeSocket(s *simSocket) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.sockets, s.addr)
+}
+
+func (n *simNet) deliver(p *simPacket, dst string) {
+	s := n.socket(dst)
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed || (s.peer != "" && s.peer != p.src) {
+		return
+	}
+	select {
+	case s.recv <- p:
+	default:
+	}
+}
+
+func (s *simSocket) LocalAddr() net.Addr {
+	return simAddr(s.addr)
+}
+
+func (s *simSocket) WriteTo(b []byte, addr net.Addr) (int, error) {
+	dst := addr.String()
+
+	type delivery struct {
+		p *simPacket
+		d time.Duration
+	}
+	var deliveries []delivery
+
+	// the delays are decided under the lock, the packets are delivered after it, the dst may be s itself
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return 0, errors.New("write closed socket")
+	}
+
+	if s.rand.Float64() >= s.config.LossRate {
+		num := 1
+		if s.rand.Float64() < s.config.DupRate {
+			num++
+		}
+
+		now := s.net.clock.Now()
+		var delay time.Duration
+		if s.config.BandwidthKB > 0 {
+			if s.nextsend < now {
+				s.nextsend = now
+			}
+			s.nextsend += time.Duration(len(b)) * time.Second / time.Duration(s.config.BandwidthKB*1024)
+			delay = s.nextsend - now
+		}
+
+		for i := 0; i < num; i++ {
+			d := delay + time.Duration(s.config.LatencyMs)*time.Millisecond
+			if s.config.JitterMs > 0 {
+				d += time.Duration(s.rand.Intn(s.config.JitterMs+1)) * time.Millisecond
+			}
+			if s.rand.Float64() < s.config.ReorderRate {
+				d += time.Duration(s.config.LatencyMs+s.config.JitterMs+1) * time.Millisecond
+			}
+
+			p := &simPacket{src: s.addr, data: make([]byte, len(b))}
+			copy(p.data, b)
+			deliveries = append(deliveries, delivery{p, d})
+		}
+	}
+	s.lock.Unlock()
+
+	for _, dl := range deliveries {
+		p := dl.p
+		if dl.d <= 0 {
+			s.net.deliver(p, dst)
+		} else {
+			s.net.clock.AfterFunc(dl.d, func() {
+				s.net.deliver(p, dst)
+			})
+		}
+	}
+	return len(b), nil
+}
+
+func (s *simSocket) ReadFrom(b []byte) (int, net.Addr, error) {
+	s.lock.Lock()
+	deadline := s.readdeadline
+	s.lock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, nil, errors.New("read timeout")
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case p, ok := <-s.recv:
+		if !ok {
+			return 0, nil, errors.New("read closed socket")
+		}
+		return copy(b, p.data), simAddr(p.src), nil
+	case <-timeout:
+		return 0, nil, errors.New("read timeout")
+	}
+}
+
+func (s *simSocket) SetDeadline(t time.Time) error {
+	return s.SetReadDeadline(t)
+}
+
+func (s *simSocket) SetReadDeadline(t time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.readdeadline = t
+	return nil
+}
+
+// SetWriteDeadline is useless, the write never blocks
+func (s *simSocket) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *simSocket) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.recv)
+	s.lock.Unlock()
+
+	s.net.removeSocket(s)
+	return nil
+}
+
+// simClock is the virtual clock of a simNet, the packets in flight fire in the order of the virtual time and then the write order,
+// so the same writes always arrive in the same order. the clock ticks SIM_CLOCK_TICK while there are packets in flight,
+// or only by Advance if manual
+type simClock struct {
+	lock    sync.Mutex
+	now     time.Duration
+	seq     int64
+	events  simEvents
+	running bool
+	manual  bool
+}
+
+const SIM_CLOCK_TICK = time.Millisecond
+
+type simEvent struct {
+	at  time.Duration
+	seq int64
+	fn  func()
+}
+
+type simEvents []*simEvent
+
+func (e simEvents) Len() int {
+	return len(e)
+}
+
+func (e simEvents) Less(i, j int) bool {
+	if e[i].at != e[j].at {
+		return e[i].at < e[j].at
+	}
+	return e[i].seq < e[j].seq
+}
+
+func (e simEvents) Swap(i, j int) {
+	e[i], e[j] = e[j], e[i]
+}
+
+func (e *simEvents) Push(x interface{}) {
+	*e = append(*e, x.(*simEvent))
+}
+
+func (e *simEvents) Pop() interface{} {
+	old := *e
+	n := len(old)
+	x := old[n-1]
+	*e = old[:n-1]
+	return x
+}
+
+func newSimClock() *simClock {
+	return &simClock{}
+}
+
+func (c *simClock) Now() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// AfterFunc call the fn after d of the virtual time
+func (c *simClock) AfterFunc(d time.Duration, fn func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.seq++
+	heap.Push(&c.events, &simEvent{at: c.now + d, seq: c.seq, fn: fn})
+	if !c.running && !c.manual {
+		c.running = true
+		go c.loop()
+	}
+}
+
+// Advance move the virtual time on and fire the due events
+func (c *simClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	c.now += d
+	c.lock.Unlock()
+	c.fire()
+}
+
+func (c *simClock) fire() {
+	for {
+		c.lock.Lock()
+		if c.events.Len() <= 0 || c.events[0].at > c.now {
+			c.lock.Unlock()
+			return
+		}
+		e := heap.Pop(&c.events).(*simEvent)
+		c.lock.Unlock()
+		e.fn()
+	}
+}
+
+func (c *simClock) loop() {
+	for {
+		time.Sleep(SIM_CLOCK_TICK)
+		c.Advance(SIM_CLOCK_TICK)
+
+		c.lock.Lock()
+		if c.events.Len() <= 0 {
+			c.running = false
+			c.lock.Unlock()
+			return
+		}
+		c.lock.Unlock()
+	}
+}
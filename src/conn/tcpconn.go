@@ -92,3 +92,19 @@ func (c *tcpConn) Accept() (Conn, error) {
 	}
 	return &tcpConn{conn: conn.(*net.TCPConn)}, nil
 }
+
+func (c *tcpConn) LocalAddr() net.Addr {
+	if c.conn != nil {
+		return c.conn.LocalAddr()
+	} else if c.listener != nil {
+		return c.listener.Addr()
+	}
+	return nil
+}
+
+func (c *tcpConn) RemoteAddr() net.Addr {
+	if c.conn != nil {
+		return c.conn.RemoteAddr()
+	}
+	return nil
+}
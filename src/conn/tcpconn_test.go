@@ -244,7 +244,6 @@ func Test0005TCP1(t *testing.T) {
 			fmt.Println(string(buf[0:n]))
 			time.Sleep(time.Millisecond * 100)
 		}
-		fmt.Println("write done")
 	}()
 
 	time.Sleep(time.Second)
@@ -345,7 +344,6 @@ func Test0008TCP(t *testing.T) {
 				start = time.Now()
 			}
 		}
-		fmt.Println("write done")
 	}()
 
 	ccc, err := c.Dial(":58080")
@@ -376,7 +374,6 @@ func Test0008TCP(t *testing.T) {
 				start = time.Now()
 			}
 		}
-		fmt.Println("write done")
 	}()
 
 	time.Sleep(time.Second * 60)
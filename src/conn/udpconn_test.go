@@ -255,7 +255,6 @@ func Test0005UDP1(t *testing.T) {
 			fmt.Println(string(buf[0:n]))
 			time.Sleep(time.Millisecond * 100)
 		}
-		fmt.Println("write done")
 	}()
 
 	time.Sleep(time.Second)
@@ -305,7 +304,6 @@ func Test0008UDP(t *testing.T) {
 				start = time.Now()
 			}
 		}
-		fmt.Println("write done")
 	}()
 
 	ccc, err := c.Dial(":58080")
@@ -339,7 +337,6 @@ func Test0008UDP(t *testing.T) {
 				start = time.Now()
 			}
 		}
-		fmt.Println("write done")
 	}()
 
 	time.Sleep(time.Second * 60)
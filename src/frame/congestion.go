@@ -0,0 +1,372 @@
+package frame
+
+import (
+	"errors"
+	"github.com/esrrhs/go-engine/src/common"
+	"strings"
+	"time"
+)
+
+const (
+	CONGESTION_FIXED = "fixed"
+	CONGESTION_RENO  = "reno"
+	CONGESTION_BBR   = "bbr"
+)
+
+const (
+	cc_min_win        = 4
+	cc_init_win       = 16
+	cc_min_rto        = int64(20 * time.Millisecond)
+	cc_max_rto        = int64(10 * time.Second)
+	cc_min_rtt_expire = int64(10 * time.Second)
+	cc_bw_sample_num  = 10
+)
+
+// Congestion decides how many frames may be in flight and when an unacked frame is resent.
+// FrameMgr feeds it with pong rtt samples, newly acked frames and resends triggered by REQ or timeout.
+type Congestion interface {
+	Name() string
+
+	OnRtt(rttns int64, cur int64)
+	OnAck(num int, cur int64)
+	OnLoss(num int, cur int64)
+	OnSend(cur int64)
+
+	CanSend(cur int64) bool
+	Win() int32
+	RTO() int64
+}
+
+func NewCongestion(name string, maxwin int, resend_timems int) (Congestion, error) {
+	name = strings.ToLower(name)
+	if maxwin < cc_min_win {
+		maxwin = cc_min_win
+	}
+	rto := newRtoEstimator(resend_timems)
+	if name == CONGESTION_FIXED || name == "" {
+		return &fixedCongestion{win: int32(maxwin), rto: int64(resend_timems) * int64(time.Millisecond)}, nil
+	} else if name == CONGESTION_RENO {
+		return newRenoCongestion(maxwin, rto), nil
+	} else if name == CONGESTION_BBR {
+		return newBbrCongestion(maxwin, rto), nil
+	}
+	return nil, errors.New("undefined congestion " + name)
+}
+
+// CheckCongestion check the name before the FrameMgr is created, as the listener does
+func CheckCongestion(name string) error {
+	name = strings.ToLower(name)
+	if name == CONGESTION_FIXED || name == "" || name == CONGESTION_RENO || name == CONGESTION_BBR {
+		return nil
+	}
+	return errors.New("undefined congestion " + name)
+}
+
+type rtoEstimator struct {
+	srtt   int64
+	rttvar int64
+	rto    int64
+}
+
+func newRtoEstimator(resend_timems int) *rtoEstimator {
+	return &rtoEstimator{rto: int64(resend_timems) * int64(time.Millisecond)}
+}
+
+// RFC 6298
+func (r *rtoEstimator) onRtt(rtt int64) {
+	if rtt <= 0 {
+		return
+	}
+	if r.srtt == 0 {
+		r.srtt = rtt
+		r.rttvar = rtt / 2
+	} else {
+		r.rttvar = (3*r.rttvar + common.AbsInt64(r.srtt-rtt)) / 4
+		r.srtt = (7*r.srtt + rtt) / 8
+	}
+	r.rto = r.srtt + 4*r.rttvar
+	if r.rto < cc_min_rto {
+		r.rto = cc_min_rto
+	}
+	if r.rto > cc_max_rto {
+		r.rto = cc_max_rto
+	}
+}
+
+type fixedCongestion struct {
+	win int32
+	rto int64
+}
+
+func (c *fixedCongestion) Name() string {
+	return CONGESTION_FIXED
+}
+
+func (c *fixedCongestion) OnRtt(rttns int64, cur int64) {
+}
+
+func (c *fixedCongestion) OnAck(num int, cur int64) {
+}
+
+func (c *fixedCongestion) OnLoss(num int, cur int64) {
+}
+
+func (c *fixedCongestion) OnSend(cur int64) {
+}
+
+func (c *fixedCongestion) CanSend(cur int64) bool {
+	return true
+}
+
+func (c *fixedCongestion) Win() int32 {
+	return c.win
+}
+
+func (c *fixedCongestion) RTO() int64 {
+	return c.rto
+}
+
+// NewReno style AIMD: slow start until ssthresh, then one frame per window, halve on loss at most once per rtt
+type renoCongestion struct {
+	maxwin   float64
+	cwnd     float64
+	ssthresh float64
+	lastLoss int64
+	rto      *rtoEstimator
+}
+
+func newRenoCongestion(maxwin int, rto *rtoEstimator) *renoCongestion {
+	return &renoCongestion{
+		maxwin:   float64(maxwin),
+		cwnd:     float64(common.MinOfInt(cc_init_win, maxwin)),
+		ssthresh: float64(maxwin),
+		rto:      rto,
+	}
+}
+
+func (c *renoCongestion) Name() string {
+	return CONGESTION_RENO
+}
+
+func (c *renoCongestion) OnRtt(rttns int64, cur int64) {
+	c.rto.onRtt(rttns)
+}
+
+func (c *renoCongestion) OnAck(num int, cur int64) {
+	for i := 0; i < num; i++ {
+		if c.cwnd < c.ssthresh {
+			c.cwnd++
+		} else {
+			c.cwnd += 1 / c.cwnd
+		}
+	}
+	if c.cwnd > c.maxwin {
+		c.cwnd = c.maxwin
+	}
+}
+
+func (c *renoCongestion) OnLoss(num int, cur int64) {
+	if num <= 0 || cur-c.lastLoss < c.rto.srtt {
+		return
+	}
+	c.lastLoss = cur
+	c.ssthresh = c.cwnd / 2
+	if c.ssthresh < cc_min_win {
+		c.ssthresh = cc_min_win
+	}
+	c.cwnd = c.ssthresh
+}
+
+func (c *renoCongestion) OnSend(cur int64) {
+}
+
+func (c *renoCongestion) CanSend(cur int64) bool {
+	return true
+}
+
+func (c *renoCongestion) Win() int32 {
+	return int32(c.cwnd)
+}
+
+func (c *renoCongestion) RTO() int64 {
+	return c.rto.rto
+}
+
+const (
+	bbr_startup = iota
+	bbr_drain
+	bbr_probe_bw
+)
+
+var bbrPacingGain = []float64{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+const (
+	bbr_high_gain = 2.89
+	bbr_cwnd_gain = 2
+)
+
+// BBR like: estimate bottleneck bandwidth (acked frames per second) and min rtt,
+// pace sends at gain*bw and keep cwnd_gain*bdp frames in flight
+type bbrCongestion struct {
+	maxwin int32
+	rto    *rtoEstimator
+
+	mode      int
+	cycle     int
+	cycleTime int64
+
+	minrtt     int64
+	minrttTime int64
+
+	delivered  int
+	sampleTime int64
+	bwsamples  []float64
+	btlbw      float64
+
+	fullbw    float64
+	fullbwNum int
+
+	tokens    float64
+	tokenTime int64
+}
+
+func newBbrCongestion(maxwin int, rto *rtoEstimator) *bbrCongestion {
+	return &bbrCongestion{
+		maxwin:    int32(maxwin),
+		rto:       rto,
+		mode:      bbr_startup,
+		bwsamples: make([]float64, 0, cc_bw_sample_num),
+	}
+}
+
+func (c *bbrCongestion) Name() string {
+	return CONGESTION_BBR
+}
+
+func (c *bbrCongestion) OnRtt(rttns int64, cur int64) {
+	c.rto.onRtt(rttns)
+	if rttns <= 0 {
+		return
+	}
+	if c.minrtt == 0 || rttns <= c.minrtt || cur-c.minrttTime > cc_min_rtt_expire {
+		c.minrtt = rttns
+		c.minrttTime = cur
+	}
+}
+
+func (c *bbrCongestion) OnAck(num int, cur int64) {
+	if c.sampleTime == 0 {
+		c.sampleTime = cur
+	}
+	c.delivered += num
+
+	interval := c.minrtt
+	if interval < int64(10*time.Millisecond) {
+		interval = int64(10 * time.Millisecond)
+	}
+	if cur-c.sampleTime < interval {
+		return
+	}
+
+	bw := float64(c.delivered) * float64(time.Second) / float64(cur-c.sampleTime)
+	c.delivered = 0
+	c.sampleTime = cur
+
+	if len(c.bwsamples) >= cc_bw_sample_num {
+		c.bwsamples = c.bwsamples[1:]
+	}
+	c.bwsamples = append(c.bwsamples, bw)
+	c.btlbw = 0
+	for _, s := range c.bwsamples {
+		if s > c.btlbw {
+			c.btlbw = s
+		}
+	}
+
+	c.updateMode(cur)
+}
+
+func (c *bbrCongestion) updateMode(cur int64) {
+	if c.mode == bbr_startup {
+		if c.btlbw >= c.fullbw*1.25 {
+			c.fullbw = c.btlbw
+			c.fullbwNum = 0
+		} else {
+			c.fullbwNum++
+		}
+		if c.fullbwNum >= 3 {
+			c.mode = bbr_drain
+			c.cycleTime = cur
+		}
+	} else if c.mode == bbr_drain {
+		if cur-c.cycleTime > c.minrtt {
+			c.mode = bbr_probe_bw
+			c.cycle = 0
+			c.cycleTime = cur
+		}
+	} else {
+		if cur-c.cycleTime > c.minrtt {
+			c.cycle = (c.cycle + 1) % len(bbrPacingGain)
+			c.cycleTime = cur
+		}
+	}
+}
+
+func (c *bbrCongestion) OnLoss(num int, cur int64) {
+}
+
+func (c *bbrCongestion) pacingGain() float64 {
+	if c.mode == bbr_startup {
+		return bbr_high_gain
+	} else if c.mode == bbr_drain {
+		return 1 / bbr_high_gain
+	}
+	return bbrPacingGain[c.cycle]
+}
+
+func (c *bbrCongestion) OnSend(cur int64) {
+	if c.btlbw > 0 {
+		c.tokens--
+	}
+}
+
+func (c *bbrCongestion) CanSend(cur int64) bool {
+	if c.btlbw <= 0 {
+		return true
+	}
+	rate := c.pacingGain() * c.btlbw
+	if c.tokenTime != 0 {
+		c.tokens += rate * float64(cur-c.tokenTime) / float64(time.Second)
+	}
+	c.tokenTime = cur
+	burst := rate * float64(10*time.Millisecond) / float64(time.Second)
+	if burst < cc_min_win {
+		burst = cc_min_win
+	}
+	if c.tokens > burst {
+		c.tokens = burst
+	}
+	return c.tokens >= 1
+}
+
+func (c *bbrCongestion) Win() int32 {
+	if c.btlbw <= 0 || c.minrtt <= 0 {
+		return int32(common.MinOfInt(cc_init_win, int(c.maxwin)))
+	}
+	gain := float64(bbr_cwnd_gain)
+	if c.mode == bbr_startup {
+		gain = bbr_high_gain
+	}
+	win := int32(gain * c.btlbw * float64(c.minrtt) / float64(time.Second))
+	if win < cc_min_win {
+		win = cc_min_win
+	}
+	if win > c.maxwin {
+		win = c.maxwin
+	}
+	return win
+}
+
+func (c *bbrCongestion) RTO() int64 {
+	return c.rto.rto
+}
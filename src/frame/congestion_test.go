@@ -0,0 +1,89 @@
+package frame
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test0001Congestion(t *testing.T) {
+	_, err := NewCongestion("cubic", 100, 200)
+	if err == nil {
+		t.Error("undefined congestion should fail")
+	}
+	if CheckCongestion("cubic") == nil || CheckCongestion("BBR") != nil {
+		t.Error("check congestion fail")
+	}
+
+	cc, _ := NewCongestion(CONGESTION_FIXED, 100, 200)
+	cc.OnLoss(10, time.Now().UnixNano())
+	if cc.Win() != 100 || cc.RTO() != int64(200*time.Millisecond) {
+		t.Error("fixed congestion changed", cc.Win(), cc.RTO())
+	}
+}
+
+func Test0002Congestion(t *testing.T) {
+	cc, _ := NewCongestion(CONGESTION_RENO, 1000, 200)
+	cur := time.Now().UnixNano()
+	cc.OnRtt(int64(50*time.Millisecond), cur)
+
+	start := cc.Win()
+	cc.OnAck(100, cur)
+	grow := cc.Win()
+	if grow <= start {
+		t.Error("reno should grow on ack", start, grow)
+	}
+
+	cc.OnLoss(1, cur+int64(time.Second))
+	half := cc.Win()
+	if half != grow/2 {
+		t.Error("reno should halve on loss", grow, half)
+	}
+
+	cc.OnLoss(1, cur+int64(time.Second)+1)
+	if cc.Win() != half {
+		t.Error("reno should reduce once per rtt", half, cc.Win())
+	}
+
+	cc.OnAck(int(half)*2, cur)
+	if cc.Win() != half+1 {
+		t.Error("reno should grow one per window after loss", half, cc.Win())
+	}
+
+	fmt.Println("reno rto ", time.Duration(cc.RTO()))
+	if cc.RTO() < int64(50*time.Millisecond) || cc.RTO() > int64(200*time.Millisecond) {
+		t.Error("reno rto not follow rtt", cc.RTO())
+	}
+}
+
+func Test0003Congestion(t *testing.T) {
+	cc, _ := NewCongestion(CONGESTION_BBR, 10000, 200)
+	cur := time.Now().UnixNano()
+	rtt := int64(100 * time.Millisecond)
+	cc.OnRtt(rtt, cur)
+
+	// 1000 frames per second for 2 seconds
+	for i := 0; i < 2000; i++ {
+		cur += int64(time.Millisecond)
+		cc.OnAck(1, cur)
+	}
+
+	fmt.Println("bbr win ", cc.Win())
+	bdp := int32(1000 * rtt / int64(time.Second))
+	if cc.Win() < bdp || cc.Win() > 3*bdp {
+		t.Error("bbr win not follow bdp", bdp, cc.Win())
+	}
+
+	sent := 0
+	for i := 0; i < 1000; i++ {
+		cur += int64(time.Millisecond)
+		for cc.CanSend(cur) {
+			cc.OnSend(cur)
+			sent++
+		}
+	}
+	fmt.Println("bbr paced ", sent)
+	if sent < 500 || sent > 3000 {
+		t.Error("bbr pacing not follow bw", sent)
+	}
+}
@@ -151,6 +151,8 @@ type Frame struct {
 	Data                 *FrameData `protobuf:"bytes,5,opt,name=data,proto3" json:"data,omitempty"`
 	Dataid               []int32    `protobuf:"varint,6,rep,packed,name=dataid,proto3" json:"dataid,omitempty"`
 	Acked                bool       `protobuf:"varint,7,opt,name=acked,proto3" json:"acked,omitempty"`
+	Dataidrange          []int32    `protobuf:"varint,8,rep,packed,name=dataidrange,proto3" json:"dataidrange,omitempty"`
+	Caps                 int32      `protobuf:"varint,9,opt,name=caps,proto3" json:"caps,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
 	XXX_unrecognized     []byte     `json:"-"`
 	XXX_sizecache        int32      `json:"-"`
@@ -230,6 +232,20 @@ func (m *Frame) GetAcked() bool {
 	return false
 }
 
+func (m *Frame) GetDataidrange() []int32 {
+	if m != nil {
+		return m.Dataidrange
+	}
+	return nil
+}
+
+func (m *Frame) GetCaps() int32 {
+	if m != nil {
+		return m.Caps
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterEnum("FrameData_TYPE", FrameData_TYPE_name, FrameData_TYPE_value)
 	proto.RegisterEnum("Frame_TYPE", Frame_TYPE_name, Frame_TYPE_value)
@@ -240,24 +256,26 @@ func init() {
 func init() { proto.RegisterFile("frame.proto", fileDescriptor_5379e2b825e15002) }
 
 var fileDescriptor_5379e2b825e15002 = []byte{
-	// 299 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x91, 0xcd, 0x4a, 0xf4, 0x30,
-	0x14, 0x86, 0xbf, 0xfc, 0xb5, 0x9d, 0x33, 0x9f, 0x12, 0x82, 0x0c, 0xc1, 0x85, 0x94, 0xae, 0xba,
-	0x9a, 0x85, 0x82, 0x5b, 0x99, 0x9f, 0x3a, 0x8a, 0xd2, 0x19, 0x33, 0xe3, 0x42, 0x37, 0x12, 0x27,
-	0x11, 0x8a, 0x8c, 0x2d, 0x6d, 0x37, 0xde, 0x85, 0x37, 0xe9, 0x7d, 0x48, 0x62, 0xe8, 0xca, 0xd5,
-	0x79, 0x9f, 0x16, 0x92, 0xe7, 0xcd, 0x81, 0xf1, 0x5b, 0xab, 0x0f, 0x76, 0xda, 0xb4, 0x75, 0x5f,
-	0x67, 0x5f, 0x08, 0x46, 0xd7, 0x8e, 0x97, 0xba, 0xd7, 0x42, 0x00, 0xed, 0x3f, 0x1b, 0x2b, 0x51,
-	0x8a, 0x72, 0xa6, 0x7c, 0x76, 0xdf, 0x8c, 0xee, 0xb5, 0xc4, 0x29, 0xca, 0xff, 0x2b, 0x9f, 0xc5,
-	0x29, 0x24, 0xfb, 0xfa, 0xd0, 0xb4, 0xb6, 0xeb, 0x24, 0x49, 0x51, 0x9e, 0xa8, 0x81, 0xb3, 0x2b,
-	0xa0, 0xbb, 0xa7, 0x4d, 0x21, 0x8e, 0x60, 0xf4, 0xb8, 0x2d, 0xd4, 0xcb, 0x72, 0xb6, 0x9b, 0xf1,
-	0x7f, 0x22, 0x01, 0xba, 0x58, 0x97, 0x25, 0x47, 0x62, 0x0c, 0xb1, 0x4b, 0x6a, 0xbb, 0xe1, 0x58,
-	0x8c, 0x80, 0x2d, 0xee, 0xd7, 0xdb, 0x82, 0x13, 0x11, 0x01, 0xbe, 0x99, 0x73, 0x9a, 0x7d, 0x23,
-	0x60, 0x5e, 0xe9, 0x4f, 0x9d, 0x09, 0x44, 0xad, 0xed, 0xec, 0x87, 0xf1, 0x42, 0x89, 0x0a, 0xe4,
-	0x94, 0xdc, 0xec, 0xab, 0x83, 0xf5, 0x4a, 0x44, 0x0d, 0x2c, 0x8e, 0x01, 0x57, 0x46, 0x52, 0x7f,
-	0x0a, 0xae, 0x8c, 0x38, 0x0b, 0x95, 0x58, 0x8a, 0xf2, 0xf1, 0x39, 0x4c, 0x87, 0x07, 0x08, 0xf5,
-	0x26, 0x10, 0xb9, 0x59, 0x19, 0x19, 0xa5, 0x24, 0x67, 0x2a, 0x90, 0x38, 0x01, 0xa6, 0xf7, 0xef,
-	0xd6, 0xc8, 0xd8, 0x5f, 0xfd, 0x0b, 0xd9, 0x65, 0x28, 0x9c, 0x00, 0x0d, 0x5d, 0x63, 0x20, 0xaa,
-	0x78, 0xe0, 0xc8, 0x85, 0xd9, 0xe2, 0x8e, 0x63, 0xf7, 0x6f, 0x73, 0x5b, 0xae, 0x38, 0xf1, 0x69,
-	0x5d, 0xae, 0x38, 0x9d, 0xc7, 0xcf, 0xcc, 0x6f, 0xe2, 0x35, 0xf2, 0xab, 0xb8, 0xf8, 0x09, 0x00,
-	0x00, 0xff, 0xff, 0xc6, 0x07, 0xe4, 0x02, 0x99, 0x01, 0x00, 0x00,
+	// 321 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x91, 0x3d, 0x4f, 0xfb, 0x30,
+	0x10, 0xc6, 0xff, 0x76, 0xec, 0xbc, 0x5c, 0xfe, 0x20, 0xeb, 0x84, 0x2a, 0x8b, 0x01, 0x45, 0x99,
+	0x32, 0x75, 0x00, 0x89, 0x15, 0xf5, 0x25, 0x14, 0x04, 0x4a, 0x8b, 0x5b, 0x06, 0x58, 0x90, 0x69,
+	0x0c, 0x8a, 0x50, 0xdb, 0x28, 0xc9, 0xc2, 0xb7, 0x60, 0xe7, 0xcb, 0x22, 0xbb, 0x51, 0xc5, 0xc0,
+	0x74, 0xcf, 0xef, 0x4e, 0x89, 0x9f, 0xe7, 0x0e, 0xe2, 0xb7, 0x46, 0x6f, 0xcc, 0xb0, 0x6e, 0x76,
+	0xdd, 0x2e, 0xfd, 0x22, 0x10, 0x5d, 0x5b, 0x9e, 0xea, 0x4e, 0x23, 0x02, 0xeb, 0x3e, 0x6b, 0x23,
+	0x49, 0x42, 0x32, 0xae, 0x9c, 0xb6, 0xbd, 0x52, 0x77, 0x5a, 0xd2, 0x84, 0x64, 0xff, 0x95, 0xd3,
+	0x78, 0x0a, 0xe1, 0x7a, 0xb7, 0xa9, 0x1b, 0xd3, 0xb6, 0xd2, 0x4b, 0x48, 0x16, 0xaa, 0x03, 0xa7,
+	0x57, 0xc0, 0x56, 0x4f, 0x8b, 0x1c, 0x8f, 0x20, 0x7a, 0x5c, 0xe6, 0xea, 0x65, 0x3a, 0x5a, 0x8d,
+	0xc4, 0x3f, 0x0c, 0x81, 0x4d, 0xe6, 0x45, 0x21, 0x08, 0xc6, 0x10, 0x58, 0xa5, 0x96, 0x0b, 0x41,
+	0x31, 0x02, 0x3e, 0xb9, 0x9f, 0x2f, 0x73, 0xe1, 0xa1, 0x0f, 0xf4, 0x66, 0x2c, 0x58, 0xfa, 0x4d,
+	0x81, 0x3b, 0x4b, 0x7f, 0xda, 0x19, 0x80, 0xdf, 0x98, 0xd6, 0x6c, 0x4b, 0x67, 0x28, 0x54, 0x3d,
+	0x59, 0x4b, 0xb6, 0x76, 0xd5, 0xc6, 0x38, 0x4b, 0x9e, 0x3a, 0x30, 0x1e, 0x03, 0xad, 0x4a, 0xc9,
+	0xdc, 0x5f, 0x68, 0x55, 0xe2, 0x59, 0x1f, 0x89, 0x27, 0x24, 0x8b, 0xcf, 0x61, 0x78, 0x58, 0x40,
+	0x1f, 0x6f, 0x00, 0xbe, 0xad, 0x55, 0x29, 0xfd, 0xc4, 0xcb, 0xb8, 0xea, 0x09, 0x4f, 0x80, 0xeb,
+	0xf5, 0x87, 0x29, 0x65, 0xe0, 0x9e, 0xde, 0x03, 0x26, 0x10, 0xef, 0xe7, 0x8d, 0xde, 0xbe, 0x1b,
+	0x19, 0xba, 0x4f, 0x7e, 0xb7, 0x6c, 0x8e, 0xb5, 0xae, 0x5b, 0x19, 0xed, 0x73, 0x58, 0x9d, 0x5e,
+	0xf6, 0x6b, 0x0a, 0x81, 0xf5, 0x1b, 0x0a, 0xc0, 0x53, 0xf9, 0x83, 0x20, 0x56, 0x8c, 0x26, 0x77,
+	0x82, 0xda, 0xd9, 0xe2, 0xb6, 0x98, 0x09, 0xcf, 0xa9, 0x79, 0x31, 0x13, 0x6c, 0x1c, 0x3c, 0x73,
+	0x77, 0xbf, 0x57, 0xdf, 0x1d, 0xf0, 0xe2, 0x67, 0x00, 0xd8, 0xc8, 0x4e, 0xaf, 0xcf, 0x01, 0x00,
+	0x00,
 }
@@ -6,11 +6,23 @@ import (
 	"github.com/esrrhs/go-engine/src/loggo"
 	"github.com/esrrhs/go-engine/src/rbuffergo"
 	"github.com/golang/protobuf/proto"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 )
 
+const (
+	FRAME_FAST_RESEND = 3
+)
+
+const (
+	FRAME_CAP_ACK_RANGE = 1 << iota
+)
+
+// caps of this side, carried by every ping and pong
+const FRAME_CAPS = FRAME_CAP_ACK_RANGE
+
 type FrameStat struct {
 	sendDataNum     int
 	recvDataNum     int
@@ -30,6 +42,8 @@ type FrameStat struct {
 	recvpong        int
 	recvOldNum      int
 	recvOutWinNum   int
+	fastResendNum   int
+	tailProbeNum    int
 }
 
 type FrameMgr struct {
@@ -62,6 +76,9 @@ type FrameMgr struct {
 	lastPingTime int64
 	lastPongTime int64
 	rttns        int64
+	peercaps     int32 // 对端能力，收到ping或pong后才知道
+
+	cc Congestion
 
 	lastSendHBTime   int64
 	lastRecvHBTime   int64
@@ -69,6 +86,11 @@ type FrameMgr struct {
 
 	reqmap map[int32]int64
 
+	fastresend    int
+	dupgapmap     map[int32]int
+	lastAckTime   int64
+	lastProbeTime int64
+
 	connected bool
 
 	fs            *FrameStat
@@ -103,14 +125,40 @@ func NewFrameMgr(frame_max_size int, frame_max_id int, buffersize int, windowsiz
 		rttns:     (int64)(resend_timems * 1000),
 		reqmap:    make(map[int32]int64),
 		connected: false, openstat: openstat, lastPrintStat: time.Now().UnixNano(),
+		fastresend: FRAME_FAST_RESEND, dupgapmap: make(map[int32]int),
+		lastAckTime: time.Now().UnixNano(), lastProbeTime: time.Now().UnixNano(),
 	}
 
+	fm.cc, _ = NewCongestion(CONGESTION_FIXED, windowsize, resend_timems)
+
 	if openstat > 0 {
 		fm.resetStat()
 	}
 	return fm
 }
 
+func (fm *FrameMgr) SetCongestion(name string) error {
+	cc, err := NewCongestion(name, int(fm.windowsize), fm.resend_timems)
+	if err != nil {
+		return err
+	}
+	fm.cc = cc
+	return nil
+}
+
+// resend a frame after fastresend frames behind it had been acked, <= 0 means only resend by timer and REQ
+func (fm *FrameMgr) SetFastResend(fastresend int) {
+	fm.fastresend = fastresend
+}
+
+func (fm *FrameMgr) GetCongestion() Congestion {
+	return fm.cc
+}
+
+func (fm *FrameMgr) sendWinLimit() int {
+	return common.MinOfInt(int(fm.cc.Win()), int(fm.windowsize))
+}
+
 func (fm *FrameMgr) GetSendBufferLeft() int {
 	fm.sendblock.Lock()
 	defer fm.sendblock.Unlock()
@@ -132,10 +180,12 @@ func (fm *FrameMgr) Update() bool {
 
 	tmpreq, tmpack, tmpackto := fm.preProcessRecvList()
 	avtive := len(tmpreq) + len(tmpack) + len(tmpackto)
-	fm.processRecvList(tmpreq, tmpack, tmpackto)
+	fm.processRecvList(cur, tmpreq, tmpack, tmpackto)
 
 	fm.combineWindowToRecvBuffer(cur)
 
+	fm.tailProbe(cur)
+
 	fm.calSendList(cur)
 
 	fm.ping()
@@ -157,7 +207,9 @@ func (fm *FrameMgr) cutSendBufferToWindow(cur int64) {
 		sendall = true
 	}
 
-	for fm.sendb.Size() >= fm.frame_max_size && fm.sendwin.Size() < int(fm.windowsize) {
+	sendwinlimit := fm.sendWinLimit()
+
+	for fm.sendb.Size() >= fm.frame_max_size && fm.sendwin.Size() < sendwinlimit {
 		fd := &FrameData{Type: (int32)(FrameData_USER_DATA),
 			Data: make([]byte, fm.frame_max_size)}
 		fm.sendb.Read(fd.Data)
@@ -186,7 +238,7 @@ func (fm *FrameMgr) cutSendBufferToWindow(cur int64) {
 		//loggo.Debug("debugid %v cut frame push to send win %v %v %v", fm.debugid, f.Id, fm.frame_max_size, fm.sendwin.Size())
 	}
 
-	if sendall && fm.sendb.Size() > 0 && fm.sendwin.Size() < int(fm.windowsize) {
+	if sendall && fm.sendb.Size() > 0 && fm.sendwin.Size() < sendwinlimit {
 		fd := &FrameData{Type: (int32)(FrameData_USER_DATA),
 			Data: make([]byte, fm.sendb.Size())}
 		fm.sendb.Read(fd.Data)
@@ -239,21 +291,39 @@ func (fm *FrameMgr) cutSendBufferToWindow(cur int64) {
 func (fm *FrameMgr) calSendList(cur int64) {
 
 	i := 0
+	lost := 0
+	rto := fm.cc.RTO()
 	for e := fm.sendwin.FrontInter(); e != nil; e = e.Next() {
 		f := e.Value.(*Frame)
-		if !f.Acked && (f.Resend || cur-f.Sendtime > int64(fm.resend_timems*(int)(time.Millisecond))) &&
+		timeout := cur-f.Sendtime > rto
+		if !f.Acked && (f.Resend || timeout) &&
 			cur-f.Sendtime > fm.rttns {
+			if !fm.cc.CanSend(cur) {
+				break
+			}
+			if f.Sendtime != 0 && !f.Resend {
+				lost++
+			}
+			if f.Sendtime != 0 {
+				gMetricResend.Inc()
+			}
 			f.Sendtime = cur
 			fm.sendFrame(f)
+			fm.cc.OnSend(cur)
 			f.Resend = false
 			if fm.openstat > 0 {
 				fm.fs.sendDataNum++
 				fm.fs.sendDataNumsMap[f.Id]++
 			}
+			gMetricSendData.Inc()
 			i++
 			//loggo.Debug("debugid %v push frame to sendlist %v %v", fm.debugid, f.Id, len(f.Data.Data))
 		}
 	}
+
+	if lost > 0 {
+		fm.cc.OnLoss(lost, cur)
+	}
 }
 
 func (fm *FrameMgr) GetSendList() *list.List {
@@ -295,9 +365,16 @@ func (fm *FrameMgr) preProcessRecvList() (map[int32]int, map[int32]int, map[int3
 				//loggo.Debug("debugid %v recv req %v %v", fm.debugid, f.Id, common.Int32ArrayToString(f.Dataid, ","))
 			}
 		} else if f.Type == (int32)(Frame_ACK) {
-			for _, id := range f.Dataid {
-				tmpack[id]++
-				//loggo.Debug("debugid %v recv ack %v %v", fm.debugid, f.Id, common.Int32ArrayToString(f.Dataid, ","))
+			// the peer sends ranges only after it knows we have FRAME_CAP_ACK_RANGE
+			if len(f.Dataidrange) > 0 {
+				fm.rangeToId(f.Dataidrange, func(id int32) {
+					tmpack[id]++
+				})
+			} else {
+				for _, id := range f.Dataid {
+					tmpack[id]++
+					//loggo.Debug("debugid %v recv ack %v %v", fm.debugid, f.Id, common.Int32ArrayToString(f.Dataid, ","))
+				}
 			}
 		} else if f.Type == (int32)(Frame_DATA) {
 			tmpackto[f.Id] = f
@@ -305,6 +382,7 @@ func (fm *FrameMgr) preProcessRecvList() (map[int32]int, map[int32]int, map[int3
 				fm.fs.recvDataNum++
 				fm.fs.recvDataNumsMap[f.Id]++
 			}
+			gMetricRecvData.Inc()
 			//loggo.Debug("debugid %v recv data %v %v", fm.debugid, f.Id, len(f.Data.Data))
 		} else if f.Type == (int32)(Frame_PING) {
 			fm.processPing(f)
@@ -318,8 +396,9 @@ func (fm *FrameMgr) preProcessRecvList() (map[int32]int, map[int32]int, map[int3
 	return tmpreq, tmpack, tmpackto
 }
 
-func (fm *FrameMgr) processRecvList(tmpreq map[int32]int, tmpack map[int32]int, tmpackto map[int32]*Frame) {
+func (fm *FrameMgr) processRecvList(cur int64, tmpreq map[int32]int, tmpack map[int32]int, tmpackto map[int32]*Frame) {
 
+	lost := 0
 	for id, num := range tmpreq {
 		err, value := fm.sendwin.Get(int(id))
 		if err != nil {
@@ -331,6 +410,9 @@ func (fm *FrameMgr) processRecvList(tmpreq map[int32]int, tmpack map[int32]int,
 		}
 		f := value.(*Frame)
 		if f.Id == id {
+			if !f.Acked {
+				lost++
+			}
 			f.Resend = true
 			//loggo.Debug("debugid %v choose resend win %v %v", fm.debugid, f.Id, len(f.Data.Data))
 		} else {
@@ -341,8 +423,14 @@ func (fm *FrameMgr) processRecvList(tmpreq map[int32]int, tmpack map[int32]int,
 			fm.fs.recvReqNum += num
 			fm.fs.recvReqNumsMap[id] += num
 		}
+		gMetricRecvReq.Add(float64(num))
+	}
+
+	if lost > 0 {
+		fm.cc.OnLoss(lost, cur)
 	}
 
+	acked := 0
 	for id, num := range tmpack {
 		err, value := fm.sendwin.Get(int(id))
 		if err != nil {
@@ -353,6 +441,9 @@ func (fm *FrameMgr) processRecvList(tmpreq map[int32]int, tmpack map[int32]int,
 		}
 		f := value.(*Frame)
 		if f.Id == id {
+			if !f.Acked {
+				acked++
+			}
 			f.Acked = true
 			//loggo.Debug("debugid %v remove send win %v %v", fm.debugid, f.Id, len(f.Data.Data))
 		} else {
@@ -363,6 +454,18 @@ func (fm *FrameMgr) processRecvList(tmpreq map[int32]int, tmpack map[int32]int,
 			fm.fs.recvAckNum += num
 			fm.fs.recvAckNumsMap[id] += num
 		}
+		gMetricRecvAck.Add(float64(num))
+	}
+
+	if acked > 0 {
+		fm.lastAckTime = cur
+		fm.cc.OnAck(acked, cur)
+		if fm.fastresend > 0 {
+			fast := fm.checkFastResend(tmpack)
+			if fast > 0 {
+				fm.cc.OnLoss(fast, cur)
+			}
+		}
 	}
 
 	for !fm.sendwin.Empty() {
@@ -381,6 +484,7 @@ func (fm *FrameMgr) processRecvList(tmpreq map[int32]int, tmpack map[int32]int,
 				loggo.Error("sendwin PopFront fail ")
 				break
 			}
+			delete(fm.dupgapmap, f.Id)
 		} else {
 			f.Resend = true
 			break
@@ -388,39 +492,159 @@ func (fm *FrameMgr) processRecvList(tmpreq map[int32]int, tmpack map[int32]int,
 	}
 
 	if len(tmpackto) > 0 {
-		tmpsize := common.MinOfInt(len(tmpackto), fm.frame_max_size/2/4)
-		tmp := make([]int32, len(tmpackto))
-		index := 0
+		tmp := make([]int32, 0, len(tmpackto))
 		for id, rf := range tmpackto {
 			if fm.addToRecvWin(rf) {
-				tmp[index] = id
-				index++
+				tmp = append(tmp, id)
 				if fm.openstat > 0 {
 					fm.fs.sendAckNum++
 					fm.fs.sendAckNumsMap[id]++
 				}
-				if index >= tmpsize {
-					f := &Frame{Type: (int32)(Frame_ACK), Resend: false, Sendtime: 0,
-						Id:     0,
-						Dataid: tmp[0:index]}
-					fm.sendFrame(f)
-					index = 0
-					tmp = make([]int32, len(tmpackto))
-					//loggo.Debug("debugid %v send ack %v %v", fm.debugid, f.Id, common.Int32ArrayToString(f.Dataid, ","))
-				}
+				gMetricSendAck.Inc()
 				//loggo.Debug("debugid %v add data to win %v %v", fm.debugid, rf.Id, len(rf.Data.Data))
 			}
 		}
-		if index > 0 {
-			f := &Frame{Type: (int32)(Frame_ACK), Resend: false, Sendtime: 0,
-				Id:     0,
-				Dataid: tmp[0:index]}
-			fm.sendFrame(f)
-			//loggo.Debug("debugid %v send ack %v %v", fm.debugid, f.Id, common.Int32ArrayToString(f.Dataid, ","))
+		if len(tmp) > 0 {
+			fm.sendAck(tmp)
 		}
 	}
 }
 
+// sendAck send the ids in Dataidrange if the peer has told us it can read them, else in Dataid, one frame has the ids of half frame size at most
+func (fm *FrameMgr) sendAck(ids []int32) {
+	fm.sortId(ids)
+	tmpsize := common.MaxOfInt(fm.frame_max_size/2/4/3, 1)
+	for len(ids) > 0 {
+		n := common.MinOfInt(len(ids), tmpsize)
+		f := &Frame{Type: (int32)(Frame_ACK), Resend: false, Sendtime: 0,
+			Id: 0}
+		if fm.peercaps&FRAME_CAP_ACK_RANGE != 0 {
+			f.Dataidrange = fm.idToRange(ids[0:n])
+		} else {
+			f.Dataid = make([]int32, n)
+			copy(f.Dataid, ids[0:n])
+		}
+		fm.sendFrame(f)
+		ids = ids[n:]
+		//loggo.Debug("debugid %v send ack %v %v", fm.debugid, f.Id, common.Int32ArrayToString(f.Dataidrange, ","))
+	}
+}
+
+// the pos of id counted from recvid-windowsize, so that the ids can wrap around frame_max_id
+func (fm *FrameMgr) idPos(id int32) int32 {
+	base := fm.recvid - fm.windowsize
+	if base < 0 {
+		base += fm.frame_max_id
+	}
+	return (id - base + fm.frame_max_id) % fm.frame_max_id
+}
+
+func (fm *FrameMgr) sortId(ids []int32) {
+	sort.Slice(ids, func(i, j int) bool {
+		return fm.idPos(ids[i]) < fm.idPos(ids[j])
+	})
+}
+
+// ids -> [begin, num, begin, num ...], ids must be sorted by sortId
+func (fm *FrameMgr) idToRange(ids []int32) []int32 {
+	pos := fm.idPos
+
+	ret := make([]int32, 0)
+	for i := 0; i < len(ids); {
+		j := i + 1
+		for j < len(ids) && pos(ids[j]) == pos(ids[j-1])+1 {
+			j++
+		}
+		ret = append(ret, ids[i], int32(j-i))
+		i = j
+	}
+	return ret
+}
+
+func (fm *FrameMgr) rangeToId(ranges []int32, f func(id int32)) {
+	for i := 0; i+1 < len(ranges); i += 2 {
+		id := ranges[i]
+		num := ranges[i+1]
+		if id < 0 || id >= fm.frame_max_id || num <= 0 || num > fm.frame_max_id {
+			loggo.Error("error ack range %v %v", id, num)
+			continue
+		}
+		for j := int32(0); j < num; j++ {
+			f(id)
+			id++
+			if id >= fm.frame_max_id {
+				id = 0
+			}
+		}
+	}
+}
+
+// like dup ack in tcp, every acked frame behind an unacked frame counts one gap for it
+func (fm *FrameMgr) checkFastResend(tmpack map[int32]int) int {
+	gaps := make([]*Frame, 0)
+	gapsacked := make([]int, 0)
+	acked := 0
+	for e := fm.sendwin.FrontInter(); e != nil; e = e.Next() {
+		f := e.Value.(*Frame)
+		if f.Acked {
+			if _, ok := tmpack[f.Id]; ok {
+				acked++
+			}
+		} else if f.Sendtime != 0 {
+			gaps = append(gaps, f)
+			gapsacked = append(gapsacked, acked)
+		}
+	}
+
+	num := 0
+	for i, f := range gaps {
+		dup := acked - gapsacked[i]
+		if dup <= 0 {
+			continue
+		}
+		fm.dupgapmap[f.Id] += dup
+		if fm.dupgapmap[f.Id] >= fm.fastresend {
+			delete(fm.dupgapmap, f.Id)
+			if !f.Resend {
+				f.Resend = true
+				num++
+			}
+		}
+	}
+	if fm.openstat > 0 {
+		fm.fs.fastResendNum += num
+	}
+	gMetricFastResend.Add(float64(num))
+	return num
+}
+
+// no ack for a while means the tail of sendwin may be lost, no frame behind it can trigger REQ or fast resend.
+// resend the last unacked frame, so the remote can find the gap
+func (fm *FrameMgr) tailProbe(cur int64) {
+	pto := common.MaxOfInt64(2*fm.rttns, int64(10*time.Millisecond))
+	if cur-fm.lastAckTime < pto || cur-fm.lastProbeTime < pto {
+		return
+	}
+
+	var last *Frame
+	for e := fm.sendwin.FrontInter(); e != nil; e = e.Next() {
+		f := e.Value.(*Frame)
+		if !f.Acked && f.Sendtime != 0 {
+			last = f
+		}
+	}
+	if last == nil || cur-last.Sendtime < pto {
+		return
+	}
+
+	fm.lastProbeTime = cur
+	last.Resend = true
+	if fm.openstat > 0 {
+		fm.fs.tailProbeNum++
+	}
+	gMetricTailProbe.Inc()
+}
+
 func (fm *FrameMgr) addToRecvWin(rf *Frame) bool {
 
 	if !fm.isIdInRange(rf.Id, fm.frame_max_id) {
@@ -429,11 +653,13 @@ func (fm *FrameMgr) addToRecvWin(rf *Frame) bool {
 			if fm.openstat > 0 {
 				fm.fs.recvOldNum++
 			}
+			gMetricRecvOld.Inc()
 			return true
 		}
 		if fm.openstat > 0 {
 			fm.fs.recvOutWinNum++
 		}
+		gMetricRecvOutWin.Inc()
 		return false
 	}
 
@@ -561,6 +787,7 @@ func (fm *FrameMgr) combineWindowToRecvBuffer(cur int64) {
 				fm.fs.sendReqNum++
 				fm.fs.sendReqNumsMap[id]++
 			}
+			gMetricSendReq.Inc()
 		}
 		fm.sendFrame(f)
 		//loggo.Debug("debugid %v send req %v %v", fm.debugid, f.Id, common.Int32ArrayToString(f.Dataid, ","))
@@ -604,7 +831,7 @@ func (fm *FrameMgr) ping() {
 	if cur-fm.lastPingTime > (int64)(time.Second) {
 		fm.lastPingTime = cur
 		f := &Frame{Type: (int32)(Frame_PING), Resend: false, Sendtime: cur,
-			Id: 0}
+			Id: 0, Caps: FRAME_CAPS}
 		fm.sendFrame(f)
 		//loggo.Debug("debugid %v send ping %v", fm.debugid, cur)
 		if fm.openstat > 0 {
@@ -638,8 +865,9 @@ func (fm *FrameMgr) hb() {
 }
 
 func (fm *FrameMgr) processPing(f *Frame) {
+	fm.peercaps = f.Caps
 	rf := &Frame{Type: (int32)(Frame_PONG), Resend: false, Sendtime: f.Sendtime,
-		Id: 0}
+		Id: 0, Caps: FRAME_CAPS}
 	fm.sendFrame(rf)
 	if fm.openstat > 0 {
 		fm.fs.recvping++
@@ -649,10 +877,13 @@ func (fm *FrameMgr) processPing(f *Frame) {
 }
 
 func (fm *FrameMgr) processPong(f *Frame) {
+	fm.peercaps = f.Caps
 	cur := time.Now().UnixNano()
 	if cur > f.Sendtime {
 		rtt := cur - f.Sendtime
 		fm.rttns = (fm.rttns + rtt) / 2
+		gMetricRtt.Observe(time.Duration(rtt).Seconds())
+		fm.cc.OnRtt(rtt, cur)
 		if fm.openstat > 0 {
 			fm.fs.recvpong++
 		}
@@ -761,7 +992,9 @@ func (fm *FrameMgr) second(cur int64) {
 				"sendping %v\nrecvping %v\nsendpong %v\nrecvpong %v\n"+
 				"sendwin %v\nrecvwin %v\n"+
 				"recvOldNum %v\nrecvOutWinNum %v\n"+
-				"rtt %v\n",
+				"fastResendNum %v\ntailProbeNum %v\n"+
+				"rtt %v\n"+
+				"congestion %v\nwin %v\nrto %v\n",
 				fs.sendDataNum, fs.recvDataNum,
 				fs.sendReqNum, fs.recvReqNum,
 				fs.sendAckNum, fs.recvAckNum,
@@ -772,7 +1005,9 @@ func (fm *FrameMgr) second(cur int64) {
 				fs.sendpong, fs.recvpong,
 				fm.sendwin.Size(), fm.recvwin.Size(),
 				fs.recvOldNum, fs.recvOutWinNum,
-				time.Duration(fm.rttns).String())
+				fs.fastResendNum, fs.tailProbeNum,
+				time.Duration(fm.rttns).String(),
+				fm.cc.Name(), fm.sendWinLimit(), time.Duration(fm.cc.RTO()).String())
 			fm.resetStat()
 		}
 
@@ -1,9 +1,12 @@
 package frame
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/esrrhs/go-engine/src/common"
 	"github.com/esrrhs/go-engine/src/rbuffergo"
+	"github.com/golang/protobuf/proto"
+	"math/rand"
 	"testing"
 	"time"
 )
@@ -97,5 +100,152 @@ func Test0001(t *testing.T) {
 	fm.resetStat()
 	fm.sendwin = rbuffergo.NewROBuffer(100, 0, 10000)
 	fm.recvwin = rbuffergo.NewROBuffer(100, 0, 10000)
-	fm.printStat(time.Now().UnixNano())
+	fm.cc, _ = NewCongestion(CONGESTION_FIXED, 100, 200)
+	fm.second(time.Now().UnixNano())
+}
+
+func Test0002(t *testing.T) {
+	fm := NewFrameMgr(100, 100, 1024, 20, 200, 0, 0)
+	fm.recvid = 95
+
+	ids := []int32{97, 3, 96, 98, 0, 1, 99, 5, 2}
+	fm.sortId(ids)
+	r := fm.idToRange(ids)
+	fmt.Println("fm.idToRange  = ", r)
+	if len(r) != 4 || r[0] != 96 || r[1] != 8 || r[2] != 5 || r[3] != 1 {
+		t.Error("idToRange fail", r)
+	}
+
+	var back []int32
+	fm.rangeToId(r, func(id int32) {
+		back = append(back, id)
+	})
+	fmt.Println("fm.rangeToId  = ", back)
+	if len(back) != len(ids) {
+		t.Error("rangeToId fail", back)
+	}
+
+	// the peer may have a bigger window
+	back = nil
+	fm.rangeToId([]int32{90, 50, 0, 101}, func(id int32) {
+		back = append(back, id)
+	})
+	if len(back) != 50 || back[49] != 39 {
+		t.Error("rangeToId big range fail", len(back))
+	}
+
+	countAck := func() (int, int) {
+		ackids := 0
+		ackranges := 0
+		for e := fm.sendlist.Front(); e != nil; e = e.Next() {
+			f := e.Value.(*Frame)
+			fmt.Println("fm.sendAck  = ", f.Dataid, f.Dataidrange)
+			ackids += len(f.Dataid)
+			fm.rangeToId(f.Dataidrange, func(id int32) {
+				ackranges++
+			})
+		}
+		fm.sendlist.Init()
+		return ackids, ackranges
+	}
+
+	// only ids before the peer tells its caps
+	fm.sendAck(ids)
+	ackids, ackranges := countAck()
+	if ackids != len(ids) || ackranges != 0 {
+		t.Error("sendAck old peer fail", ackids, ackranges)
+	}
+
+	fm.processPing(&Frame{Type: int32(Frame_PING), Caps: FRAME_CAP_ACK_RANGE})
+	fm.sendlist.Init()
+	fm.sendAck(ids)
+	ackids, ackranges = countAck()
+	if ackids != 0 || ackranges != len(ids) {
+		t.Error("sendAck new peer fail", ackids, ackranges)
+	}
+}
+
+type lossyLink struct {
+	r       *rand.Rand
+	loss    float64
+	maxack  int
+	dropped int
+}
+
+func (l *lossyLink) transfer(from *FrameMgr, to *FrameMgr) {
+	sendlist := from.GetSendList()
+	for e := sendlist.Front(); e != nil; e = e.Next() {
+		f := e.Value.(*Frame)
+		mb, _ := from.MarshalFrame(f)
+		if f.Type == int32(Frame_ACK) && len(mb) > l.maxack {
+			l.maxack = len(mb)
+		}
+		if l.r.Float64() < l.loss {
+			l.dropped++
+			continue
+		}
+		rf := &Frame{}
+		proto.Unmarshal(mb, rf)
+		to.OnRecvFrame(rf)
+	}
+}
+
+func runLossyLink(t *testing.T, loss float64, fastresend int) (time.Duration, *lossyLink, *FrameStat) {
+	src := NewFrameMgr(500, 100000, 1024*1024, 1000, 200, 0, 1)
+	dst := NewFrameMgr(500, 100000, 1024*1024, 1000, 200, 0, 1)
+	src.SetFastResend(fastresend)
+	dst.SetFastResend(fastresend)
+
+	data := make([]byte, 1024*1024)
+	r := rand.New(rand.NewSource(1))
+	r.Read(data)
+
+	link := &lossyLink{r: rand.New(rand.NewSource(2)), loss: loss}
+
+	recv := make([]byte, 0, len(data))
+	cur := 0
+	fs := &FrameStat{}
+	begin := time.Now()
+	for len(recv) < len(data) && time.Now().Sub(begin) < time.Second*30 {
+		if cur < len(data) {
+			n := common.MinOfInt(src.GetSendBufferLeft(), len(data)-cur)
+			src.WriteSendBuffer(data[cur : cur+n])
+			cur += n
+		}
+
+		src.Update()
+		link.transfer(src, dst)
+		dst.Update()
+		link.transfer(dst, src)
+
+		for dst.GetRecvBufferSize() > 0 {
+			b := dst.GetRecvReadLineBuffer()
+			recv = append(recv, b...)
+			dst.SkipRecvBuffer(len(b))
+		}
+
+		fs.fastResendNum += src.fs.fastResendNum
+		fs.tailProbeNum += src.fs.tailProbeNum
+		fs.sendDataNum += src.fs.sendDataNum
+		src.resetStat()
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if !bytes.Equal(recv, data) {
+		t.Error("lossy link data diff", len(recv), len(data))
+	}
+	return time.Now().Sub(begin), link, fs
+}
+
+func Test0003(t *testing.T) {
+	cost, link, fs := runLossyLink(t, 0.1, FRAME_FAST_RESEND)
+	fmt.Println("lossy link cost ", cost, " dropped ", link.dropped, " max ack ", link.maxack,
+		" send data ", fs.sendDataNum, " fast resend ", fs.fastResendNum, " tail probe ", fs.tailProbeNum)
+	if fs.fastResendNum <= 0 {
+		t.Error("no fast resend on lossy link")
+	}
+	if link.maxack > 500 {
+		t.Error("ack frame too big", link.maxack)
+	}
 }
@@ -0,0 +1,21 @@
+package frame
+
+import (
+	"github.com/esrrhs/go-engine/src/metrics"
+)
+
+// published always, FrameStat is only kept when openstat
+var (
+	gMetricResend     = metrics.Default.Counter("frame_resend_total", "data frames resent")
+	gMetricRtt        = metrics.Default.Histogram("frame_rtt_seconds", "rtt of the frame ping", nil)
+	gMetricSendData   = metrics.Default.Counter("frame_send_data_total", "data frames sent, include resend")
+	gMetricRecvData   = metrics.Default.Counter("frame_recv_data_total", "data frames received")
+	gMetricSendReq    = metrics.Default.Counter("frame_send_req_total", "frames requested to resend by us")
+	gMetricRecvReq    = metrics.Default.Counter("frame_recv_req_total", "frames requested to resend by remote")
+	gMetricSendAck    = metrics.Default.Counter("frame_send_ack_total", "frames acked by us")
+	gMetricRecvAck    = metrics.Default.Counter("frame_recv_ack_total", "frames acked by remote")
+	gMetricRecvOld    = metrics.Default.Counter("frame_recv_old_total", "duplicate frames received")
+	gMetricRecvOutWin = metrics.Default.Counter("frame_recv_out_win_total", "frames received out of window")
+	gMetricFastResend = metrics.Default.Counter("frame_fast_resend_total", "frames fast resent")
+	gMetricTailProbe  = metrics.Default.Counter("frame_tail_probe_total", "tail probes sent")
+)
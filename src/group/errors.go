@@ -0,0 +1,38 @@
+package group
+
+import (
+	"strings"
+)
+
+// GoError is the error returned by the goroutine Name in the Group
+type GoError struct {
+	Group string
+	Name  string
+	Err   error
+}
+
+func (e *GoError) Error() string {
+	return e.Group + "/" + e.Name + ": " + e.Err.Error()
+}
+
+func (e *GoError) Unwrap() error {
+	return e.Err
+}
+
+type MultiError []error
+
+func (m MultiError) Error() string {
+	var strs []string
+	for _, err := range m {
+		strs = append(strs, err.Error())
+	}
+	return strings.Join(strs, "; ")
+}
+
+// Err return nil if no error
+func (m MultiError) Err() error {
+	if len(m) <= 0 {
+		return nil
+	}
+	return m
+}
@@ -1,10 +1,13 @@
 package group
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/esrrhs/go-engine/src/common"
 	"github.com/esrrhs/go-engine/src/loggo"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,15 +19,43 @@ type Group struct {
 	wg       int32
 	errOnce  sync.Once
 	err      error
-	isexit   bool
+	errs     []error
 	exitfunc func()
 	donech   chan int
 	sonname  map[string]int
+	restart  map[string]int
 	lock     sync.Mutex
 	name     string
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
 func NewGroup(name string, father *Group, exitfunc func()) *Group {
+	var ctx context.Context
+	if father != nil {
+		ctx = father.Context()
+	} else {
+		ctx = context.Background()
+	}
+	return newGroup(name, father, ctx, exitfunc)
+}
+
+// NewGroupWithContext return a Group exits when the ctx is done
+func NewGroupWithContext(name string, ctx context.Context, exitfunc func()) *Group {
+	g := newGroup(name, nil, ctx, exitfunc)
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				g.exit(ctx.Err())
+			case <-g.donech:
+			}
+		}()
+	}
+	return g
+}
+
+func newGroup(name string, father *Group, ctx context.Context, exitfunc func()) *Group {
 	g := &Group{
 		father:   father,
 		exitfunc: exitfunc,
@@ -33,8 +64,10 @@ func NewGroup(name string, father *Group, exitfunc func()) *Group {
 	defer g.lock.Unlock()
 	g.donech = make(chan int)
 	g.sonname = make(map[string]int)
+	g.restart = make(map[string]int)
 	g.son = make(map[*Group]int)
 	g.name = name
+	g.ctx, g.cancel = context.WithCancel(ctx)
 
 	if father != nil {
 		father.addson(g)
@@ -43,6 +76,11 @@ func NewGroup(name string, father *Group, exitfunc func()) *Group {
 	return g
 }
 
+// Context is canceled when the group exits, pass it to the calls inside Go
+func (g *Group) Context() context.Context {
+	return g.ctx
+}
+
 func (g *Group) addson(son *Group) {
 	g.lock.Lock()
 	defer g.lock.Unlock()
@@ -76,45 +114,122 @@ func (g *Group) done() {
 }
 
 func (g *Group) IsExit() bool {
-	return g.isexit
+	select {
+	case <-g.donech:
+		return true
+	default:
+		return false
+	}
 }
 
+// Error return the error made the group exit
 func (g *Group) Error() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
 	return g.err
 }
 
+// Errors return all the errors returned by the goroutines, not only the first one
+func (g *Group) Errors() MultiError {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	ret := make(MultiError, len(g.errs))
+	copy(ret, g.errs)
+	return ret
+}
+
+func (g *Group) addErr(name string, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.errs = append(g.errs, &GoError{Group: g.name, Name: name, Err: err})
+}
+
 func (g *Group) exit(err error) {
 	g.errOnce.Do(func() {
+		g.lock.Lock()
 		g.err = err
-		g.isexit = true
+		g.lock.Unlock()
 		close(g.donech)
+		g.cancel()
 		if g.exitfunc != nil {
 			g.exitfunc()
 		}
 
+		g.lock.Lock()
+		var sons []*Group
 		for son, _ := range g.son {
+			sons = append(sons, son)
+		}
+		g.lock.Unlock()
+
+		for _, son := range sons {
 			son.exit(err)
 		}
 	})
 }
 
-func (g *Group) runningmap() string {
+// Running return the running goroutines of the group and its sons
+func (g *Group) Running() *RunningInfo {
 	g.lock.Lock()
-	defer g.lock.Unlock()
-	ret := ""
-	tmp := make(map[string]int)
+	ret := &RunningInfo{
+		Name:    g.name,
+		Exit:    g.IsExit(),
+		Running: make(map[string]int),
+		Restart: make(map[string]int),
+	}
 	for k, v := range g.sonname {
 		if v > 0 {
-			tmp[k] = v
+			ret.Running[k] = v
 		}
 	}
-	ret += fmt.Sprintf("%v", tmp) + "\n"
+	for k, v := range g.restart {
+		ret.Restart[k] = v
+	}
+	var sons []*Group
 	for son, _ := range g.son {
-		ret += son.runningmap()
+		sons = append(sons, son)
+	}
+	g.lock.Unlock()
+
+	for _, son := range sons {
+		ret.Sons = append(ret.Sons, son.Running())
 	}
+	sort.Slice(ret.Sons, func(i, j int) bool {
+		return ret.Sons[i].Name < ret.Sons[j].Name
+	})
 	return ret
 }
 
+type RunningInfo struct {
+	Name    string
+	Exit    bool
+	Running map[string]int // goroutine name -> num
+	Restart map[string]int // goroutine name -> restart times, for Supervise
+	Sons    []*RunningInfo
+}
+
+func (r *RunningInfo) String() string {
+	var b strings.Builder
+	r.write(&b, 0)
+	return b.String()
+}
+
+func (r *RunningInfo) write(b *strings.Builder, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	fmt.Fprintf(b, "%s exit=%v running=%v", r.Name, r.Exit, r.Running)
+	if len(r.Restart) > 0 {
+		fmt.Fprintf(b, " restart=%v", r.Restart)
+	}
+	b.WriteString("\n")
+	for _, son := range r.Sons {
+		son.write(b, depth+1)
+	}
+}
+
+func (g *Group) runningmap() string {
+	return g.Running().String()
+}
+
 func (g *Group) Done() <-chan int {
 	return g.donech
 }
@@ -122,7 +237,7 @@ func (g *Group) Done() <-chan int {
 func (g *Group) Go(name string, f func() error) {
 	g.lock.Lock()
 	defer g.lock.Unlock()
-	if g.isexit {
+	if g.IsExit() {
 		return
 	}
 	g.add()
@@ -133,6 +248,7 @@ func (g *Group) Go(name string, f func() error) {
 		defer g.done()
 
 		if err := f(); err != nil {
+			g.addErr(name, err)
 			g.exit(err)
 		}
 
@@ -149,8 +265,8 @@ func (g *Group) Stop() {
 func (g *Group) Wait() error {
 	last := int64(0)
 	begin := int64(0)
-	for g.wg != 0 {
-		if g.isexit {
+	for atomic.LoadInt32(&g.wg) != 0 {
+		if g.IsExit() {
 			cur := time.Now().Unix()
 			if last == 0 {
 				last = cur
@@ -158,7 +274,7 @@ func (g *Group) Wait() error {
 			} else {
 				if cur-last > 30 {
 					last = cur
-					loggo.Error("Group Wait too long %s %d %s %v", g.name, g.wg,
+					loggo.Error("Group Wait too long %s %d %s %v", g.name, atomic.LoadInt32(&g.wg),
 						time.Duration((cur-begin)*int64(time.Second)).String(), g.runningmap())
 				}
 			}
@@ -172,5 +288,5 @@ func (g *Group) Wait() error {
 	if g.father != nil {
 		g.father.removeson(g)
 	}
-	return g.err
+	return g.Error()
 }
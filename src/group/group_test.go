@@ -1,8 +1,10 @@
 package group
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -48,7 +50,6 @@ func Test0003(t *testing.T) {
 				fmt.Println("tick")
 			}
 		}
-		return nil
 	})
 	g.Go("", func() error {
 		time.Sleep(time.Second * 5)
@@ -69,7 +70,6 @@ func Test0004(t *testing.T) {
 				fmt.Println("tick father")
 			}
 		}
-		return nil
 	})
 	g.Go("", func() error {
 		time.Sleep(time.Second * 10)
@@ -84,7 +84,6 @@ func Test0004(t *testing.T) {
 				fmt.Println("tick")
 			}
 		}
-		return nil
 	})
 	gg.Go("", func() error {
 		time.Sleep(time.Second * 5)
@@ -108,7 +107,6 @@ func Test0005(t *testing.T) {
 				fmt.Println("tick 1")
 			}
 		}
-		return nil
 	})
 
 	g.Go("", func() error {
@@ -120,7 +118,6 @@ func Test0005(t *testing.T) {
 				fmt.Println("tick 2")
 			}
 		}
-		return nil
 	})
 
 	time.Sleep(time.Second * 5)
@@ -185,7 +182,6 @@ func Test0007(t *testing.T) {
 				fmt.Println("tick 3")
 			}
 		}
-		return nil
 	})
 
 	time.Sleep(time.Second * 5)
@@ -220,3 +216,147 @@ func Test008(t *testing.T) {
 	g.Wait()
 
 }
+
+func Test0009Context(t *testing.T) {
+	g := NewGroup("father", nil, nil)
+	gg := NewGroup("son", g, nil)
+	gg.Go("wait ctx", func() error {
+		<-gg.Context().Done()
+		return nil
+	})
+
+	time.Sleep(time.Millisecond * 100)
+	if gg.Context().Err() != nil {
+		t.Error("canceled before stop")
+	}
+	g.Stop()
+	gg.Wait()
+	g.Wait()
+	if gg.Context().Err() != context.Canceled {
+		t.Error("son ctx not canceled")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g = NewGroupWithContext("ctx", ctx, nil)
+	g.Go("wait", func() error {
+		<-g.Done()
+		return nil
+	})
+	cancel()
+	err := g.Wait()
+	fmt.Println(err)
+	if err != context.Canceled {
+		t.Error("parent ctx not propagate", err)
+	}
+}
+
+func Test0010Errors(t *testing.T) {
+	g := NewGroup("g", nil, nil)
+	e1 := errors.New("e1")
+	g.Go("a", func() error {
+		return e1
+	})
+	g.Go("b", func() error {
+		<-g.Done()
+		return errors.New("e2")
+	})
+	g.Go("c", func() error {
+		<-g.Done()
+		return nil
+	})
+	err := g.Wait()
+	if err != e1 {
+		t.Error("first error diff", err)
+	}
+
+	errs := g.Errors()
+	fmt.Println(errs)
+	if len(errs) != 2 || !errors.Is(errs[0], e1) || errs.Error() != "g/a: e1; g/b: e2" {
+		t.Error("errors diff", errs)
+	}
+	if errs[1].(*GoError).Name != "b" {
+		t.Error("error name diff")
+	}
+	if NewGroup("", nil, nil).Errors().Err() != nil {
+		t.Error("empty errors not nil")
+	}
+}
+
+func Test0011Supervise(t *testing.T) {
+	g := NewGroup("g", nil, nil)
+	n := 0
+	g.Supervise("flaky", RestartPolicy{Mode: RESTART_ON_ERROR, Backoff: time.Millisecond * 10}, func() error {
+		n++
+		switch n {
+		case 1, 2:
+			return fmt.Errorf("fail %d", n)
+		case 3:
+			panic("crash")
+		}
+		return nil
+	})
+	err := g.Wait()
+	if err != nil || n != 4 {
+		t.Error("restart diff", err, n)
+	}
+	if len(g.Errors()) != 3 || g.Running().Restart["flaky"] != 3 {
+		t.Error("restart errors diff", g.Errors(), g.Running())
+	}
+
+	g = NewGroup("g", nil, nil)
+	n = 0
+	g.Supervise("bad", RestartPolicy{Mode: RESTART_ON_ERROR, MaxRestart: 2}, func() error {
+		n++
+		return errors.New("bad")
+	})
+	err = g.Wait()
+	if err == nil || n != 3 || len(g.Errors()) != 3 {
+		t.Error("max restart diff", err, n)
+	}
+
+	g = NewGroup("g", nil, nil)
+	n = 0
+	g.Supervise("always", RestartPolicy{Mode: RESTART_ALWAYS, Backoff: time.Millisecond * 10}, func() error {
+		n++
+		if n >= 3 {
+			g.Stop()
+		}
+		return nil
+	})
+	g.Wait()
+	if n != 3 || len(g.Errors()) != 0 {
+		t.Error("always diff", n)
+	}
+}
+
+func Test0012Running(t *testing.T) {
+	g := NewGroup("father", nil, nil)
+	gg := NewGroup("son", g, nil)
+	for i := 0; i < 2; i++ {
+		gg.Go("loop", func() error {
+			<-gg.Done()
+			return nil
+		})
+	}
+	g.Go("main", func() error {
+		<-g.Done()
+		return nil
+	})
+
+	r := g.Running()
+	fmt.Print(r)
+	if r.Running["main"] != 1 || len(r.Sons) != 1 || r.Sons[0].Running["loop"] != 2 {
+		t.Error("running diff", r)
+	}
+	if !strings.Contains(r.String(), "\n  son exit=false running=map[loop:2]") {
+		t.Error("running string diff", r)
+	}
+
+	g.Stop()
+	gg.Wait()
+	g.Wait()
+	r = g.Running()
+	if !r.Exit || len(r.Running) != 0 || len(r.Sons) != 0 {
+		t.Error("running after stop diff", r)
+	}
+}
@@ -0,0 +1,99 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"github.com/esrrhs/go-engine/src/common"
+	"github.com/esrrhs/go-engine/src/loggo"
+	"time"
+)
+
+const (
+	RESTART_NEVER    = iota // same as Go
+	RESTART_ON_ERROR        // restart when return error or panic
+	RESTART_ALWAYS          // restart when return, until the group exits
+)
+
+type RestartPolicy struct {
+	Mode       int
+	MaxRestart int           // the group exits with the error after restarted MaxRestart times, 0 for no limit
+	Backoff    time.Duration // wait before restart, doubled each time
+	MaxBackoff time.Duration // 0 for no limit
+}
+
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		Mode:       RESTART_ON_ERROR,
+		MaxRestart: 0,
+		Backoff:    time.Second,
+		MaxBackoff: time.Minute,
+	}
+}
+
+// Supervise run f like Go, and restart it as the policy says, the errors before restart are kept in Errors
+func (g *Group) Supervise(name string, policy RestartPolicy, f func() error) {
+	g.Go(name, func() error {
+		backoff := policy.Backoff
+		restart := 0
+		for {
+			err := runSafe(f)
+			if g.IsExit() {
+				return nil
+			}
+
+			switch policy.Mode {
+			case RESTART_ON_ERROR:
+				if err == nil {
+					return nil
+				}
+			case RESTART_ALWAYS:
+			default:
+				return err
+			}
+
+			if policy.MaxRestart > 0 && restart >= policy.MaxRestart {
+				if err == nil {
+					return nil
+				}
+				return err
+			}
+
+			if err != nil {
+				g.addErr(name, err)
+				loggo.Warn("Group Supervise restart %s %s %v %v", g.name, name, restart, err)
+			}
+			restart++
+			g.lock.Lock()
+			g.restart[name]++
+			g.lock.Unlock()
+
+			if backoff > 0 {
+				select {
+				case <-g.Done():
+					return nil
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+					backoff = policy.MaxBackoff
+				}
+			}
+		}
+	})
+}
+
+// runSafe turn the panic into error, so it can be restarted
+func runSafe(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch x := r.(type) {
+			case error:
+				err = x
+			default:
+				err = errors.New(fmt.Sprint(x))
+			}
+			loggo.Error("Group Supervise crash %s \n%s", err, common.DumpStacks())
+		}
+	}()
+	return f()
+}
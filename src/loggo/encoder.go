@@ -0,0 +1,130 @@
+package loggo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Encoder interface {
+	// Encode return one line ended with \n
+	Encode(e *Entry) []byte
+}
+
+// TextEncoder is the loggo format, the fields follow the msg as k=v
+type TextEncoder struct {
+}
+
+func (t *TextEncoder) Encode(e *Entry) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "[%v] [%v] [%v:%v] [%v] %v", levelName(e.Level), e.Time.Format(time.RFC3339Nano), e.File, e.Line, e.Func, e.Msg)
+	for _, f := range e.Fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(f.Value))
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+type JSONEncoder struct {
+}
+
+func (j *JSONEncoder) Encode(e *Entry) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	writeJSONField(&b, "level", levelName(e.Level))
+	b.WriteByte(',')
+	writeJSONField(&b, "time", e.Time.Format(time.RFC3339Nano))
+	b.WriteByte(',')
+	writeJSONField(&b, "caller", e.File+":"+strconv.Itoa(e.Line))
+	b.WriteByte(',')
+	writeJSONField(&b, "func", e.Func)
+	b.WriteByte(',')
+	writeJSONField(&b, "msg", e.Msg)
+	for _, f := range e.Fields {
+		b.WriteByte(',')
+		writeJSONField(&b, f.Key, f.Value)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func writeJSONField(b *bytes.Buffer, key string, value interface{}) {
+	kb, _ := json.Marshal(key)
+	b.Write(kb)
+	b.WriteByte(':')
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	vb, err := json.Marshal(value)
+	if err != nil {
+		vb, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(vb)
+}
+
+type LogfmtEncoder struct {
+}
+
+func (l *LogfmtEncoder) Encode(e *Entry) []byte {
+	var b bytes.Buffer
+	b.WriteString("level=")
+	b.WriteString(strings.ToLower(levelName(e.Level)))
+	b.WriteString(" time=")
+	b.WriteString(e.Time.Format(time.RFC3339Nano))
+	b.WriteString(" caller=")
+	b.WriteString(logfmtValue(e.File + ":" + strconv.Itoa(e.Line)))
+	b.WriteString(" func=")
+	b.WriteString(logfmtValue(e.Func))
+	b.WriteString(" msg=")
+	b.WriteString(logfmtValue(e.Msg))
+	for _, f := range e.Fields {
+		b.WriteByte(' ')
+		b.WriteString(logfmtKey(f.Key))
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(f.Value))
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func logfmtKey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue quote the value if it has space, = or "
+func logfmtValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case nil:
+		return "nil"
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" {
+		return `""`
+	}
+	if strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
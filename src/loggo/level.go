@@ -0,0 +1,129 @@
+package loggo
+
+import (
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var gLevelLock sync.RWMutex
+var gLevel = LEVEL_DEBUG
+var gModuleLevel = make(map[string]int)
+
+// gMinLevel is the lowest of gLevel and the module levels, for the fast check before the caller is known
+var gMinLevel int32 = LEVEL_DEBUG
+
+func iniLevel(level int, moduleLevel map[string]int) {
+	gLevelLock.Lock()
+	defer gLevelLock.Unlock()
+	gLevel = level
+	gModuleLevel = make(map[string]int)
+	for k, v := range moduleLevel {
+		gModuleLevel[k] = v
+	}
+	updateMinLevel()
+}
+
+func updateMinLevel() {
+	min := gLevel
+	for _, l := range gModuleLevel {
+		if l < min {
+			min = l
+		}
+	}
+	atomic.StoreInt32(&gMinLevel, int32(min))
+}
+
+// SetLevel change the level of the modules without their own level
+func SetLevel(level int) {
+	gLevelLock.Lock()
+	defer gLevelLock.Unlock()
+	gLevel = level
+	updateMinLevel()
+}
+
+func GetLevel() int {
+	gLevelLock.RLock()
+	defer gLevelLock.RUnlock()
+	return gLevel
+}
+
+// SetModuleLevel override the level of a module, the module is the package path or the last element of it, such as frame
+func SetModuleLevel(module string, level int) {
+	gLevelLock.Lock()
+	defer gLevelLock.Unlock()
+	gModuleLevel[module] = level
+	updateMinLevel()
+}
+
+func RemoveModuleLevel(module string) {
+	gLevelLock.Lock()
+	defer gLevelLock.Unlock()
+	delete(gModuleLevel, module)
+	updateMinLevel()
+}
+
+func GetModuleLevel() map[string]int {
+	gLevelLock.RLock()
+	defer gLevelLock.RUnlock()
+	ret := make(map[string]int)
+	for k, v := range gModuleLevel {
+		ret[k] = v
+	}
+	return ret
+}
+
+func enabled(level int) bool {
+	return int32(level) >= atomic.LoadInt32(&gMinLevel)
+}
+
+// levelOf return the level of the module the func belongs to
+func levelOf(funcName string) int {
+	gLevelLock.RLock()
+	defer gLevelLock.RUnlock()
+	if len(gModuleLevel) <= 0 {
+		return gLevel
+	}
+	pkg := moduleOf(funcName)
+	if l, ok := gModuleLevel[pkg]; ok {
+		return l
+	}
+	if l, ok := gModuleLevel[path.Base(pkg)]; ok {
+		return l
+	}
+	return gLevel
+}
+
+// moduleOf return the package path of a func name such as github.com/esrrhs/go-engine/src/frame.(*Frame).Update
+func moduleOf(funcName string) string {
+	slash := strings.LastIndex(funcName, "/")
+	dot := strings.Index(funcName[slash+1:], ".")
+	if dot < 0 {
+		return funcName
+	}
+	return funcName[:slash+1+dot]
+}
+
+// isLevel must be called by the exported IsXXX funcs directly, to get the right caller
+func isLevel(level int) bool {
+	if !enabled(level) {
+		return false
+	}
+	gLevelLock.RLock()
+	simple := len(gModuleLevel) <= 0
+	gLevelLock.RUnlock()
+	if simple {
+		return level >= GetLevel()
+	}
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return level >= GetLevel()
+	}
+	f := runtime.FuncForPC(pc)
+	if f == nil {
+		return level >= GetLevel()
+	}
+	return level >= levelOf(f.Name())
+}
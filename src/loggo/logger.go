@@ -0,0 +1,97 @@
+package loggo
+
+import (
+	"fmt"
+	"time"
+)
+
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+type Entry struct {
+	Level  int
+	Time   time.Time
+	File   string
+	Line   int
+	Func   string
+	Msg    string
+	Fields []Field
+}
+
+// Logger logs with the bound fields, such as the conn id or the client name
+type Logger struct {
+	fields []Field
+}
+
+// With return a Logger with the key/value pairs bound
+func With(kv ...interface{}) *Logger {
+	return (&Logger{}).With(kv...)
+}
+
+// With return a child Logger with the parent fields and the key/value pairs bound
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]Field, 0, len(l.fields)+len(kv)/2)
+	fields = append(fields, l.fields...)
+	return &Logger{fields: append(fields, toFields(kv)...)}
+}
+
+func (l *Logger) Fields() []Field {
+	return l.fields
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	if enabled(LEVEL_DEBUG) {
+		output(LEVEL_DEBUG, msg, l.merge(kv))
+	}
+}
+
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	if enabled(LEVEL_INFO) {
+		output(LEVEL_INFO, msg, l.merge(kv))
+	}
+}
+
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	if enabled(LEVEL_WARN) {
+		output(LEVEL_WARN, msg, l.merge(kv))
+	}
+}
+
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	if enabled(LEVEL_ERROR) {
+		output(LEVEL_ERROR, msg, l.merge(kv))
+	}
+}
+
+func (l *Logger) merge(kv []interface{}) []Field {
+	if len(kv) <= 0 {
+		return l.fields
+	}
+	fields := make([]Field, 0, len(l.fields)+len(kv)/2)
+	fields = append(fields, l.fields...)
+	return append(fields, toFields(kv)...)
+}
+
+// toFields pair the kv, a Field is taken as it is, the last value without a key goes to !BADKEY
+func toFields(kv []interface{}) []Field {
+	var ret []Field
+	for i := 0; i < len(kv); i++ {
+		if f, ok := kv[i].(Field); ok {
+			ret = append(ret, f)
+			continue
+		}
+		if i+1 >= len(kv) {
+			ret = append(ret, Field{Key: "!BADKEY", Value: kv[i]})
+			break
+		}
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		ret = append(ret, Field{Key: key, Value: kv[i+1]})
+		i++
+	}
+	return ret
+}
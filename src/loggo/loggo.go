@@ -3,12 +3,11 @@ package loggo
 import (
 	"errors"
 	"fmt"
-	"github.com/esrrhs/go-engine/src/termcolor"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,6 +26,13 @@ type Config struct {
 	NoPrint    bool
 	NoLogColor bool
 	FullPath   bool
+	Encoder    Encoder // nil for TextEncoder, used by the log file and the print
+	Sinks      []Sink  // more sinks besides the log file and the print
+
+	MaxSize      int64          // rotate the log file when it will be bigger than MaxSize, 0 for no limit
+	Compress     bool           // gzip the rotated log files
+	MaxTotalSize int64          // delete the oldest log files when all levels together are bigger than it, 0 for no limit
+	ModuleLevel  map[string]int // the level of some modules, such as frame, see SetModuleLevel
 }
 
 var gConfig Config
@@ -54,6 +60,8 @@ func Ini(config Config) {
 		panic("log prefix contain _")
 	}
 
+	iniLevel(gConfig.Level, gConfig.ModuleLevel)
+
 	Warn("loggo Ini")
 
 	gInited = true
@@ -63,128 +71,68 @@ func Ini(config Config) {
 }
 
 func Debug(format string, a ...interface{}) {
-	if gConfig.Level <= LEVEL_DEBUG {
-		str := genLog(LEVEL_DEBUG, format, a...)
-		if !gConfig.NoLogFile {
-			file := openLog(LEVEL_DEBUG)
-			defer file.Close()
-			file.WriteString(str)
-		}
-		if !gConfig.NoPrint {
-			if !gConfig.NoLogColor {
-				fmt.Print(termcolor.FgString(str, 0, 0, 255))
-			} else {
-				fmt.Print(str)
-			}
-		}
+	if enabled(LEVEL_DEBUG) {
+		output(LEVEL_DEBUG, fmt.Sprintf(format, a...), nil)
 	}
 }
 
 func Info(format string, a ...interface{}) {
-	if gConfig.Level <= LEVEL_INFO {
-		str := genLog(LEVEL_INFO, format, a...)
-		if !gConfig.NoLogFile {
-			file := openLog(LEVEL_INFO)
-			defer file.Close()
-			file.WriteString(str)
-		}
-		if gConfig.Level <= LEVEL_DEBUG {
-			if !gConfig.NoLogFile {
-				file1 := openLog(LEVEL_DEBUG)
-				defer file1.Close()
-				file1.WriteString(str)
-			}
-		}
-		if !gConfig.NoPrint {
-			if !gConfig.NoLogColor {
-				fmt.Print(termcolor.FgString(str, 0, 255, 0))
-			} else {
-				fmt.Print(str)
-			}
-		}
+	if enabled(LEVEL_INFO) {
+		output(LEVEL_INFO, fmt.Sprintf(format, a...), nil)
 	}
 }
 
 func Warn(format string, a ...interface{}) {
-	if gConfig.Level <= LEVEL_WARN {
-		str := genLog(LEVEL_WARN, format, a...)
-		if !gConfig.NoLogFile {
-			file := openLog(LEVEL_WARN)
-			defer file.Close()
-			file.WriteString(str)
-		}
-		if gConfig.Level <= LEVEL_INFO {
-			if !gConfig.NoLogFile {
-				file1 := openLog(LEVEL_INFO)
-				defer file1.Close()
-				file1.WriteString(str)
-			}
-		}
-		if gConfig.Level <= LEVEL_DEBUG {
-			if !gConfig.NoLogFile {
-				file2 := openLog(LEVEL_DEBUG)
-				defer file2.Close()
-				file2.WriteString(str)
-			}
-		}
-		if !gConfig.NoPrint {
-			if !gConfig.NoLogColor {
-				fmt.Print(termcolor.FgString(str, 255, 255, 0))
-			} else {
-				fmt.Print(str)
-			}
-		}
+	if enabled(LEVEL_WARN) {
+		output(LEVEL_WARN, fmt.Sprintf(format, a...), nil)
 	}
 }
 
 func Error(format string, a ...interface{}) {
-	if gConfig.Level <= LEVEL_ERROR {
-		str := genLog(LEVEL_ERROR, format, a...)
-		if !gConfig.NoLogFile {
-			file := openLog(LEVEL_ERROR)
-			defer file.Close()
-			file.WriteString(str)
-		}
-		if gConfig.Level <= LEVEL_WARN {
-			if !gConfig.NoLogFile {
-				file0 := openLog(LEVEL_WARN)
-				defer file0.Close()
-				file0.WriteString(str)
-			}
-		}
-		if gConfig.Level <= LEVEL_INFO {
-			if !gConfig.NoLogFile {
-				file1 := openLog(LEVEL_INFO)
-				defer file1.Close()
-				file1.WriteString(str)
-			}
-		}
-		if gConfig.Level <= LEVEL_DEBUG {
-			if !gConfig.NoLogFile {
-				file2 := openLog(LEVEL_DEBUG)
-				defer file2.Close()
-				file2.WriteString(str)
-			}
-		}
-		if !gConfig.NoPrint {
-			if !gConfig.NoLogColor {
-				fmt.Print(termcolor.FgString(str, 255, 0, 0))
-			} else {
-				fmt.Print(str)
-			}
-		}
+	if enabled(LEVEL_ERROR) {
+		output(LEVEL_ERROR, fmt.Sprintf(format, a...), nil)
 	}
 }
 
-func genLog(level int, format string, a ...interface{}) string {
+// output must be called by the exported log funcs directly, to get the right caller
+func output(level int, msg string, fields []Field) {
 	file, funcName, line := getFunc()
+	if level < levelOf(funcName) {
+		return
+	}
 	if !gConfig.FullPath {
 		file = filepath.Base(file)
 	}
-	t := time.Now().Format(time.RFC3339Nano)
-	str := fmt.Sprintf(format, a...)
-	ret := fmt.Sprintf("[%v] [%v] [%v:%v] [%v] %v\n", levelName(level), t, file, line, funcName, str)
-	return ret
+	e := &Entry{
+		Level:  level,
+		Time:   time.Now(),
+		File:   file,
+		Line:   line,
+		Func:   funcName,
+		Msg:    msg,
+		Fields: fields,
+	}
+
+	encoder := gConfig.Encoder
+	if encoder == nil {
+		encoder = &TextEncoder{}
+	}
+	if !gConfig.NoLogFile {
+		(&FileSink{
+			Prefix:       gConfig.Prefix,
+			Level:        int(atomic.LoadInt32(&gMinLevel)),
+			Encoder:      encoder,
+			MaxSize:      gConfig.MaxSize,
+			Compress:     gConfig.Compress,
+			MaxTotalSize: gConfig.MaxTotalSize,
+		}).Write(e)
+	}
+	if !gConfig.NoPrint {
+		(&ConsoleSink{NoColor: gConfig.NoLogColor, Encoder: encoder}).Write(e)
+	}
+	for _, sink := range gConfig.Sinks {
+		sink.Write(e)
+	}
 }
 
 func getFunc() (string, string, int) {
@@ -244,9 +192,9 @@ func NameToLevel(name string) int {
 	return -1
 }
 
-func openLog(level int) os.File {
+func openLog(prefix string, level int) os.File {
 	date := time.Now().Format("2006-01-02")
-	fileName := gConfig.Prefix + "_" + levelName(level) + "_" + date + ".log"
+	fileName := prefix + "_" + levelName(level) + "_" + date + ".log"
 	f, e := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.ModePerm)
 	if e != nil {
 		panic(e)
@@ -256,57 +204,31 @@ func openLog(level int) os.File {
 
 func checkDate(config Config) {
 	now := time.Now().Format("2006-01-02")
-	nowt, _ := time.Parse("2006-01-02", now)
+	nowt, _ := time.ParseInLocation("2006-01-02", now, time.Local)
 	nowunix := nowt.Unix()
 
-	dir, err := os.Getwd()
-	if err != nil {
-		Error("loggo checkDate Getwd fail %v ", err)
-		return
-	}
-
-	files, err := ioutil.ReadDir(dir)
+	files, err := listLogFiles(config.Prefix)
 	if err != nil {
-		Error("loggo checkDate ReadDir fail %v %v", dir, err)
+		Error("loggo checkDate list fail %v %v", config.Prefix, err)
 		return
 	}
 
 	for _, f := range files {
-
-		if f == nil || f.IsDir() {
-			continue
-		}
-
-		if !strings.HasSuffix(f.Name(), ".log") {
-			continue
-		}
-
-		strs := strings.Split(f.Name(), "_")
-		if strs == nil || len(strs) < 3 {
-			continue
-		}
-
-		if strs[0] != config.Prefix {
-			continue
-		}
-
-		date := strs[2]
-		date = strings.TrimRight(date, ".log")
-
-		t, e := time.Parse("2006-01-02", date)
-		if e != nil {
-			Error("loggo delete Parse file fail %v %v %v", f.Name(), date, err)
-			continue
-		}
-		tunix := t.Unix()
+		tunix := f.date.Unix()
 		if nowunix-tunix > int64(config.MaxDay)*24*3600 {
-			err := os.Remove(f.Name())
-			if e != nil {
-				Error("loggo delete log file fail %v %v", f.Name(), err)
+			err := os.Remove(f.name)
+			if err != nil {
+				Error("loggo delete log file fail %v %v", f.name, err)
 				continue
 			}
 		}
 	}
+
+	if config.MaxTotalSize > 0 {
+		gCleanLock.Lock()
+		checkTotalSize(config.Prefix, config.MaxTotalSize)
+		gCleanLock.Unlock()
+	}
 }
 
 func crashLog() {
@@ -342,14 +264,14 @@ func loopCheck(config Config) {
 }
 
 func IsDebug() bool {
-	return gConfig.Level <= LEVEL_DEBUG
+	return isLevel(LEVEL_DEBUG)
 }
 func IsInfo() bool {
-	return gConfig.Level <= LEVEL_INFO
+	return isLevel(LEVEL_INFO)
 }
 func IsWarn() bool {
-	return gConfig.Level <= LEVEL_WARN
+	return isLevel(LEVEL_WARN)
 }
 func IsError() bool {
-	return gConfig.Level <= LEVEL_ERROR
+	return isLevel(LEVEL_ERROR)
 }
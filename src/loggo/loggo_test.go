@@ -1,6 +1,16 @@
 package loggo
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -18,3 +28,296 @@ func Test0001(t *testing.T) {
 	}
 
 }
+
+func testEntry() *Entry {
+	return &Entry{
+		Level:  LEVEL_WARN,
+		Time:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		File:   "a.go",
+		Line:   10,
+		Func:   "main.f",
+		Msg:    "hello world",
+		Fields: []Field{{"conn", 12}, {"client", "a b"}, {"err", errors.New("eof")}},
+	}
+}
+
+func Test0002Encoder(t *testing.T) {
+	text := string((&TextEncoder{}).Encode(testEntry()))
+	fmt.Print(text)
+	if text != `[WARN] [2020-01-02T03:04:05Z] [a.go:10] [main.f] hello world conn=12 client="a b" err=eof`+"\n" {
+		t.Error("text diff", text)
+	}
+
+	logfmt := string((&LogfmtEncoder{}).Encode(testEntry()))
+	fmt.Print(logfmt)
+	if logfmt != `level=warn time=2020-01-02T03:04:05Z caller=a.go:10 func=main.f msg="hello world" conn=12 client="a b" err=eof`+"\n" {
+		t.Error("logfmt diff", logfmt)
+	}
+
+	js := (&JSONEncoder{}).Encode(testEntry())
+	fmt.Print(string(js))
+	m := make(map[string]interface{})
+	err := json.Unmarshal(js, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["level"] != "WARN" || m["msg"] != "hello world" || m["conn"] != float64(12) ||
+		m["client"] != "a b" || m["err"] != "eof" || m["caller"] != "a.go:10" {
+		t.Error("json diff", m)
+	}
+	if !strings.HasPrefix(string(js), `{"level":"WARN","time":`) {
+		t.Error("json not ordered", string(js))
+	}
+
+	// the old format is kept without fields
+	e := testEntry()
+	e.Fields = nil
+	if string((&TextEncoder{}).Encode(e)) != "[WARN] [2020-01-02T03:04:05Z] [a.go:10] [main.f] hello world\n" {
+		t.Error("text without fields diff")
+	}
+}
+
+func Test0003Logger(t *testing.T) {
+	ring := NewRingSink(3)
+	Ini(Config{
+		Level:     LEVEL_INFO,
+		Prefix:    "test",
+		NoLogFile: true,
+		NoPrint:   true,
+		Sinks:     []Sink{ring},
+	})
+
+	l := With("conn", 1)
+	child := l.With("client", "c1")
+	child.Info("open", "size", 100)
+	child.Debug("no debug")
+	l.Warn("bad", "odd")
+	Error("old %d", 2)
+
+	entries := ring.Entries()
+	if len(entries) != 3 {
+		t.Fatal("entries num", len(entries))
+	}
+	for _, e := range entries {
+		fmt.Print(string((&TextEncoder{}).Encode(e)))
+	}
+	if entries[0].Msg != "open" || fmt.Sprint(entries[0].Fields) != "[{conn 1} {client c1} {size 100}]" {
+		t.Error("child fields diff", entries[0].Fields)
+	}
+	if fmt.Sprint(entries[1].Fields) != "[{conn 1} {!BADKEY odd}]" {
+		t.Error("bad key diff", entries[1].Fields)
+	}
+	if entries[2].Msg != "old 2" || entries[2].Level != LEVEL_ERROR || len(entries[2].Fields) != 0 {
+		t.Error("old func diff", entries[2])
+	}
+	// the caller is the test, not the logger
+	for _, e := range entries {
+		if e.File != "loggo_test.go" || !strings.Contains(e.Func, "Test0003Logger") {
+			t.Error("caller diff", e.File, e.Func)
+		}
+	}
+	if len(l.Fields()) != 1 {
+		t.Error("parent changed by child", l.Fields())
+	}
+
+	// the ring keeps the last ones
+	Info("4")
+	entries = ring.Entries()
+	if len(entries) != 3 || entries[0].Msg != "bad" || entries[2].Msg != "4" {
+		t.Error("ring diff", len(entries))
+	}
+}
+
+func Test0004Syslog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loggo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addr := filepath.Join(dir, "log.sock")
+	l, err := net.ListenPacket("unixgram", addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+
+	s, err := NewSyslogSink("unixgram", addr, "test", &LogfmtEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	err = s.Write(testEntry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2000)
+	l.SetReadDeadline(time.Now().Add(time.Second * 5))
+	n, _, err := l.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(buf[:n])
+	fmt.Print(line)
+	prefix := fmt.Sprintf("<12>Jan  2 03:04:05 test[%d]: level=warn", os.Getpid())
+	if !strings.HasPrefix(line, prefix) || !strings.Contains(line, "client=\"a b\"") {
+		t.Error("syslog diff", line)
+	}
+}
+
+func Test0005FileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loggo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prefix := filepath.Join(dir, "test")
+	f := &FileSink{Prefix: prefix, Level: LEVEL_INFO, Encoder: &JSONEncoder{}}
+	err = f.Write(testEntry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Now().Format("2006-01-02")
+	for _, level := range []int{LEVEL_WARN, LEVEL_INFO} {
+		b, err := ioutil.ReadFile(prefix + "_" + levelName(level) + "_" + date + ".log")
+		if err != nil || !strings.Contains(string(b), `"client":"a b"`) {
+			t.Error("file diff", levelName(level), err, string(b))
+		}
+	}
+	_, err = os.Stat(prefix + "_" + levelName(LEVEL_DEBUG) + "_" + date + ".log")
+	if err == nil {
+		t.Error("debug file should not be written")
+	}
+}
+
+func Test0006Rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loggo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prefix := filepath.Join(dir, "test")
+	f := &FileSink{Prefix: prefix, Level: LEVEL_INFO, Encoder: &TextEncoder{}, MaxSize: 1000, Compress: true, MaxTotalSize: 1500}
+	e := testEntry()
+	e.Level = LEVEL_INFO
+	for i := 0; i < 200; i++ {
+		e.Msg = fmt.Sprintf("hello %d", i)
+		err = f.Write(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the gzip and the clean are in background
+	var files []*logFile
+	for i := 0; i < 50; i++ {
+		time.Sleep(time.Millisecond * 100)
+		files, err = listLogFiles(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := true
+		for _, f := range files {
+			if f.index > 0 && !strings.HasSuffix(f.name, ".gz") {
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	var total int64
+	maxindex := 0
+	gz := ""
+	for _, f := range files {
+		fmt.Println(f.name, f.index, f.size)
+		total += f.size
+		if f.index > maxindex {
+			maxindex = f.index
+			gz = f.name
+		}
+		if f.index > 0 && !strings.HasSuffix(f.name, ".gz") {
+			t.Error("not gzip", f.name)
+		}
+		if f.index == 1 {
+			t.Error("oldest not deleted", f.name)
+		}
+		if f.index == 0 && f.size > 1000 {
+			t.Error("not rotated", f.size)
+		}
+	}
+	if total > 1500 {
+		t.Error("total size", total)
+	}
+	if maxindex < 10 {
+		t.Fatal("rotate num", maxindex)
+	}
+
+	b, err := ioutil.ReadFile(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadAll(r)
+	if err != nil || len(b) > 1000 || !strings.HasPrefix(string(b), "[INFO]") {
+		t.Error("gzip content diff", err, len(b))
+	}
+}
+
+func Test0007ModuleLevel(t *testing.T) {
+	if moduleOf("github.com/esrrhs/go-engine/src/frame.(*Frame).Update") != "github.com/esrrhs/go-engine/src/frame" ||
+		moduleOf("main.main") != "main" {
+		t.Error("module diff")
+	}
+
+	ring := NewRingSink(10)
+	Ini(Config{
+		Level:       LEVEL_WARN,
+		Prefix:      "test",
+		NoLogFile:   true,
+		NoPrint:     true,
+		Sinks:       []Sink{ring},
+		ModuleLevel: map[string]int{"loggo": LEVEL_DEBUG},
+	})
+	defer iniLevel(LEVEL_DEBUG, nil)
+
+	ring.Reset()
+	Debug("debug by module")
+	if len(ring.Entries()) != 1 || !IsDebug() {
+		t.Error("module debug not logged")
+	}
+
+	SetModuleLevel("github.com/esrrhs/go-engine/src/loggo", LEVEL_ERROR)
+	ring.Reset()
+	Warn("warn")
+	With("a", 1).Warn("warn")
+	if len(ring.Entries()) != 0 || IsWarn() || !IsError() {
+		t.Error("full path module level not used", len(ring.Entries()))
+	}
+
+	RemoveModuleLevel("github.com/esrrhs/go-engine/src/loggo")
+	RemoveModuleLevel("loggo")
+	SetModuleLevel("frame", LEVEL_DEBUG)
+	ring.Reset()
+	Debug("debug of other module")
+	Warn("warn")
+	if len(ring.Entries()) != 1 || ring.Entries()[0].Msg != "warn" || IsDebug() {
+		t.Error("other module level used")
+	}
+
+	SetLevel(LEVEL_INFO)
+	ring.Reset()
+	Info("info")
+	if len(ring.Entries()) != 1 || GetLevel() != LEVEL_INFO || len(GetModuleLevel()) != 1 {
+		t.Error("set level fail")
+	}
+}
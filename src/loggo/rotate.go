@@ -0,0 +1,223 @@
+package loggo
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var gRotateLock sync.Mutex
+var gCleanLock sync.Mutex
+
+// logFile is prefix_LEVEL_date.log, or prefix_LEVEL_date.N.log[.gz] after rotated
+type logFile struct {
+	name    string
+	level   int
+	date    time.Time
+	index   int
+	size    int64
+	modTime time.Time
+}
+
+func (l *logFile) active() bool {
+	return l.index == 0 && l.date.Format("2006-01-02") == time.Now().Format("2006-01-02")
+}
+
+func listLogFiles(prefix string) ([]*logFile, error) {
+	dir := filepath.Dir(prefix)
+	base := filepath.Base(prefix) + "_"
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*logFile
+	for _, f := range files {
+		if f == nil || f.IsDir() {
+			continue
+		}
+		if !strings.HasPrefix(f.Name(), base) {
+			continue
+		}
+		name := strings.TrimSuffix(f.Name(), ".gz")
+		if !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		strs := strings.Split(strings.TrimSuffix(name[len(base):], ".log"), "_")
+		if len(strs) != 2 || len(strs[1]) < 10 {
+			continue
+		}
+		level := NameToLevel(strs[0])
+		if level < 0 {
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02", strs[1][:10], time.Local)
+		if err != nil {
+			continue
+		}
+		index := 0
+		if len(strs[1]) > 10 {
+			index, err = strconv.Atoi(strings.TrimPrefix(strs[1][10:], "."))
+			if err != nil || index <= 0 {
+				continue
+			}
+		}
+		ret = append(ret, &logFile{
+			name:    filepath.Join(dir, f.Name()),
+			level:   level,
+			date:    t,
+			index:   index,
+			size:    f.Size(),
+			modTime: f.ModTime(),
+		})
+	}
+	return ret, nil
+}
+
+// writeRotate write to the level file, rotate it first if it will be bigger than MaxSize
+func (f *FileSink) writeRotate(level int, b []byte) error {
+	gRotateLock.Lock()
+	defer gRotateLock.Unlock()
+
+	file := openLog(f.Prefix, level)
+	info, err := file.Stat()
+	if err == nil && info.Size() > 0 && info.Size()+int64(len(b)) > f.MaxSize {
+		file.Close()
+		_, err := rotateLog(f.Prefix, file.Name())
+		if err == nil && (f.Compress || f.MaxTotalSize > 0) {
+			go afterRotate(f.Prefix, f.Compress, f.MaxTotalSize)
+		}
+		file = openLog(f.Prefix, level)
+	}
+	_, err = file.Write(b)
+	file.Close()
+	return err
+}
+
+// rotateLog rename prefix_LEVEL_date.log to prefix_LEVEL_date.N.log with the next N
+func rotateLog(prefix string, name string) (string, error) {
+	files, err := listLogFiles(prefix)
+	if err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(name, ".log")
+	index := 0
+	for _, f := range files {
+		if strings.HasPrefix(filepath.Base(f.name), filepath.Base(base)+".") && f.index > index {
+			index = f.index
+		}
+	}
+	rotated := base + "." + strconv.Itoa(index+1) + ".log"
+	err = os.Rename(name, rotated)
+	if err != nil {
+		return "", err
+	}
+	return rotated, nil
+}
+
+// afterRotate gzip all the rotated files not gzipped yet, so the order of the runs does not matter
+func afterRotate(prefix string, compress bool, maxTotalSize int64) {
+	defer crashLog()
+
+	gCleanLock.Lock()
+	defer gCleanLock.Unlock()
+
+	if compress {
+		files, err := listLogFiles(prefix)
+		if err != nil {
+			Error("loggo afterRotate list fail %v %v", prefix, err)
+			return
+		}
+		for _, f := range files {
+			if f.index <= 0 || strings.HasSuffix(f.name, ".gz") {
+				continue
+			}
+			err := gzipLog(f.name)
+			if err != nil && !os.IsNotExist(err) {
+				Error("loggo gzip log file fail %v %v", f.name, err)
+			}
+		}
+	}
+	if maxTotalSize > 0 {
+		checkTotalSize(prefix, maxTotalSize)
+	}
+}
+
+func gzipLog(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// keep the time for checkTotalSize to find the oldest
+	os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	err = os.Rename(tmp, name+".gz")
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// checkTotalSize delete the oldest files until all the files of the prefix are not bigger than maxTotalSize, the files being written are kept
+func checkTotalSize(prefix string, maxTotalSize int64) {
+	files, err := listLogFiles(prefix)
+	if err != nil {
+		Error("loggo checkTotalSize list fail %v %v", prefix, err)
+		return
+	}
+
+	var total int64
+	var old []*logFile
+	for _, f := range files {
+		total += f.size
+		if !f.active() {
+			old = append(old, f)
+		}
+	}
+	sort.Slice(old, func(i, j int) bool {
+		return old[i].modTime.Before(old[j].modTime)
+	})
+
+	for _, f := range old {
+		if total <= maxTotalSize {
+			break
+		}
+		err := os.Remove(f.name)
+		if err != nil && !os.IsNotExist(err) {
+			Error("loggo delete log file fail %v %v", f.name, err)
+			continue
+		}
+		total -= f.size
+	}
+}
@@ -0,0 +1,218 @@
+package loggo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/esrrhs/go-engine/src/termcolor"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type Sink interface {
+	Write(e *Entry) error
+	Close() error
+}
+
+// FileSink writes to the daily files of each level, the entry goes to its level file and the lower ones down to Level
+type FileSink struct {
+	Prefix       string
+	Level        int
+	Encoder      Encoder
+	MaxSize      int64 // rotate the file to prefix_LEVEL_date.N.log when it will be bigger than MaxSize, 0 for no limit
+	Compress     bool  // gzip the rotated files
+	MaxTotalSize int64 // delete the oldest files after rotated when all the files are bigger than it, 0 for no limit
+}
+
+func (f *FileSink) Write(e *Entry) error {
+	b := f.Encoder.Encode(e)
+	for level := e.Level; level >= f.Level && level >= LEVEL_DEBUG; level-- {
+		var err error
+		if f.MaxSize > 0 {
+			err = f.writeRotate(level, b)
+		} else {
+			file := openLog(f.Prefix, level)
+			_, err = file.Write(b)
+			file.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileSink) Close() error {
+	return nil
+}
+
+// ConsoleSink prints to the stdout, colored by level
+type ConsoleSink struct {
+	NoColor bool
+	Encoder Encoder
+}
+
+func (c *ConsoleSink) Write(e *Entry) error {
+	str := string(c.Encoder.Encode(e))
+	if c.NoColor {
+		fmt.Print(str)
+		return nil
+	}
+	switch e.Level {
+	case LEVEL_DEBUG:
+		fmt.Print(termcolor.FgString(str, 0, 0, 255))
+	case LEVEL_INFO:
+		fmt.Print(termcolor.FgString(str, 0, 255, 0))
+	case LEVEL_WARN:
+		fmt.Print(termcolor.FgString(str, 255, 255, 0))
+	default:
+		fmt.Print(termcolor.FgString(str, 255, 0, 0))
+	}
+	return nil
+}
+
+func (c *ConsoleSink) Close() error {
+	return nil
+}
+
+// SyslogSink sends to the local syslog daemon in RFC 3164 format
+type SyslogSink struct {
+	Tag     string
+	Encoder Encoder
+	lock    sync.Mutex
+	conn    net.Conn
+	network string
+	addr    string
+}
+
+const (
+	syslogFacilityUser = 1
+)
+
+// NewSyslogSink connect the syslog socket, empty addr for the default unix socket
+func NewSyslogSink(network string, addr string, tag string, encoder Encoder) (*SyslogSink, error) {
+	s := &SyslogSink{Tag: tag, Encoder: encoder, network: network, addr: addr}
+	err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SyslogSink) connect() error {
+	if s.addr != "" {
+		c, err := net.Dial(s.network, s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = c
+		return nil
+	}
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			c, err := net.Dial(network, path)
+			if err == nil {
+				s.conn = c
+				return nil
+			}
+		}
+	}
+	return errors.New("no local syslog")
+}
+
+func syslogSeverity(level int) int {
+	switch level {
+	case LEVEL_DEBUG:
+		return 7
+	case LEVEL_INFO:
+		return 6
+	case LEVEL_WARN:
+		return 4
+	}
+	return 3
+}
+
+func (s *SyslogSink) Write(e *Entry) error {
+	msg := bytes.TrimRight(s.Encoder.Encode(e), "\n")
+	line := fmt.Sprintf("<%d>%s %s[%d]: %s\n", syslogFacilityUser*8+syslogSeverity(e.Level),
+		e.Time.Format(time.Stamp), s.Tag, os.Getpid(), msg)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn == nil {
+		err := s.connect()
+		if err != nil {
+			return err
+		}
+	}
+	_, err := s.conn.Write([]byte(line))
+	if err != nil {
+		// the daemon may restart, reconnect next time
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *SyslogSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != nil {
+		err := s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// RingSink keeps the last entries in memory, for tests
+type RingSink struct {
+	lock    sync.Mutex
+	entries []*Entry
+	next    int
+	full    bool
+}
+
+func NewRingSink(size int) *RingSink {
+	if size <= 0 {
+		size = 1
+	}
+	return &RingSink{entries: make([]*Entry, size)}
+}
+
+func (r *RingSink) Write(e *Entry) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries[r.next] = e
+	r.next++
+	if r.next >= len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+	return nil
+}
+
+// Entries return the entries from the oldest
+func (r *RingSink) Entries() []*Entry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var ret []*Entry
+	if r.full {
+		ret = append(ret, r.entries[r.next:]...)
+	}
+	return append(ret, r.entries[:r.next]...)
+}
+
+func (r *RingSink) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.next = 0
+	r.full = false
+}
+
+func (r *RingSink) Close() error {
+	return nil
+}
@@ -0,0 +1,306 @@
+package metrics
+
+import (
+	"github.com/esrrhs/go-engine/src/loggo"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	TYPE_COUNTER   = "counter"
+	TYPE_GAUGE     = "gauge"
+	TYPE_HISTOGRAM = "histogram"
+)
+
+// DefBuckets is for durations in seconds, from 1ms to 10s
+var DefBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry the engine subsystems publish to
+var Default = NewRegistry()
+
+type floatValue struct {
+	bits uint64
+}
+
+func (v *floatValue) Add(d float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		n := math.Float64bits(math.Float64frombits(old) + d)
+		if atomic.CompareAndSwapUint64(&v.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (v *floatValue) Set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *floatValue) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Counter only goes up, Add with negative value is ignored
+type Counter struct {
+	v floatValue
+	f func() float64
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Add(d float64) {
+	if d < 0 {
+		return
+	}
+	c.v.Add(d)
+}
+
+func (c *Counter) Value() float64 {
+	if c.f != nil {
+		return c.f()
+	}
+	return c.v.Value()
+}
+
+// Gauge can go up and down
+type Gauge struct {
+	v floatValue
+	f func() float64
+}
+
+func (g *Gauge) Set(f float64) {
+	g.v.Set(f)
+}
+
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+func (g *Gauge) Add(d float64) {
+	g.v.Add(d)
+}
+
+func (g *Gauge) Value() float64 {
+	if g.f != nil {
+		return g.f()
+	}
+	return g.v.Value()
+}
+
+// Histogram count the observed values in buckets, the buckets are upper bounds
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     floatValue
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	if len(buckets) <= 0 {
+		buckets = DefBuckets
+	}
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+	return &Histogram{
+		buckets: b,
+		counts:  make([]uint64, len(b)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.Add(v)
+}
+
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+func (h *Histogram) Sum() float64 {
+	return h.sum.Value()
+}
+
+type metric struct {
+	labels string
+	value  interface{} // *Counter *Gauge *Histogram
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	metrics map[string]*metric
+}
+
+// Registry hold the metrics by name and labels, the same name and labels get the same metric
+type Registry struct {
+	lock     sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Counter get or create a counter, labels are key value pairs
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	m := r.get(name, help, TYPE_COUNTER, labels, func() interface{} {
+		return &Counter{}
+	})
+	c, ok := m.(*Counter)
+	if !ok {
+		return &Counter{}
+	}
+	return c
+}
+
+// CounterFunc register a counter read from f, f must only goes up
+func (r *Registry) CounterFunc(name string, help string, f func() float64, labels ...string) {
+	r.get(name, help, TYPE_COUNTER, labels, func() interface{} {
+		return &Counter{f: f}
+	})
+}
+
+// Gauge get or create a gauge, labels are key value pairs
+func (r *Registry) Gauge(name string, help string, labels ...string) *Gauge {
+	m := r.get(name, help, TYPE_GAUGE, labels, func() interface{} {
+		return &Gauge{}
+	})
+	g, ok := m.(*Gauge)
+	if !ok {
+		return &Gauge{}
+	}
+	return g
+}
+
+// GaugeFunc register a gauge read from f when exported
+func (r *Registry) GaugeFunc(name string, help string, f func() float64, labels ...string) {
+	r.get(name, help, TYPE_GAUGE, labels, func() interface{} {
+		return &Gauge{f: f}
+	})
+}
+
+// Histogram get or create a histogram, nil buckets means DefBuckets, labels are key value pairs
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	m := r.get(name, help, TYPE_HISTOGRAM, labels, func() interface{} {
+		return newHistogram(buckets)
+	})
+	h, ok := m.(*Histogram)
+	if !ok {
+		return newHistogram(buckets)
+	}
+	return h
+}
+
+// Unregister remove the metric with the labels, the family is removed when empty
+func (r *Registry) Unregister(name string, labels ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fa, ok := r.families[name]
+	if !ok {
+		return
+	}
+	delete(fa.metrics, formatLabels(labels))
+	if len(fa.metrics) <= 0 {
+		delete(r.families, name)
+	}
+}
+
+func (r *Registry) get(name string, help string, typ string, labels []string, create func() interface{}) interface{} {
+	if !validName(name) || len(labels)%2 != 0 {
+		loggo.Error("metrics invalid name or labels %s %v", name, labels)
+		return nil
+	}
+	for i := 0; i < len(labels); i += 2 {
+		if !validName(labels[i]) {
+			loggo.Error("metrics invalid label %s %s", name, labels[i])
+			return nil
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	fa, ok := r.families[name]
+	if !ok {
+		fa = &family{name: name, help: help, typ: typ, metrics: make(map[string]*metric)}
+		r.families[name] = fa
+	}
+	if fa.typ != typ {
+		loggo.Error("metrics type not match %s %s %s", name, fa.typ, typ)
+		return nil
+	}
+
+	ls := formatLabels(labels)
+	m, ok := fa.metrics[ls]
+	if !ok {
+		m = &metric{labels: ls, value: create()}
+		fa.metrics[ls] = m
+	}
+	return m.value
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// formatLabels make {k="v",...}, keys are sorted so the order of labels does not matter
+func formatLabels(labels []string) string {
+	if len(labels) <= 0 {
+		return ""
+	}
+	type kv struct {
+		k string
+		v string
+	}
+	var kvs []kv
+	for i := 0; i+1 < len(labels); i += 2 {
+		kvs = append(kvs, kv{labels[i], labels[i+1]})
+	}
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].k < kvs[j].k
+	})
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, l := range kvs {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(l.k)
+		sb.WriteString("=\"")
+		sb.WriteString(escapeLabel(l.v))
+		sb.WriteString("\"")
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+func escapeLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return s
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test0001Counter(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_total", "test counter", "k", "v")
+	c.Inc()
+	c.Add(2.5)
+	c.Add(-1)
+	if c.Value() != 3.5 {
+		t.Error("counter fail", c.Value())
+	}
+	if r.Counter("test_total", "test counter", "k", "v") != c {
+		t.Error("get counter again fail")
+	}
+	if r.Counter("test_total", "test counter", "k", "v2") == c {
+		t.Error("counter with other labels is the same")
+	}
+
+	// type not match, get a detached one
+	g := r.Gauge("test_total", "test gauge")
+	g.Set(100)
+	if c.Value() != 3.5 {
+		t.Error("counter changed by detached gauge", c.Value())
+	}
+}
+
+func Test0001Gauge(t *testing.T) {
+	r := NewRegistry()
+	g := r.Gauge("test_gauge", "test gauge")
+	g.Set(10)
+	g.Inc()
+	g.Dec()
+	g.Dec()
+	g.Add(-0.5)
+	if g.Value() != 8.5 {
+		t.Error("gauge fail", g.Value())
+	}
+
+	n := 0
+	r.GaugeFunc("test_gauge_func", "test gauge func", func() float64 {
+		n++
+		return float64(n)
+	})
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	r.WriteText(&buf)
+	if n != 2 {
+		t.Error("gauge func fail", n)
+	}
+}
+
+func Test0001Histogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("test_seconds", "test histogram", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(5)
+	if h.Count() != 4 || h.Sum() != 5.65 {
+		t.Error("histogram fail", h.Count(), h.Sum())
+	}
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	fmt.Println(buf.String())
+	want := `# HELP test_seconds test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 2
+test_seconds_bucket{le="1"} 3
+test_seconds_bucket{le="+Inf"} 4
+test_seconds_sum 5.65
+test_seconds_count 4
+`
+	if buf.String() != want {
+		t.Error("histogram text fail", buf.String())
+	}
+}
+
+func Test0001Text(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("b_total", "b\nhelp", "z", "1", "a", `x"y\`).Add(3)
+	r.Gauge("a_gauge", "a help").Set(1.5)
+	r.Gauge("bad-name", "bad")
+	r.Counter("bad_label", "bad", "k")
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	text := rec.Body.String()
+	fmt.Println(text)
+	if rec.Header().Get("Content-Type") != TEXT_CONTENT_TYPE {
+		t.Error("content type fail", rec.Header().Get("Content-Type"))
+	}
+	want := `# HELP a_gauge a help
+# TYPE a_gauge gauge
+a_gauge 1.5
+# HELP b_total b\nhelp
+# TYPE b_total counter
+b_total{a="x\"y\\",z="1"} 3
+`
+	if text != want {
+		t.Error("text fail", text)
+	}
+
+	r.Unregister("a_gauge")
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "a_gauge") {
+		t.Error("unregister fail")
+	}
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const TEXT_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteText write all metrics in prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	type snapshot struct {
+		fa      *family
+		metrics []*metric
+	}
+
+	r.lock.Lock()
+	var fas []snapshot
+	for _, fa := range r.families {
+		s := snapshot{fa: fa}
+		for _, m := range fa.metrics {
+			s.metrics = append(s.metrics, m)
+		}
+		fas = append(fas, s)
+	}
+	r.lock.Unlock()
+
+	sort.Slice(fas, func(i, j int) bool {
+		return fas[i].fa.name < fas[j].fa.name
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, s := range fas {
+		fa := s.fa
+		sort.Slice(s.metrics, func(i, j int) bool {
+			return s.metrics[i].labels < s.metrics[j].labels
+		})
+
+		bw.WriteString("# HELP " + fa.name + " " + escapeHelp(fa.help) + "\n")
+		bw.WriteString("# TYPE " + fa.name + " " + fa.typ + "\n")
+		for _, m := range s.metrics {
+			switch v := m.value.(type) {
+			case *Counter:
+				writeSample(bw, fa.name, m.labels, v.Value())
+			case *Gauge:
+				writeSample(bw, fa.name, m.labels, v.Value())
+			case *Histogram:
+				var cum uint64
+				for i, b := range v.buckets {
+					cum += atomic.LoadUint64(&v.counts[i])
+					writeSample(bw, fa.name+"_bucket", addLabel(m.labels, "le", formatFloat(b)), float64(cum))
+				}
+				count := v.Count()
+				if count < cum {
+					// observed while writing the buckets
+					count = cum
+				}
+				writeSample(bw, fa.name+"_bucket", addLabel(m.labels, "le", "+Inf"), float64(count))
+				writeSample(bw, fa.name+"_sum", m.labels, v.Sum())
+				writeSample(bw, fa.name+"_count", m.labels, float64(count))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler serve WriteText, for the scrape of prometheus
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", TEXT_CONTENT_TYPE)
+		r.WriteText(w)
+	})
+}
+
+func writeSample(bw *bufio.Writer, name string, labels string, v float64) {
+	bw.WriteString(name)
+	bw.WriteString(labels)
+	bw.WriteString(" ")
+	bw.WriteString(formatFloat(v))
+	bw.WriteString("\n")
+}
+
+func addLabel(labels string, k string, v string) string {
+	l := k + "=\"" + v + "\""
+	if labels == "" {
+		return "{" + l + "}"
+	}
+	return labels[0:len(labels)-1] + "," + l + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return s
+}
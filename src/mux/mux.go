@@ -0,0 +1,378 @@
+package mux
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"github.com/esrrhs/go-engine/src/conn"
+	"github.com/esrrhs/go-engine/src/group"
+	"github.com/esrrhs/go-engine/src/loggo"
+	"io"
+	"strconv"
+	"sync"
+)
+
+type Config struct {
+	MaxFrameSize  int // 每个数据帧最大长度
+	StreamWindow  int // 每个stream的接收窗口
+	AcceptBacklog int // 未Accept的stream最大数目
+	MaxStream     int // 最大stream数目
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		MaxFrameSize:  32 * 1024,
+		StreamWindow:  256 * 1024,
+		AcceptBacklog: 128,
+		MaxStream:     1024,
+	}
+}
+
+const (
+	MUX_VERSION = 1
+
+	PRIORITY_LOW    = 0
+	PRIORITY_NORMAL = 50
+	PRIORITY_HIGH   = 100
+)
+
+const (
+	cmd_syn = iota // open stream, data is priority
+	cmd_fin        // close stream
+	cmd_psh        // data
+	cmd_upd        // window update
+)
+
+// ver(1) cmd(1) sid(4) len(4)
+const header_size = 10
+
+// control frames go before data frames, and data frames go by stream priority
+const priority_control = 1 << 30
+
+type muxFrame struct {
+	cmd      uint8
+	sid      uint32
+	data     []byte
+	priority int
+	seq      uint64
+	done     chan error
+	index    int // index in the sendqueue, -1 after popped
+}
+
+type muxFrameHeap []*muxFrame
+
+func (h muxFrameHeap) Len() int { return len(h) }
+func (h muxFrameHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h muxFrameHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *muxFrameHeap) Push(x interface{}) {
+	f := x.(*muxFrame)
+	f.index = len(*h)
+	*h = append(*h, f)
+}
+func (h *muxFrameHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	x.index = -1
+	*h = old[0 : n-1]
+	return x
+}
+
+// Session multiplexes streams over one conn.Conn, client opens odd stream id, server opens even stream id
+type Session struct {
+	conn   conn.Conn
+	config *Config
+	wg     *group.Group
+
+	nextid uint32
+
+	streams    map[uint32]*Stream
+	streamlock sync.Mutex
+	accept     chan *Stream
+
+	sendlock   sync.Mutex
+	sendqueue  muxFrameHeap
+	sendseq    uint64
+	sendnotify chan int
+}
+
+func NewSession(c conn.Conn, config *Config, isclient bool) *Session {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	s := &Session{
+		conn:       c,
+		config:     config,
+		streams:    make(map[uint32]*Stream),
+		accept:     make(chan *Stream, config.AcceptBacklog),
+		sendnotify: make(chan int, 1),
+	}
+	if isclient {
+		s.nextid = 1
+	} else {
+		s.nextid = 2
+	}
+
+	s.wg = group.NewGroup("mux Session "+c.Info(), nil, func() {
+		c.Close()
+	})
+
+	s.wg.Go("mux Session recvLoop "+c.Info(), func() error {
+		return s.recvLoop()
+	})
+
+	s.wg.Go("mux Session sendLoop "+c.Info(), func() error {
+		return s.sendLoop()
+	})
+
+	return s
+}
+
+func (s *Session) OpenStream(priority int) (*Stream, error) {
+	if s.IsClosed() {
+		return nil, errors.New("session closed")
+	}
+
+	s.streamlock.Lock()
+	if len(s.streams) >= s.config.MaxStream {
+		s.streamlock.Unlock()
+		return nil, errors.New("too many streams")
+	}
+	sid := s.nextid
+	s.nextid += 2
+	st := newStream(sid, s, priority)
+	s.streams[sid] = st
+	s.streamlock.Unlock()
+
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(priority))
+	err := s.writeFrame(cmd_syn, sid, data, priority_control, st.die)
+	if err != nil {
+		s.removeStream(sid)
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.wg.Done():
+		return nil, errors.New("session closed")
+	}
+}
+
+func (s *Session) Close() error {
+	s.wg.Stop()
+	s.wg.Wait()
+
+	s.streamlock.Lock()
+	defer s.streamlock.Unlock()
+	for _, st := range s.streams {
+		st.sessionClose()
+	}
+	s.streams = make(map[uint32]*Stream)
+	return nil
+}
+
+func (s *Session) IsClosed() bool {
+	return s.wg.IsExit()
+}
+
+func (s *Session) NumStreams() int {
+	s.streamlock.Lock()
+	defer s.streamlock.Unlock()
+	return len(s.streams)
+}
+
+func (s *Session) Info() string {
+	return s.conn.Info()
+}
+
+func (s *Session) removeStream(sid uint32) {
+	s.streamlock.Lock()
+	defer s.streamlock.Unlock()
+	delete(s.streams, sid)
+}
+
+func (s *Session) getStream(sid uint32) *Stream {
+	s.streamlock.Lock()
+	defer s.streamlock.Unlock()
+	return s.streams[sid]
+}
+
+// writeFrame queue the frame and wait until it is written to conn, so frames of one stream never reorder.
+// if it returns before that, the frame is dequeued or already copied, the caller can reuse data
+func (s *Session) writeFrame(cmd uint8, sid uint32, data []byte, priority int, die <-chan int) error {
+	f := &muxFrame{cmd: cmd, sid: sid, data: data, priority: priority, done: make(chan error, 1)}
+
+	s.sendlock.Lock()
+	if s.wg.IsExit() {
+		s.sendlock.Unlock()
+		return errors.New("session closed")
+	}
+	s.sendseq++
+	f.seq = s.sendseq
+	heap.Push(&s.sendqueue, f)
+	s.sendlock.Unlock()
+
+	select {
+	case s.sendnotify <- 1:
+	default:
+	}
+
+	select {
+	case err := <-f.done:
+		return err
+	case <-s.wg.Done():
+		s.cancelFrame(f)
+		return errors.New("session closed")
+	case <-die:
+		s.cancelFrame(f)
+		return errors.New("stream closed")
+	}
+}
+
+// cancelFrame remove the frame not sent yet, the sendLoop copy the data of popped frame under sendlock
+func (s *Session) cancelFrame(f *muxFrame) {
+	s.sendlock.Lock()
+	defer s.sendlock.Unlock()
+	if f.index >= 0 {
+		heap.Remove(&s.sendqueue, f.index)
+	}
+}
+
+func (s *Session) sendLoop() error {
+	buf := make([]byte, header_size+s.config.MaxFrameSize)
+	for !s.wg.IsExit() {
+		s.sendlock.Lock()
+		if s.sendqueue.Len() <= 0 {
+			s.sendlock.Unlock()
+			select {
+			case <-s.sendnotify:
+			case <-s.wg.Done():
+			}
+			continue
+		}
+		f := heap.Pop(&s.sendqueue).(*muxFrame)
+
+		buf[0] = MUX_VERSION
+		buf[1] = f.cmd
+		binary.LittleEndian.PutUint32(buf[2:], f.sid)
+		binary.LittleEndian.PutUint32(buf[6:], uint32(len(f.data)))
+		n := copy(buf[header_size:], f.data)
+		f.data = nil
+		s.sendlock.Unlock()
+
+		_, err := s.conn.Write(buf[0 : header_size+n])
+		f.done <- err
+		if err != nil {
+			loggo.Info("mux sendLoop Write fail %s %s", s.conn.Info(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) recvLoop() error {
+	header := make([]byte, header_size)
+	for !s.wg.IsExit() {
+		_, err := io.ReadFull(s.conn, header)
+		if err != nil {
+			loggo.Info("mux recvLoop ReadFull fail %s %s", s.conn.Info(), err)
+			return err
+		}
+
+		if header[0] != MUX_VERSION {
+			loggo.Error("mux recvLoop version fail %s %d", s.conn.Info(), header[0])
+			return errors.New("mux version fail " + strconv.Itoa(int(header[0])))
+		}
+		cmd := header[1]
+		sid := binary.LittleEndian.Uint32(header[2:])
+		datalen := binary.LittleEndian.Uint32(header[6:])
+		if datalen > uint32(s.config.MaxFrameSize) {
+			loggo.Error("mux recvLoop len fail %s %d", s.conn.Info(), datalen)
+			return errors.New("mux len fail " + strconv.Itoa(int(datalen)))
+		}
+
+		var data []byte
+		if datalen > 0 {
+			data = make([]byte, datalen)
+			_, err = io.ReadFull(s.conn, data)
+			if err != nil {
+				loggo.Info("mux recvLoop ReadFull fail %s %s", s.conn.Info(), err)
+				return err
+			}
+		}
+
+		switch cmd {
+		case cmd_syn:
+			priority := PRIORITY_NORMAL
+			if len(data) >= 4 {
+				priority = int(int32(binary.LittleEndian.Uint32(data)))
+			}
+			s.processSyn(sid, priority)
+		case cmd_fin:
+			st := s.getStream(sid)
+			if st != nil {
+				st.remoteClose()
+			}
+		case cmd_psh:
+			st := s.getStream(sid)
+			if st != nil && !st.pushData(data) {
+				// the remote ignore our window
+				loggo.Error("mux recvLoop stream window overflow %s %d", s.conn.Info(), sid)
+				st.reset()
+			}
+		case cmd_upd:
+			st := s.getStream(sid)
+			if st != nil && len(data) >= 4 {
+				st.updateWindow(int32(binary.LittleEndian.Uint32(data)))
+			}
+		default:
+			loggo.Error("mux recvLoop cmd fail %s %d", s.conn.Info(), cmd)
+			return errors.New("mux cmd fail " + strconv.Itoa(int(cmd)))
+		}
+	}
+	return nil
+}
+
+func (s *Session) processSyn(sid uint32, priority int) {
+	s.streamlock.Lock()
+	_, ok := s.streams[sid]
+	if ok {
+		// a fin for the sid would close the live stream, so the duplicate syn is ignored
+		s.streamlock.Unlock()
+		loggo.Error("mux processSyn duplicate %s %d", s.conn.Info(), sid)
+		return
+	}
+	if len(s.streams) >= s.config.MaxStream {
+		s.streamlock.Unlock()
+		loggo.Error("mux processSyn fail %s %d", s.conn.Info(), sid)
+		go s.writeFrame(cmd_fin, sid, nil, priority_control, nil)
+		return
+	}
+	st := newStream(sid, s, priority)
+	s.streams[sid] = st
+	s.streamlock.Unlock()
+
+	select {
+	case s.accept <- st:
+	default:
+		loggo.Error("mux processSyn accept backlog full %s %d", s.conn.Info(), sid)
+		s.removeStream(sid)
+		go s.writeFrame(cmd_fin, sid, nil, priority_control, nil)
+	}
+}
@@ -0,0 +1,273 @@
+package mux
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"github.com/esrrhs/go-engine/src/conn"
+	"github.com/esrrhs/go-engine/src/group"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestSession(t *testing.T, addr string) (*Session, *Session, conn.Conn) {
+	c, err := conn.NewConn("sim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan conn.Conn)
+	go func() {
+		cc, err := l.Accept()
+		if err != nil {
+			fmt.Println(err)
+		}
+		ch <- cc
+	}()
+
+	client, err := c.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-ch
+
+	return NewSession(client, nil, true), NewSession(server, nil, false), l
+}
+
+func Test0001(t *testing.T) {
+	h := &muxFrameHeap{}
+	heap.Push(h, &muxFrame{priority: PRIORITY_LOW, seq: 1})
+	heap.Push(h, &muxFrame{priority: PRIORITY_HIGH, seq: 2})
+	heap.Push(h, &muxFrame{priority: priority_control, seq: 3})
+	heap.Push(h, &muxFrame{priority: PRIORITY_HIGH, seq: 4})
+	heap.Push(h, &muxFrame{priority: PRIORITY_NORMAL, seq: 5})
+
+	var ret []uint64
+	for h.Len() > 0 {
+		ret = append(ret, heap.Pop(h).(*muxFrame).seq)
+	}
+	fmt.Println(ret)
+	if fmt.Sprint(ret) != "[3 2 4 5 1]" {
+		t.Error("send order fail", ret)
+	}
+}
+
+func Test0002(t *testing.T) {
+	client, server, l := newTestSession(t, "mux-test-2")
+	defer l.Close()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		for {
+			st, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer st.Close()
+				io.Copy(st, st)
+			}()
+		}
+	}()
+
+	done := make(chan error)
+	for i := 0; i < 10; i++ {
+		index := i
+		go func() {
+			st, err := client.OpenStream(PRIORITY_NORMAL + index)
+			if err != nil {
+				done <- err
+				return
+			}
+			defer st.Close()
+
+			var _ net.Conn = st
+
+			data := bytes.Repeat([]byte(strconv.Itoa(index)), 100*1024)
+			go st.Write(data)
+
+			buf := make([]byte, len(data))
+			_, err = io.ReadFull(st, buf)
+			if err != nil {
+				done <- err
+				return
+			}
+			if !bytes.Equal(buf, data) {
+				done <- fmt.Errorf("echo diff %d", index)
+				return
+			}
+			done <- nil
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second * 30):
+			t.Fatal("echo timeout")
+		}
+	}
+}
+
+func Test0003(t *testing.T) {
+	client, server, l := newTestSession(t, "mux-test-3")
+	defer l.Close()
+	defer client.Close()
+	defer server.Close()
+
+	// nobody reads the slow stream, it must not block the fast one
+	slow, err := client.OpenStream(PRIORITY_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := client.OpenStream(PRIORITY_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow.SetWriteDeadline(time.Now().Add(time.Second))
+	n, err := slow.Write(make([]byte, DefaultConfig().StreamWindow*2))
+	fmt.Println("slow write ", n, err)
+	if err != os.ErrDeadlineExceeded || n != DefaultConfig().StreamWindow {
+		t.Error("slow stream should stop at window", n, err)
+	}
+
+	_, err = fast.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.AcceptStream()
+	sfast, _ := server.AcceptStream()
+	buf := make([]byte, 5)
+	sfast.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = io.ReadFull(sfast, buf)
+	if err != nil || string(buf) != "hello" {
+		t.Error("fast stream blocked", err, string(buf))
+	}
+
+	fast.Close()
+	time.Sleep(time.Millisecond * 500)
+	_, err = sfast.Read(buf)
+	fmt.Println("read remote closed ", err)
+	if err != io.EOF {
+		t.Error("remote close should be EOF", err)
+	}
+	sfast.Close()
+	time.Sleep(time.Millisecond * 500)
+	if client.NumStreams() != 1 || server.NumStreams() != 1 {
+		t.Error("closed stream not removed", client.NumStreams(), server.NumStreams())
+	}
+}
+
+func Test0004(t *testing.T) {
+	client, server, l := newTestSession(t, "mux-test-4")
+	defer l.Close()
+	defer client.Close()
+	defer server.Close()
+
+	st, err := client.OpenStream(PRIORITY_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sst, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// send more than the window without waiting for the update, like a bad remote
+	data := make([]byte, DefaultConfig().MaxFrameSize)
+	for i := 0; i <= DefaultConfig().StreamWindow/len(data); i++ {
+		err := client.writeFrame(cmd_psh, st.Id(), data, PRIORITY_NORMAL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond * 500)
+
+	_, err = sst.Read(make([]byte, 1))
+	fmt.Println("read reset stream ", err)
+	if err == nil {
+		t.Error("overflow stream should be reset")
+	}
+	if server.NumStreams() != 0 {
+		t.Error("reset stream not removed", server.NumStreams())
+	}
+	if client.IsClosed() || server.IsClosed() {
+		t.Error("reset stream should not close session")
+	}
+
+	st.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = st.Read(make([]byte, 1))
+	fmt.Println("read remote reset stream ", err)
+	if err != io.EOF {
+		t.Error("remote reset should be EOF", err)
+	}
+}
+
+func Test0005(t *testing.T) {
+	// no sendLoop, the frame stays queued until the stream dies
+	s := &Session{config: DefaultConfig(), sendnotify: make(chan int, 1), wg: group.NewGroup("mux-test-5", nil, nil)}
+	die := make(chan int)
+	close(die)
+
+	data := []byte("hello")
+	err := s.writeFrame(cmd_psh, 1, data, PRIORITY_NORMAL, die)
+	fmt.Println("write dead stream ", err)
+	if err == nil {
+		t.Error("write dead stream should fail")
+	}
+	if s.sendqueue.Len() != 0 {
+		t.Error("dead frame not dequeued", s.sendqueue.Len())
+	}
+}
+
+func Test0006(t *testing.T) {
+	client, server, l := newTestSession(t, "mux-test-6")
+	defer l.Close()
+	defer client.Close()
+	defer server.Close()
+
+	st, err := client.OpenStream(PRIORITY_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sst, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the duplicate syn of the open stream is ignored
+	err = client.writeFrame(cmd_syn, st.Id(), nil, priority_control, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 500)
+	if server.NumStreams() != 1 {
+		t.Error("duplicate syn changed the streams", server.NumStreams())
+	}
+
+	_, err = st.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	sst.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = io.ReadFull(sst, buf)
+	fmt.Println("read after duplicate syn ", string(buf), err)
+	if err != nil || string(buf) != "hello" {
+		t.Error("stream closed by duplicate syn", err)
+	}
+}
@@ -0,0 +1,300 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stream is a net.Conn over Session, the remote can only send StreamWindow bytes until we read them
+type Stream struct {
+	id       uint32
+	session  *Session
+	priority int
+
+	lock       sync.Mutex
+	recvbuf    bytes.Buffer
+	recvnotify chan int
+	consumed   int
+	sendwin    int32
+	sendnotify chan int
+
+	remoteclosed bool
+	closed       bool
+	die          chan int
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+type muxAddr struct {
+	info string
+}
+
+func (a *muxAddr) Network() string {
+	return "mux"
+}
+
+func (a *muxAddr) String() string {
+	return a.info
+}
+
+func newStream(id uint32, session *Session, priority int) *Stream {
+	return &Stream{
+		id:         id,
+		session:    session,
+		priority:   priority,
+		recvnotify: make(chan int, 1),
+		sendwin:    int32(session.config.StreamWindow),
+		sendnotify: make(chan int, 1),
+		die:        make(chan int),
+	}
+}
+
+func (st *Stream) Id() uint32 {
+	return st.id
+}
+
+func (st *Stream) Priority() int {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return st.priority
+}
+
+// SetPriority change the send priority of stream, higher priority data goes first when the session is busy
+func (st *Stream) SetPriority(priority int) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.priority = priority
+}
+
+func (st *Stream) Read(p []byte) (n int, err error) {
+	if len(p) <= 0 {
+		return 0, nil
+	}
+
+	for {
+		st.lock.Lock()
+		if st.recvbuf.Len() > 0 {
+			n, _ = st.recvbuf.Read(p)
+			st.consumed += n
+			update := 0
+			if st.consumed >= st.session.config.StreamWindow/2 {
+				update = st.consumed
+				st.consumed = 0
+			}
+			st.lock.Unlock()
+
+			if update > 0 {
+				data := make([]byte, 4)
+				binary.LittleEndian.PutUint32(data, uint32(update))
+				st.session.writeFrame(cmd_upd, st.id, data, priority_control, st.die)
+			}
+			return n, nil
+		}
+		if st.closed {
+			st.lock.Unlock()
+			return 0, errors.New("read closed stream")
+		}
+		if st.remoteclosed {
+			st.lock.Unlock()
+			return 0, io.EOF
+		}
+		deadline := st.readDeadline
+		st.lock.Unlock()
+
+		err := st.wait(st.recvnotify, deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (st *Stream) Write(p []byte) (n int, err error) {
+	cur := 0
+	for cur < len(p) {
+		st.lock.Lock()
+		if st.closed {
+			st.lock.Unlock()
+			return cur, errors.New("write closed stream")
+		}
+		if st.remoteclosed {
+			st.lock.Unlock()
+			return cur, errors.New("write remote closed stream")
+		}
+		if st.sendwin <= 0 {
+			deadline := st.writeDeadline
+			st.lock.Unlock()
+			err := st.wait(st.sendnotify, deadline)
+			if err != nil {
+				return cur, err
+			}
+			continue
+		}
+		size := len(p) - cur
+		if size > int(st.sendwin) {
+			size = int(st.sendwin)
+		}
+		if size > st.session.config.MaxFrameSize {
+			size = st.session.config.MaxFrameSize
+		}
+		st.sendwin -= int32(size)
+		priority := st.priority
+		st.lock.Unlock()
+
+		err := st.session.writeFrame(cmd_psh, st.id, p[cur:cur+size], priority, st.die)
+		if err != nil {
+			return cur, err
+		}
+		cur += size
+	}
+	return cur, nil
+}
+
+func (st *Stream) Close() error {
+	st.lock.Lock()
+	if st.closed {
+		st.lock.Unlock()
+		return nil
+	}
+	remoteclosed := st.remoteclosed
+	st.lock.Unlock()
+
+	err := st.session.writeFrame(cmd_fin, st.id, nil, priority_control, st.die)
+
+	st.lock.Lock()
+	if !st.closed {
+		st.closed = true
+		close(st.die)
+	}
+	st.lock.Unlock()
+
+	if remoteclosed {
+		st.session.removeStream(st.id)
+	}
+	return err
+}
+
+func (st *Stream) LocalAddr() net.Addr {
+	return &muxAddr{info: st.session.Info() + " local " + strconv.Itoa(int(st.id))}
+}
+
+func (st *Stream) RemoteAddr() net.Addr {
+	return &muxAddr{info: st.session.Info() + " remote " + strconv.Itoa(int(st.id))}
+}
+
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	st.SetWriteDeadline(t)
+	return nil
+}
+
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.lock.Lock()
+	st.readDeadline = t
+	st.lock.Unlock()
+	st.notify(st.recvnotify)
+	return nil
+}
+
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.lock.Lock()
+	st.writeDeadline = t
+	st.lock.Unlock()
+	st.notify(st.sendnotify)
+	return nil
+}
+
+func (st *Stream) wait(ch chan int, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-st.die:
+		return nil
+	case <-st.session.wg.Done():
+		return errors.New("session closed")
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (st *Stream) notify(ch chan int) {
+	select {
+	case ch <- 1:
+	default:
+	}
+}
+
+// pushData return false if the buffered data would exceed StreamWindow
+func (st *Stream) pushData(data []byte) bool {
+	st.lock.Lock()
+	if st.recvbuf.Len()+len(data) > st.session.config.StreamWindow {
+		st.lock.Unlock()
+		return false
+	}
+	st.recvbuf.Write(data)
+	st.lock.Unlock()
+	st.notify(st.recvnotify)
+	return true
+}
+
+func (st *Stream) updateWindow(n int32) {
+	st.lock.Lock()
+	st.sendwin += n
+	st.lock.Unlock()
+	st.notify(st.sendnotify)
+}
+
+func (st *Stream) remoteClose() {
+	st.lock.Lock()
+	st.remoteclosed = true
+	closed := st.closed
+	st.lock.Unlock()
+	st.notify(st.recvnotify)
+	st.notify(st.sendnotify)
+
+	if closed {
+		st.session.removeStream(st.id)
+	}
+}
+
+// reset close the stream at once, drop the buffered data and tell the remote by fin
+func (st *Stream) reset() {
+	st.lock.Lock()
+	st.recvbuf.Reset()
+	st.remoteclosed = true
+	if !st.closed {
+		st.closed = true
+		close(st.die)
+	}
+	st.lock.Unlock()
+
+	st.session.removeStream(st.id)
+	go st.session.writeFrame(cmd_fin, st.id, nil, priority_control, nil)
+}
+
+func (st *Stream) sessionClose() {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if !st.closed {
+		st.closed = true
+		close(st.die)
+	}
+}
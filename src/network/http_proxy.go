@@ -0,0 +1,107 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errHttpNotProxy = errors.New("http request not proxy request")
+	errHttpAuth     = errors.New("http proxy authentication fail")
+)
+
+const httpProxyMaxHead = 64 * 1024
+
+// HttpProxyGetRequest read one http proxy request, CONNECT or absolute-URI request.
+// return the target host:port, and the data should be sent to the target first.
+// CONNECT is replied immediately. other request is rewritten to origin-form with
+// Connection: close, so the conn only carry one request to one target.
+func HttpProxyGetRequest(conn io.ReadWriter, username string, password string) (host string, data []byte, err error) {
+	br := bufio.NewReader(io.LimitReader(conn, httpProxyMaxHead))
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if username != "" {
+		if !httpProxyCheckAuth(req, username, password) {
+			HttpProxyReply(conn, http.StatusProxyAuthRequired, "Proxy-Authenticate: Basic realm=\"proxy\"\r\n")
+			return "", nil, errHttpAuth
+		}
+	}
+
+	if req.Method == http.MethodConnect {
+		host = req.Host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			HttpProxyReply(conn, http.StatusBadRequest, "")
+			return "", nil, errors.New("http connect host error " + host)
+		}
+		if err := HttpProxyReply(conn, http.StatusOK, ""); err != nil {
+			return "", nil, err
+		}
+		// the client may not wait for the reply
+		data, _ = br.Peek(br.Buffered())
+		return host, data, nil
+	}
+
+	if req.URL.Host == "" || req.URL.Scheme != "http" {
+		HttpProxyReply(conn, http.StatusBadRequest, "")
+		return "", nil, errHttpNotProxy
+	}
+	host = req.URL.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "80")
+	}
+
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	req.Header.Set("Connection", "close")
+	if len(req.TransferEncoding) > 0 {
+		req.Header.Set("Transfer-Encoding", strings.Join(req.TransferEncoding, ", "))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(req.Method + " " + req.URL.RequestURI() + " " + req.Proto + "\r\n")
+	buf.WriteString("Host: " + req.Host + "\r\n")
+	req.Header.Write(&buf)
+	buf.WriteString("\r\n")
+
+	// the body is left in conn, only the part already read is appended
+	rest, _ := br.Peek(br.Buffered())
+	buf.Write(rest)
+	return host, buf.Bytes(), nil
+}
+
+// HttpProxyReply write a response without body, header must end with \r\n if not empty
+func HttpProxyReply(conn io.Writer, code int, header string) error {
+	s := "HTTP/1.1 " + strconv.Itoa(code) + " " + http.StatusText(code) + "\r\n"
+	if code == http.StatusOK {
+		s = "HTTP/1.1 200 Connection established\r\n"
+	} else {
+		header += "Content-Length: 0\r\nConnection: close\r\n"
+	}
+	_, err := conn.Write([]byte(s + header + "\r\n"))
+	return err
+}
+
+func httpProxyCheckAuth(req *http.Request, username string, password string) bool {
+	auth := req.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	b, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return false
+	}
+	// the constant time compare does not tell how much of the password is right
+	return subtle.ConstantTimeCompare(b, []byte(username+":"+password)) == 1
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/subtle"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -19,7 +20,16 @@ var (
 )
 
 const (
-	socksCmdConnect = 1
+	Socks5CmdConnect      = 1
+	Socks5CmdBind         = 2
+	Socks5CmdUdpAssociate = 3
+
+	Socks5RepSuccess         = 0
+	Socks5RepFailure         = 1
+	Socks5RepCmdNotSupported = 7
+)
+
+const (
 	NoAuth          = uint8(0)
 	userAuthVersion = uint8(1)
 	UserPassAuth    = uint8(2)
@@ -99,7 +109,7 @@ func Sock5HandshakeBy(conn io.ReadWriter, username string, password string) (err
 		}
 
 		// Verify the password
-		if username == string(user) && password == string(pass) {
+		if subtle.ConstantTimeCompare(user, []byte(username))&subtle.ConstantTimeCompare(pass, []byte(password)) == 1 {
 			if _, err := conn.Write([]byte{userAuthVersion, authSuccess}); err != nil {
 				return err
 			}
@@ -113,6 +123,19 @@ func Sock5HandshakeBy(conn io.ReadWriter, username string, password string) (err
 }
 
 func Sock5GetRequest(conn io.ReadWriter) (rawaddr []byte, host string, err error) {
+	cmd, rawaddr, host, err := Sock5GetRequestCmd(conn)
+	if err != nil {
+		return
+	}
+	if cmd != Socks5CmdConnect {
+		err = errCmd
+		return
+	}
+	return
+}
+
+// Sock5GetRequestCmd read the request of CONNECT, BIND or UDP ASSOCIATE
+func Sock5GetRequestCmd(conn io.ReadWriter) (cmd byte, rawaddr []byte, host string, err error) {
 	const (
 		idVer   = 0
 		idCmd   = 1
@@ -141,7 +164,8 @@ func Sock5GetRequest(conn io.ReadWriter) (rawaddr []byte, host string, err error
 		err = errVer
 		return
 	}
-	if buf[idCmd] != socksCmdConnect {
+	cmd = buf[idCmd]
+	if cmd != Socks5CmdConnect && cmd != Socks5CmdBind && cmd != Socks5CmdUdpAssociate {
 		err = errCmd
 		return
 	}
@@ -0,0 +1,116 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+var errUdpFrag = errors.New("socks udp fragment not supported")
+
+// Sock5PackAddr pack host:port to ATYP ADDR PORT
+func Sock5PackAddr(host string) ([]byte, error) {
+	h, p, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || port < 0 || port > 0xffff {
+		return nil, errors.New("socks port error " + p)
+	}
+
+	var buf []byte
+	ip := net.ParseIP(h)
+	if ip == nil {
+		if len(h) > 255 {
+			return nil, errors.New("socks domain too long " + h)
+		}
+		buf = append(buf, Socks5AtypDomain, byte(len(h)))
+		buf = append(buf, h...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		buf = append(buf, Socks5AtypIP4)
+		buf = append(buf, ip4...)
+	} else {
+		buf = append(buf, Socks5AtypIP6)
+		buf = append(buf, ip.To16()...)
+	}
+	buf = append(buf, byte(port>>8), byte(port))
+	return buf, nil
+}
+
+// Sock5ParseAddr parse ATYP ADDR PORT, return host:port and the length parsed
+func Sock5ParseAddr(b []byte) (host string, n int, err error) {
+	if len(b) < 1 {
+		return "", 0, errAddrType
+	}
+	switch b[0] {
+	case Socks5AtypIP4:
+		n = 1 + net.IPv4len + 2
+		if len(b) < n {
+			return "", 0, errAddrType
+		}
+		host = net.IP(b[1 : 1+net.IPv4len]).String()
+	case Socks5AtypIP6:
+		n = 1 + net.IPv6len + 2
+		if len(b) < n {
+			return "", 0, errAddrType
+		}
+		host = net.IP(b[1 : 1+net.IPv6len]).String()
+	case Socks5AtypDomain:
+		if len(b) < 2 {
+			return "", 0, errAddrType
+		}
+		n = 1 + 1 + int(b[1]) + 2
+		if len(b) < n {
+			return "", 0, errAddrType
+		}
+		host = string(b[2 : 2+int(b[1])])
+	default:
+		return "", 0, errAddrType
+	}
+	port := binary.BigEndian.Uint16(b[n-2 : n])
+	host = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	return host, n, nil
+}
+
+// Sock5Reply write the reply of request, addr is the BND.ADDR
+func Sock5Reply(conn io.Writer, rep byte, addr string) error {
+	rawaddr, err := Sock5PackAddr(addr)
+	if err != nil {
+		return err
+	}
+	buf := []byte{socksVer5, rep, 0x00}
+	buf = append(buf, rawaddr...)
+	_, err = conn.Write(buf)
+	return err
+}
+
+// Sock5PackUdp add the udp request header RSV FRAG ATYP ADDR PORT
+func Sock5PackUdp(host string, data []byte) ([]byte, error) {
+	rawaddr, err := Sock5PackAddr(host)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 3+len(rawaddr)+len(data))
+	buf = append(buf, 0x00, 0x00, 0x00)
+	buf = append(buf, rawaddr...)
+	buf = append(buf, data...)
+	return buf, nil
+}
+
+// Sock5ParseUdp parse the udp request header, fragment is not supported
+func Sock5ParseUdp(b []byte) (host string, data []byte, err error) {
+	if len(b) < 3 {
+		return "", nil, errAddrType
+	}
+	if b[2] != 0 {
+		return "", nil, errUdpFrag
+	}
+	host, n, err := Sock5ParseAddr(b[3:])
+	if err != nil {
+		return "", nil, err
+	}
+	return host, b[3+n:], nil
+}
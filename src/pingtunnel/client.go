@@ -2,16 +2,15 @@ package pingtunnel
 
 import (
 	"github.com/esrrhs/go-engine/src/common"
-	"github.com/esrrhs/go-engine/src/frame"
+	"github.com/esrrhs/go-engine/src/conn"
 	"github.com/esrrhs/go-engine/src/loggo"
 	"github.com/esrrhs/go-engine/src/network"
-	"github.com/golang/protobuf/proto"
-	"golang.org/x/net/icmp"
 	"io"
 	"math"
 	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -46,14 +45,11 @@ func NewClient(addr string, server string, target string, timeout int, key int,
 	}
 
 	rand.Seed(time.Now().UnixNano())
-	return &Client{
-		exit:                  false,
-		rtt:                   0,
+	c := &Client{
 		id:                    rand.Intn(math.MaxInt16),
 		ipaddr:                ipaddr,
 		tcpaddr:               tcpaddr,
 		addr:                  addr,
-		ipaddrServer:          ipaddrServer,
 		addrServer:            server,
 		targetAddr:            target,
 		timeout:               timeout,
@@ -66,19 +62,22 @@ func NewClient(addr string, server string, target string, timeout int, key int,
 		tcpmode_stat:          tcpmode_stat,
 		open_sock5:            open_sock5,
 		maxconn:               maxconn,
-		pongTime:              time.Now(),
+		pongTime:              time.Now().UnixNano(),
 		sock5_filter:          sock5_filter,
-	}, nil
+		listenPacket:          listenICMP,
+	}
+	c.ipaddrServer.Store(ipaddrServer)
+	return c, nil
 }
 
 type Client struct {
-	exit           bool
-	rtt            time.Duration
+	exit           int32
+	rtt            int64
 	workResultLock sync.WaitGroup
 	maxconn        int
 
 	id       int
-	sequence int
+	sequence int32
 
 	timeout               int
 	sproto                int
@@ -98,14 +97,17 @@ type Client struct {
 	tcpaddr *net.TCPAddr
 	addr    string
 
-	ipaddrServer *net.IPAddr
+	ipaddrServer atomic.Value // *net.IPAddr，会被updateServerAddr更新
 	addrServer   string
 
 	targetAddr string
 
-	conn          *icmp.PacketConn
+	conn          net.PacketConn
 	listenConn    *net.UDPConn
 	tcplistenConn *net.TCPListener
+	listenPacket  func() (net.PacketConn, error)
+	obfs          *conn.ObfsConfig
+	ricmp         conn.Conn
 
 	localAddrToConnMap sync.Map
 	localIdToConnMap   sync.Map
@@ -114,24 +116,25 @@ type Client struct {
 	recvPacket             uint64
 	sendPacketSize         uint64
 	recvPacketSize         uint64
-	localAddrToConnMapSize int
-	localIdToConnMapSize   int
+	localAddrToConnMapSize int32
+	localIdToConnMapSize   int32
 
 	recvcontrol chan int
 
-	pongTime time.Time
+	pongTime int64
 }
 
 type ClientConn struct {
-	exit           bool
+	exit           int32
 	ipaddr         *net.UDPAddr
 	tcpaddr        *net.TCPAddr
 	id             string
-	activeRecvTime time.Time
-	activeSendTime time.Time
-	close          bool
+	activeRecvTime int64 // UnixNano
+	activeSendTime int64 // UnixNano
+	close          int32
 
-	fm *frame.FrameMgr
+	tcpconn *net.TCPConn
+	rconn   conn.Conn
 }
 
 func (p *Client) Addr() string {
@@ -147,7 +150,7 @@ func (p *Client) TargetAddr() string {
 }
 
 func (p *Client) ServerIPAddr() *net.IPAddr {
-	return p.ipaddrServer
+	return p.ipaddrServer.Load().(*net.IPAddr)
 }
 
 func (p *Client) ServerAddr() string {
@@ -155,43 +158,79 @@ func (p *Client) ServerAddr() string {
 }
 
 func (p *Client) RTT() time.Duration {
-	return p.rtt
+	return time.Duration(atomic.LoadInt64(&p.rtt))
 }
 
 func (p *Client) RecvPacketSize() uint64 {
-	return p.recvPacketSize
+	return atomic.LoadUint64(&p.recvPacketSize)
 }
 
 func (p *Client) SendPacketSize() uint64 {
-	return p.sendPacketSize
+	return atomic.LoadUint64(&p.sendPacketSize)
 }
 
 func (p *Client) RecvPacket() uint64 {
-	return p.recvPacket
+	return atomic.LoadUint64(&p.recvPacket)
 }
 
 func (p *Client) SendPacket() uint64 {
-	return p.sendPacket
+	return atomic.LoadUint64(&p.sendPacket)
 }
 
 func (p *Client) LocalIdToConnMapSize() int {
-	return p.localIdToConnMapSize
+	return int(atomic.LoadInt32(&p.localIdToConnMapSize))
 }
 
 func (p *Client) LocalAddrToConnMapSize() int {
-	return p.localAddrToConnMapSize
+	return int(atomic.LoadInt32(&p.localAddrToConnMapSize))
+}
+
+// SetListenPacket replace the raw icmp socket, call it before Run
+func (p *Client) SetListenPacket(listenPacket func() (net.PacketConn, error)) {
+	p.listenPacket = listenPacket
+}
+
+// SetObfs obfuscates all the icmp packets, the client and server must be the same, call it before Run
+func (p *Client) SetObfs(config *conn.ObfsConfig) {
+	p.obfs = config
 }
 
 func (p *Client) Run() error {
 
-	conn, err := icmp.ListenPacket("ip4:icmp", "")
+	listenPacket := p.listenPacket
+	if p.obfs != nil {
+		obfs, err := conn.NewObfs(p.obfs)
+		if err != nil {
+			loggo.Error("Error obfs: %s", err.Error())
+			return err
+		}
+		listenPacket = conn.ObfsListenPacket(p.listenPacket, obfs)
+	}
+
+	icmpconn, err := listenPacket()
 	if err != nil {
 		loggo.Error("Error listening for ICMP packets: %s", err.Error())
 		return err
 	}
-	p.conn = conn
+	// the ricmp dialers share the socket, the obfs is done under the mux
+	mux := newIcmpMux(icmpconn)
+	p.conn = mux.myConn()
 
 	if p.tcpmode > 0 {
+		config := newRicmpConfig(mux.listenRicmp)
+		if p.tcpmode_buffersize > 0 {
+			config.BufferSize = p.tcpmode_buffersize
+		}
+		if p.tcpmode_maxwin > 0 {
+			config.MaxWin = p.tcpmode_maxwin
+		}
+		if p.tcpmode_resend_timems > 0 {
+			config.ResendTimems = p.tcpmode_resend_timems
+		}
+		config.Compress = p.tcpmode_compress
+		config.Stat = p.tcpmode_stat
+		p.ricmp = conn.NewRicmpConn(config)
+
 		tcplistenConn, err := net.ListenTCP("tcp", p.tcpaddr)
 		if err != nil {
 			loggo.Error("Error listening for tcp packets: %s", err.Error())
@@ -215,15 +254,15 @@ func (p *Client) Run() error {
 
 	recv := make(chan *Packet, 10000)
 	p.recvcontrol = make(chan int, 1)
-	go recvICMP(&p.workResultLock, &p.exit, *p.conn, recv)
+	p.workResultLock.Add(1)
+	go recvICMP(&p.workResultLock, &p.exit, p.conn, recv)
 
+	p.workResultLock.Add(1)
 	go func() {
 		defer common.CrashLog()
-
-		p.workResultLock.Add(1)
 		defer p.workResultLock.Done()
 
-		for !p.exit {
+		for !p.isExit() {
 			p.checkTimeoutConn()
 			p.ping()
 			p.showNet()
@@ -231,25 +270,23 @@ func (p *Client) Run() error {
 		}
 	}()
 
+	p.workResultLock.Add(1)
 	go func() {
 		defer common.CrashLog()
-
-		p.workResultLock.Add(1)
 		defer p.workResultLock.Done()
 
-		for !p.exit {
+		for !p.isExit() {
 			p.updateServerAddr()
 			time.Sleep(time.Second)
 		}
 	}()
 
+	p.workResultLock.Add(1)
 	go func() {
 		defer common.CrashLog()
-
-		p.workResultLock.Add(1)
 		defer p.workResultLock.Done()
 
-		for !p.exit {
+		for !p.isExit() {
 			select {
 			case <-p.recvcontrol:
 				return
@@ -262,9 +299,20 @@ func (p *Client) Run() error {
 	return nil
 }
 
+func (p *Client) isExit() bool {
+	return atomic.LoadInt32(&p.exit) != 0
+}
+
 func (p *Client) Stop() {
-	p.exit = true
+	atomic.StoreInt32(&p.exit, 1)
 	p.recvcontrol <- 1
+	p.localIdToConnMap.Range(func(key, value interface{}) bool {
+		clientConn := value.(*ClientConn)
+		if clientConn.tcpconn != nil {
+			clientConn.tcpconn.Close()
+		}
+		return true
+	})
 	p.workResultLock.Wait()
 	p.conn.Close()
 	if p.tcplistenConn != nil {
@@ -284,7 +332,7 @@ func (p *Client) AcceptTcp() error {
 
 	loggo.Info("client waiting local accept tcp")
 
-	for !p.exit {
+	for !p.isExit() {
 		p.tcplistenConn.SetDeadline(time.Now().Add(time.Millisecond * 1000))
 
 		conn, err := p.tcplistenConn.AcceptTCP()
@@ -316,196 +364,52 @@ func (p *Client) AcceptTcpConn(conn *net.TCPConn, targetAddr string) {
 
 	tcpsrcaddr := conn.RemoteAddr().(*net.TCPAddr)
 
-	if p.maxconn > 0 && p.localIdToConnMapSize >= p.maxconn {
-		loggo.Info("too many connections %d, client accept new local tcp fail %s", p.localIdToConnMapSize, tcpsrcaddr.String())
+	if p.maxconn > 0 && p.LocalIdToConnMapSize() >= p.maxconn {
+		loggo.Info("too many connections %d, client accept new local tcp fail %s", p.LocalIdToConnMapSize(), tcpsrcaddr.String())
+		conn.Close()
 		return
 	}
 
 	uuid := common.UniqueId()
 
-	fm := frame.NewFrameMgr(FRAME_MAX_SIZE, FRAME_MAX_ID, p.tcpmode_buffersize, p.tcpmode_maxwin, p.tcpmode_resend_timems, p.tcpmode_compress, p.tcpmode_stat)
-
-	now := time.Now()
-	clientConn := &ClientConn{exit: false, tcpaddr: tcpsrcaddr, id: uuid, activeRecvTime: now, activeSendTime: now, close: false,
-		fm: fm}
+	now := time.Now().UnixNano()
+	clientConn := &ClientConn{tcpaddr: tcpsrcaddr, id: uuid, activeRecvTime: now, activeSendTime: now,
+		tcpconn: conn}
 	p.addClientConn(uuid, tcpsrcaddr.String(), clientConn)
 	loggo.Info("client accept new local tcp %s %s", uuid, tcpsrcaddr.String())
 
 	loggo.Info("start connect remote tcp %s %s", uuid, tcpsrcaddr.String())
-	clientConn.fm.Connect()
-	startConnectTime := common.GetNowUpdateInSecond()
-	for !p.exit && !clientConn.exit {
-		if clientConn.fm.IsConnected() {
-			break
-		}
-		clientConn.fm.Update()
-		sendlist := clientConn.fm.GetSendList()
-		for e := sendlist.Front(); e != nil; e = e.Next() {
-			f := e.Value.(*frame.Frame)
-			mb, _ := clientConn.fm.MarshalFrame(f)
-			p.sequence++
-			sendICMP(p.id, p.sequence, *p.conn, p.ipaddrServer, targetAddr, clientConn.id, (uint32)(MyMsg_DATA), mb,
-				SEND_PROTO, RECV_PROTO, p.key,
-				p.tcpmode, p.tcpmode_buffersize, p.tcpmode_maxwin, p.tcpmode_resend_timems, p.tcpmode_compress, p.tcpmode_stat,
-				p.timeout)
-			p.sendPacket++
-			p.sendPacketSize += (uint64)(len(mb))
-		}
-		time.Sleep(time.Millisecond * 10)
-		now := common.GetNowUpdateInSecond()
-		diffclose := now.Sub(startConnectTime)
-		if diffclose > time.Second*5 {
-			loggo.Info("can not connect remote tcp %s %s", uuid, tcpsrcaddr.String())
-			p.close(clientConn)
-			return
-		}
-	}
-
-	if !clientConn.exit {
-		loggo.Info("connected remote tcp %s %s", uuid, tcpsrcaddr.String())
+	rconn, err := p.ricmp.Dial(p.ServerIPAddr().String())
+	if err != nil {
+		loggo.Info("can not connect remote tcp %s %s %s", uuid, tcpsrcaddr.String(), err)
+		conn.Close()
+		p.close(clientConn)
+		return
 	}
+	clientConn.rconn = rconn
 
-	bytes := make([]byte, 10240)
-
-	tcpActiveRecvTime := common.GetNowUpdateInSecond()
-	tcpActiveSendTime := common.GetNowUpdateInSecond()
-
-	for !p.exit && !clientConn.exit {
-		now := common.GetNowUpdateInSecond()
-		sleep := true
-
-		left := common.MinOfInt(clientConn.fm.GetSendBufferLeft(), len(bytes))
-		if left > 0 {
-			conn.SetReadDeadline(time.Now().Add(time.Millisecond * 1))
-			n, err := conn.Read(bytes[0:left])
-			if err != nil {
-				nerr, ok := err.(net.Error)
-				if !ok || !nerr.Timeout() {
-					loggo.Info("Error read tcp %s %s %s", uuid, tcpsrcaddr.String(), err)
-					clientConn.fm.Close()
-					break
-				}
-			}
-			if n > 0 {
-				sleep = false
-				clientConn.fm.WriteSendBuffer(bytes[:n])
-				tcpActiveRecvTime = now
-			}
-		}
-
-		clientConn.fm.Update()
-
-		sendlist := clientConn.fm.GetSendList()
-		if sendlist.Len() > 0 {
-			sleep = false
-			clientConn.activeSendTime = now
-			for e := sendlist.Front(); e != nil; e = e.Next() {
-				f := e.Value.(*frame.Frame)
-				mb, err := clientConn.fm.MarshalFrame(f)
-				if err != nil {
-					loggo.Error("Error tcp Marshal %s %s %s", uuid, tcpsrcaddr.String(), err)
-					continue
-				}
-				p.sequence++
-				sendICMP(p.id, p.sequence, *p.conn, p.ipaddrServer, targetAddr, clientConn.id, (uint32)(MyMsg_DATA), mb,
-					SEND_PROTO, RECV_PROTO, p.key,
-					p.tcpmode, 0, 0, 0, 0, 0,
-					0)
-				p.sendPacket++
-				p.sendPacketSize += (uint64)(len(mb))
-			}
-		}
-
-		if clientConn.fm.GetRecvBufferSize() > 0 {
-			sleep = false
-			rr := clientConn.fm.GetRecvReadLineBuffer()
-			conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 1))
-			n, err := conn.Write(rr)
-			if err != nil {
-				nerr, ok := err.(net.Error)
-				if !ok || !nerr.Timeout() {
-					loggo.Info("Error write tcp %s %s %s", uuid, tcpsrcaddr.String(), err)
-					clientConn.fm.Close()
-					break
-				}
-			}
-			if n > 0 {
-				clientConn.fm.SkipRecvBuffer(n)
-				tcpActiveSendTime = now
-			}
-		}
-
-		if sleep {
-			time.Sleep(time.Millisecond * 10)
-		}
-
-		diffrecv := now.Sub(clientConn.activeRecvTime)
-		diffsend := now.Sub(clientConn.activeSendTime)
-		tcpdiffrecv := now.Sub(tcpActiveRecvTime)
-		tcpdiffsend := now.Sub(tcpActiveSendTime)
-		if diffrecv > time.Second*(time.Duration(p.timeout)) || diffsend > time.Second*(time.Duration(p.timeout)) ||
-			(tcpdiffrecv > time.Second*(time.Duration(p.timeout)) && tcpdiffsend > time.Second*(time.Duration(p.timeout))) {
-			loggo.Info("close inactive conn %s %s", clientConn.id, clientConn.tcpaddr.String())
-			clientConn.fm.Close()
-			break
-		}
-
-		if clientConn.fm.IsRemoteClosed() {
-			loggo.Info("closed by remote conn %s %s", clientConn.id, clientConn.tcpaddr.String())
-			clientConn.fm.Close()
-			break
-		}
+	err = writeTcpHead(rconn, &MyMsg{
+		Id:      uuid,
+		Type:    (int32)(MyMsg_DATA),
+		Target:  targetAddr,
+		Key:     (int32)(p.key),
+		Tcpmode: (int32)(p.tcpmode),
+		Timeout: (int32)(p.timeout),
+		Magic:   (int32)(MyMsg_MAGIC),
+	})
+	if err != nil {
+		loggo.Info("Error write tcp head %s %s %s", uuid, tcpsrcaddr.String(), err)
+		rconn.Close()
+		conn.Close()
+		p.close(clientConn)
+		return
 	}
 
-	clientConn.fm.Close()
-
-	startCloseTime := common.GetNowUpdateInSecond()
-	for !p.exit && !clientConn.exit {
-		now := common.GetNowUpdateInSecond()
-
-		clientConn.fm.Update()
-
-		sendlist := clientConn.fm.GetSendList()
-		for e := sendlist.Front(); e != nil; e = e.Next() {
-			f := e.Value.(*frame.Frame)
-			mb, _ := clientConn.fm.MarshalFrame(f)
-			p.sequence++
-			sendICMP(p.id, p.sequence, *p.conn, p.ipaddrServer, targetAddr, clientConn.id, (uint32)(MyMsg_DATA), mb,
-				SEND_PROTO, RECV_PROTO, p.key,
-				p.tcpmode, 0, 0, 0, 0, 0,
-				0)
-			p.sendPacket++
-			p.sendPacketSize += (uint64)(len(mb))
-		}
-
-		nodatarecv := true
-		if clientConn.fm.GetRecvBufferSize() > 0 {
-			rr := clientConn.fm.GetRecvReadLineBuffer()
-			conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
-			n, _ := conn.Write(rr)
-			if n > 0 {
-				clientConn.fm.SkipRecvBuffer(n)
-				nodatarecv = false
-			}
-		}
-
-		diffclose := now.Sub(startCloseTime)
-		if diffclose > time.Second*10 {
-			loggo.Info("close conn had timeout %s %s", clientConn.id, clientConn.tcpaddr.String())
-			break
-		}
+	loggo.Info("connected remote tcp %s %s", uuid, tcpsrcaddr.String())
 
-		remoteclosed := clientConn.fm.IsRemoteClosed()
-		if remoteclosed && nodatarecv {
-			loggo.Info("remote conn had closed %s %s", clientConn.id, clientConn.tcpaddr.String())
-			break
-		}
-
-		time.Sleep(time.Millisecond * 100)
-	}
+	transferTcp(conn, rconn)
 
 	loggo.Info("close tcp conn %s %s", clientConn.id, clientConn.tcpaddr.String())
-	conn.Close()
 	p.close(clientConn)
 }
 
@@ -520,7 +424,7 @@ func (p *Client) Accept() error {
 
 	bytes := make([]byte, 10240)
 
-	for !p.exit {
+	for !p.isExit() {
 		p.listenConn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
 		n, srcaddr, err := p.listenConn.ReadFromUDP(bytes)
 		if err != nil {
@@ -534,29 +438,27 @@ func (p *Client) Accept() error {
 			continue
 		}
 
-		now := common.GetNowUpdateInSecond()
+		now := common.GetNowUpdateInSecond().UnixNano()
 		clientConn := p.getClientConnByAddr(srcaddr.String())
 		if clientConn == nil {
-			if p.maxconn > 0 && p.localIdToConnMapSize >= p.maxconn {
-				loggo.Info("too many connections %d, client accept new local udp fail %s", p.localIdToConnMapSize, srcaddr.String())
+			if p.maxconn > 0 && p.LocalIdToConnMapSize() >= p.maxconn {
+				loggo.Info("too many connections %d, client accept new local udp fail %s", p.LocalIdToConnMapSize(), srcaddr.String())
 				continue
 			}
 			uuid := common.UniqueId()
-			clientConn = &ClientConn{exit: false, ipaddr: srcaddr, id: uuid, activeRecvTime: now, activeSendTime: now, close: false}
+			clientConn = &ClientConn{ipaddr: srcaddr, id: uuid, activeRecvTime: now, activeSendTime: now}
 			p.addClientConn(uuid, srcaddr.String(), clientConn)
 			loggo.Info("client accept new local udp %s %s", uuid, srcaddr.String())
 		}
 
-		clientConn.activeSendTime = now
-		sendICMP(p.id, p.sequence, *p.conn, p.ipaddrServer, p.targetAddr, clientConn.id, (uint32)(MyMsg_DATA), bytes[:n],
+		atomic.StoreInt64(&clientConn.activeSendTime, now)
+		sendICMP(p.id, p.nextSequence(), p.conn, p.ServerIPAddr(), p.targetAddr, clientConn.id, (uint32)(MyMsg_DATA), bytes[:n],
 			SEND_PROTO, RECV_PROTO, p.key,
 			p.tcpmode, 0, 0, 0, 0, 0,
 			p.timeout)
 
-		p.sequence++
-
-		p.sendPacket++
-		p.sendPacketSize += (uint64)(n)
+		atomic.AddUint64(&p.sendPacket, 1)
+		atomic.AddUint64(&p.sendPacketSize, (uint64)(n))
 	}
 	return nil
 }
@@ -581,8 +483,8 @@ func (p *Client) processPacket(packet *Packet) {
 		now := time.Now()
 		d := now.Sub(t)
 		loggo.Info("pong from %s %s", packet.src.String(), d.String())
-		p.rtt = d
-		p.pongTime = now
+		atomic.StoreInt64(&p.rtt, int64(d))
+		atomic.StoreInt64(&p.pongTime, now.UnixNano())
 		return
 	}
 
@@ -595,6 +497,11 @@ func (p *Client) processPacket(packet *Packet) {
 		return
 	}
 
+	if p.tcpmode > 0 {
+		// the tcp mode data goes through ricmp
+		return
+	}
+
 	loggo.Debug("processPacket %s %s %d", packet.my.Id, packet.src.String(), len(packet.my.Data))
 
 	clientConn := p.getClientConnById(packet.my.Id)
@@ -604,37 +511,25 @@ func (p *Client) processPacket(packet *Packet) {
 		return
 	}
 
-	now := common.GetNowUpdateInSecond()
-	clientConn.activeRecvTime = now
+	atomic.StoreInt64(&clientConn.activeRecvTime, common.GetNowUpdateInSecond().UnixNano())
 
-	if p.tcpmode > 0 {
-		f := &frame.Frame{}
-		err := proto.Unmarshal(packet.my.Data, f)
-		if err != nil {
-			loggo.Error("Unmarshal tcp Error %s", err)
-			return
-		}
-
-		clientConn.fm.OnRecvFrame(f)
-	} else {
-		if packet.my.Data == nil {
-			return
-		}
-		addr := clientConn.ipaddr
-		_, err := p.listenConn.WriteToUDP(packet.my.Data, addr)
-		if err != nil {
-			loggo.Info("WriteToUDP Error read udp %s", err)
-			clientConn.close = true
-			return
-		}
+	if packet.my.Data == nil {
+		return
+	}
+	addr := clientConn.ipaddr
+	_, err := p.listenConn.WriteToUDP(packet.my.Data, addr)
+	if err != nil {
+		loggo.Info("WriteToUDP Error read udp %s", err)
+		atomic.StoreInt32(&clientConn.close, 1)
+		return
 	}
 
-	p.recvPacket++
-	p.recvPacketSize += (uint64)(len(packet.my.Data))
+	atomic.AddUint64(&p.recvPacket, 1)
+	atomic.AddUint64(&p.recvPacketSize, (uint64)(len(packet.my.Data)))
 }
 
 func (p *Client) close(clientConn *ClientConn) {
-	clientConn.exit = true
+	atomic.StoreInt32(&clientConn.exit, 1)
 	p.deleteClientConn(clientConn.id, clientConn.ipaddr.String())
 	p.deleteClientConn(clientConn.id, clientConn.tcpaddr.String())
 }
@@ -653,17 +548,17 @@ func (p *Client) checkTimeoutConn() {
 		return true
 	})
 
-	now := common.GetNowUpdateInSecond()
+	now := common.GetNowUpdateInSecond().UnixNano()
 	for _, conn := range tmp {
-		diffrecv := now.Sub(conn.activeRecvTime)
-		diffsend := now.Sub(conn.activeSendTime)
+		diffrecv := time.Duration(now - atomic.LoadInt64(&conn.activeRecvTime))
+		diffsend := time.Duration(now - atomic.LoadInt64(&conn.activeSendTime))
 		if diffrecv > time.Second*(time.Duration(p.timeout)) || diffsend > time.Second*(time.Duration(p.timeout)) {
-			conn.close = true
+			atomic.StoreInt32(&conn.close, 1)
 		}
 	}
 
 	for id, conn := range tmp {
-		if conn.close {
+		if atomic.LoadInt32(&conn.close) != 0 {
 			loggo.Info("close inactive conn %s %s", id, conn.ipaddr.String())
 			p.close(conn)
 		}
@@ -673,34 +568,37 @@ func (p *Client) checkTimeoutConn() {
 func (p *Client) ping() {
 	now := time.Now()
 	b, _ := now.MarshalBinary()
-	sendICMP(p.id, p.sequence, *p.conn, p.ipaddrServer, "", "", (uint32)(MyMsg_PING), b,
+	seq := p.nextSequence()
+	sendICMP(p.id, seq, p.conn, p.ServerIPAddr(), "", "", (uint32)(MyMsg_PING), b,
 		SEND_PROTO, RECV_PROTO, p.key,
 		0, 0, 0, 0, 0, 0,
 		0)
-	loggo.Info("ping %s %s %d %d %d %d", p.addrServer, now.String(), p.sproto, p.rproto, p.id, p.sequence)
-	p.sequence++
-	if now.Sub(p.pongTime) > time.Second*3 {
-		p.rtt = 0
+	loggo.Info("ping %s %s %d %d %d %d", p.addrServer, now.String(), p.sproto, p.rproto, p.id, seq)
+	if now.Sub(time.Unix(0, atomic.LoadInt64(&p.pongTime))) > time.Second*3 {
+		atomic.StoreInt64(&p.rtt, 0)
 	}
 }
 
+func (p *Client) nextSequence() int {
+	return int(atomic.AddInt32(&p.sequence, 1) - 1)
+}
+
 func (p *Client) showNet() {
-	p.localAddrToConnMapSize = 0
-	p.localIdToConnMap.Range(func(key, value interface{}) bool {
-		p.localAddrToConnMapSize++
+	var addrsize int32
+	p.localAddrToConnMap.Range(func(key, value interface{}) bool {
+		addrsize++
 		return true
 	})
-	p.localIdToConnMapSize = 0
+	atomic.StoreInt32(&p.localAddrToConnMapSize, addrsize)
+	var idsize int32
 	p.localIdToConnMap.Range(func(key, value interface{}) bool {
-		p.localIdToConnMapSize++
+		idsize++
 		return true
 	})
+	atomic.StoreInt32(&p.localIdToConnMapSize, idsize)
 	loggo.Info("send %dPacket/s %dKB/s recv %dPacket/s %dKB/s %d/%dConnections",
-		p.sendPacket, p.sendPacketSize/1024, p.recvPacket, p.recvPacketSize/1024, p.localAddrToConnMapSize, p.localIdToConnMapSize)
-	p.sendPacket = 0
-	p.recvPacket = 0
-	p.sendPacketSize = 0
-	p.recvPacketSize = 0
+		atomic.SwapUint64(&p.sendPacket, 0), atomic.SwapUint64(&p.sendPacketSize, 0)/1024,
+		atomic.SwapUint64(&p.recvPacket, 0), atomic.SwapUint64(&p.recvPacketSize, 0)/1024, addrsize, idsize)
 }
 
 func (p *Client) AcceptSock5Conn(conn *net.TCPConn) {
@@ -773,7 +671,7 @@ func (p *Client) deleteClientConn(uuid string, addr string) {
 }
 
 func (p *Client) remoteError(uuid string) {
-	sendICMP(p.id, p.sequence, *p.conn, p.ipaddrServer, "", uuid, (uint32)(MyMsg_KICK), []byte{},
+	sendICMP(p.id, p.nextSequence(), p.conn, p.ServerIPAddr(), "", uuid, (uint32)(MyMsg_KICK), []byte{},
 		SEND_PROTO, RECV_PROTO, p.key,
 		0, 0, 0, 0, 0, 0,
 		0)
@@ -824,7 +722,7 @@ func (p *Client) updateServerAddr() {
 	if err != nil {
 		return
 	}
-	if p.ipaddrServer.String() != ipaddrServer.String() {
-		p.ipaddrServer = ipaddrServer
+	if p.ServerIPAddr().String() != ipaddrServer.String() {
+		p.ipaddrServer.Store(ipaddrServer)
 	}
 }
@@ -0,0 +1,229 @@
+package pingtunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/esrrhs/go-engine/src/common"
+	"github.com/esrrhs/go-engine/src/conn"
+	"github.com/esrrhs/go-engine/src/loggo"
+	"net"
+	"sync"
+	"time"
+)
+
+const ICMP_MUX_BUFFER = 1024
+
+// icmpMux share one icmp socket between the MyMsg packets and the ricmp conns.
+// the ricmp packets start with the ricmp magic, every ricmp socket gets all of them as a raw socket does, the others go to the MyMsg socket
+type icmpMux struct {
+	conn   net.PacketConn
+	lock   sync.Mutex
+	my     *icmpMuxConn
+	ricmp  map[*icmpMuxConn]int
+	closed bool
+}
+
+type icmpMuxConn struct {
+	mux      *icmpMux
+	recv     chan *icmpMuxPacket
+	die      chan int
+	deadline time.Time
+	lock     sync.Mutex
+	closed   bool
+}
+
+type icmpMuxPacket struct {
+	src  net.Addr
+	data []byte
+}
+
+type icmpMuxTimeout struct{}
+
+func (e *icmpMuxTimeout) Error() string   { return "i/o timeout" }
+func (e *icmpMuxTimeout) Timeout() bool   { return true }
+func (e *icmpMuxTimeout) Temporary() bool { return true }
+
+func newIcmpMux(c net.PacketConn) *icmpMux {
+	m := &icmpMux{
+		conn:  c,
+		ricmp: make(map[*icmpMuxConn]int),
+	}
+	m.my = m.newConn()
+	go m.loopRecv()
+	return m
+}
+
+func (m *icmpMux) newConn() *icmpMuxConn {
+	return &icmpMuxConn{
+		mux:  m,
+		recv: make(chan *icmpMuxPacket, ICMP_MUX_BUFFER),
+		die:  make(chan int),
+	}
+}
+
+// myConn return the socket of the MyMsg packets, close it to close the mux
+func (m *icmpMux) myConn() net.PacketConn {
+	return m.my
+}
+
+// listenRicmp open a socket of the ricmp packets, set it to RicmpConfig.ListenPacket
+func (m *icmpMux) listenRicmp() (net.PacketConn, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return nil, errors.New("icmp mux closed")
+	}
+	c := m.newConn()
+	m.ricmp[c]++
+	return c, nil
+}
+
+func (m *icmpMux) close() {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return
+	}
+	m.closed = true
+	tmp := make([]*icmpMuxConn, 0, len(m.ricmp)+1)
+	tmp = append(tmp, m.my)
+	for c := range m.ricmp {
+		tmp = append(tmp, c)
+	}
+	m.lock.Unlock()
+
+	m.conn.Close()
+	for _, c := range tmp {
+		c.shut()
+	}
+}
+
+func (m *icmpMux) loopRecv() {
+	defer common.CrashLog()
+
+	bytes := make([]byte, 10240)
+	for {
+		n, srcaddr, err := m.conn.ReadFrom(bytes)
+		if err != nil {
+			m.lock.Lock()
+			closed := m.closed
+			m.lock.Unlock()
+			if closed {
+				return
+			}
+			nerr, ok := err.(net.Error)
+			if !ok || !nerr.Timeout() {
+				loggo.Info("icmp mux read error %s", err)
+			}
+			continue
+		}
+
+		p := &icmpMuxPacket{src: srcaddr, data: make([]byte, n)}
+		copy(p.data, bytes[:n])
+
+		m.lock.Lock()
+		if isRicmpPacket(p.data) {
+			for c := range m.ricmp {
+				c.push(p)
+			}
+		} else {
+			m.my.push(p)
+		}
+		m.lock.Unlock()
+	}
+}
+
+// isRicmpPacket check the magic after the 8 bytes echo header
+func isRicmpPacket(b []byte) bool {
+	if len(b) < 12 {
+		return false
+	}
+	magic := binary.BigEndian.Uint32(b[8:12])
+	return magic == conn.RICMP_MAGIC_REQUEST || magic == conn.RICMP_MAGIC_REPLY
+}
+
+// push drop the packet if the reader is too slow, as the socket buffer does
+func (c *icmpMuxConn) push(p *icmpMuxPacket) {
+	select {
+	case c.recv <- p:
+	default:
+	}
+}
+
+func (c *icmpMuxConn) shut() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.die)
+}
+
+func (c *icmpMuxConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.lock.Lock()
+	deadline := c.deadline
+	c.lock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := deadline.Sub(time.Now())
+		if d <= 0 {
+			return 0, nil, &icmpMuxTimeout{}
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p := <-c.recv:
+		return copy(b, p.data), p.src, nil
+	case <-c.die:
+		return 0, nil, errors.New("read closed socket")
+	case <-timeout:
+		return 0, nil, &icmpMuxTimeout{}
+	}
+}
+
+func (c *icmpMuxConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.lock.Lock()
+	closed := c.closed
+	c.lock.Unlock()
+	if closed {
+		return 0, errors.New("write closed socket")
+	}
+	return c.mux.conn.WriteTo(b, addr)
+}
+
+// Close the MyMsg socket close the mux and the shared socket, the ricmp one only leaves the mux
+func (c *icmpMuxConn) Close() error {
+	if c == c.mux.my {
+		c.mux.close()
+		return nil
+	}
+	c.mux.lock.Lock()
+	delete(c.mux.ricmp, c)
+	c.mux.lock.Unlock()
+	c.shut()
+	return nil
+}
+
+func (c *icmpMuxConn) LocalAddr() net.Addr {
+	return c.mux.conn.LocalAddr()
+}
+
+func (c *icmpMuxConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *icmpMuxConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *icmpMuxConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -2,17 +2,21 @@ package pingtunnel
 
 import (
 	"encoding/binary"
+	"errors"
 	"github.com/esrrhs/go-engine/src/common"
+	"github.com/esrrhs/go-engine/src/conn"
 	"github.com/esrrhs/go-engine/src/loggo"
 	"github.com/golang/protobuf/proto"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func sendICMP(id int, sequence int, conn icmp.PacketConn, server *net.IPAddr, target string,
+func sendICMP(id int, sequence int, conn net.PacketConn, server *net.IPAddr, target string,
 	connId string, msgType uint32, data []byte, sproto int, rproto int, key int,
 	tcpmode int, tcpmode_buffer_size int, tcpmode_maxwin int, tcpmode_resend_time int, tcpmode_compress int, tcpmode_stat int,
 	timeout int) {
@@ -61,15 +65,14 @@ func sendICMP(id int, sequence int, conn icmp.PacketConn, server *net.IPAddr, ta
 	conn.WriteTo(bytes, server)
 }
 
-func recvICMP(workResultLock *sync.WaitGroup, exit *bool, conn icmp.PacketConn, recv chan<- *Packet) {
+// recvICMP read the packets until exit, the caller Add the workResultLock before start it
+func recvICMP(workResultLock *sync.WaitGroup, exit *int32, conn net.PacketConn, recv chan<- *Packet) {
 
 	defer common.CrashLog()
-
-	(*workResultLock).Add(1)
 	defer (*workResultLock).Done()
 
 	bytes := make([]byte, 10240)
-	for !*exit {
+	for atomic.LoadInt32(exit) == 0 {
 		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
 		n, srcaddr, err := conn.ReadFrom(bytes)
 
@@ -117,3 +120,69 @@ const (
 	FRAME_MAX_SIZE int = 888
 	FRAME_MAX_ID   int = 1000000
 )
+
+func listenICMP() (net.PacketConn, error) {
+	return icmp.ListenPacket("ip4:icmp", "")
+}
+
+// the tcp mode is carried by ricmp, shares the same FrameMgr as conn
+func newRicmpConfig(listenPacket func() (net.PacketConn, error)) *conn.RicmpConfig {
+	config := conn.DefaultRicmpConfig()
+	config.CutSize = FRAME_MAX_SIZE
+	config.MaxId = FRAME_MAX_ID
+	config.ListenPacket = listenPacket
+	return config
+}
+
+// the first message in the ricmp conn tells the server where to connect
+func writeTcpHead(w io.Writer, my *MyMsg) error {
+	mb, err := proto.Marshal(my)
+	if err != nil {
+		return err
+	}
+	if len(mb) > 0xffff {
+		return errors.New("tcp head too long")
+	}
+	head := make([]byte, 2+len(mb))
+	binary.BigEndian.PutUint16(head, uint16(len(mb)))
+	copy(head[2:], mb)
+	_, err = w.Write(head)
+	return err
+}
+
+func readTcpHead(r io.Reader) (*MyMsg, error) {
+	head := make([]byte, 2)
+	_, err := io.ReadFull(r, head)
+	if err != nil {
+		return nil, err
+	}
+	mb := make([]byte, binary.BigEndian.Uint16(head))
+	_, err = io.ReadFull(r, mb)
+	if err != nil {
+		return nil, err
+	}
+	my := &MyMsg{}
+	err = proto.Unmarshal(mb, my)
+	if err != nil {
+		return nil, err
+	}
+	if my.Magic != (int32)(MyMsg_MAGIC) {
+		return nil, errors.New("tcp head invalid")
+	}
+	return my, nil
+}
+
+// transferTcp copy in both directions until one side closed, the ricmp conn is closed after the left data sent
+func transferTcp(tcpconn net.Conn, rconn conn.Conn) {
+	done := make(chan int)
+	go func() {
+		defer common.CrashLog()
+		io.Copy(tcpconn, rconn)
+		tcpconn.Close()
+		close(done)
+	}()
+	io.Copy(rconn, tcpconn)
+	rconn.Close()
+	tcpconn.Close()
+	<-done
+}
@@ -1,9 +1,16 @@
 package pingtunnel
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"github.com/esrrhs/go-engine/src/conn"
 	"github.com/golang/protobuf/proto"
+	"io"
+	"math/rand"
+	"net"
 	"testing"
+	"time"
 )
 
 func Test0001(t *testing.T) {
@@ -25,3 +32,311 @@ func Test0001(t *testing.T) {
 	fmt.Println("my1 = ", my1)
 
 }
+
+func startTestTcpEcho(t *testing.T, addr string) net.Listener {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return l
+}
+
+func startTestTunnel(t *testing.T, fakenet *conn.FakeIcmpNet, key int, tcpmode int, addr string, target string) (*Server, *Client) {
+	server, err := NewServer(123, 0, 0, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetListenPacket(fakenet.Host("10.0.0.2"))
+	err = server.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(addr, "10.0.0.2", target, 60, key,
+		tcpmode, 1024*1024, 10000, 200, 0, 0, 0, 0, nil)
+	if err != nil {
+		server.Stop()
+		t.Fatal(err)
+	}
+	client.SetListenPacket(fakenet.Host("10.0.0.1"))
+	err = client.Run()
+	if err != nil {
+		server.Stop()
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func testTcpEcho(c net.Conn, data []byte) error {
+	c.SetDeadline(time.Now().Add(time.Second * 30))
+	go c.Write(data)
+	buf := make([]byte, len(data))
+	_, err := io.ReadFull(c, buf)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(buf, data) {
+		return fmt.Errorf("echo data diff")
+	}
+	return nil
+}
+
+func testServerConnNum(s *Server) int {
+	n := 0
+	s.localConnMap.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func Test0001Tcp(t *testing.T) {
+	target := startTestTcpEcho(t, "127.0.0.1:58091")
+	defer target.Close()
+
+	fakenet := conn.NewFakeIcmpNet(0)
+	server, client := startTestTunnel(t, fakenet, 123, 1, "127.0.0.1:58090", "127.0.0.1:58091")
+	defer server.Stop()
+	defer client.Stop()
+
+	c, err := net.DialTimeout("tcp", "127.0.0.1:58090", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 256*1024)
+	rand.Read(data)
+	err = testTcpEcho(c, data)
+	if err != nil {
+		t.Error(err)
+	}
+	if testServerConnNum(server) != 1 {
+		t.Error("server conn num", testServerConnNum(server))
+	}
+
+	time.Sleep(time.Second * 2)
+	fmt.Println("rtt", client.RTT())
+	if client.RTT() <= 0 {
+		t.Error("no pong")
+	}
+
+	// the close goes to the server too
+	c.Close()
+	time.Sleep(time.Second * 2)
+	if testServerConnNum(server) != 0 {
+		t.Error("server conn not closed", testServerConnNum(server))
+	}
+}
+
+func Test0001TcpLoss(t *testing.T) {
+	target := startTestTcpEcho(t, "127.0.0.1:58093")
+	defer target.Close()
+
+	fakenet := conn.NewFakeIcmpNet(0.1)
+	server, client := startTestTunnel(t, fakenet, 123, 1, "127.0.0.1:58092", "127.0.0.1:58093")
+	defer server.Stop()
+	defer client.Stop()
+
+	done := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			c, err := net.DialTimeout("tcp", "127.0.0.1:58092", time.Second)
+			if err != nil {
+				done <- err
+				return
+			}
+			defer c.Close()
+			data := make([]byte, 128*1024)
+			rand.Read(data)
+			done <- testTcpEcho(c, data)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		err := <-done
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func Test0001TcpKey(t *testing.T) {
+	target := startTestTcpEcho(t, "127.0.0.1:58095")
+	defer target.Close()
+
+	fakenet := conn.NewFakeIcmpNet(0)
+	server, client := startTestTunnel(t, fakenet, 456, 1, "127.0.0.1:58094", "127.0.0.1:58095")
+	defer server.Stop()
+	defer client.Stop()
+
+	c, err := net.DialTimeout("tcp", "127.0.0.1:58094", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	err = testTcpEcho(c, []byte("hello"))
+	fmt.Println(err)
+	if err == nil {
+		t.Error("wrong key should fail")
+	}
+	if testServerConnNum(server) != 0 {
+		t.Error("server conn num", testServerConnNum(server))
+	}
+}
+
+func Test0001Udp(t *testing.T) {
+	target, err := net.ListenPacket("udp", "127.0.0.1:58097")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		buf := make([]byte, 2000)
+		for {
+			n, addr, err := target.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			target.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	fakenet := conn.NewFakeIcmpNet(0)
+	server, client := startTestTunnel(t, fakenet, 123, 0, "127.0.0.1:58096", "127.0.0.1:58097")
+	defer server.Stop()
+	defer client.Stop()
+
+	c, err := net.Dial("udp", "127.0.0.1:58096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		data := []byte(fmt.Sprintf("hello %d", i))
+		c.Write(data)
+		c.SetReadDeadline(time.Now().Add(time.Second * 5))
+		buf := make([]byte, 2000)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], data) {
+			t.Error("udp data diff", string(buf[:n]))
+		}
+	}
+}
+
+func Test0001TcpObfs(t *testing.T) {
+	target := startTestTcpEcho(t, "127.0.0.1:58099")
+	defer target.Close()
+
+	obfs := conn.DefaultObfsConfig()
+	obfs.Key = "123"
+	obfs.Mimic = conn.OBFS_MIMIC_LINUX
+
+	fakenet := conn.NewFakeIcmpNet(0)
+	server, err := NewServer(123, 0, 0, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetListenPacket(fakenet.Host("10.0.0.2"))
+	server.SetObfs(obfs)
+	err = server.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	client, err := NewClient("127.0.0.1:58098", "10.0.0.2", "127.0.0.1:58099", 60, 123,
+		1, 1024*1024, 10000, 200, 0, 0, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetListenPacket(fakenet.Host("10.0.0.1"))
+	client.SetObfs(obfs)
+	err = client.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	c, err := net.DialTimeout("tcp", "127.0.0.1:58098", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+	err = testTcpEcho(c, data)
+	if err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(time.Second * 2)
+	if client.RTT() <= 0 {
+		t.Error("no pong with obfs")
+	}
+}
+
+func Test0002Mux(t *testing.T) {
+	fakenet := conn.NewFakeIcmpNet(0)
+	a, _ := fakenet.Host("10.0.0.1")()
+	defer a.Close()
+	b, _ := fakenet.Host("10.0.0.2")()
+
+	mux := newIcmpMux(b)
+	my := mux.myConn()
+	defer my.Close()
+	r1, _ := mux.listenRicmp()
+	r2, _ := mux.listenRicmp()
+	defer r2.Close()
+
+	dst := &net.IPAddr{IP: net.ParseIP("10.0.0.2")}
+	sendICMP(1, 1, a, dst, "", "id", (uint32)(MyMsg_DATA), []byte("my"), SEND_PROTO, RECV_PROTO, 0, 0, 0, 0, 0, 0, 0, 0)
+	ricmp := []byte{8, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 'r'}
+	binary.BigEndian.PutUint32(ricmp[8:], conn.RICMP_MAGIC_REQUEST)
+	a.WriteTo(ricmp, dst)
+
+	buf := make([]byte, 1024)
+	my.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := my.ReadFrom(buf)
+	if err != nil || isRicmpPacket(buf[:n]) {
+		t.Error("my conn should get the MyMsg", err)
+	}
+	for _, r := range []net.PacketConn{r1, r2} {
+		r.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err = r.ReadFrom(buf)
+		if err != nil || !isRicmpPacket(buf[:n]) {
+			t.Error("every ricmp conn should get the ricmp packet", err)
+		}
+	}
+	my.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	_, _, err = my.ReadFrom(buf)
+	fmt.Println("my conn ", err)
+	if err == nil {
+		t.Error("my conn should not get the ricmp packet")
+	}
+
+	// the ricmp conn only leaves the mux
+	r1.Close()
+	a.WriteTo(ricmp, dst)
+	r2.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = r2.ReadFrom(buf)
+	if err != nil {
+		t.Error("ricmp conn closed by the other", err)
+	}
+}
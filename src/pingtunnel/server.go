@@ -2,24 +2,23 @@ package pingtunnel
 
 import (
 	"github.com/esrrhs/go-engine/src/common"
-	"github.com/esrrhs/go-engine/src/frame"
+	"github.com/esrrhs/go-engine/src/conn"
 	"github.com/esrrhs/go-engine/src/loggo"
 	"github.com/esrrhs/go-engine/src/threadpool"
-	"github.com/golang/protobuf/proto"
-	"golang.org/x/net/icmp"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 func NewServer(key int, maxconn int, maxprocessthread int, maxprocessbuffer int, connecttmeout int) (*Server, error) {
 	s := &Server{
-		exit:             false,
 		key:              key,
 		maxconn:          maxconn,
 		maxprocessthread: maxprocessthread,
 		maxprocessbuffer: maxprocessbuffer,
 		connecttmeout:    connecttmeout,
+		listenPacket:     listenICMP,
 	}
 
 	if maxprocessthread > 0 {
@@ -33,7 +32,7 @@ func NewServer(key int, maxconn int, maxprocessthread int, maxprocessbuffer int,
 }
 
 type Server struct {
-	exit             bool
+	exit             int32
 	key              int
 	workResultLock   sync.WaitGroup
 	maxconn          int
@@ -41,7 +40,10 @@ type Server struct {
 	maxprocessbuffer int
 	connecttmeout    int
 
-	conn *icmp.PacketConn
+	conn          net.PacketConn
+	listenPacket  func() (net.PacketConn, error)
+	obfs          *conn.ObfsConfig
+	ricmplistener conn.Conn
 
 	localConnMap sync.Map
 	connErrorMap sync.Map
@@ -50,50 +52,83 @@ type Server struct {
 	recvPacket       uint64
 	sendPacketSize   uint64
 	recvPacketSize   uint64
-	localConnMapSize int
+	localConnMapSize int32
 
 	processtp   *threadpool.ThreadPool
 	recvcontrol chan int
 }
 
 type ServerConn struct {
-	exit           bool
+	exit           int32
 	timeout        int
 	ipaddrTarget   *net.UDPAddr
 	conn           *net.UDPConn
 	tcpaddrTarget  *net.TCPAddr
 	tcpconn        *net.TCPConn
 	id             string
-	activeRecvTime time.Time
-	activeSendTime time.Time
-	close          bool
+	activeRecvTime int64 // UnixNano
+	activeSendTime int64 // UnixNano
+	close          int32
 	rproto         int
-	fm             *frame.FrameMgr
+	rconn          conn.Conn
 	tcpmode        int
-	echoId         int
-	echoSeq        int
+	echoId         int32 // 客户端最后的echo，回复时使用
+	echoSeq        int32
+}
+
+// SetListenPacket replace the raw icmp socket, call it before Run
+func (p *Server) SetListenPacket(listenPacket func() (net.PacketConn, error)) {
+	p.listenPacket = listenPacket
+}
+
+// SetObfs obfuscates all the icmp packets, the client and server must be the same, call it before Run
+func (p *Server) SetObfs(config *conn.ObfsConfig) {
+	p.obfs = config
 }
 
 func (p *Server) Run() error {
 
-	conn, err := icmp.ListenPacket("ip4:icmp", "")
+	listenPacket := p.listenPacket
+	if p.obfs != nil {
+		obfs, err := conn.NewObfs(p.obfs)
+		if err != nil {
+			loggo.Error("Error obfs: %s", err.Error())
+			return err
+		}
+		listenPacket = conn.ObfsListenPacket(p.listenPacket, obfs)
+	}
+
+	icmpconn, err := listenPacket()
 	if err != nil {
 		loggo.Error("Error listening for ICMP packets: %s", err.Error())
 		return err
 	}
-	p.conn = conn
+	// the ricmp listener shares the socket, the obfs is done under the mux
+	mux := newIcmpMux(icmpconn)
+	p.conn = mux.myConn()
+
+	config := newRicmpConfig(mux.listenRicmp)
+	ricmplistener, err := conn.NewRicmpConn(config).Listen("")
+	if err != nil {
+		loggo.Error("Error listening for ricmp: %s", err.Error())
+		p.conn.Close()
+		return err
+	}
+	p.ricmplistener = ricmplistener
 
 	recv := make(chan *Packet, 10000)
 	p.recvcontrol = make(chan int, 1)
-	go recvICMP(&p.workResultLock, &p.exit, *p.conn, recv)
+	p.workResultLock.Add(1)
+	go recvICMP(&p.workResultLock, &p.exit, p.conn, recv)
+
+	go p.AcceptTcp()
 
+	p.workResultLock.Add(1)
 	go func() {
 		defer common.CrashLog()
-
-		p.workResultLock.Add(1)
 		defer p.workResultLock.Done()
 
-		for !p.exit {
+		for !p.isExit() {
 			p.checkTimeoutConn()
 			p.showNet()
 			p.updateConnError()
@@ -101,13 +136,12 @@ func (p *Server) Run() error {
 		}
 	}()
 
+	p.workResultLock.Add(1)
 	go func() {
 		defer common.CrashLog()
-
-		p.workResultLock.Add(1)
 		defer p.workResultLock.Done()
 
-		for !p.exit {
+		for !p.isExit() {
 			select {
 			case <-p.recvcontrol:
 				return
@@ -120,11 +154,25 @@ func (p *Server) Run() error {
 	return nil
 }
 
+func (p *Server) isExit() bool {
+	return atomic.LoadInt32(&p.exit) != 0
+}
+
 func (p *Server) Stop() {
-	p.exit = true
+	atomic.StoreInt32(&p.exit, 1)
 	p.recvcontrol <- 1
+	p.ricmplistener.Close()
+	p.localConnMap.Range(func(key, value interface{}) bool {
+		serverConn := value.(*ServerConn)
+		if serverConn.tcpconn != nil {
+			serverConn.tcpconn.Close()
+		}
+		return true
+	})
 	p.workResultLock.Wait()
-	p.processtp.Stop()
+	if p.processtp != nil {
+		p.processtp.Stop()
+	}
 	p.conn.Close()
 }
 
@@ -138,7 +186,7 @@ func (p *Server) processPacket(packet *Packet) {
 		t := time.Time{}
 		t.UnmarshalBinary(packet.my.Data)
 		loggo.Info("ping from %s %s %d %d %d", packet.src.String(), t.String(), packet.my.Rproto, packet.echoId, packet.echoSeq)
-		sendICMP(packet.echoId, packet.echoSeq, *p.conn, packet.src, "", "", (uint32)(MyMsg_PING), packet.my.Data,
+		sendICMP(packet.echoId, packet.echoSeq, p.conn, packet.src, "", "", (uint32)(MyMsg_PING), packet.my.Data,
 			(int)(packet.my.Rproto), -1, p.key,
 			0, 0, 0, 0, 0, 0,
 			0)
@@ -163,12 +211,12 @@ func (p *Server) processPacket(packet *Packet) {
 
 func (p *Server) processDataPacketNewConn(id string, packet *Packet) *ServerConn {
 
-	now := common.GetNowUpdateInSecond()
+	now := common.GetNowUpdateInSecond().UnixNano()
 
 	loggo.Info("start add new connect  %s %s", id, packet.my.Target)
 
-	if p.maxconn > 0 && p.localConnMapSize >= p.maxconn {
-		loggo.Info("too many connections %d, server connected target fail %s", p.localConnMapSize, packet.my.Target)
+	if p.maxconn > 0 && int(atomic.LoadInt32(&p.localConnMapSize)) >= p.maxconn {
+		loggo.Info("too many connections %d, server connected target fail %s", atomic.LoadInt32(&p.localConnMapSize), packet.my.Target)
 		p.remoteError(packet.echoId, packet.echoSeq, id, (int)(packet.my.Rproto), packet.src)
 		return nil
 	}
@@ -181,58 +229,38 @@ func (p *Server) processDataPacketNewConn(id string, packet *Packet) *ServerConn
 	}
 
 	if packet.my.Tcpmode > 0 {
+		// the tcp mode goes through ricmp, the old clients send it in MyMsg and must upgrade
+		loggo.Info("tcp mode packet not in ricmp, client too old %s %s", id, addr)
+		p.remoteError(packet.echoId, packet.echoSeq, id, (int)(packet.my.Rproto), packet.src)
+		return nil
+	}
 
-		c, err := net.DialTimeout("tcp", addr, time.Millisecond*time.Duration(p.connecttmeout))
-		if err != nil {
-			loggo.Error("Error listening for tcp packets: %s %s", id, err.Error())
-			p.remoteError(packet.echoId, packet.echoSeq, id, (int)(packet.my.Rproto), packet.src)
-			p.addConnError(addr)
-			return nil
-		}
-		targetConn := c.(*net.TCPConn)
-		ipaddrTarget := targetConn.RemoteAddr().(*net.TCPAddr)
-
-		fm := frame.NewFrameMgr(FRAME_MAX_SIZE, FRAME_MAX_ID, (int)(packet.my.TcpmodeBuffersize), (int)(packet.my.TcpmodeMaxwin), (int)(packet.my.TcpmodeResendTimems), (int)(packet.my.TcpmodeCompress),
-			(int)(packet.my.TcpmodeStat))
-
-		localConn := &ServerConn{exit: false, timeout: (int)(packet.my.Timeout), tcpconn: targetConn, tcpaddrTarget: ipaddrTarget, id: id, activeRecvTime: now, activeSendTime: now, close: false,
-			rproto: (int)(packet.my.Rproto), fm: fm, tcpmode: (int)(packet.my.Tcpmode)}
-
-		p.addServerConn(id, localConn)
-
-		go p.RecvTCP(localConn, id, packet.src)
-		return localConn
-
-	} else {
-
-		c, err := net.DialTimeout("udp", addr, time.Millisecond*time.Duration(p.connecttmeout))
-		if err != nil {
-			loggo.Error("Error listening for udp packets: %s %s", id, err.Error())
-			p.remoteError(packet.echoId, packet.echoSeq, id, (int)(packet.my.Rproto), packet.src)
-			p.addConnError(addr)
-			return nil
-		}
-		targetConn := c.(*net.UDPConn)
-		ipaddrTarget := targetConn.RemoteAddr().(*net.UDPAddr)
-
-		localConn := &ServerConn{exit: false, timeout: (int)(packet.my.Timeout), conn: targetConn, ipaddrTarget: ipaddrTarget, id: id, activeRecvTime: now, activeSendTime: now, close: false,
-			rproto: (int)(packet.my.Rproto), tcpmode: (int)(packet.my.Tcpmode)}
+	c, err := net.DialTimeout("udp", addr, time.Millisecond*time.Duration(p.connecttmeout))
+	if err != nil {
+		loggo.Error("Error listening for udp packets: %s %s", id, err.Error())
+		p.remoteError(packet.echoId, packet.echoSeq, id, (int)(packet.my.Rproto), packet.src)
+		p.addConnError(addr)
+		return nil
+	}
+	targetConn := c.(*net.UDPConn)
+	ipaddrTarget := targetConn.RemoteAddr().(*net.UDPAddr)
 
-		p.addServerConn(id, localConn)
+	localConn := &ServerConn{timeout: (int)(packet.my.Timeout), conn: targetConn, ipaddrTarget: ipaddrTarget, id: id, activeRecvTime: now, activeSendTime: now,
+		rproto: (int)(packet.my.Rproto), tcpmode: (int)(packet.my.Tcpmode),
+		echoId: int32(packet.echoId), echoSeq: int32(packet.echoSeq)}
 
-		go p.Recv(localConn, id, packet.src)
+	p.addServerConn(id, localConn)
 
-		return localConn
-	}
+	go p.Recv(localConn, id, packet.src)
 
-	return nil
+	return localConn
 }
 
 func (p *Server) processDataPacket(packet *Packet) {
 
 	loggo.Debug("processPacket %s %s %d", packet.my.Id, packet.src.String(), len(packet.my.Data))
 
-	now := common.GetNowUpdateInSecond()
+	now := common.GetNowUpdateInSecond().UnixNano()
 
 	id := packet.my.Id
 	localConn := p.getServerConnById(id)
@@ -243,222 +271,105 @@ func (p *Server) processDataPacket(packet *Packet) {
 		}
 	}
 
-	localConn.activeRecvTime = now
-	localConn.echoId = packet.echoId
-	localConn.echoSeq = packet.echoSeq
+	atomic.StoreInt64(&localConn.activeRecvTime, now)
+	atomic.StoreInt32(&localConn.echoId, int32(packet.echoId))
+	atomic.StoreInt32(&localConn.echoSeq, int32(packet.echoSeq))
 
 	if packet.my.Type == (int32)(MyMsg_DATA) {
 
-		if packet.my.Tcpmode > 0 {
-			f := &frame.Frame{}
-			err := proto.Unmarshal(packet.my.Data, f)
-			if err != nil {
-				loggo.Error("Unmarshal tcp Error %s", err)
-				return
-			}
-
-			localConn.fm.OnRecvFrame(f)
-
-		} else {
-			if packet.my.Data == nil {
-				return
-			}
-			_, err := localConn.conn.Write(packet.my.Data)
-			if err != nil {
-				loggo.Info("WriteToUDP Error %s", err)
-				localConn.close = true
-				return
-			}
+		if packet.my.Data == nil {
+			return
+		}
+		_, err := localConn.conn.Write(packet.my.Data)
+		if err != nil {
+			loggo.Info("WriteToUDP Error %s", err)
+			atomic.StoreInt32(&localConn.close, 1)
+			return
 		}
 
-		p.recvPacket++
-		p.recvPacketSize += (uint64)(len(packet.my.Data))
+		atomic.AddUint64(&p.recvPacket, 1)
+		atomic.AddUint64(&p.recvPacketSize, (uint64)(len(packet.my.Data)))
 	}
 }
 
-func (p *Server) RecvTCP(conn *ServerConn, id string, src *net.IPAddr) {
+func (p *Server) AcceptTcp() {
 
 	defer common.CrashLog()
 
 	p.workResultLock.Add(1)
 	defer p.workResultLock.Done()
 
-	loggo.Info("server waiting target response %s -> %s %s", conn.tcpaddrTarget.String(), conn.id, conn.tcpconn.LocalAddr().String())
+	loggo.Info("server waiting ricmp accept tcp")
 
-	loggo.Info("start wait remote connect tcp %s %s", conn.id, conn.tcpaddrTarget.String())
-	startConnectTime := common.GetNowUpdateInSecond()
-	for !p.exit && !conn.exit {
-		if conn.fm.IsConnected() {
+	for !p.isExit() {
+		rconn, err := p.ricmplistener.Accept()
+		if err != nil {
+			loggo.Info("Error accept ricmp %s", err)
 			break
 		}
-		conn.fm.Update()
-		sendlist := conn.fm.GetSendList()
-		for e := sendlist.Front(); e != nil; e = e.Next() {
-			f := e.Value.(*frame.Frame)
-			mb, _ := conn.fm.MarshalFrame(f)
-			sendICMP(conn.echoId, conn.echoSeq, *p.conn, src, "", id, (uint32)(MyMsg_DATA), mb,
-				conn.rproto, -1, p.key,
-				0, 0, 0, 0, 0, 0,
-				0)
-			p.sendPacket++
-			p.sendPacketSize += (uint64)(len(mb))
-		}
-		time.Sleep(time.Millisecond * 10)
-		now := common.GetNowUpdateInSecond()
-		diffclose := now.Sub(startConnectTime)
-		if diffclose > time.Second*5 {
-			loggo.Info("can not connect remote tcp %s %s", conn.id, conn.tcpaddrTarget.String())
-			p.close(conn)
-			p.remoteError(conn.echoId, conn.echoSeq, id, conn.rproto, src)
-			return
-		}
-	}
-
-	if !conn.exit {
-		loggo.Info("remote connected tcp %s %s", conn.id, conn.tcpaddrTarget.String())
+		go p.RecvTCP(rconn)
 	}
+}
 
-	bytes := make([]byte, 10240)
+func (p *Server) RecvTCP(rconn conn.Conn) {
 
-	tcpActiveRecvTime := common.GetNowUpdateInSecond()
-	tcpActiveSendTime := common.GetNowUpdateInSecond()
+	defer common.CrashLog()
 
-	for !p.exit && !conn.exit {
-		now := common.GetNowUpdateInSecond()
-		sleep := true
-
-		left := common.MinOfInt(conn.fm.GetSendBufferLeft(), len(bytes))
-		if left > 0 {
-			conn.tcpconn.SetReadDeadline(time.Now().Add(time.Millisecond * 1))
-			n, err := conn.tcpconn.Read(bytes[0:left])
-			if err != nil {
-				nerr, ok := err.(net.Error)
-				if !ok || !nerr.Timeout() {
-					loggo.Info("Error read tcp %s %s %s", conn.id, conn.tcpaddrTarget.String(), err)
-					conn.fm.Close()
-					break
-				}
-			}
-			if n > 0 {
-				sleep = false
-				conn.fm.WriteSendBuffer(bytes[:n])
-				tcpActiveRecvTime = now
-			}
-		}
+	p.workResultLock.Add(1)
+	defer p.workResultLock.Done()
 
-		conn.fm.Update()
-
-		sendlist := conn.fm.GetSendList()
-		if sendlist.Len() > 0 {
-			sleep = false
-			conn.activeSendTime = now
-			for e := sendlist.Front(); e != nil; e = e.Next() {
-				f := e.Value.(*frame.Frame)
-				mb, err := conn.fm.MarshalFrame(f)
-				if err != nil {
-					loggo.Error("Error tcp Marshal %s %s %s", conn.id, conn.tcpaddrTarget.String(), err)
-					continue
-				}
-				sendICMP(conn.echoId, conn.echoSeq, *p.conn, src, "", id, (uint32)(MyMsg_DATA), mb,
-					conn.rproto, -1, p.key,
-					0, 0, 0, 0, 0, 0,
-					0)
-				p.sendPacket++
-				p.sendPacketSize += (uint64)(len(mb))
-			}
-		}
+	my, err := readTcpHead(rconn)
+	if err != nil {
+		loggo.Info("Error read tcp head %s %s", rconn.Info(), err)
+		rconn.Close()
+		return
+	}
 
-		if conn.fm.GetRecvBufferSize() > 0 {
-			sleep = false
-			rr := conn.fm.GetRecvReadLineBuffer()
-			conn.tcpconn.SetWriteDeadline(time.Now().Add(time.Millisecond * 1))
-			n, err := conn.tcpconn.Write(rr)
-			if err != nil {
-				nerr, ok := err.(net.Error)
-				if !ok || !nerr.Timeout() {
-					loggo.Info("Error write tcp %s %s %s", conn.id, conn.tcpaddrTarget.String(), err)
-					conn.fm.Close()
-					break
-				}
-			}
-			if n > 0 {
-				conn.fm.SkipRecvBuffer(n)
-				tcpActiveSendTime = now
-			}
-		}
+	if my.Key != (int32)(p.key) {
+		loggo.Info("tcp head key not match %s %s", my.Id, rconn.Info())
+		rconn.Close()
+		return
+	}
 
-		if sleep {
-			time.Sleep(time.Millisecond * 10)
-		}
+	id := my.Id
+	addr := my.Target
 
-		diffrecv := now.Sub(conn.activeRecvTime)
-		diffsend := now.Sub(conn.activeSendTime)
-		tcpdiffrecv := now.Sub(tcpActiveRecvTime)
-		tcpdiffsend := now.Sub(tcpActiveSendTime)
-		if diffrecv > time.Second*(time.Duration(conn.timeout)) || diffsend > time.Second*(time.Duration(conn.timeout)) ||
-			(tcpdiffrecv > time.Second*(time.Duration(conn.timeout)) && tcpdiffsend > time.Second*(time.Duration(conn.timeout))) {
-			loggo.Info("close inactive conn %s %s", conn.id, conn.tcpaddrTarget.String())
-			conn.fm.Close()
-			break
-		}
+	loggo.Info("start add new connect  %s %s", id, addr)
 
-		if conn.fm.IsRemoteClosed() {
-			loggo.Info("closed by remote conn %s %s", conn.id, conn.tcpaddrTarget.String())
-			conn.fm.Close()
-			break
-		}
+	if p.maxconn > 0 && int(atomic.LoadInt32(&p.localConnMapSize)) >= p.maxconn {
+		loggo.Info("too many connections %d, server connected target fail %s", atomic.LoadInt32(&p.localConnMapSize), addr)
+		rconn.Close()
+		return
 	}
 
-	conn.fm.Close()
-
-	startCloseTime := common.GetNowUpdateInSecond()
-	for !p.exit && !conn.exit {
-		now := common.GetNowUpdateInSecond()
-
-		conn.fm.Update()
-
-		sendlist := conn.fm.GetSendList()
-		for e := sendlist.Front(); e != nil; e = e.Next() {
-			f := e.Value.(*frame.Frame)
-			mb, _ := conn.fm.MarshalFrame(f)
-			sendICMP(conn.echoId, conn.echoSeq, *p.conn, src, "", id, (uint32)(MyMsg_DATA), mb,
-				conn.rproto, -1, p.key,
-				0, 0, 0, 0, 0, 0,
-				0)
-			p.sendPacket++
-			p.sendPacketSize += (uint64)(len(mb))
-		}
+	if p.isConnError(addr) {
+		loggo.Info("addr connect Error before: %s %s", id, addr)
+		rconn.Close()
+		return
+	}
 
-		nodatarecv := true
-		if conn.fm.GetRecvBufferSize() > 0 {
-			rr := conn.fm.GetRecvReadLineBuffer()
-			conn.tcpconn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
-			n, _ := conn.tcpconn.Write(rr)
-			if n > 0 {
-				conn.fm.SkipRecvBuffer(n)
-				nodatarecv = false
-			}
-		}
+	c, err := net.DialTimeout("tcp", addr, time.Millisecond*time.Duration(p.connecttmeout))
+	if err != nil {
+		loggo.Error("Error listening for tcp packets: %s %s", id, err.Error())
+		p.addConnError(addr)
+		rconn.Close()
+		return
+	}
+	targetConn := c.(*net.TCPConn)
+	tcpaddrTarget := targetConn.RemoteAddr().(*net.TCPAddr)
 
-		diffclose := now.Sub(startCloseTime)
-		if diffclose > time.Second*10 {
-			loggo.Info("close conn had timeout %s %s", conn.id, conn.tcpaddrTarget.String())
-			break
-		}
+	now := common.GetNowUpdateInSecond().UnixNano()
+	localConn := &ServerConn{timeout: (int)(my.Timeout), tcpconn: targetConn, tcpaddrTarget: tcpaddrTarget, id: id, activeRecvTime: now, activeSendTime: now,
+		rconn: rconn, tcpmode: (int)(my.Tcpmode)}
 
-		remoteclosed := conn.fm.IsRemoteClosed()
-		if remoteclosed && nodatarecv {
-			loggo.Info("remote conn had closed %s %s", conn.id, conn.tcpaddrTarget.String())
-			break
-		}
+	p.addServerConn(id, localConn)
 
-		time.Sleep(time.Millisecond * 100)
-	}
+	loggo.Info("remote connected tcp %s %s", id, tcpaddrTarget.String())
 
-	time.Sleep(time.Second)
+	transferTcp(targetConn, rconn)
 
-	loggo.Info("close tcp conn %s %s", conn.id, conn.tcpaddrTarget.String())
-	p.close(conn)
+	loggo.Info("close tcp conn %s %s", id, tcpaddrTarget.String())
+	p.close(localConn)
 }
 
 func (p *Server) Recv(conn *ServerConn, id string, src *net.IPAddr) {
@@ -472,7 +383,7 @@ func (p *Server) Recv(conn *ServerConn, id string, src *net.IPAddr) {
 
 	bytes := make([]byte, 2000)
 
-	for !p.exit {
+	for !p.isExit() {
 
 		conn.conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
 		n, _, err := conn.conn.ReadFromUDP(bytes)
@@ -480,33 +391,35 @@ func (p *Server) Recv(conn *ServerConn, id string, src *net.IPAddr) {
 			nerr, ok := err.(net.Error)
 			if !ok || !nerr.Timeout() {
 				loggo.Info("ReadFromUDP Error read udp %s", err)
-				conn.close = true
+				atomic.StoreInt32(&conn.close, 1)
 				return
 			}
 		}
 
-		now := common.GetNowUpdateInSecond()
-		conn.activeSendTime = now
+		atomic.StoreInt64(&conn.activeSendTime, common.GetNowUpdateInSecond().UnixNano())
 
-		sendICMP(conn.echoId, conn.echoSeq, *p.conn, src, "", id, (uint32)(MyMsg_DATA), bytes[:n],
+		sendICMP(int(atomic.LoadInt32(&conn.echoId)), int(atomic.LoadInt32(&conn.echoSeq)), p.conn, src, "", id, (uint32)(MyMsg_DATA), bytes[:n],
 			conn.rproto, -1, p.key,
 			0, 0, 0, 0, 0, 0,
 			0)
 
-		p.sendPacket++
-		p.sendPacketSize += (uint64)(n)
+		atomic.AddUint64(&p.sendPacket, 1)
+		atomic.AddUint64(&p.sendPacketSize, (uint64)(n))
 	}
 }
 
 func (p *Server) close(conn *ServerConn) {
 	if p.getServerConnById(conn.id) != nil {
-		conn.exit = true
+		atomic.StoreInt32(&conn.exit, 1)
 		if conn.conn != nil {
 			conn.conn.Close()
 		}
 		if conn.tcpconn != nil {
 			conn.tcpconn.Close()
 		}
+		if conn.rconn != nil {
+			conn.rconn.Close()
+		}
 		p.deleteServerConn(conn.id)
 	}
 }
@@ -521,15 +434,15 @@ func (p *Server) checkTimeoutConn() {
 		return true
 	})
 
-	now := common.GetNowUpdateInSecond()
+	now := common.GetNowUpdateInSecond().UnixNano()
 	for _, conn := range tmp {
 		if conn.tcpmode > 0 {
 			continue
 		}
-		diffrecv := now.Sub(conn.activeRecvTime)
-		diffsend := now.Sub(conn.activeSendTime)
+		diffrecv := time.Duration(now - atomic.LoadInt64(&conn.activeRecvTime))
+		diffsend := time.Duration(now - atomic.LoadInt64(&conn.activeSendTime))
 		if diffrecv > time.Second*(time.Duration(conn.timeout)) || diffsend > time.Second*(time.Duration(conn.timeout)) {
-			conn.close = true
+			atomic.StoreInt32(&conn.close, 1)
 		}
 	}
 
@@ -537,7 +450,7 @@ func (p *Server) checkTimeoutConn() {
 		if conn.tcpmode > 0 {
 			continue
 		}
-		if conn.close {
+		if atomic.LoadInt32(&conn.close) != 0 {
 			loggo.Info("close inactive conn %s %s", id, conn.ipaddrTarget.String())
 			p.close(conn)
 		}
@@ -545,17 +458,15 @@ func (p *Server) checkTimeoutConn() {
 }
 
 func (p *Server) showNet() {
-	p.localConnMapSize = 0
+	var size int32
 	p.localConnMap.Range(func(key, value interface{}) bool {
-		p.localConnMapSize++
+		size++
 		return true
 	})
+	atomic.StoreInt32(&p.localConnMapSize, size)
 	loggo.Info("send %dPacket/s %dKB/s recv %dPacket/s %dKB/s %dConnections",
-		p.sendPacket, p.sendPacketSize/1024, p.recvPacket, p.recvPacketSize/1024, p.localConnMapSize)
-	p.sendPacket = 0
-	p.recvPacket = 0
-	p.sendPacketSize = 0
-	p.recvPacketSize = 0
+		atomic.SwapUint64(&p.sendPacket, 0), atomic.SwapUint64(&p.sendPacketSize, 0)/1024,
+		atomic.SwapUint64(&p.recvPacket, 0), atomic.SwapUint64(&p.recvPacketSize, 0)/1024, size)
 }
 
 func (p *Server) addServerConn(uuid string, serverConn *ServerConn) {
@@ -575,7 +486,7 @@ func (p *Server) deleteServerConn(uuid string) {
 }
 
 func (p *Server) remoteError(echoId int, echoSeq int, uuid string, rprpto int, src *net.IPAddr) {
-	sendICMP(echoId, echoSeq, *p.conn, src, "", uuid, (uint32)(MyMsg_KICK), []byte{},
+	sendICMP(echoId, echoSeq, p.conn, src, "", uuid, (uint32)(MyMsg_KICK), []byte{},
 		rprpto, -1, p.key,
 		0, 0, 0, 0, 0, 0,
 		0)
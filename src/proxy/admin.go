@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/esrrhs/go-engine/src/loggo"
+	"github.com/esrrhs/go-engine/src/metrics"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+type SonnyInfo struct {
+	Id          string
+	Addr        string
+	Established bool
+}
+
+type ClientInfo struct {
+	Name        string
+	Addr        string
+	User        string
+	ClientType  string
+	ProxyProto  string
+	FromAddr    string
+	ToAddr      string
+	Established bool
+	Bond        bool
+	SendSize    int64
+	RecvSize    int64
+	Sonny       []SonnyInfo
+}
+
+type MappingInfo struct {
+	Index       int
+	ProxyProto  string
+	FromAddr    string
+	ToAddr      string
+	Addr        string
+	Established bool
+	Sonny       []SonnyInfo
+	Servers     []MappingServerInfo
+}
+
+type MappingServerInfo struct {
+	Server      string
+	Addr        string
+	Established bool
+	Healthy     bool
+	RttMs       int64
+}
+
+func sonnyInfo(sonny *sync.Map) []SonnyInfo {
+	var ret []SonnyInfo
+	sonny.Range(func(key, value interface{}) bool {
+		proxyconn := value.(*ProxyConn)
+		si := SonnyInfo{Id: proxyconn.id, Established: proxyconn.isEstablished()}
+		c := proxyconn.conn
+		if c != nil {
+			si.Addr = c.Info()
+		}
+		ret = append(ret, si)
+		return true
+	})
+	return ret
+}
+
+// Admin serve a local http api to manage a Server or a Client, addr is ip:port or unix:path, the ip is 127.0.0.1 if empty.
+// every request need the header "Authorization: Bearer <token>", every POST body is json with Content-Type application/json
+//
+// Server: GET /clients, POST /kick {"Name"}, GET|POST /config
+// Client: GET /mappings, POST /mapping/add {"Proto","FromAddr","ToAddr"}, POST /mapping/remove {"Index"}, GET|POST /config
+//
+// POST /config take a json of Config, only the fields can be reloaded are applied
+// GET /metrics export metrics.Default in prometheus text format
+type Admin struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+type adminKickReq struct {
+	Name string
+}
+
+type adminAddMappingReq struct {
+	Proto    string
+	FromAddr string
+	ToAddr   string
+}
+
+type adminRemoveMappingReq struct {
+	Index int
+}
+
+func NewServerAdmin(addr string, token string, s *Server) (*Admin, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clients", func(w http.ResponseWriter, r *http.Request) {
+		adminReply(w, s.Clients(), nil)
+	})
+	mux.HandleFunc("/kick", func(w http.ResponseWriter, r *http.Request) {
+		req := adminKickReq{}
+		if !adminPost(w, r, &req) {
+			return
+		}
+		adminReply(w, nil, s.Kick(req.Name))
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		adminConfig(w, r, s.Config, s.ReloadConfig)
+	})
+	mux.Handle("/metrics", metrics.Default.Handler())
+	return newAdmin(addr, token, mux)
+}
+
+func NewClientAdmin(addr string, token string, c *Client) (*Admin, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mappings", func(w http.ResponseWriter, r *http.Request) {
+		adminReply(w, c.Mappings(), nil)
+	})
+	mux.HandleFunc("/mapping/add", func(w http.ResponseWriter, r *http.Request) {
+		req := adminAddMappingReq{}
+		if !adminPost(w, r, &req) {
+			return
+		}
+		index, err := c.AddMapping(req.Proto, req.FromAddr, req.ToAddr)
+		adminReply(w, index, err)
+	})
+	mux.HandleFunc("/mapping/remove", func(w http.ResponseWriter, r *http.Request) {
+		req := adminRemoveMappingReq{}
+		if !adminPost(w, r, &req) {
+			return
+		}
+		adminReply(w, nil, c.RemoveMapping(req.Index))
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		adminConfig(w, r, c.Config, c.ReloadConfig)
+	})
+	mux.Handle("/metrics", metrics.Default.Handler())
+	return newAdmin(addr, token, mux)
+}
+
+func newAdmin(addr string, token string, mux *http.ServeMux) (*Admin, error) {
+	if token == "" {
+		return nil, errors.New("admin token empty")
+	}
+
+	var listener net.Listener
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		// remove the socket left by last run
+		fi, err := os.Stat(path)
+		if err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		listener, err = net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			loggo.Warn("NewAdmin listen on not loopback address %s", addr)
+		}
+		listener, err = net.Listen("tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a := &Admin{
+		listener: listener,
+		server:   &http.Server{Handler: adminAuth(token, mux)},
+	}
+
+	go func() {
+		err := a.server.Serve(listener)
+		loggo.Info("Admin serve end %s %s", addr, err)
+	}()
+
+	loggo.Info("NewAdmin ok %s", addr)
+
+	return a, nil
+}
+
+func (a *Admin) Close() {
+	a.server.Close()
+}
+
+func (a *Admin) Addr() net.Addr {
+	return a.listener.Addr()
+}
+
+func adminAuth(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			loggo.Error("Admin auth fail %s %s", r.RemoteAddr, r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			adminReply(w, nil, errors.New("unauthorized"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// adminPost decode the json body to req, the form and the other content types are rejected, so a browser can not post them cross site
+func adminPost(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if r.Method != http.MethodPost {
+		adminReply(w, nil, errors.New("need POST"))
+		return false
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct != "application/json" {
+		adminReply(w, nil, errors.New("need Content-Type application/json"))
+		return false
+	}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		adminReply(w, nil, err)
+		return false
+	}
+	return true
+}
+
+func adminConfig(w http.ResponseWriter, r *http.Request, get func() Config, reload func(config *Config) error) {
+	if r.Method == http.MethodPost {
+		// the fields not in body keep the current value
+		config := get()
+		if !adminPost(w, r, &config) {
+			return
+		}
+		err := reload(&config)
+		if err != nil {
+			adminReply(w, nil, err)
+			return
+		}
+	}
+	config := get()
+	config.Key = ""
+	config.Password = ""
+	adminReply(w, config, nil)
+}
+
+type adminRsp struct {
+	Ret  bool
+	Msg  string
+	Data interface{} `json:",omitempty"`
+}
+
+func adminReply(w http.ResponseWriter, data interface{}, err error) {
+	rsp := adminRsp{Ret: true, Msg: "ok", Data: data}
+	if err != nil {
+		rsp.Ret = false
+		rsp.Msg = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rsp)
+}
@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const adminTestToken = "test-token"
+
+func adminTestCall(t *testing.T, hc *http.Client, method string, u string, body string, data interface{}) adminRsp {
+	req, _ := http.NewRequest(method, u, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+adminTestToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := hc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	rsp := adminRsp{Data: data}
+	err = json.NewDecoder(resp.Body).Decode(&rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(method, u, rsp.Ret, rsp.Msg)
+	return rsp
+}
+
+func adminTestEcho(addr string) error {
+	c, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second * 5))
+	c.Write([]byte("xhello"))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(c, buf)
+	if err != nil {
+		return err
+	}
+	if string(buf) != "hello" {
+		return fmt.Errorf("echo error %s", string(buf))
+	}
+	return nil
+}
+
+func Test0001Admin(t *testing.T) {
+	target := startTestTarget(t, "127.0.0.1:58064")
+	defer target.Close()
+
+	config := DefaultConfig()
+	server, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58060"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	sock := filepath.Join(os.TempDir(), "proxy_admin_test.sock")
+	sadmin, err := NewServerAdmin("unix:"+sock, adminTestToken, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sadmin.Close()
+	shc := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial("unix", sock)
+	}}}
+
+	client, err := NewClient(DefaultConfig(), "tcp", "127.0.0.1:58060", "test", "PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58061"}, []string{"127.0.0.1:58064"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	_, err = NewClientAdmin("127.0.0.1:58063", "", client)
+	if err == nil {
+		t.Error("admin without token should fail")
+	}
+	cadmin, err := NewClientAdmin(":58063", adminTestToken, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cadmin.Close()
+	chc := &http.Client{}
+
+	time.Sleep(time.Second)
+
+	var clients []ClientInfo
+	rsp := adminTestCall(t, shc, "GET", "http://admin/clients", "", &clients)
+	if !rsp.Ret || len(clients) != 1 || clients[0].Name != "test_0" || !clients[0].Established {
+		t.Error("clients fail", clients)
+	}
+
+	// no token, wrong token and form body
+	resp, err := chc.Post("http://127.0.0.1:58063/mapping/remove", "application/json", strings.NewReader(`{"Index":0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Error("admin without token should be unauthorized", resp.StatusCode)
+	}
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:58063/mapping/remove", strings.NewReader(`{"Index":0}`))
+	req.Header.Set("Authorization", "Bearer wrong")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = chc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Error("admin with wrong token should be unauthorized", resp.StatusCode)
+	}
+	req, _ = http.NewRequest("POST", "http://127.0.0.1:58063/mapping/remove", strings.NewReader("index=0"))
+	req.Header.Set("Authorization", "Bearer "+adminTestToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err = chc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp = adminRsp{}
+	json.NewDecoder(resp.Body).Decode(&rsp)
+	resp.Body.Close()
+	if rsp.Ret {
+		t.Error("admin form body should fail")
+	}
+	if cadmin.Addr().(*net.TCPAddr).IP.String() != "127.0.0.1" {
+		t.Error("admin should listen on loopback", cadmin.Addr())
+	}
+
+	// reload config
+	var sconfig Config
+	rsp = adminTestCall(t, shc, "POST", "http://admin/config", `{"MaxSonny": 5, "MaxMsgSize": 1}`, &sconfig)
+	if !rsp.Ret || server.Config().MaxSonny != 5 || sconfig.MaxSonny != 5 || sconfig.MaxMsgSize != config.MaxMsgSize {
+		t.Error("reload config fail", sconfig.MaxSonny, sconfig.MaxMsgSize)
+	}
+	rsp = adminTestCall(t, shc, "POST", "http://admin/config", `{"ConnTimeout": 0}`, nil)
+	if rsp.Ret {
+		t.Error("reload bad config should fail")
+	}
+
+	// add and remove mapping
+	err = adminTestEcho("127.0.0.1:58061")
+	if err != nil {
+		t.Error("mapping 0 echo fail", err)
+	}
+	var index int
+	rsp = adminTestCall(t, chc, "POST", "http://127.0.0.1:58063/mapping/add", `{"Proto":"tcp","FromAddr":"127.0.0.1:58062","ToAddr":"127.0.0.1:58064"}`, &index)
+	if !rsp.Ret || index != 1 {
+		t.Error("add mapping fail", index)
+	}
+	time.Sleep(time.Second)
+	err = adminTestEcho("127.0.0.1:58062")
+	if err != nil {
+		t.Error("mapping 1 echo fail", err)
+	}
+
+	var mappings []MappingInfo
+	adminTestCall(t, chc, "GET", "http://127.0.0.1:58063/mappings", "", &mappings)
+	if len(mappings) != 2 || mappings[1].FromAddr != "127.0.0.1:58062" || !mappings[1].Established {
+		t.Error("mappings fail", mappings)
+	}
+
+	rsp = adminTestCall(t, chc, "POST", "http://127.0.0.1:58063/mapping/remove", `{"Index":1}`, nil)
+	if !rsp.Ret {
+		t.Error("remove mapping fail")
+	}
+	time.Sleep(time.Second)
+	err = adminTestEcho("127.0.0.1:58062")
+	if err == nil {
+		t.Error("removed mapping still work")
+	}
+	err = adminTestEcho("127.0.0.1:58061")
+	if err != nil {
+		t.Error("mapping 0 echo fail after remove", err)
+	}
+
+	req, _ = http.NewRequest("GET", "http://127.0.0.1:58063/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+adminTestToken)
+	resp, err = chc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(text), `proxy_main_conn{side="client"} 1`) {
+		t.Error("metrics fail", string(text))
+	}
+
+	// kick, the client reconnect
+	rsp = adminTestCall(t, shc, "POST", "http://admin/kick", `{"Name":"test_0"}`, nil)
+	if !rsp.Ret {
+		t.Error("kick fail")
+	}
+	rsp = adminTestCall(t, shc, "GET", "http://admin/kick", `{"Name":"test_0"}`, nil)
+	if rsp.Ret {
+		t.Error("kick need POST")
+	}
+	time.Sleep(time.Second * 3)
+	err = adminTestEcho("127.0.0.1:58061")
+	if err != nil {
+		t.Error("mapping 0 echo fail after kick", err)
+	}
+}
@@ -0,0 +1,305 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/esrrhs/go-engine/src/common"
+	"github.com/esrrhs/go-engine/src/group"
+	"github.com/esrrhs/go-engine/src/loggo"
+	"github.com/golang/protobuf/proto"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BOND_STRIPE   = "stripe"   // 帧轮流从各主通道发出
+	BOND_FAILOVER = "failover" // 帧从第一个可用的主通道发出，不可用时切到下一个
+)
+
+func checkBond(mode string) error {
+	switch mode {
+	case "", BOND_STRIPE, BOND_FAILOVER:
+		return nil
+	}
+	return errors.New("no Bond " + mode)
+}
+
+type bondMember struct {
+	proxyconn *ProxyConn
+	order     int
+}
+
+// bondFrame is a frame sent and not acked, member is the main conn it goes, nil if it need to be resent
+type bondFrame struct {
+	f      *ProxyFrame
+	member *ProxyConn
+}
+
+// bond is one session over several main conns, maybe in different protos.
+// The sonny conns use the bond father, its frames are dispatched to the main conns,
+// and the remote reorders the data frames by the DataFrame index.
+// Every frame has a bond seq, the remote acks them, the frames not acked on a main conn left are resent on the others.
+type bond struct {
+	name     string
+	mode     string
+	login    string // the members must login with the same
+	config   *Config
+	father   ProxyConn
+	lock     sync.Mutex
+	members  []bondMember
+	rr       int
+	wg       *group.Group
+	input    *Inputer
+	output   *Outputer
+	sendseq  int64
+	unacked  []*bondFrame // in seq order
+	resend   []*bondFrame
+	resendch chan int
+	recvseq  int64          // all the frames before it are received
+	recvd    map[int64]bool // the frames received after recvseq
+}
+
+func newBond(fwg *group.Group, name string, mode string, config *Config) *bond {
+	sendch := common.NewChannel(config.MainBuffer)
+
+	b := &bond{
+		name:     name,
+		mode:     mode,
+		config:   config,
+		father:   ProxyConn{sendch: sendch, bonding: true},
+		resendch: make(chan int, 1),
+		recvd:    make(map[int64]bool),
+	}
+
+	b.wg = group.NewGroup("bond"+" "+name, fwg, func() {
+		loggo.Info("group start exit %s", name)
+		sendch.Close()
+		if b.input != nil {
+			b.input.Close()
+		}
+		if b.output != nil {
+			b.output.Close()
+		}
+		loggo.Info("group end exit %s", name)
+	})
+
+	b.wg.Go("bond dispatch"+" "+name, func() error {
+		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+		return b.dispatch()
+	})
+
+	b.wg.Go("bond ack"+" "+name, func() error {
+		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+		return b.sendAck()
+	})
+
+	loggo.Info("newBond ok %s %s", name, mode)
+
+	return b
+}
+
+// join add the main conn, the failover mode use the members by order
+func (b *bond) join(proxyconn *ProxyConn, order int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.members = append(b.members, bondMember{proxyconn: proxyconn, order: order})
+	sort.SliceStable(b.members, func(i, j int) bool {
+		return b.members[i].order < b.members[j].order
+	})
+	loggo.Info("bond join %s %s %d", b.name, proxyconn.conn.Info(), len(b.members))
+}
+
+// leave remove the main conn, the frames not acked on it are resent, return the number of members left
+func (b *bond) leave(proxyconn *ProxyConn) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i, m := range b.members {
+		if m.proxyconn == proxyconn {
+			b.members = append(b.members[:i], b.members[i+1:]...)
+			break
+		}
+	}
+	n := 0
+	for _, bf := range b.unacked {
+		if bf.member == proxyconn {
+			bf.member = nil
+			b.resend = append(b.resend, bf)
+			n++
+		}
+	}
+	if n > 0 {
+		select {
+		case b.resendch <- 1:
+		default:
+		}
+	}
+	loggo.Info("bond leave %s %s %d resend %d", b.name, proxyconn.conn.Info(), len(b.members), n)
+	return len(b.members)
+}
+
+func (b *bond) size() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.members)
+}
+
+// pick choose a healthy member for the next frame, nil if no one
+func (b *bond) pick() *ProxyConn {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var candidates []*ProxyConn
+	for _, m := range b.members {
+		if healthy(m.proxyconn, b.config) {
+			candidates = append(candidates, m.proxyconn)
+		}
+	}
+	if len(candidates) <= 0 {
+		return nil
+	}
+	if b.mode == BOND_STRIPE {
+		b.rr++
+		return candidates[b.rr%len(candidates)]
+	}
+	return candidates[0]
+}
+
+// send record the frame go to the member, false if the member has left
+func (b *bond) send(bf *bondFrame, member *ProxyConn) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, m := range b.members {
+		if m.proxyconn == member {
+			bf.member = member
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bond) popResend() *bondFrame {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.resend) <= 0 {
+		return nil
+	}
+	bf := b.resend[0]
+	b.resend = b.resend[1:]
+	return bf
+}
+
+func (b *bond) dispatch() error {
+
+	loggo.Info("bond dispatch start %s", b.name)
+
+	for !b.wg.IsExit() {
+		bf := b.popResend()
+		if bf == nil {
+			var ff interface{}
+			select {
+			case ff = <-b.father.sendch.Ch():
+			case <-b.resendch:
+				continue
+			}
+			if ff == nil {
+				break
+			}
+			f := ff.(*ProxyFrame)
+			b.sendseq++
+			f.Bondseq = b.sendseq
+			bf = &bondFrame{f: f}
+			b.lock.Lock()
+			b.unacked = append(b.unacked, bf)
+			b.lock.Unlock()
+		}
+
+		for !b.wg.IsExit() {
+			member := b.pick()
+			if member == nil {
+				// wait a main conn come back
+				time.Sleep(time.Millisecond * 100)
+				continue
+			}
+			if !b.send(bf, member) {
+				continue
+			}
+			// the main conn own the frame it sends, the one kept may be resent
+			f := proto.Clone(bf.f).(*ProxyFrame)
+			// a slow main conn should not block the others
+			if !member.sendch.WriteTimeout(f, b.config.load().MainWriteChannelTimeoutMs) {
+				loggo.Info("bond dispatch timeout %s %s", b.name, member.conn.Info())
+				continue
+			}
+			if f.Type == FRAME_TYPE_DATA {
+				atomic.AddInt64(&member.sendsize, int64(len(f.DataFrame.Data)))
+			}
+			break
+		}
+	}
+
+	loggo.Info("bond dispatch end %s", b.name)
+	return nil
+}
+
+// ack remove the frames the remote has received
+func (b *bond) ack(seq int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n := 0
+	for n < len(b.unacked) && b.unacked[n].f.Bondseq <= seq {
+		n++
+	}
+	b.unacked = b.unacked[n:]
+}
+
+// recv return false if the frame has been received from other main conn
+func (b *bond) recv(seq int64) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if seq <= b.recvseq || b.recvd[seq] {
+		return false
+	}
+	b.recvd[seq] = true
+	for b.recvd[b.recvseq+1] {
+		delete(b.recvd, b.recvseq+1)
+		b.recvseq++
+	}
+	return true
+}
+
+// sendAck tell the remote the frames received, at once when changed, and every second in case the ack is lost
+func (b *bond) sendAck() error {
+
+	loggo.Info("bond sendAck start %s", b.name)
+
+	acked := int64(0)
+	last := time.Now()
+	for !b.wg.IsExit() {
+		time.Sleep(time.Millisecond * 100)
+
+		b.lock.Lock()
+		seq := b.recvseq
+		b.lock.Unlock()
+		if seq <= 0 || (seq == acked && time.Now().Sub(last) < time.Second) {
+			continue
+		}
+
+		member := b.pick()
+		if member == nil {
+			continue
+		}
+		f := &ProxyFrame{}
+		f.Type = FRAME_TYPE_BONDACK
+		f.BondAckFrame = &BondAckFrame{}
+		f.BondAckFrame.Seq = seq
+		if member.sendch.WriteTimeout(f, b.config.load().MainWriteChannelTimeoutMs) {
+			acked = seq
+			last = time.Now()
+		}
+	}
+
+	loggo.Info("bond sendAck end %s", b.name)
+	return nil
+}
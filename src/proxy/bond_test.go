@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/esrrhs/go-engine/src/common"
+	"github.com/esrrhs/go-engine/src/conn"
+	"github.com/esrrhs/go-engine/src/group"
+	"io"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test0001Bond(t *testing.T) {
+	target := startTestTarget(t, "127.0.0.1:58083")
+	defer target.Close()
+
+	config := DefaultConfig()
+	config.Bond = BOND_STRIPE
+
+	server, err := NewServer(config, []string{"tcp", "rudp"}, []string{"127.0.0.1:58080", "127.0.0.1:58081"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewClient(config, "tcp", "127.0.0.1:58080,rudp://127.0.0.1:58081", "test", "PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58082"}, []string{"127.0.0.1:58083"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	time.Sleep(time.Second * 2)
+
+	clients := server.Clients()
+	if len(clients) != 2 || !clients[0].Bond || !clients[1].Bond {
+		t.Fatal("bond login fail", clients)
+	}
+
+	c, err := net.DialTimeout("tcp", "127.0.0.1:58082", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second * 20))
+
+	// striped over the two conns, must come back in order
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	go func() {
+		c.Write([]byte("x"))
+		for i := 0; i < len(data); i += 1000 {
+			end := i + 1000
+			if end > len(data) {
+				end = len(data)
+			}
+			_, err := c.Write(data[i:end])
+			if err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(c, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatal("bond data not match")
+	}
+
+	var sendsize []int64
+	for _, ci := range server.Clients() {
+		sendsize = append(sendsize, ci.SendSize)
+	}
+	fmt.Println("send size", sendsize)
+	if sendsize[0] <= 0 || sendsize[1] <= 0 {
+		t.Error("not striped", sendsize)
+	}
+
+	// one conn down, the sonny goes on in the other
+	server.clients.Range(func(key, value interface{}) bool {
+		clientconn := value.(*ClientConn)
+		if clientconn.conn.Name() == "tcp" {
+			atomic.StoreInt32(&clientconn.needclose, 1)
+		}
+		return true
+	})
+	time.Sleep(time.Second)
+
+	err = clientTestEcho(c, false)
+	if err != nil {
+		t.Error("echo after one conn down fail", err)
+	}
+
+	// the tcp conn come back
+	time.Sleep(time.Second * 2)
+	if len(server.Clients()) != 2 {
+		t.Error("bond rejoin fail", server.Clients())
+	}
+	err = clientTestEcho(c, false)
+	if err != nil {
+		t.Error("echo after rejoin fail", err)
+	}
+}
+
+func Test0002Bond(t *testing.T) {
+	target := startTestTarget(t, "127.0.0.1:58093")
+	defer target.Close()
+
+	config := DefaultConfig()
+	config.Bond = BOND_STRIPE
+
+	server, err := NewServer(config, []string{"tcp", "rudp"}, []string{"127.0.0.1:58090", "127.0.0.1:58091"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewClient(config, "tcp", "127.0.0.1:58090,rudp://127.0.0.1:58091", "test", "PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58092"}, []string{"127.0.0.1:58093"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	time.Sleep(time.Second * 2)
+
+	c, err := net.DialTimeout("tcp", "127.0.0.1:58092", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second * 30))
+
+	data := make([]byte, 4*1024*1024)
+	rand.Read(data)
+	go func() {
+		c.Write([]byte("x"))
+		for i := 0; i < len(data); i += 1000 {
+			end := i + 1000
+			if end > len(data) {
+				end = len(data)
+			}
+			_, err := c.Write(data[i:end])
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// the tcp conn is killed at both sides mid transfer, the frames on it are resent on the rudp conn
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(c, buf[:len(data)/4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.lock.Lock()
+	m := client.mappings[0]
+	client.lock.Unlock()
+	m.lock.Lock()
+	for _, serverconn := range m.serverconns {
+		if serverconn != nil && serverconn.conn.Name() == "tcp" {
+			serverconn.conn.Close()
+		}
+	}
+	m.lock.Unlock()
+	server.clients.Range(func(key, value interface{}) bool {
+		clientconn := value.(*ClientConn)
+		if clientconn.conn.Name() == "tcp" {
+			clientconn.conn.Close()
+		}
+		return true
+	})
+	_, err = io.ReadFull(c, buf[len(data)/4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatal("bond data not match after kill")
+	}
+}
+
+func Test0003Bond(t *testing.T) {
+	config := DefaultConfig()
+	wg := group.NewGroup("Test0003Bond", nil, nil)
+	defer func() {
+		wg.Stop()
+		wg.Wait()
+	}()
+	b := newBond(wg, "test", BOND_STRIPE, config)
+	remote := newBond(wg, "remote", BOND_STRIPE, config)
+
+	newMember := func() *ProxyConn {
+		c, _ := conn.NewConn("tcp")
+		return &ProxyConn{conn: c, established: 1, sendch: common.NewChannel(1024)}
+	}
+	dead := newMember()
+	alive := newMember()
+	b.join(dead, 0)
+	b.join(alive, 1)
+
+	num := 100
+	for i := 1; i <= num; i++ {
+		f := &ProxyFrame{}
+		f.Type = FRAME_TYPE_DATA
+		f.DataFrame = &DataFrame{Index: int32(i), Data: []byte{byte(i)}}
+		b.father.sendch.Write(f)
+	}
+	time.Sleep(time.Millisecond * 500)
+
+	recv := func(member *ProxyConn, max int) int {
+		n := 0
+		for i := 0; i < max && len(member.sendch.Ch()) > 0; i++ {
+			f := (<-member.sendch.Ch()).(*ProxyFrame)
+			if f.Type == FRAME_TYPE_DATA && remote.recv(f.Bondseq) {
+				n++
+			}
+		}
+		return n
+	}
+
+	// the dead member delivered some frames then died with the others queued, they are resent on the alive one
+	got := recv(dead, 10)
+	b.leave(dead)
+	time.Sleep(time.Millisecond * 500)
+	got += recv(alive, num*2)
+	fmt.Println("bond recv", got, remote.recvseq)
+	if got != num || remote.recvseq != int64(num) {
+		t.Error("bond resend fail", got, remote.recvseq)
+	}
+
+	b.ack(remote.recvseq)
+	if len(b.unacked) != 0 {
+		t.Error("bond ack fail", len(b.unacked))
+	}
+}
+
+func Test0001BondNotMatch(t *testing.T) {
+	config := DefaultConfig()
+	config.Bond = BOND_FAILOVER
+
+	server, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58085"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client1, err := NewClient(config, "tcp", "127.0.0.1:58085", "test", "PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58086"}, []string{"127.0.0.1:58088"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client1.Close()
+
+	time.Sleep(time.Second)
+
+	// same name to other toaddr
+	client2, err := NewClient(config, "tcp", "127.0.0.1:58085", "test", "PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58087"}, []string{"127.0.0.1:58089"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+
+	time.Sleep(time.Second)
+
+	clients := server.Clients()
+	if len(clients) != 1 || clients[0].ToAddr != "127.0.0.1:58088" {
+		t.Error("bond not match should fail", clients)
+	}
+}
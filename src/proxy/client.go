@@ -1,32 +1,64 @@
 package proxy
 
 import (
+	"crypto/hmac"
 	"errors"
 	"github.com/esrrhs/go-engine/src/common"
 	"github.com/esrrhs/go-engine/src/conn"
 	"github.com/esrrhs/go-engine/src/group"
 	"github.com/esrrhs/go-engine/src/loggo"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const (
+	SERVER_BALANCE_PRIORITY   = "priority"   // 使用第一个可用的server，前面的恢复后新连接切回
+	SERVER_BALANCE_ROUNDROBIN = "roundrobin" // 可用的server轮流使用
+	SERVER_BALANCE_RTT        = "rtt"        // 使用ping延迟最小的server
+)
+
 type ServerConn struct {
 	ProxyConn
+	server string
+	index  int
+	crypt  *Crypt
 	output *Outputer
-	input  *Inputer
+}
+
+// clientServer is one of the servers, proto://addr use other proto than the serverproto
+type clientServer struct {
+	server string
+	addr   string
+	cn     conn.Conn
+}
+
+// clientMapping is one fromaddr/toaddr pair, it has a server conn to every server
+type clientMapping struct {
+	index       int
+	proxyproto  PROXY_PROTO
+	fromaddr    string
+	toaddr      string
+	serverconns []*ServerConn // guarded by lock, set by the connect goroutines
+	father      ProxyConn     // the inputer pick a server conn from serverconns by it
+	rr          uint32
+	lock        sync.Mutex
+	input       *Inputer // shared by the server conns, the sonny conns stay in the server conn they opened
+	bond        *bond    // the server conns are bonded, the sonny conns use all of them
+	wg          *group.Group
 }
 
 type Client struct {
 	config     *Config
-	server     string
+	servers    []*clientServer
 	name       string
 	clienttype CLIENT_TYPE
-	proxyproto []PROXY_PROTO
-	fromaddr   []string
-	toaddr     []string
-	serverconn []*ServerConn
+	lock       sync.Mutex
+	mappings   map[int]*clientMapping
+	nextindex  int
 	wg         *group.Group
 }
 
@@ -35,9 +67,38 @@ func NewClient(config *Config, serverproto string, server string, name string, c
 	if config == nil {
 		config = DefaultConfig()
 	}
+	config.init()
 
-	cn, err := conn.NewConn(serverproto)
-	if cn == nil {
+	err := checkProto(serverproto)
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []*clientServer
+	for _, s := range strings.Split(server, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, errors.New("server empty " + server)
+		}
+		cs, err := newClientServer(serverproto, s)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, cs)
+	}
+
+	err = checkServerBalance(config.ServerBalance)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkBond(config.Bond)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkEncrypt(config.Encrypt)
+	if err != nil {
 		return nil, err
 	}
 
@@ -49,24 +110,21 @@ func NewClient(config *Config, serverproto string, server string, name string, c
 
 	var proxyproto []PROXY_PROTO
 	for i, _ := range proxyprotostr {
-		p, ok := PROXY_PROTO_value[strings.ToUpper(proxyprotostr[i])]
-		if !ok {
-			return nil, errors.New("no PROXY_PROTO " + proxyprotostr[i])
+		p, err := parseProxyProto(proxyprotostr[i])
+		if err != nil {
+			return nil, err
 		}
-		proxyproto = append(proxyproto, PROXY_PROTO(p))
+		proxyproto = append(proxyproto, p)
 	}
 
 	wg := group.NewGroup("Clent"+" "+clienttypestr, nil, nil)
 
 	c := &Client{
 		config:     config,
-		server:     server,
+		servers:    servers,
 		name:       name,
 		clienttype: CLIENT_TYPE(clienttype),
-		proxyproto: proxyproto,
-		fromaddr:   fromaddr,
-		toaddr:     toaddr,
-		serverconn: make([]*ServerConn, len(proxyprotostr)),
+		mappings:   make(map[int]*clientMapping),
 		wg:         wg,
 	}
 
@@ -78,73 +136,372 @@ func NewClient(config *Config, serverproto string, server string, name string, c
 		return checkDeadLock(wg)
 	})
 
-	for i, _ := range proxyprotostr {
-		index := i
-		wg.Go("Client connect"+" "+fromaddr[i]+" "+toaddr[i], func() error {
-			atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
-			defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-			return c.connect(index, cn)
-		})
+	for i, _ := range proxyproto {
+		c.addMapping(proxyproto[i], fromaddr[i], toaddr[i])
 	}
 
 	return c, nil
 }
 
+func newClientServer(serverproto string, server string) (*clientServer, error) {
+	proto := serverproto
+	addr := server
+	if i := strings.Index(server, "://"); i >= 0 {
+		proto = server[:i]
+		addr = server[i+3:]
+		err := checkProto(proto)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cn, err := conn.NewConn(proto)
+	if cn == nil {
+		return nil, err
+	}
+	return &clientServer{server: server, addr: addr, cn: cn}, nil
+}
+
+func checkServerBalance(balance string) error {
+	switch balance {
+	case "", SERVER_BALANCE_PRIORITY, SERVER_BALANCE_ROUNDROBIN, SERVER_BALANCE_RTT:
+		return nil
+	}
+	return errors.New("no ServerBalance " + balance)
+}
+
+func parseProxyProto(proxyprotostr string) (PROXY_PROTO, error) {
+	p, ok := PROXY_PROTO_value[strings.ToUpper(proxyprotostr)]
+	if !ok {
+		return 0, errors.New("no PROXY_PROTO " + proxyprotostr)
+	}
+	err := checkProto(proxyprotostr)
+	if err != nil {
+		return 0, err
+	}
+	return PROXY_PROTO(p), nil
+}
+
 func (c *Client) Close() {
 	c.wg.Stop()
 	c.wg.Wait()
 }
 
-func (c *Client) connect(index int, conn conn.Conn) error {
-	loggo.Info("connect start %d %s", index, c.server)
+// AddMapping add a fromaddr/toaddr pair to the running client, return its index
+func (c *Client) AddMapping(proxyprotostr string, fromaddr string, toaddr string) (int, error) {
+	p, err := parseProxyProto(proxyprotostr)
+	if err != nil {
+		return 0, err
+	}
+	if c.wg.IsExit() {
+		return 0, errors.New("client closed")
+	}
+	c.lock.Lock()
+	for _, m := range c.mappings {
+		if m.fromaddr == fromaddr && fromaddr != "" {
+			c.lock.Unlock()
+			return 0, errors.New("fromaddr exist " + fromaddr)
+		}
+	}
+	c.lock.Unlock()
+	m := c.addMapping(p, fromaddr, toaddr)
+	loggo.Info("AddMapping ok %d %s %s %s", m.index, proxyprotostr, fromaddr, toaddr)
+	return m.index, nil
+}
+
+// RemoveMapping close the server conns of the mapping and all its sonny conns
+func (c *Client) RemoveMapping(index int) error {
+	c.lock.Lock()
+	m, ok := c.mappings[index]
+	delete(c.mappings, index)
+	c.lock.Unlock()
+	if !ok {
+		return errors.New("no mapping " + strconv.Itoa(index))
+	}
+	m.wg.Stop()
+	m.wg.Wait()
+	loggo.Info("RemoveMapping ok %d %s %s", index, m.fromaddr, m.toaddr)
+	return nil
+}
+
+// Mappings list the fromaddr/toaddr pairs, with their server conns and sonny conns
+func (c *Client) Mappings() []MappingInfo {
+	c.lock.Lock()
+	var ms []*clientMapping
+	for _, m := range c.mappings {
+		ms = append(ms, m)
+	}
+	c.lock.Unlock()
+
+	var ret []MappingInfo
+	for _, m := range ms {
+		mi := MappingInfo{
+			Index:      m.index,
+			ProxyProto: m.proxyproto.String(),
+			FromAddr:   m.fromaddr,
+			ToAddr:     m.toaddr,
+		}
+		// Addr is the server conn the new sonny will use
+		var father *ProxyConn
+		if m.bond != nil {
+			father = m.bond.pick()
+		} else {
+			father = c.pick(m)
+		}
+		if father != nil {
+			mi.Addr = father.conn.Info()
+			mi.Established = true
+		}
+		for i, serverconn := range m.getServerConns() {
+			si := MappingServerInfo{Server: c.servers[i].server}
+			if serverconn != nil {
+				si.Addr = serverconn.conn.Info()
+				si.Established = serverconn.isEstablished()
+				si.Healthy = healthy(&serverconn.ProxyConn, c.config)
+				si.RttMs = atomic.LoadInt64(&serverconn.rtt) / int64(time.Millisecond)
+				if serverconn.output != nil && m.bond == nil {
+					mi.Sonny = append(mi.Sonny, sonnyInfo(&serverconn.output.sonny)...)
+				}
+			}
+			mi.Servers = append(mi.Servers, si)
+		}
+		input := m.getInput()
+		if input != nil {
+			mi.Sonny = sonnyInfo(&input.sonny)
+		}
+		if m.bond != nil && m.bond.output != nil {
+			mi.Sonny = sonnyInfo(&m.bond.output.sonny)
+		}
+		ret = append(ret, mi)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Index < ret[j].Index
+	})
+	return ret
+}
+
+func (c *Client) Config() Config {
+	return c.config.load()
+}
+
+// ReloadConfig apply the timeouts, max, compress and balance of config, the others are ignored
+func (c *Client) ReloadConfig(config *Config) error {
+	err := c.config.reload(config)
+	if err != nil {
+		return err
+	}
+	loggo.Info("Client ReloadConfig ok")
+	return nil
+}
+
+func (c *Client) addMapping(proxyproto PROXY_PROTO, fromaddr string, toaddr string) *clientMapping {
+	c.lock.Lock()
+	m := &clientMapping{
+		index:       c.nextindex,
+		proxyproto:  proxyproto,
+		fromaddr:    fromaddr,
+		toaddr:      toaddr,
+		serverconns: make([]*ServerConn, len(c.servers)),
+	}
+	m.father.pick = func() *ProxyConn {
+		return c.pick(m)
+	}
+	m.wg = group.NewGroup("Client mapping"+" "+fromaddr+" "+toaddr, c.wg, func() {
+		input := m.getInput()
+		if input != nil {
+			input.Close()
+		}
+	})
+	if c.config.Bond != "" {
+		m.bond = newBond(m.wg, c.name+"_"+strconv.Itoa(m.index), c.config.Bond, c.config)
+	}
+	c.mappings[m.index] = m
+	c.nextindex++
+	c.lock.Unlock()
 
-	for !c.wg.IsExit() {
-		if c.serverconn[index] == nil {
-			targetconn, err := conn.Dial(c.server)
+	for i, _ := range c.servers {
+		index := i
+		m.wg.Go("Client connect"+" "+c.servers[index].server+" "+fromaddr+" "+toaddr, func() error {
+			atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+			defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+			return c.connect(m, index)
+		})
+	}
+	return m
+}
+
+func (m *clientMapping) getInput() *Inputer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.input
+}
+
+func (m *clientMapping) getServerConn(index int) *ServerConn {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.serverconns[index]
+}
+
+func (m *clientMapping) setServerConn(index int, serverconn *ServerConn) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.serverconns[index] = serverconn
+}
+
+// getServerConns return a copy of serverconns, some of them may be nil
+func (m *clientMapping) getServerConns() []*ServerConn {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ret := make([]*ServerConn, len(m.serverconns))
+	copy(ret, m.serverconns)
+	return ret
+}
+
+// pick choose a healthy server conn for the new sonny by ServerBalance, nil if no one
+func (c *Client) pick(m *clientMapping) *ProxyConn {
+	var candidates []*ServerConn
+	for _, serverconn := range m.getServerConns() {
+		if serverconn != nil && healthy(&serverconn.ProxyConn, c.config) {
+			candidates = append(candidates, serverconn)
+		}
+	}
+	serverconn := pickServer(c.config.load().ServerBalance, candidates, &m.rr)
+	if serverconn == nil {
+		return nil
+	}
+	return &serverconn.ProxyConn
+}
+
+// pickServer the serverconns are in the order of the servers
+func pickServer(balance string, serverconns []*ServerConn, rr *uint32) *ServerConn {
+	if len(serverconns) <= 0 {
+		return nil
+	}
+	switch balance {
+	case SERVER_BALANCE_ROUNDROBIN:
+		n := atomic.AddUint32(rr, 1)
+		return serverconns[int(n-1)%len(serverconns)]
+	case SERVER_BALANCE_RTT:
+		// the one not measured yet is the last choice
+		var ret *ServerConn
+		var min int64
+		for _, serverconn := range serverconns {
+			rtt := atomic.LoadInt64(&serverconn.rtt)
+			if rtt > 0 && (ret == nil || rtt < min) {
+				ret = serverconn
+				min = rtt
+			}
+		}
+		if ret != nil {
+			return ret
+		}
+		return serverconns[0]
+	default:
+		return serverconns[0]
+	}
+}
+
+func (c *Client) connect(m *clientMapping, index int) error {
+	server := c.servers[index].server
+	loggo.Info("connect start %d %s", m.index, server)
+
+	for !m.wg.IsExit() {
+		serverconn := m.getServerConn(index)
+		if serverconn != nil && !c.needServer(m, index) && c.idleServer(m, serverconn) {
+			loggo.Info("connect close idle standby %d %s", m.index, server)
+			atomic.StoreInt32(&serverconn.needclose, 1)
+		}
+		if serverconn == nil && c.needServer(m, index) {
+			targetconn, err := c.servers[index].cn.Dial(c.servers[index].addr)
 			if err != nil {
-				loggo.Error("connect Dial fail: %s %s", c.server, err.Error())
+				loggo.Error("connect Dial fail: %s %s", server, err.Error())
 				time.Sleep(time.Second)
 				continue
 			}
-			c.serverconn[index] = &ServerConn{ProxyConn: ProxyConn{conn: targetconn}}
-			c.wg.Go("Client useServer"+" "+targetconn.Info(), func() error {
+			serverconn := &ServerConn{ProxyConn: ProxyConn{conn: targetconn}, server: server, index: index}
+			m.setServerConn(index, serverconn)
+			m.wg.Go("Client useServer"+" "+targetconn.Info(), func() error {
 				atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 				defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-				return c.useServer(index, c.serverconn[index])
+				defer func() {
+					m.setServerConn(index, nil)
+				}()
+				return c.useServer(m, serverconn)
 			})
 		} else {
 			time.Sleep(time.Second)
 		}
 	}
-	loggo.Info("connect end %s", c.server)
+	loggo.Info("connect end %s", server)
 	return nil
 }
 
-func (c *Client) useServer(index int, serverconn *ServerConn) error {
+// needServer the priority mode connect the server only when the ones before it are not healthy, unless HotStandby
+func (c *Client) needServer(m *clientMapping, index int) bool {
+	config := c.config.load()
+	if config.HotStandby || m.bond != nil || (config.ServerBalance != "" && config.ServerBalance != SERVER_BALANCE_PRIORITY) {
+		return true
+	}
+	serverconns := m.getServerConns()
+	for i := 0; i < index; i++ {
+		if serverconns[i] != nil && healthy(&serverconns[i].ProxyConn, c.config) {
+			return false
+		}
+	}
+	return true
+}
+
+// idleServer the server conn has logined and no sonny conn in it
+func (c *Client) idleServer(m *clientMapping, serverconn *ServerConn) bool {
+	input := m.getInput()
+	if input == nil || !serverconn.isEstablished() {
+		return false
+	}
+	return input.fatherSonnySize(&serverconn.ProxyConn) <= 0
+}
+
+func (c *Client) useServer(m *clientMapping, serverconn *ServerConn) error {
 
 	loggo.Info("useServer %s", serverconn.conn.Info())
 
+	crypt, err := clientHandshake(m.wg, serverconn.conn, c.config)
+	if err != nil {
+		loggo.Error("useServer handshake fail %s %s", serverconn.conn.Info(), err)
+		serverconn.conn.Close()
+		return nil
+	}
+	serverconn.crypt = crypt
+
+	gMetricClientConn.Inc()
+	defer gMetricClientConn.Dec()
+
 	sendch := common.NewChannel(c.config.MainBuffer)
 	recvch := common.NewChannel(c.config.MainBuffer)
 
 	serverconn.sendch = sendch
 	serverconn.recvch = recvch
 
-	wg := group.NewGroup("Client useServer"+" "+serverconn.conn.Info(), c.wg, func() {
+	wg := group.NewGroup("Client useServer"+" "+serverconn.conn.Info(), m.wg, func() {
 		loggo.Info("group start exit %s", serverconn.conn.Info())
 		serverconn.conn.Close()
 		sendch.Close()
 		recvch.Close()
-		if serverconn.output != nil {
-			serverconn.output.Close()
-		}
-		if serverconn.input != nil {
-			serverconn.input.Close()
+		if m.bond != nil {
+			// the sonny conns go on in the other server conns of the bond
+			m.bond.leave(&serverconn.ProxyConn)
+		} else {
+			if serverconn.output != nil {
+				serverconn.output.Close()
+			}
+			// the sonny conns in other server conns go on
+			input := m.getInput()
+			if input != nil {
+				input.closeFather(&serverconn.ProxyConn)
+			}
 		}
 		loggo.Info("group end exit %s", serverconn.conn.Info())
 	})
 
-	c.login(index, sendch)
+	c.login(m, serverconn, sendch, crypt)
 
 	var pingflag int32
 	var pongflag int32
@@ -153,19 +510,19 @@ func (c *Client) useServer(index int, serverconn *ServerConn) error {
 	wg.Go("Client recvFrom"+" "+serverconn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return recvFrom(wg, recvch, serverconn.conn, c.config.MaxMsgSize, c.config.Encrypt)
+		return recvFrom(wg, recvch, serverconn.conn, c.config.MaxMsgSize, crypt)
 	})
 
 	wg.Go("Client sendTo"+" "+serverconn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return sendTo(wg, sendch, serverconn.conn, c.config.Compress, c.config.MaxMsgSize, c.config.Encrypt, &pingflag, &pongflag, &pongtime)
+		return sendTo(wg, sendch, serverconn.conn, c.config, crypt, &pingflag, &pongflag, &pongtime)
 	})
 
 	wg.Go("Client checkPingActive"+" "+serverconn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return checkPingActive(wg, sendch, recvch, &serverconn.ProxyConn, c.config.EstablishedTimeout, c.config.PingInter, c.config.PingTimeoutInter, c.config.ShowPing, &pingflag)
+		return checkPingActive(wg, sendch, recvch, &serverconn.ProxyConn, c.config, &pingflag)
 	})
 
 	wg.Go("Client checkNeedClose"+" "+serverconn.conn.Info(), func() error {
@@ -177,33 +534,35 @@ func (c *Client) useServer(index int, serverconn *ServerConn) error {
 	wg.Go("Client process"+" "+serverconn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return c.process(wg, index, sendch, recvch, serverconn, &pongflag, &pongtime)
+		return c.process(wg, m, sendch, recvch, serverconn, &pongflag, &pongtime)
 	})
 
 	wg.Wait()
-	c.serverconn[index] = nil
-	loggo.Info("useServer close %s %s", c.server, serverconn.conn.Info())
+	loggo.Info("useServer close %s %s", serverconn.server, serverconn.conn.Info())
 
 	return nil
 }
 
-func (c *Client) login(index int, sendch *common.Channel) {
+func (c *Client) login(m *clientMapping, serverconn *ServerConn, sendch *common.Channel, crypt *Crypt) {
 	f := &ProxyFrame{}
 	f.Type = FRAME_TYPE_LOGIN
 	f.LoginFrame = &LoginFrame{}
-	f.LoginFrame.Proxyproto = c.proxyproto[index]
+	f.LoginFrame.Proxyproto = m.proxyproto
 	f.LoginFrame.Clienttype = c.clienttype
-	f.LoginFrame.Fromaddr = c.fromaddr[index]
-	f.LoginFrame.Toaddr = c.toaddr[index]
-	f.LoginFrame.Name = c.name + "_" + strconv.Itoa(index)
-	f.LoginFrame.Key = c.config.Key
+	f.LoginFrame.Fromaddr = m.fromaddr
+	f.LoginFrame.Toaddr = m.toaddr
+	f.LoginFrame.Name = c.name + "_" + strconv.Itoa(m.index)
+	f.LoginFrame.Keyproof = crypt.keyProof(c.config.Key, "client")
+	if m.bond != nil {
+		f.LoginFrame.Bond = m.bond.mode
+	}
 
 	sendch.Write(f)
 
-	loggo.Info("start login %d %s %s", index, c.server, f.LoginFrame.String())
+	loggo.Info("start login %d %s %s", m.index, serverconn.server, f.LoginFrame.String())
 }
 
-func (c *Client) process(wg *group.Group, index int, sendch *common.Channel, recvch *common.Channel, serverconn *ServerConn, pongflag *int32, pongtime *int64) error {
+func (c *Client) process(wg *group.Group, m *clientMapping, sendch *common.Channel, recvch *common.Channel, serverconn *ServerConn, pongflag *int32, pongtime *int64) error {
 
 	loggo.Info("process start %s", serverconn.conn.Info())
 
@@ -214,73 +573,82 @@ func (c *Client) process(wg *group.Group, index int, sendch *common.Channel, rec
 			break
 		}
 		f := ff.(*ProxyFrame)
+		if f.Bondseq > 0 && m.bond != nil && !m.bond.recv(f.Bondseq) {
+			continue
+		}
 		switch f.Type {
 		case FRAME_TYPE_LOGINRSP:
-			c.processLoginRsp(wg, index, f, sendch, serverconn)
+			c.processLoginRsp(wg, m, f, sendch, serverconn)
 
 		case FRAME_TYPE_PING:
 			processPing(f, sendch, &serverconn.ProxyConn, pongflag, pongtime)
 
 		case FRAME_TYPE_PONG:
-			processPong(f, sendch, &serverconn.ProxyConn, c.config.ShowPing)
+			processPong(f, sendch, &serverconn.ProxyConn, c.config.load().ShowPing)
 
 		case FRAME_TYPE_DATA:
-			c.processData(f, serverconn)
+			c.processData(f, m, serverconn)
 
 		case FRAME_TYPE_OPEN:
 			c.processOpen(f, serverconn)
 
 		case FRAME_TYPE_OPENRSP:
-			c.processOpenRsp(f, serverconn)
+			c.processOpenRsp(f, m)
 
 		case FRAME_TYPE_CLOSE:
-			c.processClose(f, serverconn)
+			c.processClose(f, m, serverconn)
+
+		case FRAME_TYPE_BONDACK:
+			if m.bond != nil {
+				m.bond.ack(f.BondAckFrame.Seq)
+			}
 		}
 	}
 	loggo.Info("process end %s", serverconn.conn.Info())
 	return nil
 }
 
-func (c *Client) processLoginRsp(wg *group.Group, index int, f *ProxyFrame, sendch *common.Channel, serverconn *ServerConn) {
+func (c *Client) processLoginRsp(wg *group.Group, m *clientMapping, f *ProxyFrame, sendch *common.Channel, serverconn *ServerConn) {
 	if !f.LoginRspFrame.Ret {
-		serverconn.needclose = true
-		loggo.Error("processLoginRsp fail %s %s", c.server, f.LoginRspFrame.Msg)
+		atomic.StoreInt32(&serverconn.needclose, 1)
+		loggo.Error("processLoginRsp fail %s %s", serverconn.server, f.LoginRspFrame.Msg)
+		return
+	}
+	if !hmac.Equal(f.LoginRspFrame.Keyproof, serverconn.crypt.keyProof(c.config.Key, "server")) {
+		atomic.StoreInt32(&serverconn.needclose, 1)
+		loggo.Error("processLoginRsp fail server key error %s", serverconn.server)
 		return
 	}
 
-	loggo.Info("processLoginRsp ok %s", c.server)
+	loggo.Info("processLoginRsp ok %s", serverconn.server)
 
-	err := c.iniService(wg, index, serverconn)
+	err := c.iniService(wg, m, serverconn)
 	if err != nil {
-		loggo.Error("processLoginRsp iniService fail %s %s", c.server, err)
+		loggo.Error("processLoginRsp iniService fail %s %s", serverconn.server, err)
 		return
 	}
 
-	serverconn.established = true
+	atomic.StoreInt32(&serverconn.established, 1)
+
+	if m.bond != nil {
+		m.bond.join(&serverconn.ProxyConn, serverconn.index)
+	}
 }
 
-func (c *Client) iniService(wg *group.Group, index int, serverConn *ServerConn) error {
+func (c *Client) iniService(wg *group.Group, m *clientMapping, serverConn *ServerConn) error {
 	switch c.clienttype {
-	case CLIENT_TYPE_PROXY:
-		input, err := NewInputer(wg, c.proxyproto[index].String(), c.fromaddr[index], c.clienttype, c.config, &serverConn.ProxyConn, c.toaddr[index])
-		if err != nil {
-			return err
-		}
-		serverConn.input = input
-	case CLIENT_TYPE_REVERSE_PROXY:
-		output, err := NewOutputer(wg, c.proxyproto[index].String(), c.clienttype, c.config, &serverConn.ProxyConn)
-		if err != nil {
-			return err
-		}
-		serverConn.output = output
-	case CLIENT_TYPE_SOCKS5:
-		input, err := NewSocks5Inputer(wg, c.proxyproto[index].String(), c.fromaddr[index], c.clienttype, c.config, &serverConn.ProxyConn)
-		if err != nil {
-			return err
+	case CLIENT_TYPE_PROXY, CLIENT_TYPE_SOCKS5, CLIENT_TYPE_HTTP_PROXY:
+		return c.iniInput(m)
+	case CLIENT_TYPE_REVERSE_PROXY, CLIENT_TYPE_REVERSE_SOCKS5, CLIENT_TYPE_REVERSE_HTTP_PROXY:
+		if m.bond != nil {
+			output, err := c.iniBondOutput(m)
+			if err != nil {
+				return err
+			}
+			serverConn.output = output
+			return nil
 		}
-		serverConn.input = input
-	case CLIENT_TYPE_REVERSE_SOCKS5:
-		output, err := NewOutputer(wg, c.proxyproto[index].String(), c.clienttype, c.config, &serverConn.ProxyConn)
+		output, err := NewOutputer(wg, m.proxyproto.String(), c.clienttype, c.config, &serverConn.ProxyConn)
 		if err != nil {
 			return err
 		}
@@ -291,11 +659,59 @@ func (c *Client) iniService(wg *group.Group, index int, serverConn *ServerConn)
 	return nil
 }
 
-func (c *Client) processData(f *ProxyFrame, serverconn *ServerConn) {
-	if serverconn.input != nil {
-		serverconn.input.processDataFrame(f)
-	} else if serverconn.output != nil {
+// iniInput listen the fromaddr once for the mapping, the server conns share it
+func (c *Client) iniInput(m *clientMapping) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.input != nil {
+		return nil
+	}
+
+	wg := m.wg
+	father := &m.father
+	if m.bond != nil {
+		wg = m.bond.wg
+		father = &m.bond.father
+	}
+
+	var input *Inputer
+	var err error
+	switch c.clienttype {
+	case CLIENT_TYPE_PROXY:
+		input, err = NewInputer(wg, m.proxyproto.String(), m.fromaddr, c.clienttype, c.config, father, m.toaddr)
+	case CLIENT_TYPE_SOCKS5:
+		input, err = NewSocks5Inputer(wg, m.proxyproto.String(), m.fromaddr, c.clienttype, c.config, father)
+	case CLIENT_TYPE_HTTP_PROXY:
+		input, err = NewHttpInputer(wg, m.proxyproto.String(), m.fromaddr, c.clienttype, c.config, father)
+	}
+	if err != nil {
+		return err
+	}
+	m.input = input
+	return nil
+}
+
+// iniBondOutput the server conns of the bond share one outputer
+func (c *Client) iniBondOutput(m *clientMapping) (*Outputer, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.bond.output != nil {
+		return m.bond.output, nil
+	}
+
+	output, err := NewOutputer(m.bond.wg, m.proxyproto.String(), c.clienttype, c.config, &m.bond.father)
+	if err != nil {
+		return nil, err
+	}
+	m.bond.output = output
+	return output, nil
+}
+
+func (c *Client) processData(f *ProxyFrame, m *clientMapping, serverconn *ServerConn) {
+	if serverconn.output != nil {
 		serverconn.output.processDataFrame(f)
+	} else if input := m.getInput(); input != nil {
+		input.processDataFrame(f)
 	}
 }
 
@@ -305,16 +721,16 @@ func (c *Client) processOpen(f *ProxyFrame, serverconn *ServerConn) {
 	}
 }
 
-func (c *Client) processOpenRsp(f *ProxyFrame, serverconn *ServerConn) {
-	if serverconn.input != nil {
-		serverconn.input.processOpenRspFrame(f)
+func (c *Client) processOpenRsp(f *ProxyFrame, m *clientMapping) {
+	if input := m.getInput(); input != nil {
+		input.processOpenRspFrame(f)
 	}
 }
 
-func (c *Client) processClose(f *ProxyFrame, serverconn *ServerConn) {
-	if serverconn.input != nil {
-		serverconn.input.processCloseFrame(f)
-	} else if serverconn.output != nil {
+func (c *Client) processClose(f *ProxyFrame, m *clientMapping, serverconn *ServerConn) {
+	if serverconn.output != nil {
 		serverconn.output.processCloseFrame(f)
+	} else if input := m.getInput(); input != nil {
+		input.processCloseFrame(f)
 	}
 }
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func clientTestDial(addr string) (net.Conn, error) {
+	c, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	err = clientTestEcho(c, true)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func clientTestEcho(c net.Conn, first bool) error {
+	c.SetDeadline(time.Now().Add(time.Second * 5))
+	defer c.SetDeadline(time.Time{})
+	data := "hello"
+	if first {
+		data = "x" + data
+	}
+	_, err := c.Write([]byte(data))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(c, buf)
+	if err != nil {
+		return err
+	}
+	if string(buf) != "hello" {
+		return fmt.Errorf("echo error %s", string(buf))
+	}
+	return nil
+}
+
+func clientTestSonny(s *Server) int {
+	n := 0
+	for _, ci := range s.Clients() {
+		n += len(ci.Sonny)
+	}
+	return n
+}
+
+func Test0001ClientFailover(t *testing.T) {
+	target := startTestTarget(t, "127.0.0.1:58073")
+	defer target.Close()
+
+	config := DefaultConfig()
+
+	// the first server is down at start
+	server2, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58071"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Close()
+
+	client, err := NewClient(config, "tcp", "127.0.0.1:58070, 127.0.0.1:58071", "test", "PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58072"}, []string{"127.0.0.1:58073"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	time.Sleep(time.Second)
+
+	c2, err := clientTestDial("127.0.0.1:58072")
+	if err != nil {
+		t.Fatal("dial with the second server fail", err)
+	}
+	defer c2.Close()
+	if clientTestSonny(server2) != 1 {
+		t.Error("sonny not in the second server", clientTestSonny(server2))
+	}
+
+	server1, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58070"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Close()
+
+	time.Sleep(time.Second * 3)
+
+	// new conn back to the first server, the old one drain in the second
+	c1, err := clientTestDial("127.0.0.1:58072")
+	if err != nil {
+		t.Fatal("dial with the first server fail", err)
+	}
+	defer c1.Close()
+	if clientTestSonny(server1) != 1 || clientTestSonny(server2) != 1 {
+		t.Error("sonny not back to the first server", clientTestSonny(server1), clientTestSonny(server2))
+	}
+	err = clientTestEcho(c2, false)
+	if err != nil {
+		t.Error("old conn in the second server fail", err)
+	}
+
+	mappings := client.Mappings()
+	if len(mappings) != 1 || len(mappings[0].Servers) != 2 || !mappings[0].Servers[0].Healthy || len(mappings[0].Sonny) != 2 {
+		t.Error("mappings fail", mappings)
+	}
+
+	// the first server down, its conn is closed and the new go to the second
+	server1.Close()
+	time.Sleep(time.Second)
+
+	err = clientTestEcho(c1, false)
+	if err == nil {
+		t.Error("conn in the closed server still work")
+	}
+	err = clientTestEcho(c2, false)
+	if err != nil {
+		t.Error("conn in the second server fail", err)
+	}
+	c3, err := clientTestDial("127.0.0.1:58072")
+	if err != nil {
+		t.Fatal("dial after failover fail", err)
+	}
+	defer c3.Close()
+}
+
+func Test0001ClientStandby(t *testing.T) {
+	target := startTestTarget(t, "127.0.0.1:58113")
+	defer target.Close()
+
+	config := DefaultConfig()
+
+	server1, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58110"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Close()
+	server2, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58111"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Close()
+
+	client, err := NewClient(config, "tcp", "127.0.0.1:58110,127.0.0.1:58111", "test", "PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58112"}, []string{"127.0.0.1:58113"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	hotconfig := DefaultConfig()
+	hotconfig.HotStandby = true
+	hotclient, err := NewClient(hotconfig, "tcp", "127.0.0.1:58110,127.0.0.1:58111", "hot", "PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58114"}, []string{"127.0.0.1:58113"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Second * 3)
+
+	// only the hot standby connects the second server while the first is healthy
+	fmt.Println(len(server1.Clients()), len(server2.Clients()))
+	if len(server1.Clients()) != 2 || len(server2.Clients()) != 1 || !strings.HasPrefix(server2.Clients()[0].Name, "hot") {
+		t.Error("standby server diff", len(server1.Clients()), len(server2.Clients()))
+	}
+	hotclient.Close()
+
+	server1.Close()
+	time.Sleep(time.Second * 3)
+
+	c, err := clientTestDial("127.0.0.1:58112")
+	if err != nil {
+		t.Fatal("dial after failover fail", err)
+	}
+	defer c.Close()
+	if clientTestSonny(server2) != 1 {
+		t.Error("sonny not in the second server", clientTestSonny(server2))
+	}
+}
+
+func Test0001ClientRoundRobin(t *testing.T) {
+	target := startTestTarget(t, "127.0.0.1:58078")
+	defer target.Close()
+
+	config := DefaultConfig()
+	config.ServerBalance = SERVER_BALANCE_ROUNDROBIN
+
+	server1, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58075"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Close()
+	server2, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58076"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Close()
+
+	client, err := NewClient(config, "tcp", "127.0.0.1:58075,127.0.0.1:58076", "test", "PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58077"}, []string{"127.0.0.1:58078"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	time.Sleep(time.Second)
+
+	for i := 0; i < 4; i++ {
+		c, err := clientTestDial("127.0.0.1:58077")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	if clientTestSonny(server1) != 2 || clientTestSonny(server2) != 2 {
+		t.Error("round robin fail", clientTestSonny(server1), clientTestSonny(server2))
+	}
+}
+
+func Test0001PickServer(t *testing.T) {
+	s1 := &ServerConn{server: "s1"}
+	s2 := &ServerConn{server: "s2"}
+	s3 := &ServerConn{server: "s3"}
+	var rr uint32
+
+	if pickServer(SERVER_BALANCE_PRIORITY, nil, &rr) != nil {
+		t.Error("pick from empty")
+	}
+	if pickServer(SERVER_BALANCE_PRIORITY, []*ServerConn{s2, s3}, &rr) != s2 {
+		t.Error("priority fail")
+	}
+
+	var ret []string
+	for i := 0; i < 4; i++ {
+		ret = append(ret, pickServer(SERVER_BALANCE_ROUNDROBIN, []*ServerConn{s1, s2, s3}, &rr).server)
+	}
+	fmt.Println(ret)
+	if ret[0] != "s1" || ret[1] != "s2" || ret[2] != "s3" || ret[3] != "s1" {
+		t.Error("round robin fail", ret)
+	}
+
+	// not measured yet
+	if pickServer(SERVER_BALANCE_RTT, []*ServerConn{s1, s2, s3}, &rr) != s1 {
+		t.Error("rtt without measure fail")
+	}
+	s2.rtt = int64(time.Millisecond * 50)
+	s3.rtt = int64(time.Millisecond * 10)
+	if pickServer(SERVER_BALANCE_RTT, []*ServerConn{s1, s2, s3}, &rr) != s3 {
+		t.Error("rtt fail")
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/golang/protobuf/proto"
 	"io"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -17,14 +19,14 @@ import (
 type Config struct {
 	MaxMsgSize                int    // 消息最大长度
 	MainBuffer                int    // 主通道buffer最大长度
-	ConnBuffer                int    // 每个conn buffer最大长度
+	ConnBuffer                int    // 每个conn buffer最大长度，也是通告给对端的接收窗口
 	EstablishedTimeout        int    // 主通道登录超时
 	PingInter                 int    // 主通道ping间隔
 	PingTimeoutInter          int    // 主通道ping超时间隔
 	ConnTimeout               int    // 每个conn的不活跃超时时间
 	ConnectTimeout            int    // 每个conn的连接超时
 	Key                       string // 连接密码
-	Encrypt                   string // 加密密钥
+	Encrypt                   string // 加密算法，不能为空
 	Compress                  int    // 压缩设置
 	ShowPing                  bool   // 是否显示ping
 	Username                  string // 登录用户名
@@ -32,6 +34,13 @@ type Config struct {
 	MaxClient                 int    // 最大客户端数目
 	MaxSonny                  int    // 最大连接数目
 	MainWriteChannelTimeoutMs int    // 主通道转发消息超时
+	User                      string // 登录用户，服务器配置了UserFile时使用，连接密码为Key
+	UserFile                  string // 服务器的用户配置文件，为空则只使用Key，修改后自动加载
+	ServerBalance             string // 客户端配置多个server时，新连接选择server的策略
+	Bond                      string // 客户端的多个server连接绑定为一个会话，帧分散或切换着发出，为空不绑定
+	HotStandby                bool   // priority策略下后面的server也一直保持连接，默认前面的server不可用时才连接，恢复后空闲的备用连接关闭
+
+	lock *sync.RWMutex // reload写，运行中读可reload的字段要用load
 }
 
 func DefaultConfig() *Config {
@@ -45,7 +54,7 @@ func DefaultConfig() *Config {
 		ConnTimeout:               60,
 		ConnectTimeout:            10,
 		Key:                       "123456",
-		Encrypt:                   "default",
+		Encrypt:                   ENCRYPT_AES_256_GCM,
 		Compress:                  128,
 		ShowPing:                  false,
 		Username:                  "",
@@ -53,18 +62,87 @@ func DefaultConfig() *Config {
 		MaxClient:                 8,
 		MaxSonny:                  128,
 		MainWriteChannelTimeoutMs: 1000,
+		User:                      "",
+		UserFile:                  "",
+		ServerBalance:             SERVER_BALANCE_PRIORITY,
+		Bond:                      "",
+		HotStandby:                false,
+		lock:                      &sync.RWMutex{},
 	}
 }
 
+func (c *Config) init() {
+	if c.lock == nil {
+		c.lock = &sync.RWMutex{}
+	}
+}
+
+// load return a copy, the fields can be reloaded must be read by it after the config is shared
+func (c *Config) load() Config {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return *c
+}
+
+// reload copy the fields can be changed at runtime, the others need restart
+func (c *Config) reload(n *Config) error {
+	if n.EstablishedTimeout <= 0 || n.PingInter <= 0 || n.PingTimeoutInter <= 0 || n.ConnTimeout <= 0 || n.ConnectTimeout <= 0 {
+		return errors.New("timeout must be positive")
+	}
+	if n.MaxClient <= 0 || n.MaxSonny <= 0 || n.MainWriteChannelTimeoutMs <= 0 {
+		return errors.New("max must be positive")
+	}
+	if n.Compress < 0 {
+		return errors.New("compress must not be negative")
+	}
+	err := checkServerBalance(n.ServerBalance)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.EstablishedTimeout = n.EstablishedTimeout
+	c.PingInter = n.PingInter
+	c.PingTimeoutInter = n.PingTimeoutInter
+	c.ConnTimeout = n.ConnTimeout
+	c.ConnectTimeout = n.ConnectTimeout
+	c.Compress = n.Compress
+	c.ShowPing = n.ShowPing
+	c.MaxClient = n.MaxClient
+	c.MaxSonny = n.MaxSonny
+	c.MainWriteChannelTimeoutMs = n.MainWriteChannelTimeoutMs
+	c.ServerBalance = n.ServerBalance
+	c.HotStandby = n.HotStandby
+	return nil
+}
+
 type ProxyConn struct {
 	conn        conn.Conn
-	established bool
+	established int32
 	sendch      *common.Channel // *ProxyFrame
 	recvch      *common.Channel // *ProxyFrame
 	actived     int
-	pinged      int
+	pinged      int32
 	id          string
-	needclose   bool
+	needclose   int32
+	sendwin     int32    // 还能发给对端的数据帧数目
+	sendwinch   chan int // sendwin增加的通知
+	sendsize    int64    // 发给对端的数据长度
+	recvsize    int64    // 对端发来的数据长度
+	checkOpen   func(toaddr string) (func(), error)
+	bind        bool              // socks5 bind，对端监听等待toaddr连入
+	father      *ProxyConn        // sonny所在的主通道
+	pick        func() *ProxyConn // 有多个主通道时，为新的sonny选择一个
+	rtt         int64             // 主通道ping的平滑延迟，纳秒
+	sendindex   int32             // 最后发给对端的数据帧index
+	bonding     bool              // 绑定会话的father，帧从多个主通道发出，到达对端的顺序不定
+}
+
+func checkProto(proto string) error {
+	if !conn.HasProtocol(proto) {
+		return errors.New("proto " + proto + " not in " + strings.Join(conn.Protocols(), ","))
+	}
+	return nil
 }
 
 func checkProxyFame(f *ProxyFrame) error {
@@ -101,6 +179,18 @@ func checkProxyFame(f *ProxyFrame) error {
 		if f.CloseFrame == nil {
 			return errors.New("CloseFrame nil")
 		}
+	case FRAME_TYPE_HANDSHAKE:
+		if f.HandshakeFrame == nil {
+			return errors.New("HandshakeFrame nil")
+		}
+	case FRAME_TYPE_HANDSHAKERSP:
+		if f.HandshakeRspFrame == nil {
+			return errors.New("HandshakeRspFrame nil")
+		}
+	case FRAME_TYPE_BONDACK:
+		if f.BondAckFrame == nil {
+			return errors.New("BondAckFrame nil")
+		}
 	default:
 		return errors.New("Type error")
 	}
@@ -108,7 +198,7 @@ func checkProxyFame(f *ProxyFrame) error {
 	return nil
 }
 
-func MarshalSrpFrame(f *ProxyFrame, compress int, encrpyt string) ([]byte, error) {
+func MarshalSrpFrame(f *ProxyFrame, compress int, crypt *Crypt) ([]byte, error) {
 
 	err := checkProxyFame(f)
 	if err != nil {
@@ -127,46 +217,29 @@ func MarshalSrpFrame(f *ProxyFrame, compress int, encrpyt string) ([]byte, error
 		}
 	}
 
-	if f.Type == FRAME_TYPE_DATA && encrpyt != "" {
-		newb, err := common.Rc4(encrpyt, f.DataFrame.Data)
-		if err != nil {
-			return nil, err
-		}
-		if loggo.IsDebug() {
-			loggo.Debug("MarshalSrpFrame Rc4 from %s %s", common.GetCrc32(f.DataFrame.Data), common.GetCrc32(newb))
-		}
-		f.DataFrame.Data = newb
-	}
-
 	mb, err := proto.Marshal(f)
 	if err != nil {
 		return nil, err
 	}
-	return mb, err
+	return crypt.Seal(mb), nil
 }
 
-func UnmarshalSrpFrame(b []byte, encrpyt string) (*ProxyFrame, error) {
+func UnmarshalSrpFrame(b []byte, crypt *Crypt) (*ProxyFrame, error) {
 
-	f := &ProxyFrame{}
-	err := proto.Unmarshal(b, f)
+	b, err := crypt.Open(b)
 	if err != nil {
 		return nil, err
 	}
 
-	err = checkProxyFame(f)
+	f := &ProxyFrame{}
+	err = proto.Unmarshal(b, f)
 	if err != nil {
 		return nil, err
 	}
 
-	if f.Type == FRAME_TYPE_DATA && encrpyt != "" {
-		newb, err := common.Rc4(encrpyt, f.DataFrame.Data)
-		if err != nil {
-			return nil, err
-		}
-		if loggo.IsDebug() {
-			loggo.Debug("UnmarshalSrpFrame Rc4 from %s %s", common.GetCrc32(f.DataFrame.Data), common.GetCrc32(newb))
-		}
-		f.DataFrame.Data = newb
+	err = checkProxyFame(f)
+	if err != nil {
+		return nil, err
 	}
 
 	if f.Type == FRAME_TYPE_DATA && f.DataFrame.Compress {
@@ -189,7 +262,7 @@ const (
 	MAX_PROTO_PACK_SIZE = 100
 )
 
-func recvFrom(wg *group.Group, recvch *common.Channel, conn conn.Conn, maxmsgsize int, encrypt string) error {
+func recvFrom(wg *group.Group, recvch *common.Channel, conn conn.Conn, maxmsgsize int, crypt *Crypt) error {
 
 	atomic.AddInt32(&gStateThreadNum.RecvThread, 1)
 	defer atomic.AddInt32(&gStateThreadNum.RecvThread, -1)
@@ -199,7 +272,7 @@ func recvFrom(wg *group.Group, recvch *common.Channel, conn conn.Conn, maxmsgsiz
 	ds := make([]byte, maxmsgsize+MAX_PROTO_PACK_SIZE)
 
 	for !wg.IsExit() {
-		atomic.AddInt32(&gState.RecvFrames, 1)
+		atomic.AddInt64(&gState.RecvFrames, 1)
 
 		if loggo.IsDebug() {
 			loggo.Debug("recvFrom start ReadFull len %s", conn.Info())
@@ -228,7 +301,7 @@ func recvFrom(wg *group.Group, recvch *common.Channel, conn conn.Conn, maxmsgsiz
 			return err
 		}
 
-		f, err := UnmarshalSrpFrame(ds[0:msglen], encrypt)
+		f, err := UnmarshalSrpFrame(ds[0:msglen], crypt)
 		if err != nil {
 			loggo.Error("recvFrom UnmarshalSrpFrame fail: %s %s", conn.Info(), err.Error())
 			return err
@@ -249,7 +322,7 @@ func recvFrom(wg *group.Group, recvch *common.Channel, conn conn.Conn, maxmsgsiz
 			}
 		}
 
-		atomic.AddInt32(&gState.MainRecvNum, 1)
+		atomic.AddInt64(&gState.MainRecvNum, 1)
 		atomic.AddInt64(&gState.MainRecvSize, int64(msglen)+4)
 
 		gDeadLock.recving = false
@@ -259,7 +332,7 @@ func recvFrom(wg *group.Group, recvch *common.Channel, conn conn.Conn, maxmsgsiz
 	return nil
 }
 
-func sendTo(wg *group.Group, sendch *common.Channel, conn conn.Conn, compress int, maxmsgsize int, encrypt string, pingflag *int32, pongflag *int32, pongtime *int64) error {
+func sendTo(wg *group.Group, sendch *common.Channel, conn conn.Conn, config *Config, crypt *Crypt, pingflag *int32, pongflag *int32, pongtime *int64) error {
 
 	atomic.AddInt32(&gStateThreadNum.SendThread, 1)
 	defer atomic.AddInt32(&gStateThreadNum.SendThread, -1)
@@ -268,7 +341,7 @@ func sendTo(wg *group.Group, sendch *common.Channel, conn conn.Conn, compress in
 	bs := make([]byte, 4)
 
 	for !wg.IsExit() {
-		atomic.AddInt32(&gState.SendFrames, 1)
+		atomic.AddInt64(&gState.SendFrames, 1)
 
 		var f *ProxyFrame
 		if *pingflag > 0 {
@@ -302,14 +375,26 @@ func sendTo(wg *group.Group, sendch *common.Channel, conn conn.Conn, compress in
 				continue
 			}
 		}
-		mb, err := MarshalSrpFrame(f, compress, encrypt)
+
+		// check before MarshalSrpFrame, it compress the data in place
+		if f.Type != FRAME_TYPE_PING && f.Type != FRAME_TYPE_PONG && loggo.IsDebug() {
+			loggo.Debug("sendTo %s %s", conn.Info(), f.Type.String())
+			if f.Type == FRAME_TYPE_DATA {
+				if common.GetCrc32(f.DataFrame.Data) != f.DataFrame.Crc {
+					loggo.Error("sendTo crc error %s %s %s %p", conn.Info(), common.GetCrc32(f.DataFrame.Data), f.DataFrame.Crc, f)
+					return errors.New("conn crc error")
+				}
+			}
+		}
+
+		mb, err := MarshalSrpFrame(f, config.load().Compress, crypt)
 		if err != nil {
 			loggo.Error("sendTo MarshalSrpFrame fail: %s %s", conn.Info(), err.Error())
 			return err
 		}
 
 		msglen := uint32(len(mb))
-		if msglen > uint32(maxmsgsize)+MAX_PROTO_PACK_SIZE || msglen <= 0 {
+		if msglen > uint32(config.MaxMsgSize)+MAX_PROTO_PACK_SIZE || msglen <= 0 {
 			loggo.Error("sendTo len fail: %s %d", conn.Info(), msglen)
 			return errors.New("msg len fail " + strconv.Itoa(int(msglen)))
 		}
@@ -341,17 +426,7 @@ func sendTo(wg *group.Group, sendch *common.Channel, conn conn.Conn, compress in
 			return errors.New("len error")
 		}
 
-		if f.Type != FRAME_TYPE_PING && f.Type != FRAME_TYPE_PONG && loggo.IsDebug() {
-			loggo.Debug("sendTo %s %s", conn.Info(), f.Type.String())
-			if f.Type == FRAME_TYPE_DATA {
-				if common.GetCrc32(f.DataFrame.Data) != f.DataFrame.Crc {
-					loggo.Error("sendTo crc error %s %s %s %p", conn.Info(), common.GetCrc32(f.DataFrame.Data), f.DataFrame.Crc, f)
-					return errors.New("conn crc error")
-				}
-			}
-		}
-
-		atomic.AddInt32(&gState.MainSendNum, 1)
+		atomic.AddInt64(&gState.MainSendNum, 1)
 		atomic.AddInt64(&gState.MainSendSize, int64(msglen)+4)
 
 		gDeadLock.sending = false
@@ -374,12 +449,17 @@ func recvFromSonny(wg *group.Group, recvch *common.Channel, conn conn.Conn, maxm
 
 	index := int32(0)
 	for !wg.IsExit() {
-		atomic.AddInt32(&gState.RecvSonnyFrames, 1)
+		atomic.AddInt64(&gState.RecvSonnyFrames, 1)
 
 		msglen, err := conn.Read(ds)
 		if err != nil {
 			loggo.Info("recvFromSonny Read fail: %s %s", conn.Info(), err.Error())
-			return err
+			// copySonnyRecv send the data already read, then close
+			f := &ProxyFrame{}
+			f.Type = FRAME_TYPE_CLOSE
+			f.CloseFrame = &CloseFrame{}
+			recvch.Write(f)
+			return nil
 		}
 
 		if msglen <= 0 {
@@ -405,22 +485,26 @@ func recvFromSonny(wg *group.Group, recvch *common.Channel, conn conn.Conn, maxm
 			loggo.Debug("recvFromSonny %s %d %s %d %p", conn.Info(), msglen, f.DataFrame.Crc, f.DataFrame.Index, f)
 		}
 
-		atomic.AddInt32(&gState.RecvNum, 1)
+		atomic.AddInt64(&gState.RecvNum, 1)
 		atomic.AddInt64(&gState.RecvSize, int64(len(f.DataFrame.Data)))
 	}
 	loggo.Info("recvFromSonny end %s", conn.Info())
 	return nil
 }
 
-func sendToSonny(wg *group.Group, sendch *common.Channel, conn conn.Conn, maxmsgsize int) error {
+func sendToSonny(wg *group.Group, sendch *common.Channel, proxyConn *ProxyConn, father *ProxyConn, maxmsgsize int, window int) error {
 
 	atomic.AddInt32(&gStateThreadNum.SendSonnyThread, 1)
 	defer atomic.AddInt32(&gStateThreadNum.SendSonnyThread, -1)
 
+	conn := proxyConn.conn
 	loggo.Info("sendToSonny start %s", conn.Info())
 	index := int32(0)
+	consumed := 0
+	closeindex := int32(-1)
+	pending := make(map[int32]*ProxyFrame)
 	for !wg.IsExit() {
-		atomic.AddInt32(&gState.SendSonnyFrames, 1)
+		atomic.AddInt64(&gState.SendSonnyFrames, 1)
 
 		ff := <-sendch.Ch()
 		if ff == nil {
@@ -428,8 +512,13 @@ func sendToSonny(wg *group.Group, sendch *common.Channel, conn conn.Conn, maxmsg
 		}
 		f := ff.(*ProxyFrame)
 		if f.Type == FRAME_TYPE_CLOSE {
-			loggo.Info("sendToSonny close by remote: %s", conn.Info())
-			return errors.New("close by remote")
+			if !father.bonding || f.CloseFrame.Index == index {
+				loggo.Info("sendToSonny close by remote: %s", conn.Info())
+				return errors.New("close by remote")
+			}
+			// the data frames before the close are still on the way
+			closeindex = f.CloseFrame.Index
+			continue
 		}
 		if f.DataFrame.Compress {
 			loggo.Error("sendToSonny Compress error: %s", conn.Info())
@@ -453,37 +542,60 @@ func sendToSonny(wg *group.Group, sendch *common.Channel, conn conn.Conn, maxmsg
 			}
 		}
 
-		index++
-		index = index % MAX_INDEX
-		if f.DataFrame.Index != index {
-			loggo.Error("sendToSonny index error: %s %d %d %d", conn.Info(), len(f.DataFrame.Data), f.DataFrame.Index, index)
-			return errors.New("index error")
+		if f.DataFrame.Index != (index+1)%MAX_INDEX {
+			ahead := (f.DataFrame.Index - index + MAX_INDEX) % MAX_INDEX
+			if !father.bonding || ahead > MAX_INDEX/2 {
+				loggo.Error("sendToSonny index error: %s %d %d %d", conn.Info(), len(f.DataFrame.Data), f.DataFrame.Index, index)
+				return errors.New("index error")
+			}
+			// from other main conn of the bond, wait the frames before it
+			pending[f.DataFrame.Index] = f
+			continue
 		}
 
-		n, err := conn.Write(f.DataFrame.Data)
-		if err != nil {
-			loggo.Info("sendToSonny Write fail: %s %s", conn.Info(), err.Error())
-			return err
-		}
+		for f != nil {
+			index = f.DataFrame.Index
 
-		if n != len(f.DataFrame.Data) {
-			loggo.Error("sendToSonny Write len fail: %s %d %d", conn.Info(), n, len(f.DataFrame.Data))
-			return errors.New("len error")
-		}
+			n, err := conn.Write(f.DataFrame.Data)
+			if err != nil {
+				loggo.Info("sendToSonny Write fail: %s %s", conn.Info(), err.Error())
+				return err
+			}
 
-		if loggo.IsDebug() {
-			loggo.Debug("sendToSonny %s %d %s %d", conn.Info(), len(f.DataFrame.Data), f.DataFrame.Crc, f.DataFrame.Index)
+			if n != len(f.DataFrame.Data) {
+				loggo.Error("sendToSonny Write len fail: %s %d %d", conn.Info(), n, len(f.DataFrame.Data))
+				return errors.New("len error")
+			}
+
+			if loggo.IsDebug() {
+				loggo.Debug("sendToSonny %s %d %s %d", conn.Info(), len(f.DataFrame.Data), f.DataFrame.Crc, f.DataFrame.Index)
+			}
+
+			consumed++
+			if consumed >= (window+1)/2 {
+				sendWindowUpdate(proxyConn, father, consumed)
+				consumed = 0
+			}
+
+			atomic.AddInt64(&gState.SendNum, 1)
+			atomic.AddInt64(&gState.SendSize, int64(len(f.DataFrame.Data)))
+
+			next := (index + 1) % MAX_INDEX
+			f = pending[next]
+			delete(pending, next)
 		}
 
-		atomic.AddInt32(&gState.SendNum, 1)
-		atomic.AddInt64(&gState.SendSize, int64(len(f.DataFrame.Data)))
+		if closeindex == index {
+			loggo.Info("sendToSonny close by remote: %s", conn.Info())
+			return errors.New("close by remote")
+		}
 	}
 	loggo.Info("sendToSonny end %s", conn.Info())
 	return nil
 }
 
 func checkPingActive(wg *group.Group, sendch *common.Channel, recvch *common.Channel, proxyconn *ProxyConn,
-	estimeout int, pinginter int, pingintertimeout int, showping bool, pingflag *int32) error {
+	config *Config, pingflag *int32) error {
 
 	atomic.AddInt32(&gStateThreadNum.CheckThread, 1)
 	defer atomic.AddInt32(&gStateThreadNum.CheckThread, -1)
@@ -492,10 +604,10 @@ func checkPingActive(wg *group.Group, sendch *common.Channel, recvch *common.Cha
 
 	begin := time.Now()
 	for !wg.IsExit() {
-		atomic.AddInt32(&gState.CheckFrames, 1)
+		atomic.AddInt64(&gState.CheckFrames, 1)
 
-		if !proxyconn.established {
-			if time.Now().Sub(begin) > time.Second*time.Duration(estimeout) {
+		if !proxyconn.isEstablished() {
+			if time.Now().Sub(begin) > time.Second*time.Duration(config.load().EstablishedTimeout) {
 				loggo.Info("checkPingActive established timeout %s", proxyconn.conn.Info())
 				return errors.New("established timeout")
 			}
@@ -507,20 +619,20 @@ func checkPingActive(wg *group.Group, sendch *common.Channel, recvch *common.Cha
 
 	begin = time.Now()
 	for !wg.IsExit() {
-		atomic.AddInt32(&gState.CheckFrames, 1)
+		atomic.AddInt64(&gState.CheckFrames, 1)
 
-		if time.Now().Sub(begin) > time.Duration(pinginter)*time.Second {
+		if time.Now().Sub(begin) > time.Duration(config.load().PingInter)*time.Second {
 			begin = time.Now()
 
-			if proxyconn.pinged > pingintertimeout {
+			if int(atomic.LoadInt32(&proxyconn.pinged)) > config.load().PingTimeoutInter {
 				loggo.Info("checkPingActive ping pong timeout %s", proxyconn.conn.Info())
 				return errors.New("ping pong timeout")
 			}
 
 			atomic.AddInt32(pingflag, 1)
 
-			proxyconn.pinged++
-			if showping {
+			atomic.AddInt32(&proxyconn.pinged, 1)
+			if config.load().ShowPing {
 				loggo.Info("ping %s", proxyconn.conn.Info())
 			}
 		}
@@ -531,6 +643,19 @@ func checkPingActive(wg *group.Group, sendch *common.Channel, recvch *common.Cha
 	return nil
 }
 
+func (p *ProxyConn) isEstablished() bool {
+	return atomic.LoadInt32(&p.established) != 0
+}
+
+func (p *ProxyConn) isNeedClose() bool {
+	return atomic.LoadInt32(&p.needclose) != 0
+}
+
+// healthy the main conn can take new frames, it is established and the pong is not too late
+func healthy(proxyconn *ProxyConn, config *Config) bool {
+	return proxyconn.isEstablished() && !proxyconn.isNeedClose() && int(atomic.LoadInt32(&proxyconn.pinged))*2 <= config.load().PingTimeoutInter
+}
+
 func checkNeedClose(wg *group.Group, proxyconn *ProxyConn) error {
 
 	atomic.AddInt32(&gStateThreadNum.CheckThread, 1)
@@ -539,9 +664,9 @@ func checkNeedClose(wg *group.Group, proxyconn *ProxyConn) error {
 	loggo.Info("checkNeedClose start %s", proxyconn.conn.Info())
 
 	for !wg.IsExit() {
-		atomic.AddInt32(&gState.CheckFrames, 1)
+		atomic.AddInt64(&gState.CheckFrames, 1)
 
-		if proxyconn.needclose {
+		if proxyconn.isNeedClose() {
 			loggo.Error("checkNeedClose needclose %s", proxyconn.conn.Info())
 			return errors.New("needclose")
 		}
@@ -560,7 +685,14 @@ func processPing(f *ProxyFrame, sendch *common.Channel, proxyconn *ProxyConn, po
 
 func processPong(f *ProxyFrame, sendch *common.Channel, proxyconn *ProxyConn, showping bool) {
 	elapse := time.Duration(time.Now().UnixNano() - f.PongFrame.Time)
-	proxyconn.pinged = 0
+	atomic.StoreInt32(&proxyconn.pinged, 0)
+	rtt := int64(elapse)
+	old := atomic.LoadInt64(&proxyconn.rtt)
+	if old > 0 {
+		rtt = (old + rtt) / 2
+	}
+	atomic.StoreInt64(&proxyconn.rtt, rtt)
+	gMetricPingRtt.Observe(elapse.Seconds())
 	if showping {
 		loggo.Info("pong %s %s", proxyconn.conn.Info(), elapse.String())
 	}
@@ -575,9 +707,9 @@ func checkSonnyActive(wg *group.Group, proxyconn *ProxyConn, estimeout int, time
 
 	begin := time.Now()
 	for !wg.IsExit() {
-		atomic.AddInt32(&gState.CheckFrames, 1)
+		atomic.AddInt64(&gState.CheckFrames, 1)
 
-		if !proxyconn.established {
+		if !proxyconn.isEstablished() {
 			if time.Now().Sub(begin) > time.Second*time.Duration(estimeout) {
 				loggo.Error("checkSonnyActive established timeout %s", proxyconn.conn.Info())
 				return errors.New("established timeout")
@@ -590,7 +722,7 @@ func checkSonnyActive(wg *group.Group, proxyconn *ProxyConn, estimeout int, time
 
 	begin = time.Now()
 	for !wg.IsExit() {
-		atomic.AddInt32(&gState.CheckFrames, 1)
+		atomic.AddInt64(&gState.CheckFrames, 1)
 
 		if time.Now().Sub(begin) > time.Second*time.Duration(timeout) {
 			if proxyconn.actived == 0 {
@@ -614,13 +746,17 @@ func copySonnyRecv(wg *group.Group, recvch *common.Channel, proxyConn *ProxyConn
 	loggo.Info("copySonnyRecv start %s", proxyConn.conn.Info())
 
 	for !wg.IsExit() {
-		atomic.AddInt32(&gState.CopyFrames, 1)
+		atomic.AddInt64(&gState.CopyFrames, 1)
 
 		ff := <-recvch.Ch()
 		if ff == nil {
 			break
 		}
 		f := ff.(*ProxyFrame)
+		if f.Type == FRAME_TYPE_CLOSE {
+			loggo.Info("copySonnyRecv close by sonny %s", proxyConn.conn.Info())
+			return errors.New("close by sonny")
+		}
 		if f.Type != FRAME_TYPE_DATA {
 			loggo.Error("copySonnyRecv type error %s %d", proxyConn.conn.Info(), f.Type)
 			return errors.New("conn type error")
@@ -637,8 +773,13 @@ func copySonnyRecv(wg *group.Group, recvch *common.Channel, proxyConn *ProxyConn
 		}
 		f.DataFrame.Id = proxyConn.id
 		proxyConn.actived++
+		atomic.AddInt64(&father.sendsize, int64(len(f.DataFrame.Data)))
 
+		if !waitSendWindow(wg, proxyConn) {
+			break
+		}
 		father.sendch.Write(f)
+		atomic.StoreInt32(&proxyConn.sendindex, f.DataFrame.Index)
 
 		loggo.Debug("copySonnyRecv %s %d %s %p", proxyConn.id, len(f.DataFrame.Data), f.DataFrame.Crc, f)
 	}
@@ -646,11 +787,62 @@ func copySonnyRecv(wg *group.Group, recvch *common.Channel, proxyConn *ProxyConn
 	return nil
 }
 
+func initSendWindow(proxyConn *ProxyConn, window int) {
+	proxyConn.sendwin = int32(window)
+	proxyConn.sendwinch = make(chan int, 1)
+}
+
+func addSendWindow(proxyConn *ProxyConn, n int32) {
+	atomic.AddInt32(&proxyConn.sendwin, n)
+	select {
+	case proxyConn.sendwinch <- 1:
+	default:
+	}
+}
+
+// waitSendWindow block until remote has room for one more frame, so a slow remote sonny only blocks itself
+func waitSendWindow(wg *group.Group, proxyConn *ProxyConn) bool {
+	waited := false
+	for !wg.IsExit() {
+		if atomic.LoadInt32(&proxyConn.sendwin) > 0 {
+			atomic.AddInt32(&proxyConn.sendwin, -1)
+			return true
+		}
+		if !waited {
+			waited = true
+			atomic.AddInt64(&gState.SendWinWaitNum, 1)
+			if loggo.IsDebug() {
+				loggo.Debug("waitSendWindow %s", proxyConn.id)
+			}
+		}
+		select {
+		case <-proxyConn.sendwinch:
+		case <-time.After(time.Second):
+		}
+	}
+	return false
+}
+
+func sendWindowUpdate(proxyConn *ProxyConn, father *ProxyConn, n int) {
+	f := &ProxyFrame{}
+	f.Type = FRAME_TYPE_DATA
+	f.DataFrame = &DataFrame{}
+	f.DataFrame.Id = proxyConn.id
+	f.DataFrame.Window = int32(n)
+	if loggo.IsDebug() {
+		f.DataFrame.Crc = common.GetCrc32(f.DataFrame.Data)
+	}
+
+	father.sendch.Write(f)
+	atomic.AddInt64(&gState.SendWinUpdateNum, 1)
+}
+
 func closeRemoteConn(proxyConn *ProxyConn, father *ProxyConn) {
 	f := &ProxyFrame{}
 	f.Type = FRAME_TYPE_CLOSE
 	f.CloseFrame = &CloseFrame{}
 	f.CloseFrame.Id = proxyConn.id
+	f.CloseFrame.Index = atomic.LoadInt32(&proxyConn.sendindex)
 
 	father.sendch.Write(f)
 	loggo.Info("closeConn %s", proxyConn.id)
@@ -666,13 +858,14 @@ type StateThreadNum struct {
 	CheckThread     int32
 }
 
+// State the counters only increase, showState log the delta of every minute
 type State struct {
-	RecvFrames      int32
-	SendFrames      int32
-	RecvSonnyFrames int32
-	SendSonnyFrames int32
-	CopyFrames      int32
-	CheckFrames     int32
+	RecvFrames      int64
+	SendFrames      int64
+	RecvSonnyFrames int64
+	SendSonnyFrames int64
+	CopyFrames      int64
+	CheckFrames     int64
 
 	RecvFps      int32
 	SendFps      int32
@@ -681,17 +874,20 @@ type State struct {
 	CopyFps      int32
 	CheckFps     int32
 
-	MainRecvNum  int32
-	MainSendNum  int32
+	MainRecvNum  int64
+	MainSendNum  int64
 	MainRecvSize int64
 	MainSendSize int64
-	RecvNum      int32
-	SendNum      int32
+	RecvNum      int64
+	SendNum      int64
 	RecvSize     int64
 	SendSize     int64
 
 	RecvCompSaveSize int64
 	SendCompSaveSize int64
+
+	SendWinWaitNum   int64
+	SendWinUpdateNum int64
 }
 
 type DeadLock struct {
@@ -708,47 +904,33 @@ var gDeadLock DeadLock
 func showState(wg *group.Group) error {
 	loggo.Info("showState start ")
 	begin := time.Now()
+	last := loadState(&gState)
 	for !wg.IsExit() {
 		dur := time.Now().Sub(begin)
 		if dur > time.Minute {
 			begin = time.Now()
 
-			dur := int32(dur / time.Second)
+			dur := int64(dur / time.Second)
 
-			if gStateThreadNum.RecvThread > 0 {
-				gState.RecvFps = gState.RecvFrames / gStateThreadNum.RecvThread / dur
-			} else {
-				gState.RecvFps = 0
-			}
-			if gStateThreadNum.SendThread > 0 {
-				gState.SendFps = gState.SendFrames / gStateThreadNum.SendThread / dur
-			} else {
-				gState.SendFps = 0
-			}
-			if gStateThreadNum.RecvSonnyThread > 0 {
-				gState.RecvSonnyFps = gState.RecvSonnyFrames / gStateThreadNum.RecvSonnyThread / dur
-			} else {
-				gState.RecvSonnyFps = 0
-			}
-			if gStateThreadNum.SendSonnyThread > 0 {
-				gState.SendSonnyFps = gState.SendSonnyFrames / gStateThreadNum.SendSonnyThread / dur
-			} else {
-				gState.SendSonnyFps = 0
-			}
-			if gStateThreadNum.CopyThread > 0 {
-				gState.CopyFps = gState.CopyFrames / gStateThreadNum.CopyThread / dur
-			} else {
-				gState.CopyFps = 0
-			}
-			if gStateThreadNum.CheckThread > 0 {
-				gState.CheckFps = gState.CheckFrames / gStateThreadNum.CheckThread / dur
-			} else {
-				gState.CheckFps = 0
-			}
+			cur := loadState(&gState)
+			delta := diffState(&cur, &last)
+			last = cur
 
-			loggo.Info("showState\n%s\n%s", common.StructToTable(&gStateThreadNum), common.StructToTable(&gState))
+			delta.RecvFps = fps(delta.RecvFrames, &gStateThreadNum.RecvThread, dur)
+			delta.SendFps = fps(delta.SendFrames, &gStateThreadNum.SendThread, dur)
+			delta.RecvSonnyFps = fps(delta.RecvSonnyFrames, &gStateThreadNum.RecvSonnyThread, dur)
+			delta.SendSonnyFps = fps(delta.SendSonnyFrames, &gStateThreadNum.SendSonnyThread, dur)
+			delta.CopyFps = fps(delta.CopyFrames, &gStateThreadNum.CopyThread, dur)
+			delta.CheckFps = fps(delta.CheckFrames, &gStateThreadNum.CheckThread, dur)
 
-			gState = State{}
+			atomic.StoreInt32(&gState.RecvFps, delta.RecvFps)
+			atomic.StoreInt32(&gState.SendFps, delta.SendFps)
+			atomic.StoreInt32(&gState.RecvSonnyFps, delta.RecvSonnyFps)
+			atomic.StoreInt32(&gState.SendSonnyFps, delta.SendSonnyFps)
+			atomic.StoreInt32(&gState.CopyFps, delta.CopyFps)
+			atomic.StoreInt32(&gState.CheckFps, delta.CheckFps)
+
+			loggo.Info("showState\n%s\n%s", common.StructToTable(&gStateThreadNum), common.StructToTable(&delta))
 		}
 		time.Sleep(time.Second)
 	}
@@ -756,6 +938,15 @@ func showState(wg *group.Group) error {
 	return nil
 }
 
+// fps the frames per thread per second
+func fps(frames int64, thread *int32, dur int64) int32 {
+	n := int64(atomic.LoadInt32(thread))
+	if n <= 0 || dur <= 0 {
+		return 0
+	}
+	return int32(frames / n / dur)
+}
+
 func checkDeadLock(wg *group.Group) error {
 	loggo.Info("checkDeadLock start ")
 	begin := time.Now()
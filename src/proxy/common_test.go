@@ -1,28 +1,287 @@
 package proxy
 
 import (
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/esrrhs/go-engine/src/conn"
+	"github.com/esrrhs/go-engine/src/group"
+	"io"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test0001(t *testing.T) {
+	shared := []byte("12345678901234567890123456789012")
+	transcript := []byte("transcript")
+	c1, err := newCrypt(ENCRYPT_AES_256_GCM, shared, transcript, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := newCrypt(ENCRYPT_AES_256_GCM, shared, transcript, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	src := "aaabsfasasdfasfas3rdsfasfdhsafdshsafafafafaffasfsafa1111111111111111111111111111111111111111111111111111111111"
 	f := &ProxyFrame{}
 	f.Type = FRAME_TYPE_DATA
 	f.DataFrame = &DataFrame{}
 	f.DataFrame.Data = []byte(src)
 	fmt.Println(len(f.DataFrame.Data))
-	b, err := MarshalSrpFrame(f, 10, "123123")
+	b, err := MarshalSrpFrame(f, 10, c1)
 	if err != nil {
 		t.Error(err)
 	}
 	fmt.Println(len(f.DataFrame.Data))
-	ff, err := UnmarshalSrpFrame(b, "123123")
+	ff, err := UnmarshalSrpFrame(b, c2)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	if string(ff.DataFrame.Data) != src {
 		t.Error(err)
 	}
 	fmt.Println(string(ff.DataFrame.Data))
+
+	_, err = UnmarshalSrpFrame(b, c2)
+	fmt.Println("replay ", err)
+	if err == nil {
+		t.Error("replay frame should fail")
+	}
+
+	c3, err := newCrypt(ENCRYPT_AES_256_GCM, shared, []byte("other transcript"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = UnmarshalSrpFrame(b, c3)
+	fmt.Println("other handshake ", err)
+	if err == nil {
+		t.Error("frame of other handshake should fail")
+	}
+
+	if hmac.Equal(c1.keyProof("123456", "client"), c2.keyProof("654321", "client")) ||
+		!hmac.Equal(c1.keyProof("123456", "client"), c2.keyProof("123456", "client")) ||
+		hmac.Equal(c1.keyProof("123456", "client"), c2.keyProof("123456", "server")) {
+		t.Error("keyProof diff")
+	}
+
+	_, err = newCrypt("", shared, transcript, true)
+	if err == nil {
+		t.Error("empty encrypt should fail")
+	}
+}
+
+func startTestTarget(t *testing.T, addr string) conn.Conn {
+	c, _ := conn.NewConn("tcp")
+	l, err := c.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			cc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer cc.Close()
+				buf := make([]byte, 1)
+				_, err := io.ReadFull(cc, buf)
+				if err != nil {
+					return
+				}
+				if buf[0] == 's' {
+					// slow receiver, never read again
+					time.Sleep(time.Second * 30)
+					return
+				}
+				io.Copy(cc, cc)
+			}()
+		}
+	}()
+	return l
+}
+
+func Test0002(t *testing.T) {
+	target := startTestTarget(t, "127.0.0.1:58012")
+	defer target.Close()
+
+	config := DefaultConfig()
+	config.MainWriteChannelTimeoutMs = 100000
+
+	server, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58010"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewClient(config, "tcp", "127.0.0.1:58010", "test", "PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58011"}, []string{"127.0.0.1:58012"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	time.Sleep(time.Second)
+
+	c, _ := conn.NewConn("tcp")
+	slow, err := c.Dial("127.0.0.1:58011")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	go func() {
+		slow.Write([]byte("s"))
+		buf := make([]byte, 64*1024)
+		for {
+			_, err := slow.Write(buf)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(time.Second * 2)
+
+	fast, err := c.Dial("127.0.0.1:58011")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fast.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := fast.Write([]byte("fhello"))
+		if err != nil {
+			done <- err
+			return
+		}
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(fast, buf)
+		if err == nil && string(buf) != "hello" {
+			err = errors.New("echo diff " + string(buf))
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		fmt.Println("fast conn done", err, gState.SendWinWaitNum)
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("fast conn blocked by slow conn")
+	}
+}
+
+func Test0003(t *testing.T) {
+	config := DefaultConfig()
+	server, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58020"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	c, _ := conn.NewConn("tcp")
+	wg := group.NewGroup("Test0003", nil, nil)
+
+	cc, err := c.Dial("127.0.0.1:58020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongconfig := DefaultConfig()
+	wrongconfig.Key = "654321"
+	wrongcrypt, err := clientHandshake(wg, cc, wrongconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server answers a wrong key without its keyproof
+	f := &ProxyFrame{}
+	f.Type = FRAME_TYPE_LOGIN
+	f.LoginFrame = &LoginFrame{}
+	f.LoginFrame.Keyproof = wrongcrypt.keyProof(wrongconfig.Key, "client")
+	mb, _ := MarshalSrpFrame(f, 0, wrongcrypt)
+	bs := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bs, uint32(len(mb)))
+	cc.Write(append(bs, mb...))
+	_, err = io.ReadFull(cc, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb = make([]byte, binary.LittleEndian.Uint32(bs))
+	_, err = io.ReadFull(cc, mb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf, err := UnmarshalSrpFrame(mb, wrongcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("wrong key ", rf.String())
+	if rf.Type != FRAME_TYPE_LOGINRSP || rf.LoginRspFrame.Ret || rf.LoginRspFrame.Msg != "auth failed" || len(rf.LoginRspFrame.Keyproof) != 0 {
+		t.Error("login with wrong key should fail", rf.String())
+	}
+	cc.Close()
+
+	cc, err = c.Dial("127.0.0.1:58020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crypt, err := clientHandshake(wg, cc, config)
+	if err != nil || crypt == nil {
+		t.Fatal("handshake fail", err)
+	}
+	cc.Close()
+
+	// old client send login directly
+	cc, err = c.Dial("127.0.0.1:58020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	f = &ProxyFrame{}
+	f.Type = FRAME_TYPE_LOGIN
+	f.LoginFrame = &LoginFrame{}
+	f.LoginFrame.Key = config.Key
+	err = writeHandshakeFrame(cc, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf, err = readHandshakeFrame(cc, config.MaxMsgSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("old client ", rf.String())
+	if rf.Type != FRAME_TYPE_LOGINRSP || rf.LoginRspFrame.Ret || !strings.Contains(rf.LoginRspFrame.Msg, "version") {
+		t.Error("old client should get version error")
+	}
+}
+
+func Test0004(t *testing.T) {
+	wg := group.NewGroup("Test0004", nil, nil)
+
+	// the remote advertised 2 frames
+	proxyconn := &ProxyConn{id: "test"}
+	initSendWindow(proxyconn, 2)
+	for i := 0; i < 2; i++ {
+		if !waitSendWindow(wg, proxyconn) {
+			t.Fatal("wait window fail")
+		}
+	}
+	done := make(chan bool)
+	go func() {
+		done <- waitSendWindow(wg, proxyconn)
+	}()
+	select {
+	case <-done:
+		t.Error("window should be used up")
+	case <-time.After(time.Millisecond * 200):
+	}
+	addSendWindow(proxyconn, 1)
+	if !<-done {
+		t.Error("window update fail")
+	}
 }
@@ -0,0 +1,343 @@
+package proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/esrrhs/go-engine/src/common"
+	"github.com/esrrhs/go-engine/src/conn"
+	"github.com/esrrhs/go-engine/src/group"
+	"github.com/esrrhs/go-engine/src/loggo"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 1: rc4 and plaintext key, 2: x25519 handshake and aead.
+	// 2 is not compatible with 1, the old clients get a "version too old" login rsp, so upgrade the server and the clients together
+	PROXY_VERSION     = 2
+	PROXY_MIN_VERSION = 2
+
+	ENCRYPT_AES_256_GCM = "aes-256-gcm"
+)
+
+const handshake_nonce_size = 32
+
+// Crypt seal and open the frames of one main conn, every direction has its own key derived from the x25519 shared and the key,
+// the nonce is the frame sequence, so a replayed or reordered frame can not be opened
+type Crypt struct {
+	send       cipher.AEAD
+	recv       cipher.AEAD
+	sendseq    uint64
+	recvseq    uint64
+	transcript []byte
+	user       string
+}
+
+func checkEncrypt(encrypt string) error {
+	if encrypt != ENCRYPT_AES_256_GCM {
+		return errors.New("encrypt " + encrypt + " not support, use " + ENCRYPT_AES_256_GCM)
+	}
+	return nil
+}
+
+// newCrypt derive the keys only from the x25519 shared, so the server can open the login of a wrong key and answer it,
+// the key is proved by the keyproof of the login and the login rsp
+func newCrypt(encrypt string, shared []byte, transcript []byte, isclient bool) (*Crypt, error) {
+	err := checkEncrypt(encrypt)
+	if err != nil {
+		return nil, err
+	}
+	c := &Crypt{transcript: transcript}
+
+	c2s, err := newAEAD(shared, transcript, "go-engine proxy client to server")
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := newAEAD(shared, transcript, "go-engine proxy server to client")
+	if err != nil {
+		return nil, err
+	}
+	if isclient {
+		c.send, c.recv = c2s, s2c
+	} else {
+		c.send, c.recv = s2c, c2s
+	}
+	return c, nil
+}
+
+func newAEAD(shared []byte, salt []byte, info string) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, shared, salt, info, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seqNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+func (c *Crypt) Seal(b []byte) []byte {
+	if c == nil {
+		return b
+	}
+	ret := c.send.Seal(nil, seqNonce(c.send, c.sendseq), b, nil)
+	c.sendseq++
+	return ret
+}
+
+func (c *Crypt) Open(b []byte) ([]byte, error) {
+	if c == nil {
+		return b, nil
+	}
+	ret, err := c.recv.Open(nil, seqNonce(c.recv, c.recvseq), b, nil)
+	if err != nil {
+		return nil, errors.New("open frame fail " + strconv.FormatUint(c.recvseq, 10))
+	}
+	c.recvseq++
+	return ret, nil
+}
+
+// keyProof prove we have the key without sending it, the proof is bound to this handshake.
+// the client sends it in the login, the server sends its own in the login rsp only after the client's is checked
+func (c *Crypt) keyProof(key string, role string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(role))
+	mac.Write(c.transcript)
+	return mac.Sum(nil)
+}
+
+func handshakeTranscript(clientpub []byte, clientnonce []byte, serverpub []byte, servernonce []byte) []byte {
+	var ret []byte
+	ret = append(ret, clientpub...)
+	ret = append(ret, clientnonce...)
+	ret = append(ret, serverpub...)
+	ret = append(ret, servernonce...)
+	return ret
+}
+
+func randNonce() ([]byte, error) {
+	nonce := make([]byte, handshake_nonce_size)
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+func writeHandshakeFrame(c conn.Conn, f *ProxyFrame) error {
+	mb, err := proto.Marshal(f)
+	if err != nil {
+		return err
+	}
+	bs := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bs, uint32(len(mb)))
+	_, err = c.Write(append(bs, mb...))
+	return err
+}
+
+func readHandshakeFrame(c conn.Conn, maxmsgsize int) (*ProxyFrame, error) {
+	bs := make([]byte, 4)
+	_, err := io.ReadFull(c, bs)
+	if err != nil {
+		return nil, err
+	}
+	msglen := binary.LittleEndian.Uint32(bs)
+	if msglen > uint32(maxmsgsize)+MAX_PROTO_PACK_SIZE || msglen <= 0 {
+		return nil, errors.New("msg len fail " + strconv.Itoa(int(msglen)))
+	}
+	ds := make([]byte, msglen)
+	_, err = io.ReadFull(c, ds)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalSrpFrame(ds, nil)
+}
+
+// runHandshake run f with the established timeout, c is closed if it fail
+func runHandshake(fwg *group.Group, c conn.Conn, timeout int, f func() error) error {
+	wg := group.NewGroup("handshake"+" "+c.Info(), fwg, func() {
+		c.Close()
+	})
+
+	done := make(chan int)
+	wg.Go("handshake"+" "+c.Info(), func() error {
+		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+		defer close(done)
+		return f()
+	})
+
+	wg.Go("handshake timeout"+" "+c.Info(), func() error {
+		select {
+		case <-done:
+			return nil
+		case <-wg.Done():
+			return nil
+		case <-time.After(time.Second * time.Duration(timeout)):
+			return errors.New("handshake timeout")
+		}
+	})
+
+	return wg.Wait()
+}
+
+func clientHandshake(fwg *group.Group, c conn.Conn, config *Config) (*Crypt, error) {
+	var crypt *Crypt
+	err := runHandshake(fwg, c, config.load().EstablishedTimeout, func() error {
+		priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		nonce, err := randNonce()
+		if err != nil {
+			return err
+		}
+
+		f := &ProxyFrame{}
+		f.Type = FRAME_TYPE_HANDSHAKE
+		f.HandshakeFrame = &HandshakeFrame{}
+		f.HandshakeFrame.Version = PROXY_VERSION
+		f.HandshakeFrame.Encrypt = config.Encrypt
+		f.HandshakeFrame.Pubkey = priv.PublicKey().Bytes()
+		f.HandshakeFrame.Nonce = nonce
+		f.HandshakeFrame.User = config.User
+		err = writeHandshakeFrame(c, f)
+		if err != nil {
+			return err
+		}
+
+		rf, err := readHandshakeFrame(c, config.MaxMsgSize)
+		if err != nil {
+			return errors.New("read handshake rsp fail, server may be too old: " + err.Error())
+		}
+		if rf.Type != FRAME_TYPE_HANDSHAKERSP {
+			return errors.New("handshake rsp type error " + rf.Type.String())
+		}
+		rsp := rf.HandshakeRspFrame
+		if !rsp.Ret {
+			return errors.New("handshake fail: " + rsp.Msg)
+		}
+		if rsp.Version < PROXY_MIN_VERSION || rsp.Version > PROXY_VERSION {
+			return errors.New("handshake version error " + strconv.Itoa(int(rsp.Version)))
+		}
+
+		pub, err := ecdh.X25519().NewPublicKey(rsp.Pubkey)
+		if err != nil {
+			return err
+		}
+		shared, err := priv.ECDH(pub)
+		if err != nil {
+			return err
+		}
+
+		transcript := handshakeTranscript(f.HandshakeFrame.Pubkey, nonce, rsp.Pubkey, rsp.Nonce)
+		crypt, err = newCrypt(config.Encrypt, shared, transcript, true)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return crypt, nil
+}
+
+func serverHandshake(fwg *group.Group, c conn.Conn, config *Config) (*Crypt, error) {
+	var crypt *Crypt
+	err := runHandshake(fwg, c, config.load().EstablishedTimeout, func() error {
+		f, err := readHandshakeFrame(c, config.MaxMsgSize)
+		if err != nil {
+			return err
+		}
+
+		if f.Type == FRAME_TYPE_LOGIN {
+			// old client login directly, tell it to upgrade
+			rf := &ProxyFrame{}
+			rf.Type = FRAME_TYPE_LOGINRSP
+			rf.LoginRspFrame = &LoginRspFrame{}
+			rf.LoginRspFrame.Ret = false
+			rf.LoginRspFrame.Msg = "version too old, need version " + strconv.Itoa(PROXY_MIN_VERSION) + ", please upgrade client"
+			writeHandshakeFrame(c, rf)
+			return errors.New("old client login")
+		}
+		if f.Type != FRAME_TYPE_HANDSHAKE {
+			return errors.New("handshake type error " + f.Type.String())
+		}
+
+		rf := &ProxyFrame{}
+		rf.Type = FRAME_TYPE_HANDSHAKERSP
+		rf.HandshakeRspFrame = &HandshakeRspFrame{}
+		rsp := rf.HandshakeRspFrame
+
+		version := common.MinOfInt(int(f.HandshakeFrame.Version), PROXY_VERSION)
+		if version < PROXY_MIN_VERSION {
+			rsp.Msg = "version " + strconv.Itoa(int(f.HandshakeFrame.Version)) + " not support, need version " + strconv.Itoa(PROXY_MIN_VERSION)
+			writeHandshakeFrame(c, rf)
+			return errors.New(rsp.Msg)
+		}
+		if f.HandshakeFrame.Encrypt != config.Encrypt {
+			rsp.Msg = "encrypt " + f.HandshakeFrame.Encrypt + " not match server " + config.Encrypt
+			writeHandshakeFrame(c, rf)
+			return errors.New(rsp.Msg)
+		}
+
+		pub, err := ecdh.X25519().NewPublicKey(f.HandshakeFrame.Pubkey)
+		if err != nil {
+			rsp.Msg = "pubkey error"
+			writeHandshakeFrame(c, rf)
+			return err
+		}
+		priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		shared, err := priv.ECDH(pub)
+		if err != nil {
+			rsp.Msg = "pubkey error"
+			writeHandshakeFrame(c, rf)
+			return err
+		}
+		nonce, err := randNonce()
+		if err != nil {
+			return err
+		}
+
+		rsp.Version = int32(version)
+		rsp.Pubkey = priv.PublicKey().Bytes()
+		rsp.Nonce = nonce
+
+		transcript := handshakeTranscript(f.HandshakeFrame.Pubkey, f.HandshakeFrame.Nonce, rsp.Pubkey, nonce)
+		crypt, err = newCrypt(config.Encrypt, shared, transcript, false)
+		if err != nil {
+			return err
+		}
+
+		rsp.Ret = true
+		rsp.Msg = "ok"
+		crypt.user = f.HandshakeFrame.User
+		return writeHandshakeFrame(c, rf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	loggo.Info("serverHandshake ok %s", c.Info())
+	return crypt, nil
+}
@@ -87,6 +87,38 @@ func NewSocks5Inputer(wg *group.Group, proto string, addr string, clienttype CLI
 	return input, nil
 }
 
+func NewHttpInputer(wg *group.Group, proto string, addr string, clienttype CLIENT_TYPE, config *Config, father *ProxyConn) (*Inputer, error) {
+	conn, err := conn.NewConn(proto)
+	if conn == nil {
+		return nil, err
+	}
+
+	listenconn, err := conn.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &Inputer{
+		clienttype: clienttype,
+		config:     config,
+		proto:      proto,
+		addr:       addr,
+		father:     father,
+		fwg:        wg,
+		listenconn: listenconn,
+	}
+
+	wg.Go("Inputer listenHttp"+" "+addr, func() error {
+		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+		return input.listenHttp()
+	})
+
+	loggo.Info("NewInputer ok %s", addr)
+
+	return input, nil
+}
+
 func (i *Inputer) Close() {
 	i.listenconn.Close()
 }
@@ -99,8 +131,14 @@ func (i *Inputer) processDataFrame(f *ProxyFrame) {
 		return
 	}
 	sonny := v.(*ProxyConn)
-	if !sonny.sendch.WriteTimeout(f, i.config.MainWriteChannelTimeoutMs) {
-		sonny.needclose = true
+	if f.DataFrame.Window > 0 {
+		addSendWindow(sonny, f.DataFrame.Window)
+		loggo.Debug("Inputer processDataFrame window %s %d", f.DataFrame.Id, f.DataFrame.Window)
+		return
+	}
+	atomic.AddInt64(&sonny.father.recvsize, int64(len(f.DataFrame.Data)))
+	if !sonny.sendch.WriteTimeout(f, i.config.load().MainWriteChannelTimeoutMs) {
+		atomic.StoreInt32(&sonny.needclose, 1)
 		loggo.Error("Inputer processDataFrame timeout sonnny %s %d", f.DataFrame.Id, len(f.DataFrame.Data))
 	}
 	sonny.actived++
@@ -128,10 +166,29 @@ func (i *Inputer) processOpenRspFrame(f *ProxyFrame) {
 	}
 	sonny := v.(*ProxyConn)
 	if f.OpenRspFrame.Ret {
-		sonny.established = true
+		if sonny.bind {
+			// socks5 bind reply twice, the listen addr, then the addr of the peer
+			err := network.Sock5Reply(sonny.conn, network.Socks5RepSuccess, f.OpenRspFrame.Bindaddr)
+			if err != nil {
+				atomic.StoreInt32(&sonny.needclose, 1)
+				loggo.Error("Inputer processOpenRspFrame Sock5Reply fail %s %s %s", id, sonny.conn.Info(), err)
+				return
+			}
+			if f.OpenRspFrame.Binding {
+				loggo.Info("Inputer processOpenRspFrame binding %s %s %s", id, sonny.conn.Info(), f.OpenRspFrame.Bindaddr)
+				return
+			}
+		}
+		if !sonny.isEstablished() {
+			addSendWindow(sonny, f.OpenRspFrame.Window)
+		}
+		atomic.StoreInt32(&sonny.established, 1)
 		loggo.Info("Inputer processOpenRspFrame ok %s %s", id, sonny.conn.Info())
 	} else {
-		sonny.needclose = true
+		if sonny.bind {
+			network.Sock5Reply(sonny.conn, network.Socks5RepFailure, "0.0.0.0:0")
+		}
+		atomic.StoreInt32(&sonny.needclose, 1)
 		loggo.Info("Inputer processOpenRspFrame fail %s %s", id, sonny.conn.Info())
 	}
 }
@@ -148,7 +205,7 @@ func (i *Inputer) listen(targetAddr string) error {
 		}
 
 		size := i.sonnySize()
-		if size >= i.config.MaxSonny {
+		if size >= i.config.load().MaxSonny {
 			loggo.Info("Inputer listen max sonny %s %d", conn.Info(), size)
 			conn.Close()
 			continue
@@ -177,7 +234,7 @@ func (i *Inputer) listenSocks5() error {
 		}
 
 		size := i.sonnySize()
-		if size >= i.config.MaxSonny {
+		if size >= i.config.load().MaxSonny {
 			loggo.Info("Inputer listen max sonny %s %d", conn.Info(), size)
 			conn.Close()
 			continue
@@ -194,6 +251,35 @@ func (i *Inputer) listenSocks5() error {
 	return nil
 }
 
+func (i *Inputer) listenHttp() error {
+
+	loggo.Info("Inputer start listenHttp %s", i.addr)
+
+	for !i.fwg.IsExit() {
+		conn, err := i.listenconn.Accept()
+		if err != nil {
+			loggo.Info("Inputer listen Accept fail %s", err)
+			continue
+		}
+
+		size := i.sonnySize()
+		if size >= i.config.load().MaxSonny {
+			loggo.Info("Inputer listen max sonny %s %d", conn.Info(), size)
+			conn.Close()
+			continue
+		}
+
+		proxyconn := &ProxyConn{conn: conn}
+		i.fwg.Go("Inputer processHttpConn"+" "+conn.Info(), func() error {
+			atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+			defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+			return i.processHttpConn(proxyconn)
+		})
+	}
+	loggo.Info("Inputer end listenHttp %s", i.addr)
+	return nil
+}
+
 func (i *Inputer) processSocks5Conn(proxyConn *ProxyConn) error {
 
 	loggo.Info("processSocks5Conn start %s", proxyConn.conn.Info())
@@ -205,6 +291,7 @@ func (i *Inputer) processSocks5Conn(proxyConn *ProxyConn) error {
 	})
 
 	targetAddr := ""
+	var cmd byte
 	wg.Go("Inputer socks5"+" "+proxyConn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
@@ -215,15 +302,30 @@ func (i *Inputer) processSocks5Conn(proxyConn *ProxyConn) error {
 		}
 
 		var err error = nil
-		if err = network.Sock5HandshakeBy(proxyConn.conn, i.config.Username, i.config.Password); err != nil {
+		config := i.config.load()
+		if err = network.Sock5HandshakeBy(proxyConn.conn, config.Username, config.Password); err != nil {
 			loggo.Error("processSocks5Conn Sock5HandshakeBy %s %s", proxyConn.conn.Info(), err)
 			return err
 		}
-		_, addr, err := network.Sock5GetRequest(proxyConn.conn)
+		cmd, _, targetAddr, err = network.Sock5GetRequestCmd(proxyConn.conn)
 		if err != nil {
 			loggo.Error("processSocks5Conn Sock5GetRequest %s %s", proxyConn.conn.Info(), err)
 			return err
 		}
+		if cmd == network.Socks5CmdBind && i.father.bonding {
+			network.Sock5Reply(proxyConn.conn, network.Socks5RepCmdNotSupported, "0.0.0.0:0")
+			loggo.Error("processSocks5Conn bind not support in bond %s", proxyConn.conn.Info())
+			return errors.New("socks5 bind not support in bond")
+		}
+		if cmd == network.Socks5CmdUdpAssociate || cmd == network.Socks5CmdBind {
+			// reply after the relay port or the remote port is opened
+			return nil
+		}
+		if cmd != network.Socks5CmdConnect {
+			network.Sock5Reply(proxyConn.conn, network.Socks5RepCmdNotSupported, "0.0.0.0:0")
+			loggo.Error("processSocks5Conn cmd not support %s %d", proxyConn.conn.Info(), cmd)
+			return errors.New("socks5 cmd not support")
+		}
 		// Sending connection established message immediately to client.
 		// This some round trip time for creating socks connection with the client.
 		// But if connection failed, the client will get connection reset error.
@@ -232,8 +334,65 @@ func (i *Inputer) processSocks5Conn(proxyConn *ProxyConn) error {
 			loggo.Error("processSocks5Conn Write %s %s", proxyConn.conn.Info(), err)
 			return err
 		}
+		return nil
+	})
+
+	err := wg.Wait()
+	if err != nil {
+		return nil
+	}
+
+	if cmd == network.Socks5CmdUdpAssociate {
+		loggo.Info("processSocks5Conn udp associate %s", proxyConn.conn.Info())
+		i.fwg.Go("Inputer processSocks5Udp"+" "+proxyConn.conn.Info(), func() error {
+			atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+			defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+			return i.processSocks5Udp(proxyConn)
+		})
+		return nil
+	}
+
+	proxyConn.bind = cmd == network.Socks5CmdBind
+
+	loggo.Info("processSocks5Conn ok %s %s %d", proxyConn.conn.Info(), targetAddr, cmd)
+
+	i.fwg.Go("Inputer processProxyConn"+" "+proxyConn.conn.Info(), func() error {
+		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+		return i.processProxyConn(proxyConn, targetAddr)
+	})
+
+	return nil
+}
+
+func (i *Inputer) processHttpConn(proxyConn *ProxyConn) error {
+
+	loggo.Info("processHttpConn start %s", proxyConn.conn.Info())
+
+	wg := group.NewGroup("Inputer processHttpConn"+" "+proxyConn.conn.Info(), i.fwg, func() {
+		loggo.Info("group start exit %s", proxyConn.conn.Info())
+		proxyConn.conn.Close()
+		loggo.Info("group end exit %s", proxyConn.conn.Info())
+	})
+
+	targetAddr := ""
+	var data []byte
+	wg.Go("Inputer http"+" "+proxyConn.conn.Info(), func() error {
+		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+
+		if proxyConn.conn.Name() != "tcp" {
+			loggo.Error("processHttpConn no tcp %s %s", proxyConn.conn.Info(), proxyConn.conn.Name())
+			return errors.New("http not tcp")
+		}
 
-		targetAddr = addr
+		var err error = nil
+		config := i.config.load()
+		targetAddr, data, err = network.HttpProxyGetRequest(proxyConn.conn, config.Username, config.Password)
+		if err != nil {
+			loggo.Error("processHttpConn HttpProxyGetRequest %s %s", proxyConn.conn.Info(), err)
+			return err
+		}
 		return nil
 	})
 
@@ -242,7 +401,11 @@ func (i *Inputer) processSocks5Conn(proxyConn *ProxyConn) error {
 		return nil
 	}
 
-	loggo.Info("processSocks5Conn ok %s %s", proxyConn.conn.Info(), targetAddr)
+	if len(data) > 0 {
+		proxyConn.conn = &prefixConn{Conn: proxyConn.conn, prefix: data}
+	}
+
+	loggo.Info("processHttpConn ok %s %s", proxyConn.conn.Info(), targetAddr)
 
 	i.fwg.Go("Inputer processProxyConn"+" "+proxyConn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
@@ -259,6 +422,27 @@ func (i *Inputer) processProxyConn(proxyConn *ProxyConn, targetAddr string) erro
 
 	loggo.Info("Inputer processProxyConn start %s %s %s", proxyConn.id, proxyConn.conn.Info(), targetAddr)
 
+	father := i.father
+	if i.father.pick != nil {
+		father = i.father.pick()
+		if father == nil {
+			loggo.Error("Inputer processProxyConn no father %s %s", proxyConn.id, targetAddr)
+			proxyConn.conn.Close()
+			return nil
+		}
+	}
+	proxyConn.father = father
+
+	if father.checkOpen != nil {
+		release, err := father.checkOpen(targetAddr)
+		if err != nil {
+			loggo.Error("Inputer processProxyConn checkOpen fail %s %s %s", proxyConn.id, targetAddr, err)
+			proxyConn.conn.Close()
+			return nil
+		}
+		defer release()
+	}
+
 	_, loaded := i.sonny.LoadOrStore(proxyConn.id, proxyConn)
 	if loaded {
 		loggo.Error("Inputer processProxyConn LoadOrStore fail %s", proxyConn.id)
@@ -266,11 +450,17 @@ func (i *Inputer) processProxyConn(proxyConn *ProxyConn, targetAddr string) erro
 		return nil
 	}
 
-	sendch := common.NewChannel(i.config.ConnBuffer)
+	gMetricInputSonny.Inc()
+	defer gMetricInputSonny.Dec()
+
+	// remote never sends more than ConnBuffer data frames, one more for the close frame
+	sendch := common.NewChannel(i.config.ConnBuffer + 1)
 	recvch := common.NewChannel(i.config.ConnBuffer)
 
 	proxyConn.sendch = sendch
 	proxyConn.recvch = recvch
+	// the window of the remote come with the open rsp
+	initSendWindow(proxyConn, 0)
 
 	wg := group.NewGroup("Inputer processProxyConn"+" "+proxyConn.conn.Info(), i.fwg, func() {
 		loggo.Info("group start exit %s", proxyConn.conn.Info())
@@ -291,13 +481,18 @@ func (i *Inputer) processProxyConn(proxyConn *ProxyConn, targetAddr string) erro
 	wg.Go("Inputer sendToSonny"+" "+proxyConn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return sendToSonny(wg, sendch, proxyConn.conn, i.config.MaxMsgSize)
+		return sendToSonny(wg, sendch, proxyConn, father, i.config.MaxMsgSize, i.config.ConnBuffer)
 	})
 
 	wg.Go("Inputer checkSonnyActive"+" "+proxyConn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return checkSonnyActive(wg, proxyConn, i.config.EstablishedTimeout, i.config.ConnTimeout)
+		estimeout := i.config.load().EstablishedTimeout
+		if proxyConn.bind {
+			// wait the peer to connect
+			estimeout = i.config.load().ConnTimeout
+		}
+		return checkSonnyActive(wg, proxyConn, estimeout, i.config.load().ConnTimeout)
 	})
 
 	wg.Go("Inputer checkNeedClose"+" "+proxyConn.conn.Info(), func() error {
@@ -309,13 +504,13 @@ func (i *Inputer) processProxyConn(proxyConn *ProxyConn, targetAddr string) erro
 	wg.Go("Inputer copySonnyRecv"+" "+proxyConn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return copySonnyRecv(wg, recvch, proxyConn, i.father)
+		return copySonnyRecv(wg, recvch, proxyConn, father)
 	})
 
 	wg.Wait()
 	i.sonny.Delete(proxyConn.id)
 
-	closeRemoteConn(proxyConn, i.father)
+	closeRemoteConn(proxyConn, father)
 
 	loggo.Info("Inputer processProxyConn end %s %s %s", proxyConn.id, proxyConn.conn.Info(), targetAddr)
 
@@ -328,11 +523,53 @@ func (i *Inputer) openConn(proxyConn *ProxyConn, targetAddr string) {
 	f.OpenFrame = &OpenConnFrame{}
 	f.OpenFrame.Id = proxyConn.id
 	f.OpenFrame.Toaddr = targetAddr
+	f.OpenFrame.Proto = proxyConn.conn.Name()
+	f.OpenFrame.Bind = proxyConn.bind
+	f.OpenFrame.Window = int32(i.config.ConnBuffer)
 
-	i.father.sendch.Write(f)
+	proxyConn.father.sendch.Write(f)
 	loggo.Info("Inputer openConn %s %s", proxyConn.id, targetAddr)
 }
 
+// prefixConn read the prefix first, it is the data already read from conn
+type prefixConn struct {
+	conn.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(p []byte) (n int, err error) {
+	if len(c.prefix) > 0 {
+		n = copy(p, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// closeFather close the sonny conns in the father, the father is closed
+func (i *Inputer) closeFather(father *ProxyConn) {
+	i.sonny.Range(func(key, value interface{}) bool {
+		sonny := value.(*ProxyConn)
+		if sonny.father == father {
+			atomic.StoreInt32(&sonny.needclose, 1)
+		}
+		return true
+	})
+}
+
+// fatherSonnySize the num of the sonny conns in the father
+func (i *Inputer) fatherSonnySize(father *ProxyConn) int {
+	size := 0
+	i.sonny.Range(func(key, value interface{}) bool {
+		sonny := value.(*ProxyConn)
+		if sonny.father == father {
+			size++
+		}
+		return true
+	})
+	return size
+}
+
 func (i *Inputer) sonnySize() int {
 	size := 0
 	i.sonny.Range(func(key, value interface{}) bool {
@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/esrrhs/go-engine/src/network"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test0001Socks5Bind(t *testing.T) {
+	config := DefaultConfig()
+	server, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58050"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewClient(config, "tcp", "127.0.0.1:58050", "test", "SOCKS5",
+		[]string{"tcp"}, []string{"127.0.0.1:58051"}, []string{""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	time.Sleep(time.Second)
+
+	ctrl, err := net.Dial("tcp", "127.0.0.1:58051")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctrl.Close()
+	err = network.Sock5Handshake(ctrl.(*net.TCPConn), 1000, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ctrl.Write([]byte{0x05, network.Socks5CmdBind, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp := make([]byte, 10)
+	_, err = io.ReadFull(ctrl, rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp[1] != network.Socks5RepSuccess {
+		t.Fatal("bind fail", rsp[1])
+	}
+	bindaddr, _, err := network.Sock5ParseAddr(rsp[3:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("bind ", bindaddr)
+
+	peer, err := net.Dial("tcp", bindaddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	_, err = io.ReadFull(ctrl, rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peeraddr, _, err := network.Sock5ParseAddr(rsp[3:])
+	fmt.Println("peer ", peeraddr, err)
+	if rsp[1] != network.Socks5RepSuccess || peeraddr != peer.LocalAddr().String() {
+		t.Error("bind second reply fail")
+	}
+
+	peer.Write([]byte("hello"))
+	ctrl.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(ctrl, buf)
+	if err != nil || string(buf) != "hello" {
+		t.Error("bind peer to client fail", err)
+	}
+
+	ctrl.Write([]byte("world"))
+	peer.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = io.ReadFull(peer, buf)
+	if err != nil || string(buf) != "world" {
+		t.Error("bind client to peer fail", err)
+	}
+}
+
+func Test0002Socks5Bind(t *testing.T) {
+	// the main conn is not tcp, the bind addr comes from the route to DST.ADDR
+	config := DefaultConfig()
+	server, err := NewServer(config, []string{"rudp"}, []string{"127.0.0.1:58052"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewClient(config, "rudp", "127.0.0.1:58052", "test", "SOCKS5",
+		[]string{"tcp"}, []string{"127.0.0.1:58053"}, []string{""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	time.Sleep(time.Second)
+
+	ctrl, err := net.Dial("tcp", "127.0.0.1:58053")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctrl.Close()
+	err = network.Sock5Handshake(ctrl.(*net.TCPConn), 1000, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ctrl.Write([]byte{0x05, network.Socks5CmdBind, 0x00, 0x01, 127, 0, 0, 2, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp := make([]byte, 10)
+	ctrl.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = io.ReadFull(ctrl, rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp[1] != network.Socks5RepSuccess {
+		t.Fatal("bind fail", rsp[1])
+	}
+	bindaddr, _, err := network.Sock5ParseAddr(rsp[3:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("bind ", bindaddr)
+	host, _, _ := net.SplitHostPort(bindaddr)
+	if net.ParseIP(host).IsUnspecified() {
+		t.Fatal("bind addr not routable", bindaddr)
+	}
+
+	// only DST.ADDR can connect
+	other, err := net.Dial("tcp", bindaddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = other.Read(make([]byte, 1))
+	fmt.Println("other peer ", err)
+	if err != io.EOF {
+		t.Error("other peer should be closed", err)
+	}
+
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+	peer, err := d.Dial("tcp", bindaddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	_, err = io.ReadFull(ctrl, rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peeraddr, _, err := network.Sock5ParseAddr(rsp[3:])
+	fmt.Println("peer ", peeraddr, err)
+	if rsp[1] != network.Socks5RepSuccess || peeraddr != peer.LocalAddr().String() {
+		t.Error("bind second reply fail")
+	}
+
+	peer.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(ctrl, buf)
+	if err != nil || string(buf) != "hello" {
+		t.Error("bind peer to client fail", err)
+	}
+}
+
+func Test0001HttpProxy(t *testing.T) {
+	target := startTestTarget(t, "127.0.0.1:58054")
+	defer target.Close()
+
+	web := &http.Server{Addr: "127.0.0.1:58055", Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.RequestURI, r.Header.Get("Proxy-Connection"))
+	})}
+	go web.ListenAndServe()
+	defer web.Close()
+
+	config := DefaultConfig()
+	config.Username = "u"
+	config.Password = "p"
+	server, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58052"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewClient(config, "tcp", "127.0.0.1:58052", "test", "HTTP_PROXY",
+		[]string{"tcp"}, []string{"127.0.0.1:58053"}, []string{""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	time.Sleep(time.Second)
+
+	// absolute-URI GET
+	proxyurl, _ := url.Parse("http://u:p@127.0.0.1:58053")
+	hc := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyurl)}, Timeout: time.Second * 5}
+	resp, err := hc.Get("http://127.0.0.1:58055/a?b=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	fmt.Println("http get ", string(body))
+	if string(body) != "GET /a?b=1 " {
+		t.Error("http get fail")
+	}
+
+	// no auth
+	proxyurl, _ = url.Parse("http://127.0.0.1:58053")
+	hc = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyurl)}, Timeout: time.Second * 5}
+	resp, err = hc.Get("http://127.0.0.1:58055/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Error("http auth fail", resp.StatusCode)
+	}
+
+	// CONNECT
+	c, err := net.Dial("tcp", "127.0.0.1:58053")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("CONNECT 127.0.0.1:58054 HTTP/1.1\r\nHost: 127.0.0.1:58054\r\nProxy-Authorization: Basic dTpw\r\n\r\n"))
+	br := bufio.NewReader(c)
+	resp, err = http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("http connect fail", resp.StatusCode)
+	}
+	c.Write([]byte("xhello"))
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(br, buf)
+	fmt.Println("http connect ", string(buf))
+	if err != nil || string(buf) != "hello" {
+		t.Error("http connect echo fail", err)
+	}
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"github.com/esrrhs/go-engine/src/metrics"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+var gMetricPingRtt = metrics.Default.Histogram("proxy_ping_rtt_seconds", "rtt of the main conn ping", nil)
+var gMetricServerConn = metrics.Default.Gauge("proxy_main_conn", "active main conns", "side", "server")
+var gMetricClientConn = metrics.Default.Gauge("proxy_main_conn", "active main conns", "side", "client")
+var gMetricInputSonny = metrics.Default.Gauge("proxy_sonny", "active sonny conns", "side", "inputer")
+var gMetricOutputSonny = metrics.Default.Gauge("proxy_sonny", "active sonny conns", "side", "outputer")
+
+func init() {
+	publishMetrics(metrics.Default)
+}
+
+func publishMetrics(r *metrics.Registry) {
+	st := reflect.TypeOf(gState)
+	for i := 0; i < st.NumField(); i++ {
+		name := st.Field(i).Name
+		index := i
+		if strings.HasSuffix(name, "Fps") {
+			r.GaugeFunc("proxy_"+snakeName(name), "frames per second in last minute", func() float64 {
+				return stateField(&gState, index)
+			})
+			continue
+		}
+		r.CounterFunc("proxy_"+snakeName(name)+"_total", "state "+name, func() float64 {
+			return stateField(&gState, index)
+		})
+	}
+
+	tt := reflect.TypeOf(gStateThreadNum)
+	for i := 0; i < tt.NumField(); i++ {
+		name := tt.Field(i).Name
+		index := i
+		r.GaugeFunc("proxy_"+snakeName(name), "running goroutines "+name, func() float64 {
+			return reflect.ValueOf(&gStateThreadNum).Elem().Field(index).Convert(reflect.TypeOf(float64(0))).Float()
+		})
+	}
+
+	r.GaugeFunc("proxy_deadlock_send_seconds", "how long the main conn is blocked in write", func() float64 {
+		if !gDeadLock.sending {
+			return 0
+		}
+		return time.Now().Sub(gDeadLock.sendTime).Seconds()
+	})
+	r.GaugeFunc("proxy_deadlock_recv_seconds", "how long the main conn is blocked in read", func() float64 {
+		if !gDeadLock.recving {
+			return 0
+		}
+		return time.Now().Sub(gDeadLock.recvTime).Seconds()
+	})
+}
+
+func stateField(s *State, index int) float64 {
+	return float64(stateInt(s, index))
+}
+
+func stateInt(s *State, index int) int64 {
+	f := reflect.ValueOf(s).Elem().Field(index)
+	switch f.Kind() {
+	case reflect.Int32:
+		return int64(atomic.LoadInt32(f.Addr().Interface().(*int32)))
+	case reflect.Int64:
+		return atomic.LoadInt64(f.Addr().Interface().(*int64))
+	}
+	return 0
+}
+
+// loadState copy s field by field atomically
+func loadState(s *State) State {
+	var ret State
+	v := reflect.ValueOf(&ret).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		v.Field(i).SetInt(stateInt(s, i))
+	}
+	return ret
+}
+
+// diffState the counters of cur minus last, the Fps are left zero
+func diffState(cur *State, last *State) State {
+	var ret State
+	v := reflect.ValueOf(&ret).Elem()
+	c := reflect.ValueOf(cur).Elem()
+	l := reflect.ValueOf(last).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if strings.HasSuffix(v.Type().Field(i).Name, "Fps") {
+			continue
+		}
+		v.Field(i).SetInt(c.Field(i).Int() - l.Field(i).Int())
+	}
+	return ret
+}
+
+// snakeName MainRecvSize -> main_recv_size
+func snakeName(name string) string {
+	var sb strings.Builder
+	for i, c := range name {
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				sb.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
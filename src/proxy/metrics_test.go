@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test0001Metrics(t *testing.T) {
+	s := State{RecvFrames: 100, RecvSize: 1 << 40, RecvFps: 7}
+	last := loadState(&s)
+	s.RecvFrames += 60
+	s.RecvSize += 10
+	cur := loadState(&s)
+
+	delta := diffState(&cur, &last)
+	fmt.Println(delta.RecvFrames, delta.RecvSize, delta.RecvFps)
+	if delta.RecvFrames != 60 || delta.RecvSize != 10 || delta.RecvFps != 0 {
+		t.Error("diffState fail", delta)
+	}
+	if cur.RecvSize != 1<<40+10 {
+		t.Error("loadState fail", cur.RecvSize)
+	}
+
+	var th int32 = 2
+	if fps(delta.RecvFrames, &th, 3) != 10 {
+		t.Error("fps fail", fps(delta.RecvFrames, &th, 3))
+	}
+	// loadState and diffState never write back, the counter keeps increasing
+	if stateField(&s, 0) != 160 {
+		t.Error("counter fail", stateField(&s, 0))
+	}
+}
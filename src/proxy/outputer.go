@@ -1,12 +1,15 @@
 package proxy
 
 import (
+	"errors"
 	"github.com/esrrhs/go-engine/src/common"
 	"github.com/esrrhs/go-engine/src/conn"
 	"github.com/esrrhs/go-engine/src/group"
 	"github.com/esrrhs/go-engine/src/loggo"
+	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Outputer struct {
@@ -52,8 +55,14 @@ func (o *Outputer) processDataFrame(f *ProxyFrame) {
 		return
 	}
 	sonny := v.(*ProxyConn)
-	if !sonny.sendch.WriteTimeout(f, o.config.MainWriteChannelTimeoutMs) {
-		sonny.needclose = true
+	if f.DataFrame.Window > 0 {
+		addSendWindow(sonny, f.DataFrame.Window)
+		loggo.Debug("Outputer processDataFrame window %s %d", f.DataFrame.Id, f.DataFrame.Window)
+		return
+	}
+	atomic.AddInt64(&o.father.recvsize, int64(len(f.DataFrame.Data)))
+	if !sonny.sendch.WriteTimeout(f, o.config.load().MainWriteChannelTimeoutMs) {
+		atomic.StoreInt32(&sonny.needclose, 1)
 		loggo.Error("Outputer processDataFrame timeout sonnny %s %d", f.DataFrame.Id, len(f.DataFrame.Data))
 	}
 	sonny.actived++
@@ -72,7 +81,7 @@ func (o *Outputer) processCloseFrame(f *ProxyFrame) {
 	sonny.sendch.Write(f)
 }
 
-func (o *Outputer) open(proxyconn *ProxyConn, targetAddr string) bool {
+func (o *Outputer) open(proxyconn *ProxyConn, targetAddr string, proto string) bool {
 
 	id := proxyconn.id
 
@@ -82,8 +91,9 @@ func (o *Outputer) open(proxyconn *ProxyConn, targetAddr string) bool {
 	rf.Type = FRAME_TYPE_OPENRSP
 	rf.OpenRspFrame = &OpenConnRspFrame{}
 	rf.OpenRspFrame.Id = id
+	rf.OpenRspFrame.Window = int32(o.config.ConnBuffer)
 
-	c, err := conn.NewConn(o.conn.Name())
+	c, err := conn.NewConn(proto)
 	if err != nil {
 		rf.OpenRspFrame.Ret = false
 		rf.OpenRspFrame.Msg = "NewConn fail " + targetAddr
@@ -130,10 +140,199 @@ func (o *Outputer) open(proxyconn *ProxyConn, targetAddr string) bool {
 	return true
 }
 
+// bind listen a tcp port and wait the peer to connect, the listen addr and the peer addr are sent in two OPENRSP
+// bindIP the ip to listen for bind, it must be routable to the peer, not 0.0.0.0
+func (o *Outputer) bindIP(peerips []net.IP) net.IP {
+	// the ip the father conn connected, the peer can reach us by it mostly
+	ac, ok := o.father.conn.(addrConn)
+	if ok {
+		tcpaddr, ok := ac.LocalAddr().(*net.TCPAddr)
+		if ok && !tcpaddr.IP.IsUnspecified() {
+			return tcpaddr.IP
+		}
+	}
+	// the local ip of the route to the peer, udp dial send nothing
+	for _, peerip := range peerips {
+		c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: peerip, Port: 9})
+		if err != nil {
+			continue
+		}
+		ip := c.LocalAddr().(*net.UDPAddr).IP
+		c.Close()
+		if !ip.IsUnspecified() {
+			return ip
+		}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if ok && ipnet.IP.IsGlobalUnicast() {
+				return ipnet.IP
+			}
+		}
+	}
+	return net.IPv4(127, 0, 0, 1)
+}
+
+// bindPeerAllowed the peer ip is one of DST.ADDR, the port is not checked, the peer may connect from any port
+func bindPeerAllowed(c conn.Conn, peerips []net.IP) bool {
+	if len(peerips) <= 0 {
+		return true
+	}
+	ac, ok := c.(addrConn)
+	if !ok {
+		return false
+	}
+	tcpaddr, ok := ac.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ip := range peerips {
+		if ip.Equal(tcpaddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Outputer) bind(proxyconn *ProxyConn, targetAddr string) bool {
+
+	id := proxyconn.id
+
+	loggo.Info("Outputer bind start %s %s", id, targetAddr)
+
+	rf := &ProxyFrame{}
+	rf.Type = FRAME_TYPE_OPENRSP
+	rf.OpenRspFrame = &OpenConnRspFrame{}
+	rf.OpenRspFrame.Id = id
+
+	// DST.ADDR is the peer the client expect, the unspecified ip accept any
+	host, _, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		rf.OpenRspFrame.Ret = false
+		rf.OpenRspFrame.Msg = "addr error " + targetAddr
+		o.father.sendch.Write(rf)
+		loggo.Error("Outputer bind addr error %s %s", targetAddr, err.Error())
+		return false
+	}
+	var peerips []net.IP
+	ip := net.ParseIP(host)
+	if ip == nil {
+		peerips, err = net.LookupIP(host)
+		if err != nil {
+			rf.OpenRspFrame.Ret = false
+			rf.OpenRspFrame.Msg = "LookupIP fail " + targetAddr
+			o.father.sendch.Write(rf)
+			loggo.Error("Outputer bind LookupIP fail %s %s", targetAddr, err.Error())
+			return false
+		}
+	} else if !ip.IsUnspecified() {
+		peerips = []net.IP{ip}
+	}
+
+	ip = o.bindIP(peerips)
+
+	c, err := conn.NewConn("tcp")
+	if err != nil {
+		rf.OpenRspFrame.Ret = false
+		rf.OpenRspFrame.Msg = "NewConn fail " + targetAddr
+		o.father.sendch.Write(rf)
+		loggo.Error("Outputer bind NewConn fail %s %s", targetAddr, err.Error())
+		return false
+	}
+
+	listenconn, err := c.Listen(net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		rf.OpenRspFrame.Ret = false
+		rf.OpenRspFrame.Msg = "Listen fail " + targetAddr
+		o.father.sendch.Write(rf)
+		loggo.Error("Outputer bind Listen fail %s %s", targetAddr, err.Error())
+		return false
+	}
+
+	rf.OpenRspFrame.Ret = true
+	rf.OpenRspFrame.Msg = "binding"
+	rf.OpenRspFrame.Bindaddr = listenconn.(addrConn).LocalAddr().String()
+	rf.OpenRspFrame.Binding = true
+	o.father.sendch.Write(rf)
+
+	loggo.Info("Outputer bind Listen ok %s %s", id, rf.OpenRspFrame.Bindaddr)
+
+	wg := group.NewGroup("Outputer bind"+" "+targetAddr, o.fwg, func() {
+		loggo.Info("group start exit %s", listenconn.Info())
+		listenconn.Close()
+		loggo.Info("group end exit %s", listenconn.Info())
+	})
+
+	done := make(chan int)
+	var conn conn.Conn
+	wg.Go("Outputer Accept"+" "+targetAddr, func() error {
+		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+		defer close(done)
+		for {
+			cc, err := listenconn.Accept()
+			if err != nil {
+				return err
+			}
+			if bindPeerAllowed(cc, peerips) {
+				conn = cc
+				return nil
+			}
+			loggo.Error("Outputer bind Accept not DST.ADDR %s %s", targetAddr, cc.Info())
+			cc.Close()
+		}
+	})
+
+	wg.Go("Outputer Accept timeout"+" "+targetAddr, func() error {
+		select {
+		case <-done:
+			return nil
+		case <-wg.Done():
+			return nil
+		case <-time.After(time.Second * time.Duration(o.config.load().ConnTimeout)):
+			return errors.New("accept timeout")
+		}
+	})
+
+	err = wg.Wait()
+	listenconn.Close()
+	if err != nil {
+		rf.OpenRspFrame.Ret = false
+		rf.OpenRspFrame.Msg = "Accept fail " + targetAddr
+		rf.OpenRspFrame.Bindaddr = ""
+		rf.OpenRspFrame.Binding = false
+		o.father.sendch.Write(rf)
+		loggo.Error("Outputer bind Accept fail %s %s", targetAddr, err.Error())
+		return false
+	}
+
+	loggo.Info("Outputer bind Accept ok %s %s", id, conn.Info())
+
+	proxyconn.conn = conn
+
+	rf2 := &ProxyFrame{}
+	rf2.Type = FRAME_TYPE_OPENRSP
+	rf2.OpenRspFrame = &OpenConnRspFrame{}
+	rf2.OpenRspFrame.Id = id
+	rf2.OpenRspFrame.Ret = true
+	rf2.OpenRspFrame.Msg = "ok"
+	rf2.OpenRspFrame.Window = int32(o.config.ConnBuffer)
+	rf2.OpenRspFrame.Bindaddr = conn.(addrConn).RemoteAddr().String()
+	o.father.sendch.Write(rf2)
+
+	return true
+}
+
 func (o *Outputer) processOpenFrame(f *ProxyFrame) {
 
 	id := f.OpenFrame.Id
 	targetAddr := f.OpenFrame.Toaddr
+	proto := f.OpenFrame.Proto
+	if proto == "" {
+		proto = o.conn.Name()
+	}
 
 	rf := &ProxyFrame{}
 	rf.Type = FRAME_TYPE_OPENRSP
@@ -141,15 +340,31 @@ func (o *Outputer) processOpenFrame(f *ProxyFrame) {
 	rf.OpenRspFrame.Id = id
 	rf.OpenRspFrame.Ret = false
 
+	// udp is used by socks5 udp associate
+	if proto != o.conn.Name() && proto != "udp" {
+		rf.OpenRspFrame.Msg = "proto not support " + proto
+		o.father.sendch.Write(rf)
+		loggo.Error("Outputer processOpenFrame proto not support %s %s", id, proto)
+		return
+	}
+
+	// bind reply twice, the bonded conns may deliver them out of order
+	if f.OpenFrame.Bind && (proto != "tcp" || o.father.bonding) {
+		rf.OpenRspFrame.Msg = "bind not support " + proto
+		o.father.sendch.Write(rf)
+		loggo.Error("Outputer processOpenFrame bind not support %s %s", id, proto)
+		return
+	}
+
 	size := o.sonnySize()
-	if size >= o.config.MaxSonny {
+	if size >= o.config.load().MaxSonny {
 		rf.OpenRspFrame.Msg = "max sonny"
 		o.father.sendch.Write(rf)
 		loggo.Info("Outputer listen max sonny %s %d", id, size)
 		return
 	}
 
-	proxyconn := &ProxyConn{id: id, conn: nil, established: true}
+	proxyconn := &ProxyConn{id: id, conn: nil, established: 1, bind: f.OpenFrame.Bind}
 	_, loaded := o.sonny.LoadOrStore(proxyconn.id, proxyconn)
 	if loaded {
 		rf.OpenRspFrame.Msg = "Conn id fail"
@@ -158,27 +373,59 @@ func (o *Outputer) processOpenFrame(f *ProxyFrame) {
 		return
 	}
 
-	sendch := common.NewChannel(o.config.ConnBuffer)
+	// remote never sends more than ConnBuffer data frames, one more for the close frame
+	sendch := common.NewChannel(o.config.ConnBuffer + 1)
 	recvch := common.NewChannel(o.config.ConnBuffer)
 
 	proxyconn.sendch = sendch
 	proxyconn.recvch = recvch
+	initSendWindow(proxyconn, int(f.OpenFrame.Window))
 
 	o.fwg.Go("Outputer processProxyConn"+" "+targetAddr, func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return o.processProxyConn(proxyconn, targetAddr)
+		return o.processProxyConn(proxyconn, targetAddr, proto)
 	})
 }
 
-func (o *Outputer) processProxyConn(proxyConn *ProxyConn, targetAddr string) error {
+func (o *Outputer) processProxyConn(proxyConn *ProxyConn, targetAddr string, proto string) error {
 
 	loggo.Info("Outputer processProxyConn start %s %s", proxyConn.id, targetAddr)
 
 	sendch := proxyConn.sendch
 	recvch := proxyConn.recvch
 
-	if !o.open(proxyConn, targetAddr) {
+	gMetricOutputSonny.Inc()
+	defer gMetricOutputSonny.Dec()
+
+	// check here so the release always runs, the Go of a exited group does nothing
+	ok := true
+	if o.father.checkOpen != nil {
+		release, err := o.father.checkOpen(targetAddr)
+		if err != nil {
+			rf := &ProxyFrame{}
+			rf.Type = FRAME_TYPE_OPENRSP
+			rf.OpenRspFrame = &OpenConnRspFrame{}
+			rf.OpenRspFrame.Id = proxyConn.id
+			rf.OpenRspFrame.Ret = false
+			rf.OpenRspFrame.Msg = err.Error()
+			o.father.sendch.Write(rf)
+			loggo.Error("Outputer processProxyConn checkOpen fail %s %s %s", proxyConn.id, targetAddr, err)
+			ok = false
+		} else {
+			defer release()
+		}
+	}
+
+	if ok {
+		if proxyConn.bind {
+			ok = o.bind(proxyConn, targetAddr)
+		} else {
+			ok = o.open(proxyConn, targetAddr, proto)
+		}
+	}
+	if !ok {
+		o.sonny.Delete(proxyConn.id)
 		sendch.Close()
 		recvch.Close()
 		return nil
@@ -203,13 +450,13 @@ func (o *Outputer) processProxyConn(proxyConn *ProxyConn, targetAddr string) err
 	wg.Go("Outputer sendToSonny"+" "+proxyConn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return sendToSonny(wg, sendch, proxyConn.conn, o.config.MaxMsgSize)
+		return sendToSonny(wg, sendch, proxyConn, o.father, o.config.MaxMsgSize, o.config.ConnBuffer)
 	})
 
 	wg.Go("Outputer checkSonnyActive"+" "+proxyConn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return checkSonnyActive(wg, proxyConn, o.config.EstablishedTimeout, o.config.ConnTimeout)
+		return checkSonnyActive(wg, proxyConn, o.config.load().EstablishedTimeout, o.config.load().ConnTimeout)
 	})
 
 	wg.Go("Outputer checkNeedClose"+" "+proxyConn.conn.Info(), func() error {
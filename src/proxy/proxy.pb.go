@@ -62,6 +62,10 @@ const (
 	CLIENT_TYPE_SOCKS5 CLIENT_TYPE = 2
 	// server fromaddr -> client
 	CLIENT_TYPE_REVERSE_SOCKS5 CLIENT_TYPE = 3
+	// client fromaddr http proxy -> server
+	CLIENT_TYPE_HTTP_PROXY CLIENT_TYPE = 4
+	// server fromaddr http proxy -> client
+	CLIENT_TYPE_REVERSE_HTTP_PROXY CLIENT_TYPE = 5
 )
 
 var CLIENT_TYPE_name = map[int32]string{
@@ -69,13 +73,17 @@ var CLIENT_TYPE_name = map[int32]string{
 	1: "REVERSE_PROXY",
 	2: "SOCKS5",
 	3: "REVERSE_SOCKS5",
+	4: "HTTP_PROXY",
+	5: "REVERSE_HTTP_PROXY",
 }
 
 var CLIENT_TYPE_value = map[string]int32{
-	"PROXY":          0,
-	"REVERSE_PROXY":  1,
-	"SOCKS5":         2,
-	"REVERSE_SOCKS5": 3,
+	"PROXY":              0,
+	"REVERSE_PROXY":      1,
+	"SOCKS5":             2,
+	"REVERSE_SOCKS5":     3,
+	"HTTP_PROXY":         4,
+	"REVERSE_HTTP_PROXY": 5,
 }
 
 func (x CLIENT_TYPE) String() string {
@@ -89,36 +97,45 @@ func (CLIENT_TYPE) EnumDescriptor() ([]byte, []int) {
 type FRAME_TYPE int32
 
 const (
-	FRAME_TYPE_LOGIN    FRAME_TYPE = 0
-	FRAME_TYPE_LOGINRSP FRAME_TYPE = 1
-	FRAME_TYPE_DATA     FRAME_TYPE = 2
-	FRAME_TYPE_PING     FRAME_TYPE = 3
-	FRAME_TYPE_PONG     FRAME_TYPE = 4
-	FRAME_TYPE_OPEN     FRAME_TYPE = 5
-	FRAME_TYPE_OPENRSP  FRAME_TYPE = 6
-	FRAME_TYPE_CLOSE    FRAME_TYPE = 7
+	FRAME_TYPE_LOGIN        FRAME_TYPE = 0
+	FRAME_TYPE_LOGINRSP     FRAME_TYPE = 1
+	FRAME_TYPE_DATA         FRAME_TYPE = 2
+	FRAME_TYPE_PING         FRAME_TYPE = 3
+	FRAME_TYPE_PONG         FRAME_TYPE = 4
+	FRAME_TYPE_OPEN         FRAME_TYPE = 5
+	FRAME_TYPE_OPENRSP      FRAME_TYPE = 6
+	FRAME_TYPE_CLOSE        FRAME_TYPE = 7
+	FRAME_TYPE_HANDSHAKE    FRAME_TYPE = 8
+	FRAME_TYPE_HANDSHAKERSP FRAME_TYPE = 9
+	FRAME_TYPE_BONDACK      FRAME_TYPE = 10
 )
 
 var FRAME_TYPE_name = map[int32]string{
-	0: "LOGIN",
-	1: "LOGINRSP",
-	2: "DATA",
-	3: "PING",
-	4: "PONG",
-	5: "OPEN",
-	6: "OPENRSP",
-	7: "CLOSE",
+	0:  "LOGIN",
+	1:  "LOGINRSP",
+	2:  "DATA",
+	3:  "PING",
+	4:  "PONG",
+	5:  "OPEN",
+	6:  "OPENRSP",
+	7:  "CLOSE",
+	8:  "HANDSHAKE",
+	9:  "HANDSHAKERSP",
+	10: "BONDACK",
 }
 
 var FRAME_TYPE_value = map[string]int32{
-	"LOGIN":    0,
-	"LOGINRSP": 1,
-	"DATA":     2,
-	"PING":     3,
-	"PONG":     4,
-	"OPEN":     5,
-	"OPENRSP":  6,
-	"CLOSE":    7,
+	"LOGIN":        0,
+	"LOGINRSP":     1,
+	"DATA":         2,
+	"PING":         3,
+	"PONG":         4,
+	"OPEN":         5,
+	"OPENRSP":      6,
+	"CLOSE":        7,
+	"HANDSHAKE":    8,
+	"HANDSHAKERSP": 9,
+	"BONDACK":      10,
 }
 
 func (x FRAME_TYPE) String() string {
@@ -136,6 +153,8 @@ type LoginFrame struct {
 	Toaddr               string      `protobuf:"bytes,4,opt,name=toaddr,proto3" json:"toaddr,omitempty"`
 	Name                 string      `protobuf:"bytes,5,opt,name=name,proto3" json:"name,omitempty"`
 	Key                  string      `protobuf:"bytes,6,opt,name=key,proto3" json:"key,omitempty"`
+	Keyproof             []byte      `protobuf:"bytes,7,opt,name=keyproof,proto3" json:"keyproof,omitempty"`
+	Bond                 string      `protobuf:"bytes,8,opt,name=bond,proto3" json:"bond,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
 	XXX_unrecognized     []byte      `json:"-"`
 	XXX_sizecache        int32       `json:"-"`
@@ -208,9 +227,24 @@ func (m *LoginFrame) GetKey() string {
 	return ""
 }
 
+func (m *LoginFrame) GetKeyproof() []byte {
+	if m != nil {
+		return m.Keyproof
+	}
+	return nil
+}
+
+func (m *LoginFrame) GetBond() string {
+	if m != nil {
+		return m.Bond
+	}
+	return ""
+}
+
 type LoginRspFrame struct {
 	Ret                  bool     `protobuf:"varint,1,opt,name=ret,proto3" json:"ret,omitempty"`
 	Msg                  string   `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
+	Keyproof             []byte   `protobuf:"bytes,3,opt,name=keyproof,proto3" json:"keyproof,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -255,6 +289,13 @@ func (m *LoginRspFrame) GetMsg() string {
 	return ""
 }
 
+func (m *LoginRspFrame) GetKeyproof() []byte {
+	if m != nil {
+		return m.Keyproof
+	}
+	return nil
+}
+
 type PingFrame struct {
 	Time                 int64    `protobuf:"varint,1,opt,name=time,proto3" json:"time,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -336,6 +377,9 @@ func (m *PongFrame) GetTime() int64 {
 type OpenConnFrame struct {
 	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Toaddr               string   `protobuf:"bytes,2,opt,name=toaddr,proto3" json:"toaddr,omitempty"`
+	Proto                string   `protobuf:"bytes,3,opt,name=proto,proto3" json:"proto,omitempty"`
+	Bind                 bool     `protobuf:"varint,4,opt,name=bind,proto3" json:"bind,omitempty"`
+	Window               int32    `protobuf:"varint,5,opt,name=window,proto3" json:"window,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -380,10 +424,34 @@ func (m *OpenConnFrame) GetToaddr() string {
 	return ""
 }
 
+func (m *OpenConnFrame) GetProto() string {
+	if m != nil {
+		return m.Proto
+	}
+	return ""
+}
+
+func (m *OpenConnFrame) GetBind() bool {
+	if m != nil {
+		return m.Bind
+	}
+	return false
+}
+
+func (m *OpenConnFrame) GetWindow() int32 {
+	if m != nil {
+		return m.Window
+	}
+	return 0
+}
+
 type OpenConnRspFrame struct {
 	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Ret                  bool     `protobuf:"varint,2,opt,name=ret,proto3" json:"ret,omitempty"`
 	Msg                  string   `protobuf:"bytes,3,opt,name=msg,proto3" json:"msg,omitempty"`
+	Bindaddr             string   `protobuf:"bytes,4,opt,name=bindaddr,proto3" json:"bindaddr,omitempty"`
+	Binding              bool     `protobuf:"varint,5,opt,name=binding,proto3" json:"binding,omitempty"`
+	Window               int32    `protobuf:"varint,6,opt,name=window,proto3" json:"window,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -435,8 +503,30 @@ func (m *OpenConnRspFrame) GetMsg() string {
 	return ""
 }
 
+func (m *OpenConnRspFrame) GetBindaddr() string {
+	if m != nil {
+		return m.Bindaddr
+	}
+	return ""
+}
+
+func (m *OpenConnRspFrame) GetBinding() bool {
+	if m != nil {
+		return m.Binding
+	}
+	return false
+}
+
+func (m *OpenConnRspFrame) GetWindow() int32 {
+	if m != nil {
+		return m.Window
+	}
+	return 0
+}
+
 type CloseFrame struct {
 	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Index                int32    `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -474,12 +564,20 @@ func (m *CloseFrame) GetId() string {
 	return ""
 }
 
+func (m *CloseFrame) GetIndex() int32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
 type DataFrame struct {
 	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Compress             bool     `protobuf:"varint,2,opt,name=compress,proto3" json:"compress,omitempty"`
 	Crc                  string   `protobuf:"bytes,3,opt,name=crc,proto3" json:"crc,omitempty"`
 	Data                 []byte   `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
 	Index                int32    `protobuf:"varint,5,opt,name=index,proto3" json:"index,omitempty"`
+	Window               int32    `protobuf:"varint,6,opt,name=window,proto3" json:"window,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -545,26 +643,179 @@ func (m *DataFrame) GetIndex() int32 {
 	return 0
 }
 
+func (m *DataFrame) GetWindow() int32 {
+	if m != nil {
+		return m.Window
+	}
+	return 0
+}
+
+type HandshakeFrame struct {
+	Version              int32    `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Encrypt              string   `protobuf:"bytes,2,opt,name=encrypt,proto3" json:"encrypt,omitempty"`
+	Pubkey               []byte   `protobuf:"bytes,3,opt,name=pubkey,proto3" json:"pubkey,omitempty"`
+	Nonce                []byte   `protobuf:"bytes,4,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	User                 string   `protobuf:"bytes,5,opt,name=user,proto3" json:"user,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HandshakeFrame) Reset()         { *m = HandshakeFrame{} }
+func (m *HandshakeFrame) String() string { return proto.CompactTextString(m) }
+func (*HandshakeFrame) ProtoMessage()    {}
+func (*HandshakeFrame) Descriptor() ([]byte, []int) {
+	return fileDescriptor_700b50b08ed8dbaf, []int{8}
+}
+
+func (m *HandshakeFrame) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HandshakeFrame.Unmarshal(m, b)
+}
+func (m *HandshakeFrame) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HandshakeFrame.Marshal(b, m, deterministic)
+}
+func (m *HandshakeFrame) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HandshakeFrame.Merge(m, src)
+}
+func (m *HandshakeFrame) XXX_Size() int {
+	return xxx_messageInfo_HandshakeFrame.Size(m)
+}
+func (m *HandshakeFrame) XXX_DiscardUnknown() {
+	xxx_messageInfo_HandshakeFrame.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HandshakeFrame proto.InternalMessageInfo
+
+func (m *HandshakeFrame) GetVersion() int32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *HandshakeFrame) GetEncrypt() string {
+	if m != nil {
+		return m.Encrypt
+	}
+	return ""
+}
+
+func (m *HandshakeFrame) GetPubkey() []byte {
+	if m != nil {
+		return m.Pubkey
+	}
+	return nil
+}
+
+func (m *HandshakeFrame) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
+func (m *HandshakeFrame) GetUser() string {
+	if m != nil {
+		return m.User
+	}
+	return ""
+}
+
+type HandshakeRspFrame struct {
+	Ret                  bool     `protobuf:"varint,1,opt,name=ret,proto3" json:"ret,omitempty"`
+	Msg                  string   `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
+	Version              int32    `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	Pubkey               []byte   `protobuf:"bytes,4,opt,name=pubkey,proto3" json:"pubkey,omitempty"`
+	Nonce                []byte   `protobuf:"bytes,5,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HandshakeRspFrame) Reset()         { *m = HandshakeRspFrame{} }
+func (m *HandshakeRspFrame) String() string { return proto.CompactTextString(m) }
+func (*HandshakeRspFrame) ProtoMessage()    {}
+func (*HandshakeRspFrame) Descriptor() ([]byte, []int) {
+	return fileDescriptor_700b50b08ed8dbaf, []int{9}
+}
+
+func (m *HandshakeRspFrame) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HandshakeRspFrame.Unmarshal(m, b)
+}
+func (m *HandshakeRspFrame) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HandshakeRspFrame.Marshal(b, m, deterministic)
+}
+func (m *HandshakeRspFrame) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HandshakeRspFrame.Merge(m, src)
+}
+func (m *HandshakeRspFrame) XXX_Size() int {
+	return xxx_messageInfo_HandshakeRspFrame.Size(m)
+}
+func (m *HandshakeRspFrame) XXX_DiscardUnknown() {
+	xxx_messageInfo_HandshakeRspFrame.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HandshakeRspFrame proto.InternalMessageInfo
+
+func (m *HandshakeRspFrame) GetRet() bool {
+	if m != nil {
+		return m.Ret
+	}
+	return false
+}
+
+func (m *HandshakeRspFrame) GetMsg() string {
+	if m != nil {
+		return m.Msg
+	}
+	return ""
+}
+
+func (m *HandshakeRspFrame) GetVersion() int32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *HandshakeRspFrame) GetPubkey() []byte {
+	if m != nil {
+		return m.Pubkey
+	}
+	return nil
+}
+
+func (m *HandshakeRspFrame) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
 type ProxyFrame struct {
-	Type                 FRAME_TYPE        `protobuf:"varint,1,opt,name=type,proto3,enum=FRAME_TYPE" json:"type,omitempty"`
-	LoginFrame           *LoginFrame       `protobuf:"bytes,2,opt,name=loginFrame,proto3" json:"loginFrame,omitempty"`
-	LoginRspFrame        *LoginRspFrame    `protobuf:"bytes,3,opt,name=loginRspFrame,proto3" json:"loginRspFrame,omitempty"`
-	DataFrame            *DataFrame        `protobuf:"bytes,4,opt,name=dataFrame,proto3" json:"dataFrame,omitempty"`
-	PingFrame            *PingFrame        `protobuf:"bytes,5,opt,name=pingFrame,proto3" json:"pingFrame,omitempty"`
-	PongFrame            *PongFrame        `protobuf:"bytes,6,opt,name=pongFrame,proto3" json:"pongFrame,omitempty"`
-	OpenFrame            *OpenConnFrame    `protobuf:"bytes,7,opt,name=openFrame,proto3" json:"openFrame,omitempty"`
-	OpenRspFrame         *OpenConnRspFrame `protobuf:"bytes,8,opt,name=openRspFrame,proto3" json:"openRspFrame,omitempty"`
-	CloseFrame           *CloseFrame       `protobuf:"bytes,9,opt,name=closeFrame,proto3" json:"closeFrame,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
-	XXX_unrecognized     []byte            `json:"-"`
-	XXX_sizecache        int32             `json:"-"`
+	Type                 FRAME_TYPE         `protobuf:"varint,1,opt,name=type,proto3,enum=FRAME_TYPE" json:"type,omitempty"`
+	LoginFrame           *LoginFrame        `protobuf:"bytes,2,opt,name=loginFrame,proto3" json:"loginFrame,omitempty"`
+	LoginRspFrame        *LoginRspFrame     `protobuf:"bytes,3,opt,name=loginRspFrame,proto3" json:"loginRspFrame,omitempty"`
+	DataFrame            *DataFrame         `protobuf:"bytes,4,opt,name=dataFrame,proto3" json:"dataFrame,omitempty"`
+	PingFrame            *PingFrame         `protobuf:"bytes,5,opt,name=pingFrame,proto3" json:"pingFrame,omitempty"`
+	PongFrame            *PongFrame         `protobuf:"bytes,6,opt,name=pongFrame,proto3" json:"pongFrame,omitempty"`
+	OpenFrame            *OpenConnFrame     `protobuf:"bytes,7,opt,name=openFrame,proto3" json:"openFrame,omitempty"`
+	OpenRspFrame         *OpenConnRspFrame  `protobuf:"bytes,8,opt,name=openRspFrame,proto3" json:"openRspFrame,omitempty"`
+	CloseFrame           *CloseFrame        `protobuf:"bytes,9,opt,name=closeFrame,proto3" json:"closeFrame,omitempty"`
+	HandshakeFrame       *HandshakeFrame    `protobuf:"bytes,10,opt,name=handshakeFrame,proto3" json:"handshakeFrame,omitempty"`
+	HandshakeRspFrame    *HandshakeRspFrame `protobuf:"bytes,11,opt,name=handshakeRspFrame,proto3" json:"handshakeRspFrame,omitempty"`
+	BondAckFrame         *BondAckFrame      `protobuf:"bytes,12,opt,name=bondAckFrame,proto3" json:"bondAckFrame,omitempty"`
+	Bondseq              int64              `protobuf:"varint,13,opt,name=bondseq,proto3" json:"bondseq,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
 }
 
 func (m *ProxyFrame) Reset()         { *m = ProxyFrame{} }
 func (m *ProxyFrame) String() string { return proto.CompactTextString(m) }
 func (*ProxyFrame) ProtoMessage()    {}
 func (*ProxyFrame) Descriptor() ([]byte, []int) {
-	return fileDescriptor_700b50b08ed8dbaf, []int{8}
+	return fileDescriptor_700b50b08ed8dbaf, []int{10}
 }
 
 func (m *ProxyFrame) XXX_Unmarshal(b []byte) error {
@@ -648,6 +899,73 @@ func (m *ProxyFrame) GetCloseFrame() *CloseFrame {
 	return nil
 }
 
+func (m *ProxyFrame) GetHandshakeFrame() *HandshakeFrame {
+	if m != nil {
+		return m.HandshakeFrame
+	}
+	return nil
+}
+
+func (m *ProxyFrame) GetHandshakeRspFrame() *HandshakeRspFrame {
+	if m != nil {
+		return m.HandshakeRspFrame
+	}
+	return nil
+}
+
+func (m *ProxyFrame) GetBondAckFrame() *BondAckFrame {
+	if m != nil {
+		return m.BondAckFrame
+	}
+	return nil
+}
+
+func (m *ProxyFrame) GetBondseq() int64 {
+	if m != nil {
+		return m.Bondseq
+	}
+	return 0
+}
+
+type BondAckFrame struct {
+	Seq                  int64    `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BondAckFrame) Reset()         { *m = BondAckFrame{} }
+func (m *BondAckFrame) String() string { return proto.CompactTextString(m) }
+func (*BondAckFrame) ProtoMessage()    {}
+func (*BondAckFrame) Descriptor() ([]byte, []int) {
+	return fileDescriptor_700b50b08ed8dbaf, []int{11}
+}
+
+func (m *BondAckFrame) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BondAckFrame.Unmarshal(m, b)
+}
+func (m *BondAckFrame) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BondAckFrame.Marshal(b, m, deterministic)
+}
+func (m *BondAckFrame) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BondAckFrame.Merge(m, src)
+}
+func (m *BondAckFrame) XXX_Size() int {
+	return xxx_messageInfo_BondAckFrame.Size(m)
+}
+func (m *BondAckFrame) XXX_DiscardUnknown() {
+	xxx_messageInfo_BondAckFrame.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BondAckFrame proto.InternalMessageInfo
+
+func (m *BondAckFrame) GetSeq() int64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterEnum("PROXY_PROTO", PROXY_PROTO_name, PROXY_PROTO_value)
 	proto.RegisterEnum("CLIENT_TYPE", CLIENT_TYPE_name, CLIENT_TYPE_value)
@@ -660,50 +978,73 @@ func init() {
 	proto.RegisterType((*OpenConnRspFrame)(nil), "OpenConnRspFrame")
 	proto.RegisterType((*CloseFrame)(nil), "CloseFrame")
 	proto.RegisterType((*DataFrame)(nil), "DataFrame")
+	proto.RegisterType((*HandshakeFrame)(nil), "HandshakeFrame")
+	proto.RegisterType((*HandshakeRspFrame)(nil), "HandshakeRspFrame")
 	proto.RegisterType((*ProxyFrame)(nil), "ProxyFrame")
+	proto.RegisterType((*BondAckFrame)(nil), "BondAckFrame")
 }
 
 func init() { proto.RegisterFile("proxy.proto", fileDescriptor_700b50b08ed8dbaf) }
 
 var fileDescriptor_700b50b08ed8dbaf = []byte{
-	// 622 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x54, 0x4d, 0x4f, 0xdb, 0x40,
-	0x10, 0x25, 0x76, 0xbe, 0x3c, 0x4e, 0xa2, 0x65, 0x55, 0x55, 0x11, 0xaa, 0x04, 0xca, 0x09, 0x51,
-	0xe4, 0x43, 0x00, 0xf5, 0x4c, 0x8d, 0x41, 0x11, 0x21, 0xb6, 0x26, 0x69, 0x55, 0x7a, 0x41, 0xae,
-	0xbd, 0x45, 0x56, 0x13, 0xaf, 0xe5, 0xf8, 0x40, 0xfe, 0x4d, 0x7f, 0x4b, 0x7f, 0x59, 0xb5, 0xe3,
-	0xcf, 0x50, 0xd4, 0xdb, 0xdb, 0x7d, 0x6f, 0x76, 0xe7, 0xcd, 0x3e, 0x1b, 0xcc, 0x24, 0x95, 0x2f,
-	0x3b, 0x2b, 0x49, 0x65, 0x26, 0x27, 0x7f, 0x5a, 0x00, 0x73, 0xf9, 0x1c, 0xc5, 0xb7, 0xa9, 0xbf,
-	0x11, 0xfc, 0x1c, 0x80, 0x58, 0x22, 0xc7, 0xad, 0x93, 0xd6, 0xe9, 0x68, 0x3a, 0xb0, 0x3c, 0x74,
-	0xbf, 0x3d, 0x3e, 0x79, 0xe8, 0xae, 0x5c, 0x6c, 0xf0, 0x4a, 0x1d, 0xac, 0x23, 0x11, 0x67, 0xd9,
-	0x2e, 0x11, 0x63, 0xad, 0x50, 0xdb, 0xf3, 0x99, 0xb3, 0x58, 0x3d, 0xad, 0x1e, 0x3d, 0x07, 0x1b,
-	0x3c, 0x3f, 0x82, 0xfe, 0xcf, 0x54, 0x6e, 0xfc, 0x30, 0x4c, 0xc7, 0xfa, 0x49, 0xeb, 0xd4, 0xc0,
-	0x6a, 0xcd, 0xdf, 0x43, 0x37, 0x93, 0xc4, 0xb4, 0x89, 0x29, 0x56, 0x9c, 0x43, 0x3b, 0xf6, 0x37,
-	0x62, 0xdc, 0xa1, 0x5d, 0xc2, 0x9c, 0x81, 0xfe, 0x4b, 0xec, 0xc6, 0x5d, 0xda, 0x52, 0x70, 0x72,
-	0x01, 0x43, 0xf2, 0x80, 0xdb, 0x24, 0xb7, 0xc1, 0x40, 0x4f, 0x45, 0x46, 0xfd, 0xf7, 0x51, 0x41,
-	0xb5, 0xb3, 0xd9, 0x3e, 0x53, 0x8f, 0x06, 0x2a, 0x38, 0x39, 0x06, 0xc3, 0x8b, 0xe2, 0xe7, 0xbc,
-	0x80, 0x43, 0x3b, 0x8b, 0x36, 0x82, 0x2a, 0x74, 0x24, 0x4c, 0x02, 0xf9, 0x3f, 0xc1, 0x27, 0x18,
-	0xba, 0x89, 0x88, 0x6d, 0x19, 0x17, 0xd3, 0x1b, 0x81, 0x16, 0x85, 0x24, 0x31, 0x50, 0x8b, 0xc2,
-	0x86, 0x2b, 0xad, 0xe9, 0x6a, 0x72, 0x0b, 0xac, 0x2c, 0xac, 0x5a, 0x7e, 0x5d, 0x5b, 0x58, 0xd0,
-	0xfe, 0xb1, 0xa0, 0xd7, 0x16, 0x3e, 0x00, 0xd8, 0x6b, 0xb9, 0x15, 0x6f, 0x9e, 0x30, 0xd9, 0x82,
-	0x71, 0xe3, 0x67, 0xfe, 0xdb, 0xc7, 0x1f, 0x41, 0x3f, 0x90, 0x9b, 0x24, 0x15, 0xdb, 0x6d, 0x71,
-	0x47, 0xb5, 0x56, 0x17, 0x05, 0x69, 0x50, 0x5e, 0x14, 0xa4, 0x81, 0x72, 0x1f, 0xfa, 0x99, 0x4f,
-	0x8f, 0x33, 0x40, 0xc2, 0xfc, 0x1d, 0x74, 0xa2, 0x38, 0x14, 0x2f, 0xf4, 0x36, 0x1d, 0xcc, 0x17,
-	0x93, 0xdf, 0x3a, 0x80, 0xa7, 0x12, 0x92, 0x5f, 0x7b, 0x0c, 0x6d, 0xca, 0x46, 0x9e, 0x24, 0xd3,
-	0xba, 0xc5, 0xeb, 0x07, 0x27, 0x8f, 0x06, 0x11, 0xfc, 0x23, 0xc0, 0xba, 0x8a, 0x1f, 0x75, 0x62,
-	0x4e, 0x4d, 0xab, 0x4e, 0x24, 0x36, 0x68, 0x7e, 0x09, 0xc3, 0x75, 0xf3, 0x9d, 0xa9, 0x45, 0x73,
-	0x3a, 0xb2, 0xf6, 0x5e, 0x1f, 0xf7, 0x45, 0xfc, 0x14, 0x8c, 0xb0, 0x9c, 0x03, 0x39, 0x30, 0xa7,
-	0x60, 0x55, 0x93, 0xc1, 0x9a, 0x54, 0xca, 0xa4, 0x8c, 0x04, 0xd9, 0x52, 0xca, 0x2a, 0x24, 0x58,
-	0x93, 0xa4, 0x2c, 0xb3, 0x41, 0x49, 0x24, 0xa5, 0xac, 0x95, 0x55, 0x70, 0xce, 0xc1, 0x90, 0x89,
-	0x28, 0xfc, 0xf5, 0x8a, 0x7e, 0xf7, 0x62, 0x83, 0xb5, 0x80, 0x5f, 0xc1, 0x40, 0x2d, 0x2a, 0x83,
-	0x7d, 0x2a, 0x38, 0xb4, 0x5e, 0xc7, 0x05, 0xf7, 0x64, 0x6a, 0x8a, 0x41, 0x15, 0x84, 0xb1, 0x51,
-	0x4c, 0xb1, 0xce, 0x06, 0x36, 0xe8, 0xb3, 0x4b, 0x30, 0x1b, 0x1f, 0x34, 0xef, 0x81, 0xbe, 0xb2,
-	0x3d, 0x76, 0xa0, 0xc0, 0x97, 0x1b, 0x8f, 0xb5, 0x78, 0x1f, 0xda, 0xa8, 0x90, 0xc6, 0x0d, 0xe8,
-	0xe0, 0xcc, 0x7e, 0xf0, 0x98, 0x7e, 0x76, 0x0f, 0x66, 0xe3, 0xc3, 0x56, 0x0c, 0x1d, 0xc2, 0x0e,
-	0xf8, 0x21, 0x0c, 0xd1, 0xf9, 0xea, 0xe0, 0xd2, 0x79, 0xca, 0xb7, 0x5a, 0x1c, 0xa0, 0xbb, 0x74,
-	0xed, 0xfb, 0xe5, 0x15, 0xd3, 0x38, 0x87, 0x51, 0x49, 0x17, 0x7b, 0xfa, 0x99, 0x00, 0xa8, 0x93,
-	0xa0, 0xce, 0x9a, 0xbb, 0x77, 0xb3, 0x05, 0x3b, 0xe0, 0x03, 0xe8, 0x13, 0xc4, 0x65, 0xd1, 0xc8,
-	0xcd, 0xf5, 0xea, 0x9a, 0x69, 0x0a, 0x79, 0xb3, 0xc5, 0x1d, 0xd3, 0x09, 0xb9, 0x8b, 0x3b, 0xd6,
-	0x56, 0xc8, 0xf5, 0x9c, 0x05, 0xeb, 0x70, 0x13, 0x7a, 0x0a, 0xa9, 0xa2, 0xae, 0x3a, 0xcd, 0x9e,
-	0xbb, 0x4b, 0x87, 0xf5, 0x3e, 0xf7, 0xbe, 0x77, 0xe8, 0x6f, 0xf5, 0xa3, 0x4b, 0xff, 0xab, 0x8b,
-	0xbf, 0x01, 0x00, 0x00, 0xff, 0xff, 0xe6, 0xc9, 0xd8, 0xda, 0xfb, 0x04, 0x00, 0x00,
+	// 939 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x56, 0xcd, 0x6e, 0xdb, 0x46,
+	0x10, 0x36, 0x45, 0x52, 0x22, 0x87, 0x92, 0xba, 0x5e, 0x04, 0x01, 0x91, 0x4b, 0x0c, 0x9d, 0x0c,
+	0x37, 0x20, 0x50, 0x37, 0x41, 0xaf, 0x95, 0x25, 0xc5, 0x76, 0xed, 0x88, 0xc4, 0x4a, 0x2d, 0x9a,
+	0x5e, 0x0c, 0x99, 0xdc, 0xd8, 0x84, 0x2d, 0x2e, 0x4b, 0x2a, 0x4d, 0xf4, 0x00, 0xed, 0xa5, 0xc7,
+	0xf6, 0x21, 0x7b, 0xee, 0x13, 0x14, 0x33, 0xfc, 0x11, 0x69, 0xbb, 0x05, 0x7a, 0xfb, 0x66, 0xe7,
+	0xdb, 0x9d, 0x6f, 0x86, 0x33, 0x23, 0x81, 0x93, 0x66, 0xea, 0xf3, 0xd6, 0x4b, 0x33, 0xb5, 0x51,
+	0xa3, 0xbf, 0x35, 0x80, 0x4b, 0x75, 0x13, 0x27, 0x6f, 0xb3, 0xd5, 0x5a, 0xf2, 0x57, 0x00, 0xe4,
+	0x25, 0xa7, 0xab, 0x1d, 0x68, 0x87, 0xc3, 0xe3, 0xbe, 0x17, 0x08, 0xff, 0xc7, 0xf7, 0x57, 0x81,
+	0xf0, 0x97, 0xbe, 0x68, 0xf8, 0x91, 0x1d, 0xde, 0xc7, 0x32, 0xd9, 0x6c, 0xb6, 0xa9, 0x74, 0x3b,
+	0x25, 0x7b, 0x72, 0x79, 0x3e, 0x9b, 0x2f, 0xaf, 0x96, 0xef, 0x83, 0x99, 0x68, 0xf8, 0xf9, 0x0b,
+	0xb0, 0x3e, 0x64, 0x6a, 0xbd, 0x8a, 0xa2, 0xcc, 0xd5, 0x0f, 0xb4, 0x43, 0x5b, 0xd4, 0x36, 0x7f,
+	0x0e, 0xdd, 0x8d, 0x22, 0x8f, 0x41, 0x9e, 0xd2, 0xe2, 0x1c, 0x8c, 0x64, 0xb5, 0x96, 0xae, 0x49,
+	0xa7, 0x84, 0x39, 0x03, 0xfd, 0x4e, 0x6e, 0xdd, 0x2e, 0x1d, 0x21, 0xc4, 0x97, 0xef, 0x24, 0x6a,
+	0x52, 0x1f, 0xdc, 0xde, 0x81, 0x76, 0xd8, 0x17, 0xb5, 0x8d, 0x2f, 0x5c, 0xab, 0x24, 0x72, 0xad,
+	0xe2, 0x05, 0xc4, 0x23, 0x1f, 0x06, 0x94, 0xb3, 0xc8, 0xd3, 0x22, 0x6d, 0x06, 0x7a, 0x26, 0x37,
+	0x94, 0xaf, 0x25, 0x10, 0xe2, 0xc9, 0x3a, 0xbf, 0xa1, 0x9c, 0x6c, 0x81, 0xb0, 0x15, 0x44, 0x6f,
+	0x07, 0x19, 0xbd, 0x04, 0x3b, 0x88, 0x93, 0x9b, 0xe2, 0x31, 0x0e, 0xc6, 0x26, 0x5e, 0x4b, 0x7a,
+	0x4d, 0x17, 0x84, 0x89, 0xa0, 0xfe, 0x8b, 0xb0, 0x85, 0x81, 0x9f, 0xca, 0x64, 0xa2, 0x92, 0xf2,
+	0x4b, 0x0c, 0xa1, 0x13, 0x47, 0x44, 0xb1, 0x45, 0x27, 0x8e, 0x1a, 0x15, 0xea, 0xb4, 0x2a, 0xf4,
+	0x0c, 0xcc, 0xe2, 0x63, 0x15, 0x25, 0x2d, 0x0c, 0xca, 0x3a, 0x4e, 0x22, 0xaa, 0xa6, 0x25, 0x08,
+	0xe3, 0x0b, 0x9f, 0xe2, 0x24, 0x52, 0x9f, 0xa8, 0x9a, 0xa6, 0x28, 0xad, 0xd1, 0x9f, 0x1a, 0xb0,
+	0x2a, 0x76, 0x5d, 0x91, 0x87, 0xe1, 0xcb, 0x0a, 0x75, 0x1e, 0x55, 0x48, 0x6f, 0x55, 0x08, 0x03,
+	0x35, 0x3e, 0x63, 0x6d, 0x73, 0x17, 0x7a, 0x88, 0xe3, 0xe4, 0x86, 0xa2, 0x5b, 0xa2, 0x32, 0x1b,
+	0xb2, 0xba, 0x2d, 0x59, 0xc7, 0x00, 0x93, 0x7b, 0x95, 0xcb, 0xa7, 0xf5, 0x3c, 0x03, 0x33, 0x4e,
+	0x22, 0xf9, 0x99, 0x14, 0x99, 0xa2, 0x30, 0x46, 0xbf, 0x6b, 0x60, 0x4f, 0x57, 0x9b, 0xd5, 0xd3,
+	0x77, 0x5e, 0x80, 0x15, 0xaa, 0x75, 0x9a, 0xc9, 0x3c, 0x2f, 0x13, 0xa9, 0x6d, 0xcc, 0x26, 0xcc,
+	0xc2, 0x2a, 0x9b, 0x30, 0x0b, 0xb1, 0x84, 0xd1, 0x6a, 0xb3, 0xa2, 0x4c, 0xfa, 0x82, 0xf0, 0x2e,
+	0xaa, 0xd9, 0x88, 0xfa, 0xaf, 0x19, 0xfc, 0xa6, 0xc1, 0xf0, 0x6c, 0x95, 0x44, 0xf9, 0xed, 0xea,
+	0xae, 0x4c, 0xc3, 0x85, 0xde, 0x2f, 0x32, 0xcb, 0x63, 0x95, 0x90, 0x2e, 0x53, 0x54, 0x26, 0x7a,
+	0x64, 0x12, 0x66, 0xdb, 0x74, 0x53, 0x7e, 0xe0, 0xca, 0xc4, 0xe7, 0xd3, 0x8f, 0xd7, 0xd8, 0xf2,
+	0x45, 0xdb, 0x95, 0x16, 0x8a, 0x49, 0x54, 0x12, 0xca, 0x52, 0x61, 0x61, 0xa0, 0xec, 0x8f, 0xb9,
+	0xcc, 0xaa, 0x89, 0x41, 0x3c, 0xfa, 0x55, 0x83, 0xfd, 0x5a, 0xc8, 0xff, 0x6a, 0xfa, 0x86, 0x5e,
+	0xbd, 0xad, 0x77, 0xa7, 0xca, 0x78, 0x5a, 0x95, 0xd9, 0x50, 0xf5, 0x9d, 0x61, 0x75, 0x59, 0x6f,
+	0xf4, 0x97, 0x01, 0x10, 0xe0, 0xfa, 0x28, 0x04, 0xbc, 0x04, 0x83, 0x16, 0x47, 0xb1, 0x66, 0x1c,
+	0xef, 0xad, 0x18, 0xbf, 0x9b, 0x15, 0x7b, 0x83, 0x1c, 0xfc, 0x4b, 0x80, 0xfb, 0x7a, 0x37, 0x91,
+	0x2c, 0xe7, 0xd8, 0xf1, 0x76, 0xeb, 0x4a, 0x34, 0xdc, 0xfc, 0x35, 0x0c, 0xee, 0x9b, 0x43, 0x4d,
+	0x82, 0x9d, 0xe3, 0xa1, 0xd7, 0x1a, 0x75, 0xd1, 0x26, 0xf1, 0x43, 0xb0, 0xa3, 0xaa, 0x61, 0x28,
+	0x13, 0xe7, 0x18, 0xbc, 0xba, 0x85, 0xc4, 0xce, 0x89, 0xcc, 0xb4, 0x9a, 0x71, 0xd7, 0x2c, 0x99,
+	0xf5, 0xd4, 0x8b, 0x9d, 0x93, 0x98, 0xd5, 0xb0, 0xbb, 0xdd, 0x8a, 0xa9, 0x76, 0xcc, 0x0a, 0xf2,
+	0x57, 0x60, 0xab, 0x54, 0x96, 0xf9, 0xf5, 0x4a, 0xbd, 0xad, 0x3d, 0x20, 0x76, 0x04, 0xfe, 0x06,
+	0xfa, 0x68, 0xd4, 0x09, 0x5a, 0x74, 0x61, 0xdf, 0x7b, 0x38, 0xbc, 0xa2, 0x45, 0xc3, 0x2a, 0x86,
+	0xf5, 0x20, 0xb9, 0x76, 0x59, 0xc5, 0xdd, 0x6c, 0x89, 0x86, 0x9b, 0x7f, 0x03, 0xc3, 0xdb, 0x56,
+	0xcb, 0xba, 0x40, 0x17, 0xbe, 0xf0, 0xda, 0x9d, 0x2c, 0x1e, 0xd0, 0xf8, 0xb7, 0xb0, 0x7f, 0xfb,
+	0xb0, 0xc5, 0x5c, 0x87, 0xee, 0x72, 0xef, 0x51, 0xf3, 0x89, 0xc7, 0x64, 0xfe, 0x15, 0xf4, 0x71,
+	0x3b, 0x8f, 0xc3, 0xbb, 0xe2, 0x72, 0x9f, 0x2e, 0x0f, 0xbc, 0x93, 0xc6, 0xa1, 0x68, 0x51, 0x68,
+	0xab, 0xa8, 0x24, 0xca, 0xe5, 0xcf, 0xee, 0x80, 0x96, 0x69, 0x65, 0x8e, 0x0e, 0xa0, 0xdf, 0xbc,
+	0x87, 0xad, 0x8d, 0xac, 0x62, 0xe5, 0x22, 0x3c, 0x7a, 0x0d, 0x4e, 0xe3, 0x77, 0x8d, 0xf7, 0x40,
+	0x5f, 0x4e, 0x02, 0xb6, 0x87, 0xe0, 0xfb, 0x69, 0xc0, 0x34, 0x6e, 0x81, 0x21, 0x10, 0x75, 0xb8,
+	0x0d, 0xa6, 0x38, 0x9f, 0xbc, 0x0b, 0x98, 0x7e, 0x94, 0x83, 0xd3, 0xf8, 0x7d, 0x43, 0x0f, 0x3d,
+	0xc2, 0xf6, 0xf8, 0x3e, 0x0c, 0xc4, 0xec, 0x87, 0x99, 0x58, 0xcc, 0xae, 0x8a, 0x23, 0x8d, 0x03,
+	0x74, 0x17, 0xfe, 0xe4, 0x62, 0xf1, 0x86, 0x75, 0x38, 0x87, 0x61, 0xe5, 0x2e, 0xcf, 0x74, 0x3e,
+	0x04, 0x38, 0x5b, 0x2e, 0x83, 0x92, 0x6f, 0xf0, 0xe7, 0xc0, 0x2b, 0x4e, 0xe3, 0xdc, 0x3c, 0xfa,
+	0x43, 0x03, 0xd8, 0x0d, 0x07, 0x06, 0xbd, 0xf4, 0x4f, 0xcf, 0xe7, 0x6c, 0x8f, 0xf7, 0xc1, 0x22,
+	0x28, 0x16, 0xa5, 0xe2, 0xe9, 0x78, 0x39, 0x66, 0x1d, 0x44, 0xc1, 0xf9, 0xfc, 0x94, 0xe9, 0x84,
+	0xfc, 0xf9, 0x29, 0x33, 0x10, 0xf9, 0xc1, 0x6c, 0xce, 0x4c, 0xee, 0x40, 0x0f, 0x11, 0x5e, 0xea,
+	0xe2, 0x6b, 0x93, 0x4b, 0x7f, 0x31, 0x63, 0x3d, 0x3e, 0x00, 0xfb, 0x6c, 0x3c, 0x9f, 0x2e, 0xce,
+	0xc6, 0x17, 0x33, 0x66, 0x71, 0x06, 0xfd, 0xda, 0x44, 0xae, 0x8d, 0x17, 0x4f, 0xfc, 0xf9, 0x74,
+	0x3c, 0xb9, 0x60, 0x70, 0xd2, 0xfb, 0xc9, 0xa4, 0xff, 0x02, 0xd7, 0x5d, 0xfa, 0xcd, 0xf9, 0xfa,
+	0x9f, 0x01, 0x00, 0xf9, 0x16, 0x7d, 0x81, 0x59, 0x08, 0x00, 0x00,
 }
@@ -1,18 +1,22 @@
 package proxy
 
 import (
+	"crypto/hmac"
 	"errors"
 	"github.com/esrrhs/go-engine/src/common"
 	"github.com/esrrhs/go-engine/src/conn"
 	"github.com/esrrhs/go-engine/src/group"
 	"github.com/esrrhs/go-engine/src/loggo"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type ClientConn struct {
 	ProxyConn
+	crypt *Crypt
 
 	proxyproto PROXY_PROTO
 	clienttype CLIENT_TYPE
@@ -22,6 +26,15 @@ type ClientConn struct {
 
 	input  *Inputer
 	output *Outputer
+	bond   *bond // the input and output are shared by the bond
+}
+
+// clientKey the bonded conns have the same name
+func (c *ClientConn) clientKey() string {
+	if c.bond != nil {
+		return c.name + "@" + c.conn.Info()
+	}
+	return c.name
 }
 
 type Server struct {
@@ -30,6 +43,10 @@ type Server struct {
 	listenConns []conn.Conn
 	wg          *group.Group
 	clients     sync.Map
+	users       *UserStore
+	bondlock    sync.Mutex
+	bonds       map[string]*bond
+	bondorder   int
 }
 
 func NewServer(config *Config, proto []string, listenaddrs []string) (*Server, error) {
@@ -37,9 +54,30 @@ func NewServer(config *Config, proto []string, listenaddrs []string) (*Server, e
 	if config == nil {
 		config = DefaultConfig()
 	}
+	config.init()
 
 	var listenConns []conn.Conn
 
+	for i, _ := range proto {
+		err := checkProto(proto[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := checkEncrypt(config.Encrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	var users *UserStore
+	if config.UserFile != "" {
+		users, err = NewUserStore(config.UserFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	for i, _ := range proto {
 		conn, err := conn.NewConn(proto[i])
 		if conn == nil {
@@ -67,6 +105,14 @@ func NewServer(config *Config, proto []string, listenaddrs []string) (*Server, e
 		listenaddrs: listenaddrs,
 		listenConns: listenConns,
 		wg:          wg,
+		users:       users,
+		bonds:       make(map[string]*bond),
+	}
+
+	if users != nil {
+		wg.Go("Server reload user", func() error {
+			return users.reload(wg)
+		})
 	}
 
 	for i, _ := range proto {
@@ -90,6 +136,71 @@ func (s *Server) Close() {
 	s.wg.Wait()
 }
 
+// Clients list the clients logined, with their sonny conns
+func (s *Server) Clients() []ClientInfo {
+	var ret []ClientInfo
+	s.clients.Range(func(key, value interface{}) bool {
+		clientconn := value.(*ClientConn)
+		ci := ClientInfo{
+			Name:        clientconn.name,
+			Addr:        clientconn.conn.Info(),
+			User:        clientconn.crypt.user,
+			ClientType:  clientconn.clienttype.String(),
+			ProxyProto:  clientconn.proxyproto.String(),
+			FromAddr:    clientconn.fromaddr,
+			ToAddr:      clientconn.toaddr,
+			Established: clientconn.isEstablished(),
+			Bond:        clientconn.bond != nil,
+			SendSize:    atomic.LoadInt64(&clientconn.sendsize),
+			RecvSize:    atomic.LoadInt64(&clientconn.recvsize),
+		}
+		if clientconn.input != nil {
+			ci.Sonny = sonnyInfo(&clientconn.input.sonny)
+		}
+		if clientconn.output != nil {
+			ci.Sonny = sonnyInfo(&clientconn.output.sonny)
+		}
+		ret = append(ret, ci)
+		return true
+	})
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// Kick close the client and all its sonny conns, the client will reconnect later
+func (s *Server) Kick(name string) error {
+	ok := false
+	s.clients.Range(func(key, value interface{}) bool {
+		clientconn := value.(*ClientConn)
+		if clientconn.name == name {
+			atomic.StoreInt32(&clientconn.needclose, 1)
+			ok = true
+		}
+		return true
+	})
+	if !ok {
+		return errors.New("no client " + name)
+	}
+	loggo.Info("Server Kick %s", name)
+	return nil
+}
+
+func (s *Server) Config() Config {
+	return s.config.load()
+}
+
+// ReloadConfig apply the timeouts, max and compress of config, the others are ignored
+func (s *Server) ReloadConfig(config *Config) error {
+	err := s.config.reload(config)
+	if err != nil {
+		return err
+	}
+	loggo.Info("Server ReloadConfig ok")
+	return nil
+}
+
 func (s *Server) listen(index int) error {
 	loggo.Info("listen start %d %s", index, s.listenaddrs[index])
 	for !s.wg.IsExit() {
@@ -100,7 +211,7 @@ func (s *Server) listen(index int) error {
 		}
 
 		size := s.clientSize()
-		if size >= s.config.MaxClient {
+		if size >= s.config.load().MaxClient {
 			loggo.Info("Server listen max client %s %d", conn.Info(), size)
 			conn.Close()
 			continue
@@ -130,6 +241,17 @@ func (s *Server) serveClient(clientconn *ClientConn) error {
 
 	loggo.Info("serveClient accept new client %s", clientconn.conn.Info())
 
+	crypt, err := serverHandshake(s.wg, clientconn.conn, s.config)
+	if err != nil {
+		loggo.Error("serveClient handshake fail %s %s", clientconn.conn.Info(), err)
+		clientconn.conn.Close()
+		return nil
+	}
+	clientconn.crypt = crypt
+
+	gMetricServerConn.Inc()
+	defer gMetricServerConn.Dec()
+
 	sendch := common.NewChannel(s.config.MainBuffer)
 	recvch := common.NewChannel(s.config.MainBuffer)
 
@@ -141,11 +263,16 @@ func (s *Server) serveClient(clientconn *ClientConn) error {
 		clientconn.conn.Close()
 		sendch.Close()
 		recvch.Close()
-		if clientconn.input != nil {
-			clientconn.input.Close()
-		}
-		if clientconn.output != nil {
-			clientconn.output.Close()
+		if clientconn.bond != nil {
+			// the sonny conns go on in the other conns of the bond
+			clientconn.bond.leave(&clientconn.ProxyConn)
+		} else {
+			if clientconn.input != nil {
+				clientconn.input.Close()
+			}
+			if clientconn.output != nil {
+				clientconn.output.Close()
+			}
 		}
 		loggo.Info("group end exit %s", clientconn.conn.Info())
 	})
@@ -157,19 +284,19 @@ func (s *Server) serveClient(clientconn *ClientConn) error {
 	wg.Go("Server recvFrom"+" "+clientconn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return recvFrom(wg, recvch, clientconn.conn, s.config.MaxMsgSize, s.config.Encrypt)
+		return recvFrom(wg, recvch, clientconn.conn, s.config.MaxMsgSize, crypt)
 	})
 
 	wg.Go("Server sendTo"+" "+clientconn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return sendTo(wg, sendch, clientconn.conn, s.config.Compress, s.config.MaxMsgSize, s.config.Encrypt, &pingflag, &pongflag, &pongtime)
+		return sendTo(wg, sendch, clientconn.conn, s.config, crypt, &pingflag, &pongflag, &pongtime)
 	})
 
 	wg.Go("Server checkPingActive"+" "+clientconn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
-		return checkPingActive(wg, sendch, recvch, &clientconn.ProxyConn, s.config.EstablishedTimeout, s.config.PingInter, s.config.PingTimeoutInter, s.config.ShowPing, &pingflag)
+		return checkPingActive(wg, sendch, recvch, &clientconn.ProxyConn, s.config, &pingflag)
 	})
 
 	wg.Go("Server checkNeedClose"+" "+clientconn.conn.Info(), func() error {
@@ -178,6 +305,14 @@ func (s *Server) serveClient(clientconn *ClientConn) error {
 		return checkNeedClose(wg, &clientconn.ProxyConn)
 	})
 
+	if s.users != nil {
+		wg.Go("Server checkUser"+" "+clientconn.conn.Info(), func() error {
+			atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+			defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+			return s.checkUser(wg, clientconn)
+		})
+	}
+
 	wg.Go("Server process"+" "+clientconn.conn.Info(), func() error {
 		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
 		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
@@ -185,8 +320,11 @@ func (s *Server) serveClient(clientconn *ClientConn) error {
 	})
 
 	wg.Wait()
-	if clientconn.established {
-		s.clients.Delete(clientconn.name)
+	if clientconn.isEstablished() {
+		s.clients.Delete(clientconn.clientKey())
+	}
+	if clientconn.bond != nil {
+		s.closeBond(clientconn.bond)
 	}
 
 	loggo.Info("serveClient close client %s", clientconn.conn.Info())
@@ -204,6 +342,9 @@ func (s *Server) process(wg *group.Group, sendch *common.Channel, recvch *common
 			break
 		}
 		f := ff.(*ProxyFrame)
+		if f.Bondseq > 0 && clientconn.bond != nil && !clientconn.bond.recv(f.Bondseq) {
+			continue
+		}
 		switch f.Type {
 		case FRAME_TYPE_LOGIN:
 			s.processLogin(wg, f, sendch, clientconn)
@@ -212,7 +353,7 @@ func (s *Server) process(wg *group.Group, sendch *common.Channel, recvch *common
 			processPing(f, sendch, &clientconn.ProxyConn, pongflag, pongtime)
 
 		case FRAME_TYPE_PONG:
-			processPong(f, sendch, &clientconn.ProxyConn, s.config.ShowPing)
+			processPong(f, sendch, &clientconn.ProxyConn, s.config.load().ShowPing)
 
 		case FRAME_TYPE_DATA:
 			s.processData(f, clientconn)
@@ -225,6 +366,11 @@ func (s *Server) process(wg *group.Group, sendch *common.Channel, recvch *common
 
 		case FRAME_TYPE_CLOSE:
 			s.processClose(f, clientconn)
+
+		case FRAME_TYPE_BONDACK:
+			if clientconn.bond != nil {
+				clientconn.bond.ack(f.BondAckFrame.Seq)
+			}
 		}
 	}
 	loggo.Info("process end %s", clientconn.conn.Info())
@@ -244,15 +390,18 @@ func (s *Server) processLogin(wg *group.Group, f *ProxyFrame, sendch *common.Cha
 	rf.Type = FRAME_TYPE_LOGINRSP
 	rf.LoginRspFrame = &LoginRspFrame{}
 
-	if f.LoginFrame.Key != s.config.Key {
+	// the same msg for an unknown user and a wrong key
+	key, err := s.userKey(clientconn.crypt.user)
+	if err != nil || !hmac.Equal(f.LoginFrame.Keyproof, clientconn.crypt.keyProof(key, "client")) {
 		rf.LoginRspFrame.Ret = false
-		rf.LoginRspFrame.Msg = "key error"
+		rf.LoginRspFrame.Msg = "auth failed"
 		sendch.Write(rf)
-		loggo.Error("processLogin fail key error %s %s", clientconn.conn.Info(), f.LoginFrame.String())
+		loggo.Error("processLogin fail auth failed %s %s %v", clientconn.conn.Info(), f.LoginFrame.String(), err)
 		return
 	}
+	rf.LoginRspFrame.Keyproof = clientconn.crypt.keyProof(key, "server")
 
-	if clientconn.established {
+	if clientconn.isEstablished() {
 		rf.LoginRspFrame.Ret = false
 		rf.LoginRspFrame.Msg = "has established before"
 		sendch.Write(rf)
@@ -260,7 +409,42 @@ func (s *Server) processLogin(wg *group.Group, f *ProxyFrame, sendch *common.Cha
 		return
 	}
 
-	_, loaded := s.clients.LoadOrStore(f.LoginFrame.Name, clientconn)
+	if s.users != nil {
+		err := s.checkLogin(f, clientconn)
+		if err != nil {
+			rf.LoginRspFrame.Ret = false
+			rf.LoginRspFrame.Msg = err.Error()
+			sendch.Write(rf)
+			loggo.Error("processLogin fail checkLogin %s %s %s", clientconn.conn.Info(), f.LoginFrame.String(), err)
+			return
+		}
+		clientconn.checkOpen = func(toaddr string) (func(), error) {
+			return s.checkOpen(clientconn, toaddr)
+		}
+	}
+
+	if f.LoginFrame.Bond != "" {
+		err := s.joinBond(f, clientconn)
+		if err != nil {
+			rf.LoginRspFrame.Ret = false
+			rf.LoginRspFrame.Msg = err.Error()
+			sendch.Write(rf)
+			loggo.Error("processLogin joinBond fail %s %s %s", clientconn.conn.Info(), f.LoginFrame.String(), err)
+			return
+		}
+		rf.LoginRspFrame.Ret = true
+		rf.LoginRspFrame.Msg = "ok"
+		sendch.Write(rf)
+		loggo.Info("processLogin bond ok %s %s", clientconn.conn.Info(), f.LoginFrame.String())
+		return
+	}
+
+	s.bondlock.Lock()
+	_, loaded := s.bonds[f.LoginFrame.Name]
+	if !loaded {
+		_, loaded = s.clients.LoadOrStore(f.LoginFrame.Name, clientconn)
+	}
+	s.bondlock.Unlock()
 	if loaded {
 		rf.LoginRspFrame.Ret = false
 		rf.LoginRspFrame.Msg = f.LoginFrame.Name + " has login before"
@@ -269,7 +453,7 @@ func (s *Server) processLogin(wg *group.Group, f *ProxyFrame, sendch *common.Cha
 		return
 	}
 
-	err := s.iniService(wg, f, clientconn)
+	clientconn.input, clientconn.output, err = s.iniService(wg, f, &clientconn.ProxyConn)
 	if err != nil {
 		s.clients.Delete(clientconn.name)
 		rf.LoginRspFrame.Ret = false
@@ -279,7 +463,7 @@ func (s *Server) processLogin(wg *group.Group, f *ProxyFrame, sendch *common.Cha
 		return
 	}
 
-	clientconn.established = true
+	atomic.StoreInt32(&clientconn.established, 1)
 
 	rf.LoginRspFrame.Ret = true
 	rf.LoginRspFrame.Msg = "ok"
@@ -288,39 +472,90 @@ func (s *Server) processLogin(wg *group.Group, f *ProxyFrame, sendch *common.Cha
 	loggo.Info("processLogin ok %s %s", clientconn.conn.Info(), f.LoginFrame.String())
 }
 
-func (s *Server) iniService(wg *group.Group, f *ProxyFrame, clientConn *ClientConn) error {
+func (s *Server) iniService(wg *group.Group, f *ProxyFrame, father *ProxyConn) (*Inputer, *Outputer, error) {
 	switch f.LoginFrame.Clienttype {
-	case CLIENT_TYPE_PROXY:
-		output, err := NewOutputer(wg, f.LoginFrame.Proxyproto.String(), f.LoginFrame.Clienttype, s.config, &clientConn.ProxyConn)
-		if err != nil {
-			return err
-		}
-		clientConn.output = output
+	case CLIENT_TYPE_PROXY, CLIENT_TYPE_SOCKS5, CLIENT_TYPE_HTTP_PROXY:
+		output, err := NewOutputer(wg, f.LoginFrame.Proxyproto.String(), f.LoginFrame.Clienttype, s.config, father)
+		return nil, output, err
 	case CLIENT_TYPE_REVERSE_PROXY:
-		input, err := NewInputer(wg, f.LoginFrame.Proxyproto.String(), f.LoginFrame.Fromaddr, f.LoginFrame.Clienttype, s.config, &clientConn.ProxyConn, clientConn.toaddr)
-		if err != nil {
-			return err
+		input, err := NewInputer(wg, f.LoginFrame.Proxyproto.String(), f.LoginFrame.Fromaddr, f.LoginFrame.Clienttype, s.config, father, f.LoginFrame.Toaddr)
+		return input, nil, err
+	case CLIENT_TYPE_REVERSE_SOCKS5:
+		input, err := NewSocks5Inputer(wg, f.LoginFrame.Proxyproto.String(), f.LoginFrame.Fromaddr, f.LoginFrame.Clienttype, s.config, father)
+		return input, nil, err
+	case CLIENT_TYPE_REVERSE_HTTP_PROXY:
+		input, err := NewHttpInputer(wg, f.LoginFrame.Proxyproto.String(), f.LoginFrame.Fromaddr, f.LoginFrame.Clienttype, s.config, father)
+		return input, nil, err
+	default:
+		return nil, nil, errors.New("error CLIENT_TYPE " + strconv.Itoa(int(f.LoginFrame.Clienttype)))
+	}
+}
+
+// joinBond add the conn to the bond of the name, the first conn create the bond and its service
+func (s *Server) joinBond(f *ProxyFrame, clientconn *ClientConn) error {
+	err := checkBond(f.LoginFrame.Bond)
+	if err != nil {
+		return err
+	}
+
+	login := f.LoginFrame.Bond + " " + f.LoginFrame.Clienttype.String() + " " + f.LoginFrame.Proxyproto.String() + " " +
+		f.LoginFrame.Fromaddr + " " + f.LoginFrame.Toaddr + " " + clientconn.crypt.user
+
+	s.bondlock.Lock()
+	defer s.bondlock.Unlock()
+
+	b, ok := s.bonds[f.LoginFrame.Name]
+	if ok {
+		if b.login != login {
+			return errors.New("bond " + f.LoginFrame.Name + " not match")
 		}
-		clientConn.input = input
-	case CLIENT_TYPE_SOCKS5:
-		output, err := NewOutputer(wg, f.LoginFrame.Proxyproto.String(), f.LoginFrame.Clienttype, s.config, &clientConn.ProxyConn)
-		if err != nil {
-			return err
+	} else {
+		_, loaded := s.clients.Load(f.LoginFrame.Name)
+		if loaded {
+			return errors.New(f.LoginFrame.Name + " has login before")
 		}
-		clientConn.output = output
-	case CLIENT_TYPE_REVERSE_SOCKS5:
-		input, err := NewSocks5Inputer(wg, f.LoginFrame.Proxyproto.String(), f.LoginFrame.Fromaddr, f.LoginFrame.Clienttype, s.config, &clientConn.ProxyConn)
+		b = newBond(s.wg, f.LoginFrame.Name, f.LoginFrame.Bond, s.config)
+		b.login = login
+		b.father.checkOpen = clientconn.checkOpen
+		b.input, b.output, err = s.iniService(b.wg, f, &b.father)
 		if err != nil {
-			return err
+			b.wg.Stop()
+			b.wg.Wait()
+			return errors.New("iniService fail")
 		}
-		clientConn.input = input
-	default:
-		return errors.New("error CLIENT_TYPE " + strconv.Itoa(int(f.LoginFrame.Clienttype)))
+		s.bonds[f.LoginFrame.Name] = b
 	}
+
+	clientconn.bond = b
+	clientconn.input = b.input
+	clientconn.output = b.output
+	atomic.StoreInt32(&clientconn.established, 1)
+	s.clients.Store(clientconn.clientKey(), clientconn)
+
+	s.bondorder++
+	b.join(&clientconn.ProxyConn, s.bondorder)
 	return nil
 }
 
+// closeBond close the bond if no conn left
+func (s *Server) closeBond(b *bond) {
+	s.bondlock.Lock()
+	if b.size() > 0 || s.bonds[b.name] != b {
+		s.bondlock.Unlock()
+		return
+	}
+	delete(s.bonds, b.name)
+	s.bondlock.Unlock()
+
+	b.wg.Stop()
+	b.wg.Wait()
+	loggo.Info("closeBond %s", b.name)
+}
+
 func (s *Server) processData(f *ProxyFrame, clientconn *ClientConn) {
+	if clientconn.bond != nil {
+		atomic.AddInt64(&clientconn.recvsize, int64(len(f.DataFrame.Data)))
+	}
 	if clientconn.input != nil {
 		clientconn.input.processDataFrame(f)
 	} else if clientconn.output != nil {
@@ -347,3 +582,89 @@ func (c *Server) processClose(f *ProxyFrame, clientconn *ClientConn) {
 		clientconn.output.processCloseFrame(f)
 	}
 }
+
+func (s *Server) userKey(user string) (string, error) {
+	if s.users == nil {
+		return s.config.Key, nil
+	}
+	u := s.users.Get(user)
+	if u == nil {
+		return "", errors.New("user " + user + " error")
+	}
+	return u.Key, nil
+}
+
+func (s *Server) checkLogin(f *ProxyFrame, clientconn *ClientConn) error {
+	u := s.users.Get(clientconn.crypt.user)
+	if u == nil {
+		return errors.New("user " + clientconn.crypt.user + " error")
+	}
+	err := u.checkLogin(f.LoginFrame.Clienttype, f.LoginFrame.Fromaddr, f.LoginFrame.Toaddr)
+	if err != nil {
+		return err
+	}
+	return s.users.checkQuota(u)
+}
+
+// checkOpen reserve a sonny of the user, the check and the reserve are atomic, call the release when the sonny end
+func (s *Server) checkOpen(clientconn *ClientConn, toaddr string) (func(), error) {
+	u := s.users.Get(clientconn.crypt.user)
+	if u == nil {
+		return nil, errors.New("user " + clientconn.crypt.user + " error")
+	}
+	if !matchAddr(u.ToAddrs, toaddr) {
+		return nil, errors.New("user " + u.Name + " not allowed toaddr " + toaddr)
+	}
+	err := s.users.checkQuota(u)
+	if err != nil {
+		return nil, err
+	}
+	err = s.users.openSonny(u)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		s.users.closeSonny(u.Name)
+	}, nil
+}
+
+func (s *Server) checkUser(wg *group.Group, clientconn *ClientConn) error {
+
+	loggo.Info("checkUser start %s %s", clientconn.conn.Info(), clientconn.crypt.user)
+
+	var sendsize int64
+	var recvsize int64
+	addTraffic := func() {
+		cursend := atomic.LoadInt64(&clientconn.sendsize)
+		currecv := atomic.LoadInt64(&clientconn.recvsize)
+		s.users.AddTraffic(clientconn.crypt.user, cursend-sendsize+currecv-recvsize)
+		sendsize = cursend
+		recvsize = currecv
+	}
+	defer addTraffic()
+
+	for !wg.IsExit() {
+		addTraffic()
+
+		// the unknown user go on with a random key, let the login fail it like a wrong key
+		if !clientconn.isEstablished() {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		u := s.users.Get(clientconn.crypt.user)
+		if u == nil {
+			loggo.Error("checkUser user removed %s %s", clientconn.conn.Info(), clientconn.crypt.user)
+			return errors.New("user removed")
+		}
+		err := s.users.checkQuota(u)
+		if err != nil {
+			loggo.Error("checkUser %s %s", clientconn.conn.Info(), err)
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+
+	loggo.Info("checkUser end %s %s", clientconn.conn.Info(), clientconn.crypt.user)
+	return nil
+}
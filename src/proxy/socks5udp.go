@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/esrrhs/go-engine/src/conn"
+	"github.com/esrrhs/go-engine/src/group"
+	"github.com/esrrhs/go-engine/src/loggo"
+	"github.com/esrrhs/go-engine/src/network"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// socks5UdpAssociate relay the datagrams of one UDP ASSOCIATE, every target has its own sonny
+type socks5UdpAssociate struct {
+	input      *Inputer
+	relay      *net.UDPConn
+	clientip   net.IP
+	clientaddr atomic.Value // *net.UDPAddr
+	sonny      sync.Map     // target -> *socks5UdpConn
+}
+
+// socks5UdpConn is the sonny conn of one target, Read get the datagram from client, Write send the datagram to client
+type socks5UdpConn struct {
+	associate *socks5UdpAssociate
+	target    string
+	recvch    chan []byte
+	die       chan int
+	closeOnce sync.Once
+}
+
+type addrConn interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+func (i *Inputer) processSocks5Udp(proxyConn *ProxyConn) error {
+
+	loggo.Info("processSocks5Udp start %s", proxyConn.conn.Info())
+
+	ac, ok := proxyConn.conn.(addrConn)
+	if !ok {
+		network.Sock5Reply(proxyConn.conn, network.Socks5RepFailure, "0.0.0.0:0")
+		proxyConn.conn.Close()
+		loggo.Error("processSocks5Udp no addr %s", proxyConn.conn.Info())
+		return nil
+	}
+	localip := ac.LocalAddr().(*net.TCPAddr).IP
+	clientip := ac.RemoteAddr().(*net.TCPAddr).IP
+
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: localip})
+	if err != nil {
+		network.Sock5Reply(proxyConn.conn, network.Socks5RepFailure, "0.0.0.0:0")
+		proxyConn.conn.Close()
+		loggo.Error("processSocks5Udp ListenUDP fail %s %s", proxyConn.conn.Info(), err)
+		return nil
+	}
+
+	err = network.Sock5Reply(proxyConn.conn, network.Socks5RepSuccess, relay.LocalAddr().String())
+	if err != nil {
+		relay.Close()
+		proxyConn.conn.Close()
+		loggo.Error("processSocks5Udp Sock5Reply fail %s %s", proxyConn.conn.Info(), err)
+		return nil
+	}
+
+	a := &socks5UdpAssociate{
+		input:    i,
+		relay:    relay,
+		clientip: clientip,
+	}
+
+	wg := group.NewGroup("Inputer processSocks5Udp"+" "+proxyConn.conn.Info(), i.fwg, func() {
+		loggo.Info("group start exit %s", proxyConn.conn.Info())
+		proxyConn.conn.Close()
+		relay.Close()
+		a.sonny.Range(func(key, value interface{}) bool {
+			value.(*socks5UdpConn).Close()
+			return true
+		})
+		loggo.Info("group end exit %s", proxyConn.conn.Info())
+	})
+
+	// the association ends when the tcp conn closed
+	wg.Go("Inputer socks5 udp tcp"+" "+proxyConn.conn.Info(), func() error {
+		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+		buf := make([]byte, 1024)
+		for !wg.IsExit() {
+			_, err := proxyConn.conn.Read(buf)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	wg.Go("Inputer socks5 udp relay"+" "+proxyConn.conn.Info(), func() error {
+		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+		return a.recvFromClient(wg)
+	})
+
+	wg.Wait()
+
+	loggo.Info("processSocks5Udp end %s", proxyConn.conn.Info())
+	return nil
+}
+
+func (a *socks5UdpAssociate) recvFromClient(wg *group.Group) error {
+	buf := make([]byte, a.input.config.MaxMsgSize)
+	for !wg.IsExit() {
+		n, src, err := a.relay.ReadFromUDP(buf)
+		if err != nil {
+			loggo.Info("socks5UdpAssociate ReadFromUDP fail %s", err)
+			return err
+		}
+
+		if !src.IP.Equal(a.clientip) {
+			loggo.Info("socks5UdpAssociate drop not client %s %s", src, a.clientip)
+			continue
+		}
+		a.clientaddr.Store(src)
+
+		target, data, err := network.Sock5ParseUdp(buf[0:n])
+		if err != nil {
+			loggo.Info("socks5UdpAssociate Sock5ParseUdp fail %s %s", src, err)
+			continue
+		}
+		if len(data) <= 0 {
+			continue
+		}
+
+		c := a.getConn(target)
+		if c == nil {
+			continue
+		}
+
+		b := make([]byte, len(data))
+		copy(b, data)
+		select {
+		case c.recvch <- b:
+		case <-c.die:
+		default:
+			loggo.Debug("socks5UdpAssociate drop full %s", target)
+		}
+	}
+	return nil
+}
+
+func (a *socks5UdpAssociate) getConn(target string) *socks5UdpConn {
+	v, ok := a.sonny.Load(target)
+	if ok {
+		return v.(*socks5UdpConn)
+	}
+
+	i := a.input
+	size := i.sonnySize()
+	if size >= i.config.load().MaxSonny {
+		loggo.Info("socks5UdpAssociate max sonny %s %d", target, size)
+		return nil
+	}
+
+	c := &socks5UdpConn{
+		associate: a,
+		target:    target,
+		recvch:    make(chan []byte, i.config.ConnBuffer),
+		die:       make(chan int),
+	}
+	a.sonny.Store(target, c)
+
+	proxyconn := &ProxyConn{conn: c}
+	i.fwg.Go("Inputer processProxyConn"+" "+c.Info(), func() error {
+		atomic.AddInt32(&gStateThreadNum.ThreadNum, 1)
+		defer atomic.AddInt32(&gStateThreadNum.ThreadNum, -1)
+		return i.processProxyConn(proxyconn, target)
+	})
+	return c
+}
+
+func (c *socks5UdpConn) Name() string {
+	return "udp"
+}
+
+func (c *socks5UdpConn) Info() string {
+	return "socks5 udp " + c.associate.relay.LocalAddr().String() + "<-->" + c.target
+}
+
+func (c *socks5UdpConn) Read(p []byte) (n int, err error) {
+	select {
+	case b := <-c.recvch:
+		if len(b) > len(p) {
+			return 0, errors.New("read buffer too small")
+		}
+		return copy(p, b), nil
+	case <-c.die:
+		return 0, errors.New("read closed conn")
+	}
+}
+
+func (c *socks5UdpConn) Write(p []byte) (n int, err error) {
+	select {
+	case <-c.die:
+		return 0, errors.New("write closed conn")
+	default:
+	}
+
+	dst, ok := c.associate.clientaddr.Load().(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("no client addr")
+	}
+	b, err := network.Sock5PackUdp(c.target, p)
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.associate.relay.WriteToUDP(b, dst)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *socks5UdpConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.die)
+		c.associate.sonny.Delete(c.target)
+	})
+	return nil
+}
+
+func (c *socks5UdpConn) Dial(dst string) (conn.Conn, error) {
+	return nil, errors.New("socks5 udp conn can not dial")
+}
+
+func (c *socks5UdpConn) Listen(dst string) (conn.Conn, error) {
+	return nil, errors.New("socks5 udp conn can not listen")
+}
+
+func (c *socks5UdpConn) Accept() (conn.Conn, error) {
+	return nil, errors.New("socks5 udp conn can not accept")
+}
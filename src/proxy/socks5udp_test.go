@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/esrrhs/go-engine/src/network"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test0001Socks5Udp(t *testing.T) {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 58042})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, src, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[0:n], src)
+		}
+	}()
+
+	config := DefaultConfig()
+	server, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58040"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewClient(config, "tcp", "127.0.0.1:58040", "test", "SOCKS5",
+		[]string{"tcp"}, []string{"127.0.0.1:58041"}, []string{""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	time.Sleep(time.Second)
+
+	ctrl, err := net.Dial("tcp", "127.0.0.1:58041")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctrl.Close()
+	err = network.Sock5Handshake(ctrl.(*net.TCPConn), 1000, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ctrl.Write([]byte{0x05, network.Socks5CmdUdpAssociate, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp := make([]byte, 10)
+	_, err = io.ReadFull(ctrl, rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp[1] != network.Socks5RepSuccess {
+		t.Fatal("udp associate fail", rsp[1])
+	}
+	relayaddr, _, err := network.Sock5ParseAddr(rsp[3:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("relay ", relayaddr)
+
+	raddr, _ := net.ResolveUDPAddr("udp", relayaddr)
+	u, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+
+	for i := 0; i < 3; i++ {
+		req, _ := network.Sock5PackUdp("127.0.0.1:58042", []byte(fmt.Sprintf("hello %d", i)))
+		_, err = u.Write(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.SetReadDeadline(time.Now().Add(time.Second * 5))
+		buf := make([]byte, 1024)
+		n, err := u.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		host, data, err := network.Sock5ParseUdp(buf[0:n])
+		fmt.Println("udp echo ", host, string(data), err)
+		if err != nil || host != "127.0.0.1:58042" || string(data) != fmt.Sprintf("hello %d", i) {
+			t.Error("udp echo fail")
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/esrrhs/go-engine/src/common"
+	"github.com/esrrhs/go-engine/src/group"
+	"github.com/esrrhs/go-engine/src/loggo"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User is one account in Config.UserFile, empty or zero limit means no limit
+type User struct {
+	Name        string   // 用户名
+	Key         string   // 连接密码
+	ClientTypes []string // 允许的CLIENT_TYPE
+	FromAddrs   []string // REVERSE模式允许服务器监听的地址，支持通配符
+	ToAddrs     []string // 允许访问的目标地址，支持通配符
+	MaxSonny    int      // 最大连接数目
+	DayQuota    int64    // 每天流量上限，字节，用量只记在内存，服务器重启后清零
+}
+
+type userUsage struct {
+	day  string
+	size int64
+}
+
+// UserStore load users from a json file, and reload it when the file changed.
+// the usage of DayQuota is not saved, a restarted server counts from zero again
+type UserStore struct {
+	file    string
+	lock    sync.RWMutex
+	users   map[string]*User
+	modtime time.Time
+	usage   map[string]*userUsage
+	sonny   map[string]int // 每个用户打开的sonny数目
+}
+
+func NewUserStore(file string) (*UserStore, error) {
+	us := &UserStore{
+		file:  file,
+		users: make(map[string]*User),
+		usage: make(map[string]*userUsage),
+		sonny: make(map[string]int),
+	}
+	err := us.load()
+	if err != nil {
+		return nil, err
+	}
+	return us, nil
+}
+
+func (us *UserStore) load() error {
+	fi, err := os.Stat(us.file)
+	if err != nil {
+		return err
+	}
+
+	var users []*User
+	err = common.LoadJson(us.file, &users)
+	if err != nil {
+		return err
+	}
+
+	tmp := make(map[string]*User)
+	for _, u := range users {
+		if u.Name == "" {
+			return errors.New("user name empty " + us.file)
+		}
+		for _, ct := range u.ClientTypes {
+			_, ok := CLIENT_TYPE_value[strings.ToUpper(ct)]
+			if !ok {
+				return errors.New("user " + u.Name + " no CLIENT_TYPE " + ct)
+			}
+		}
+		tmp[u.Name] = u
+	}
+
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	us.users = tmp
+	us.modtime = fi.ModTime()
+	return nil
+}
+
+func (us *UserStore) reload(wg *group.Group) error {
+	loggo.Info("UserStore reload start %s", us.file)
+	for !wg.IsExit() {
+		fi, err := os.Stat(us.file)
+		if err == nil {
+			us.lock.RLock()
+			changed := !fi.ModTime().Equal(us.modtime)
+			us.lock.RUnlock()
+			if changed {
+				err := us.load()
+				if err != nil {
+					loggo.Error("UserStore reload fail %s %s", us.file, err)
+				} else {
+					loggo.Info("UserStore reload ok %s %d", us.file, us.Size())
+				}
+			}
+		}
+		time.Sleep(time.Second)
+	}
+	loggo.Info("UserStore reload end %s", us.file)
+	return nil
+}
+
+func (us *UserStore) Get(name string) *User {
+	us.lock.RLock()
+	defer us.lock.RUnlock()
+	return us.users[name]
+}
+
+func (us *UserStore) Size() int {
+	us.lock.RLock()
+	defer us.lock.RUnlock()
+	return len(us.users)
+}
+
+func (us *UserStore) AddTraffic(name string, n int64) {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	if _, ok := us.users[name]; !ok {
+		return
+	}
+	us.getUsage(name).size += n
+}
+
+func (us *UserStore) Traffic(name string) int64 {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	return us.getUsage(name).size
+}
+
+func (us *UserStore) getUsage(name string) *userUsage {
+	day := time.Now().Format("2006-01-02")
+	u, ok := us.usage[name]
+	if !ok || u.day != day {
+		u = &userUsage{day: day}
+		us.usage[name] = u
+	}
+	return u
+}
+
+func (us *UserStore) openSonny(u *User) error {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	size := us.sonny[u.Name]
+	if u.MaxSonny > 0 && size >= u.MaxSonny {
+		return errors.New("user " + u.Name + " max sonny " + strconv.Itoa(size))
+	}
+	us.sonny[u.Name] = size + 1
+	return nil
+}
+
+func (us *UserStore) closeSonny(name string) {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	us.sonny[name]--
+	if us.sonny[name] <= 0 {
+		delete(us.sonny, name)
+	}
+}
+
+func (us *UserStore) checkQuota(u *User) error {
+	if u.DayQuota > 0 && us.Traffic(u.Name) >= u.DayQuota {
+		return errors.New("user " + u.Name + " day quota exceeded")
+	}
+	return nil
+}
+
+func (u *User) checkLogin(clienttype CLIENT_TYPE, fromaddr string, toaddr string) error {
+	if len(u.ClientTypes) > 0 {
+		found := false
+		for _, ct := range u.ClientTypes {
+			if strings.ToUpper(ct) == clienttype.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("user " + u.Name + " not allowed " + clienttype.String())
+		}
+	}
+
+	switch clienttype {
+	case CLIENT_TYPE_PROXY:
+		if !matchAddr(u.ToAddrs, toaddr) {
+			return errors.New("user " + u.Name + " not allowed toaddr " + toaddr)
+		}
+	case CLIENT_TYPE_REVERSE_PROXY:
+		if !matchAddr(u.FromAddrs, fromaddr) {
+			return errors.New("user " + u.Name + " not allowed fromaddr " + fromaddr)
+		}
+		if !matchAddr(u.ToAddrs, toaddr) {
+			return errors.New("user " + u.Name + " not allowed toaddr " + toaddr)
+		}
+	case CLIENT_TYPE_REVERSE_SOCKS5, CLIENT_TYPE_REVERSE_HTTP_PROXY:
+		if !matchAddr(u.FromAddrs, fromaddr) {
+			return errors.New("user " + u.Name + " not allowed fromaddr " + fromaddr)
+		}
+	}
+	return nil
+}
+
+func matchAddr(patterns []string, addr string) bool {
+	if len(patterns) <= 0 {
+		return true
+	}
+	for _, p := range patterns {
+		ok, err := path.Match(p, addr)
+		if err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
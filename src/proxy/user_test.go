@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"github.com/esrrhs/go-engine/src/conn"
+	"github.com/esrrhs/go-engine/src/group"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestUsers(t *testing.T, file string, content string) {
+	err := os.WriteFile(file, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test0001User(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.json")
+	writeTestUsers(t, file, `[{"Name":"a","Key":"ka","ClientTypes":["proxy"],"ToAddrs":["127.0.0.1:*"],"DayQuota":100}]`)
+
+	us, err := NewUserStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := us.Get("a")
+	if u == nil || u.Key != "ka" {
+		t.Fatal("load user fail")
+	}
+
+	t.Log(u.checkLogin(CLIENT_TYPE_PROXY, "", "127.0.0.1:80"))
+	if u.checkLogin(CLIENT_TYPE_PROXY, "", "127.0.0.1:80") != nil {
+		t.Error("should allow")
+	}
+	t.Log(u.checkLogin(CLIENT_TYPE_PROXY, "", "10.0.0.1:80"))
+	if u.checkLogin(CLIENT_TYPE_PROXY, "", "10.0.0.1:80") == nil {
+		t.Error("should deny toaddr")
+	}
+	t.Log(u.checkLogin(CLIENT_TYPE_SOCKS5, "", ""))
+	if u.checkLogin(CLIENT_TYPE_SOCKS5, "", "") == nil {
+		t.Error("should deny client type")
+	}
+
+	us.AddTraffic("a", 60)
+	if us.checkQuota(u) != nil {
+		t.Error("quota not exceeded")
+	}
+	us.AddTraffic("a", 60)
+	t.Log(us.checkQuota(u))
+	if us.checkQuota(u) == nil {
+		t.Error("quota should exceeded")
+	}
+	us.AddTraffic("nobody", 60)
+	if len(us.usage) != 1 {
+		t.Error("unknown user traffic should be ignored", len(us.usage))
+	}
+
+	// the MaxSonny check and reserve are atomic
+	u.MaxSonny = 10
+	done := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		go func() {
+			done <- us.openSonny(u)
+		}()
+	}
+	ok := 0
+	for i := 0; i < 100; i++ {
+		if <-done == nil {
+			ok++
+		}
+	}
+	t.Log("openSonny ok ", ok)
+	if ok != u.MaxSonny {
+		t.Error("openSonny over MaxSonny", ok)
+	}
+	us.closeSonny("a")
+	if us.openSonny(u) != nil || us.openSonny(u) == nil {
+		t.Error("closeSonny should release one")
+	}
+
+	wg := group.NewGroup("Test0001User", nil, nil)
+	wg.Go("reload", func() error {
+		return us.reload(wg)
+	})
+	defer func() {
+		wg.Stop()
+		wg.Wait()
+	}()
+
+	writeTestUsers(t, file, `[{"Name":"b","Key":"kb"}]`)
+	os.Chtimes(file, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	time.Sleep(time.Second * 2)
+	if us.Get("a") != nil || us.Get("b") == nil {
+		t.Error("reload fail")
+	}
+
+	// bad file keep the old users
+	writeTestUsers(t, file, `[{"Name":"c","ClientTypes":["xxx"]}]`)
+	os.Chtimes(file, time.Now().Add(time.Minute*2), time.Now().Add(time.Minute*2))
+	time.Sleep(time.Second * 2)
+	if us.Get("b") == nil {
+		t.Error("bad file should keep old users")
+	}
+}
+
+func sendTestLogin(t *testing.T, cc conn.Conn, crypt *Crypt, f *ProxyFrame) *ProxyFrame {
+	mb, err := MarshalSrpFrame(f, 0, crypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bs, uint32(len(mb)))
+	_, err = cc.Write(append(bs, mb...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = io.ReadFull(cc, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := make([]byte, binary.LittleEndian.Uint32(bs))
+	_, err = io.ReadFull(cc, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf, err := UnmarshalSrpFrame(ds, crypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rf
+}
+
+func Test0002User(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.json")
+	writeTestUsers(t, file, `[{"Name":"a","Key":"ka","ClientTypes":["PROXY"],"ToAddrs":["127.0.0.1:*"]}]`)
+
+	config := DefaultConfig()
+	config.UserFile = file
+	server, err := NewServer(config, []string{"tcp"}, []string{"127.0.0.1:58030"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	c, _ := conn.NewConn("tcp")
+	wg := group.NewGroup("Test0002User", nil, nil)
+
+	cconfig := DefaultConfig()
+	cconfig.User = "nobody"
+	cc, err := c.Dial("127.0.0.1:58030")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the unknown user fail like a wrong key, the handshake rsp does not tell it
+	crypt, err := clientHandshake(wg, cc, cconfig)
+	if err != nil {
+		t.Fatal("unknown user handshake should go on", err)
+	}
+	f := &ProxyFrame{}
+	f.Type = FRAME_TYPE_LOGIN
+	f.LoginFrame = &LoginFrame{}
+	f.LoginFrame.Clienttype = CLIENT_TYPE_PROXY
+	f.LoginFrame.Toaddr = "127.0.0.1:80"
+	f.LoginFrame.Name = "test"
+	f.LoginFrame.Keyproof = crypt.keyProof(cconfig.Key, "client")
+	rf := sendTestLogin(t, cc, crypt, f)
+	t.Log("unknown user login ", rf.String())
+	if rf.Type != FRAME_TYPE_LOGINRSP || rf.LoginRspFrame.Ret || rf.LoginRspFrame.Msg != "auth failed" || len(rf.LoginRspFrame.Keyproof) != 0 {
+		t.Error("unknown user login should fail", rf.String())
+	}
+	cc.Close()
+
+	cconfig.User = "a"
+	cconfig.Key = "wrong"
+	cc, err = c.Dial("127.0.0.1:58030")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crypt, err = clientHandshake(wg, cc, cconfig)
+	if err != nil {
+		t.Fatal("wrong key handshake should go on", err)
+	}
+	f.LoginFrame.Keyproof = crypt.keyProof(cconfig.Key, "client")
+	rf2 := sendTestLogin(t, cc, crypt, f)
+	t.Log("wrong key login ", rf2.String())
+	if rf2.Type != rf.Type || rf2.LoginRspFrame.Ret || rf2.LoginRspFrame.Msg != rf.LoginRspFrame.Msg || len(rf2.LoginRspFrame.Keyproof) != 0 {
+		t.Error("wrong key login should fail as the unknown user", rf2.String())
+	}
+	cc.Close()
+
+	cconfig.User = "a"
+	cconfig.Key = "ka"
+	cc, err = c.Dial("127.0.0.1:58030")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	crypt, err = clientHandshake(wg, cc, cconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.LoginFrame.Toaddr = "10.0.0.1:80"
+	f.LoginFrame.Keyproof = crypt.keyProof(cconfig.Key, "client")
+	rf = sendTestLogin(t, cc, crypt, f)
+	t.Log("login ", rf.String())
+	if rf.Type != FRAME_TYPE_LOGINRSP || rf.LoginRspFrame.Ret || !strings.Contains(rf.LoginRspFrame.Msg, "toaddr") {
+		t.Error("login should be denied by toaddr")
+	}
+	// the key is right, so the server proves it has the key too
+	if !hmac.Equal(rf.LoginRspFrame.Keyproof, crypt.keyProof(cconfig.Key, "server")) {
+		t.Error("server keyproof diff")
+	}
+}
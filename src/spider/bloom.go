@@ -0,0 +1,135 @@
+package spider
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+const (
+	DEFAULT_BLOOM_CAPACITY   = 100000
+	DEFAULT_BLOOM_FALSE_RATE = 0.001
+	BLOOM_GROWTH             = 2   // the next filter is BLOOM_GROWTH times bigger
+	BLOOM_TIGHTENING         = 0.5 // the next filter false rate is BLOOM_TIGHTENING times smaller
+)
+
+// BloomFilter is a scalable bloom filter, a new bigger filter is added when the last one is full,
+// so the false rate is kept under the rate however many items are added
+type BloomFilter struct {
+	lock     sync.Mutex
+	filters  []*bloomSlice
+	capacity int
+	rate     float64
+	count    int
+}
+
+type bloomSlice struct {
+	bits     []uint64
+	m        uint64
+	k        int
+	capacity int
+	count    int
+}
+
+func NewBloomFilter(capacity int, rate float64) *BloomFilter {
+	if capacity <= 0 {
+		capacity = DEFAULT_BLOOM_CAPACITY
+	}
+	if rate <= 0 || rate >= 1 {
+		rate = DEFAULT_BLOOM_FALSE_RATE
+	}
+	return &BloomFilter{capacity: capacity, rate: rate}
+}
+
+func newBloomSlice(capacity int, rate float64) *bloomSlice {
+	m := math.Ceil(-float64(capacity) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	k := int(math.Ceil(m / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	n := (uint64(m) + 63) / 64
+	return &bloomSlice{bits: make([]uint64, n), m: n * 64, k: k, capacity: capacity}
+}
+
+func (s *bloomSlice) test(h1 uint64, h2 uint64) bool {
+	for i := 0; i < s.k; i++ {
+		b := (h1 + uint64(i)*h2) % s.m
+		if s.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *bloomSlice) add(h1 uint64, h2 uint64) {
+	for i := 0; i < s.k; i++ {
+		b := (h1 + uint64(i)*h2) % s.m
+		s.bits[b/64] |= 1 << (b % 64)
+	}
+	s.count++
+}
+
+func bloomHash(key string) (uint64, uint64) {
+	a := fnv.New64a()
+	a.Write([]byte(key))
+	b := fnv.New64()
+	b.Write([]byte(key))
+	// odd h2, so the k bits differ
+	return a.Sum64(), b.Sum64() | 1
+}
+
+func (b *BloomFilter) test(h1 uint64, h2 uint64) bool {
+	for _, s := range b.filters {
+		if s.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BloomFilter) add(h1 uint64, h2 uint64) {
+	n := len(b.filters)
+	if n == 0 || b.filters[n-1].count >= b.filters[n-1].capacity {
+		capacity := b.capacity * int(math.Pow(BLOOM_GROWTH, float64(n)))
+		rate := b.rate * math.Pow(BLOOM_TIGHTENING, float64(n+1))
+		b.filters = append(b.filters, newBloomSlice(capacity, rate))
+	}
+	b.filters[len(b.filters)-1].add(h1, h2)
+	b.count++
+}
+
+// Test return false if the key is not added for sure, true if it may be added
+func (b *BloomFilter) Test(key string) bool {
+	h1, h2 := bloomHash(key)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.test(h1, h2)
+}
+
+func (b *BloomFilter) Add(key string) {
+	h1, h2 := bloomHash(key)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.test(h1, h2) {
+		b.add(h1, h2)
+	}
+}
+
+// TestAndAdd add the key, and return what Test return before
+func (b *BloomFilter) TestAndAdd(key string) bool {
+	h1, h2 := bloomHash(key)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.test(h1, h2) {
+		return true
+	}
+	b.add(h1, h2)
+	return false
+}
+
+// Count return the number of the keys added, the keys maybe added are not counted
+func (b *BloomFilter) Count() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.count
+}
@@ -0,0 +1,164 @@
+package spider
+
+import (
+	"bytes"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	CHARSET_META_SCAN   = 4096 // the meta charset is looked for in the head bytes
+	CHARSET_GUESS_SCAN  = 16384
+	CHARSET_GUESS_SCORE = 0.1 // the guessed charset is used only when its score is higher
+)
+
+var gMetaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-zA-Z0-9_:.\-]+)`)
+
+// the charsets to guess, and the most frequent characters of its language
+var gCharsetGuess = []struct {
+	name     string
+	enc      encoding.Encoding
+	frequent string
+	kana     bool
+}{
+	{"gb18030", simplifiedchinese.GB18030, "的一是不了在人有我他这个们中来上大为和国地到以说时要就出会可也你对生能而子那得于着下自之年过发后作里用道行所然家种事成方多经么去法学如都同现当没动面起看定天分还进好小部其些主样理心", false},
+	{"big5", traditionalchinese.Big5, "的一是不了在人有我他這個們中來上大為和國地到以說時要就出會可也你對生能而子那得於著下自之年過發後作裡用道行所然家種事成方多經麼去法學如都同現當沒動面起看定天分還進好小部其些主樣理心", false},
+	{"shift_jis", japanese.ShiftJIS, "日本人年大中出一国会上事時生行見者分前手自言", true},
+	{"euc-kr", korean.EUCKR, "이다의는에을하가고한지서로들기나리어도사자있수것정그대시아인보해게만라부주일전면요우내와니경상개문과제적국", false},
+}
+
+// toUTF8 convert the body to utf-8, by the BOM, the charset of the Content-Type header, the meta charset,
+// and the statistic at last. return the body and the charset
+func toUTF8(body []byte, contenttype string) ([]byte, string) {
+	enc, name := detectCharset(body, contenttype)
+	if enc == nil || name == "utf-8" {
+		return bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), "utf-8"
+	}
+	ret, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body, "utf-8"
+	}
+	return ret, name
+}
+
+func detectCharset(body []byte, contenttype string) (encoding.Encoding, string) {
+	switch {
+	case bytes.HasPrefix(body, []byte("\xef\xbb\xbf")):
+		return unicode.UTF8, "utf-8"
+	case bytes.HasPrefix(body, []byte("\xfe\xff")):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM), "utf-16be"
+	case bytes.HasPrefix(body, []byte("\xff\xfe")):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM), "utf-16le"
+	}
+
+	if _, params, err := mime.ParseMediaType(contenttype); err == nil {
+		if enc, name := lookupCharset(params["charset"]); enc != nil {
+			return enc, name
+		}
+	}
+
+	head := body
+	if len(head) > CHARSET_META_SCAN {
+		head = head[:CHARSET_META_SCAN]
+	}
+	if m := gMetaCharset.FindSubmatch(head); m != nil {
+		if enc, name := lookupCharset(string(m[1])); enc != nil {
+			// the page is not in utf-16 if the meta can be read
+			if !strings.HasPrefix(name, "utf-16") {
+				return enc, name
+			}
+		}
+	}
+
+	return guessCharset(body)
+}
+
+func lookupCharset(label string) (encoding.Encoding, string) {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if label == "" {
+		return nil, ""
+	}
+	// the superset, the pages declared gb2312 often use gbk characters
+	if label == "gb2312" || label == "gbk" || label == "x-gbk" {
+		return simplifiedchinese.GB18030, "gb18030"
+	}
+	return charset.Lookup(label)
+}
+
+// guessCharset decode the body by each charset, the one with the most frequent characters wins
+func guessCharset(body []byte) (encoding.Encoding, string) {
+	if len(body) > CHARSET_GUESS_SCAN {
+		body = body[:CHARSET_GUESS_SCAN]
+		// drop the partial rune at the end
+		for i := len(body) - 1; i >= 0 && i > len(body)-4; i-- {
+			if utf8.RuneStart(body[i]) {
+				body = body[:i]
+				break
+			}
+		}
+	}
+
+	ascii := true
+	for _, c := range body {
+		if c >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii || utf8.Valid(body) {
+		return unicode.UTF8, "utf-8"
+	}
+
+	var best encoding.Encoding
+	bestname := ""
+	bestscore := CHARSET_GUESS_SCORE
+	for _, g := range gCharsetGuess {
+		score := charsetScore(body, g.enc, g.frequent, g.kana)
+		if score > bestscore {
+			best, bestname, bestscore = g.enc, g.name, score
+		}
+	}
+	if best == nil {
+		return charmap.Windows1252, "windows-1252"
+	}
+	return best, bestname
+}
+
+// charsetScore is the ratio of the frequent characters in the non-ascii ones, the bad ones count against it
+func charsetScore(body []byte, enc encoding.Encoding, frequent string, kana bool) float64 {
+	s, err := enc.NewDecoder().String(string(body))
+	if err != nil {
+		return 0
+	}
+	total := 0
+	good := 0
+	bad := 0
+	for _, r := range s {
+		if r < 0x80 {
+			continue
+		}
+		total++
+		switch {
+		case r == utf8.RuneError || (r >= 0xe000 && r <= 0xf8ff):
+			bad++
+		case kana && r >= 0x3040 && r <= 0x30ff:
+			good++
+		case strings.ContainsRune(frequent, r):
+			good++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(good-bad*4) / float64(total)
+}
@@ -0,0 +1,206 @@
+package spider
+
+import (
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/esrrhs/go-engine/src/common"
+	"github.com/esrrhs/go-engine/src/loggo"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	EXTRACT_SELECTOR = "selector" // the SelectorRule to PageInfo.Fields
+	EXTRACT_META     = "meta"     // the OpenGraph, twitter and the other meta to PageInfo.Meta, the JSON-LD to PageInfo.JSONLD
+	EXTRACT_TEXT     = "text"     // the main text to PageInfo.Text, like readability
+	EXTRACT_FILETYPE = "filetype" // the PageLinkInfo.FileType of the links to the files
+)
+
+// Extractor fill the PageInfo from the doc, after the title and the links, before Content.Crawl
+type Extractor interface {
+	Extract(pg *PageInfo, doc *goquery.Document) error
+}
+
+type ExtractorFactory func(config *Config) (Extractor, error)
+
+var gExtractorFactory = common.NewRegistry[ExtractorFactory]("extractor")
+
+func init() {
+	RegisterExtractor(EXTRACT_SELECTOR, newSelectorExtractor)
+	RegisterExtractor(EXTRACT_META, newMetaExtractor)
+	RegisterExtractor(EXTRACT_TEXT, newTextExtractor)
+	RegisterExtractor(EXTRACT_FILETYPE, newFileTypeExtractor)
+}
+
+// RegisterExtractor add a extractor to the spider, it runs when its name is in Config.Extractors.
+// call it before Start, the factory is called once by every Start
+func RegisterExtractor(name string, factory ExtractorFactory) error {
+	if factory == nil {
+		return errors.New("nil extractor factory " + name)
+	}
+	return gExtractorFactory.Register(name, factory)
+}
+
+// NewExtractors create the Config.Extractors in order
+func NewExtractors(config *Config) ([]Extractor, error) {
+	var ret []Extractor
+	for _, name := range config.Extractors {
+		factory, err := gExtractorFactory.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		e, err := factory(config)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+// extract run the extractors, one fail do not stop the others
+func extract(pg *PageInfo, doc *goquery.Document, ctx *Content) {
+	for _, e := range ctx.extractors {
+		err := e.Extract(pg, doc)
+		if err != nil {
+			loggo.Info("spider extract fail %v %v", pg.UI.Url, err)
+		}
+	}
+}
+
+type SelectorRule struct {
+	Name     string // the key of PageInfo.Fields
+	Selector string // the css selector
+	Attr     string // the attr of the elements, empty for the text
+	URL      string // the regexp of the page url to use the rule, empty for all
+}
+
+type selectorRule struct {
+	SelectorRule
+	url *regexp.Regexp
+}
+
+type selectorExtractor struct {
+	rules []selectorRule
+}
+
+// newSelectorExtractor use the Config.SelectorRules and the rules in the json file Config.SelectorFile
+func newSelectorExtractor(config *Config) (Extractor, error) {
+	rules := append([]SelectorRule{}, config.SelectorRules...)
+	if config.SelectorFile != "" {
+		var filerules []SelectorRule
+		err := common.LoadJson(config.SelectorFile, &filerules)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, filerules...)
+	}
+
+	e := &selectorExtractor{}
+	for _, r := range rules {
+		if r.Name == "" || r.Selector == "" {
+			return nil, errors.New("selector rule no name or selector")
+		}
+		sr := selectorRule{SelectorRule: r}
+		if r.URL != "" {
+			re, err := regexp.Compile(r.URL)
+			if err != nil {
+				return nil, err
+			}
+			sr.url = re
+		}
+		e.rules = append(e.rules, sr)
+	}
+	return e, nil
+}
+
+func (e *selectorExtractor) Extract(pg *PageInfo, doc *goquery.Document) error {
+	for _, r := range e.rules {
+		if r.url != nil && !r.url.MatchString(pg.UI.Url) {
+			continue
+		}
+		doc.Find(r.Selector).Each(func(i int, s *goquery.Selection) {
+			var v string
+			if r.Attr != "" {
+				attr, ok := s.Attr(r.Attr)
+				if !ok {
+					return
+				}
+				v = attr
+			} else {
+				v = s.Text()
+			}
+			v = strings.TrimSpace(v)
+			if v == "" {
+				return
+			}
+			if pg.Fields == nil {
+				pg.Fields = make(map[string][]string)
+			}
+			pg.Fields[r.Name] = append(pg.Fields[r.Name], v)
+		})
+	}
+	return nil
+}
+
+var gFileType = map[string]string{
+	".torrent": "torrent",
+	".pdf":     "pdf",
+	".doc":     "doc",
+	".docx":    "doc",
+	".xls":     "xls",
+	".xlsx":    "xls",
+	".ppt":     "ppt",
+	".pptx":    "ppt",
+	".txt":     "txt",
+	".epub":    "ebook",
+	".mobi":    "ebook",
+	".zip":     "archive",
+	".rar":     "archive",
+	".7z":      "archive",
+	".gz":      "archive",
+	".tar":     "archive",
+	".iso":     "image",
+	".exe":     "app",
+	".apk":     "app",
+	".dmg":     "app",
+	".msi":     "app",
+	".jpg":     "picture",
+	".jpeg":    "picture",
+	".png":     "picture",
+	".gif":     "picture",
+	".webp":    "picture",
+	".mp3":     "audio",
+	".flac":    "audio",
+	".mp4":     "video",
+	".mkv":     "video",
+	".avi":     "video",
+}
+
+type fileTypeExtractor struct {
+}
+
+func newFileTypeExtractor(config *Config) (Extractor, error) {
+	return &fileTypeExtractor{}, nil
+}
+
+func (e *fileTypeExtractor) Extract(pg *PageInfo, doc *goquery.Document) error {
+	for i := range pg.Son {
+		pg.Son[i].FileType = fileType(pg.Son[i].UI.Url)
+	}
+	return nil
+}
+
+// fileType return the type of the file the link to, empty for the page
+func fileType(href string) string {
+	if strings.HasPrefix(strings.ToLower(href), "magnet:") {
+		return "magnet"
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	return gFileType[strings.ToLower(path.Ext(u.Path))]
+}
@@ -0,0 +1,77 @@
+package spider
+
+import (
+	"encoding/json"
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+)
+
+// the meta names kept besides og: and twitter:
+var gMetaName = map[string]bool{
+	"description": true,
+	"keywords":    true,
+	"author":      true,
+}
+
+type metaExtractor struct {
+}
+
+func newMetaExtractor(config *Config) (Extractor, error) {
+	return &metaExtractor{}, nil
+}
+
+func (e *metaExtractor) Extract(pg *PageInfo, doc *goquery.Document) error {
+	doc.Find("meta").Each(func(i int, s *goquery.Selection) {
+		key, ok := s.Attr("property")
+		if !ok {
+			key, ok = s.Attr("name")
+		}
+		if !ok {
+			return
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !strings.HasPrefix(key, "og:") && !strings.HasPrefix(key, "twitter:") && !gMetaName[key] {
+			return
+		}
+		content, ok := s.Attr("content")
+		content = strings.TrimSpace(content)
+		if !ok || content == "" {
+			return
+		}
+		if pg.Meta == nil {
+			pg.Meta = make(map[string]string)
+		}
+		// the first one, as the title
+		if _, ok := pg.Meta[key]; !ok {
+			pg.Meta[key] = content
+		}
+	})
+
+	var err error
+	doc.Find(`script[type="application/ld+json"]`).Each(func(i int, s *goquery.Selection) {
+		var v interface{}
+		e := json.Unmarshal([]byte(s.Text()), &v)
+		if e != nil {
+			err = e
+			return
+		}
+		pg.JSONLD = appendJSONLD(pg.JSONLD, v)
+	})
+	return err
+}
+
+// appendJSONLD flatten the arrays and the @graph
+func appendJSONLD(ret []map[string]interface{}, v interface{}) []map[string]interface{} {
+	switch x := v.(type) {
+	case []interface{}:
+		for _, o := range x {
+			ret = appendJSONLD(ret, o)
+		}
+	case map[string]interface{}:
+		if graph, ok := x["@graph"]; ok {
+			return appendJSONLD(ret, graph)
+		}
+		ret = append(ret, x)
+	}
+	return ret
+}
@@ -0,0 +1,380 @@
+package spider
+
+import (
+	"crypto/tls"
+	"github.com/esrrhs/go-engine/src/loggo"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_USER_AGENT    = "Mozilla/5.0 (compatible; go-engine-spider/1.0)"
+	DEFAULT_ROBOTS_EXPIRE = 3600
+	DEFAULT_ROBOTS_RETRY  = 60
+	DEFAULT_MAX_RETRY     = 600
+	ROBOTS_MAX_SIZE       = 512 * 1024
+	HOSTS_PRUNE_SIZE      = 1024
+)
+
+// the result of Allowed
+const (
+	ROBOTS_ALLOWED = iota
+	ROBOTS_DISALLOWED
+	ROBOTS_UNREACHABLE
+)
+
+// Politeness decides when a url can be crawled, by robots.txt, the rate and the inflight of each host and the Retry-After
+type Politeness struct {
+	config    Config
+	stat      *Stat
+	lock      sync.Mutex
+	cond      *sync.Cond
+	hosts     map[string]*hostState
+	nextPrune int
+	client    *http.Client
+	now       func() time.Time
+}
+
+type hostState struct {
+	robots        *robotsRules
+	robotsFail    bool
+	robotsExpire  time.Time
+	robotsLoading chan int
+	tokens        float64
+	last          time.Time
+	inflight      int
+	blockUntil    time.Time
+	backoff       int
+}
+
+func NewPoliteness(config Config, stat *Stat) *Politeness {
+	if config.UserAgent == "" {
+		config.UserAgent = DEFAULT_USER_AGENT
+	}
+	if config.RobotsExpire <= 0 {
+		config.RobotsExpire = DEFAULT_ROBOTS_EXPIRE
+	}
+	if config.RobotsRetry <= 0 {
+		config.RobotsRetry = DEFAULT_ROBOTS_RETRY
+	}
+	if config.RobotsRetry > config.RobotsExpire {
+		config.RobotsRetry = config.RobotsExpire
+	}
+	if config.MaxRetryAfter <= 0 {
+		config.MaxRetryAfter = DEFAULT_MAX_RETRY
+	}
+	if config.HostBurst <= 0 {
+		config.HostBurst = 1
+	}
+	timeout := config.CrawlTimeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+	p := &Politeness{
+		config:    config,
+		stat:      stat,
+		hosts:     make(map[string]*hostState),
+		nextPrune: HOSTS_PRUNE_SIZE,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+			Timeout: time.Duration(timeout) * time.Second,
+		},
+		now: time.Now,
+	}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+func (p *Politeness) UserAgent() string {
+	return p.config.UserAgent
+}
+
+// host must be called with the lock
+func (p *Politeness) host(u *url.URL) *hostState {
+	key := strings.ToLower(u.Scheme + "://" + u.Host)
+	h, ok := p.hosts[key]
+	if !ok {
+		if len(p.hosts) >= p.nextPrune {
+			p.prune()
+		}
+		h = &hostState{tokens: float64(p.config.HostBurst), last: p.now()}
+		p.hosts[key] = h
+	}
+	return h
+}
+
+// prune drop the idle hosts whose robots.txt is expired, must be called with the lock
+func (p *Politeness) prune() {
+	now := p.now()
+	for key, h := range p.hosts {
+		if h.inflight > 0 || h.robotsLoading != nil || now.Before(h.robotsExpire) || now.Before(h.blockUntil) {
+			continue
+		}
+		// the tokens are full again
+		if now.Sub(h.last) < p.interval(h)*time.Duration(p.config.HostBurst) {
+			continue
+		}
+		delete(p.hosts, key)
+	}
+	p.nextPrune = len(p.hosts) * 2
+	if p.nextPrune < HOSTS_PRUNE_SIZE {
+		p.nextPrune = HOSTS_PRUNE_SIZE
+	}
+}
+
+// Allowed check the robots.txt of the host, fetch it if not cached.
+// ROBOTS_UNREACHABLE means the robots.txt can not be fetched now, all is disallowed until it is retried after RobotsRetry, see WaitRobots
+func (p *Politeness) Allowed(rawurl string) int {
+	if p.config.IgnoreRobots {
+		return ROBOTS_ALLOWED
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return ROBOTS_DISALLOWED
+	}
+	rules, ok := p.robots(u)
+	if !ok {
+		return ROBOTS_UNREACHABLE
+	}
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if !rules.Allowed(path) {
+		p.lock.Lock()
+		p.stat.RobotsDenyNum++
+		p.lock.Unlock()
+		loggo.Info("spider robots deny %v", rawurl)
+		return ROBOTS_DISALLOWED
+	}
+	return ROBOTS_ALLOWED
+}
+
+// WaitRobots wait until the unreachable robots.txt of the host can be fetched again
+func (p *Politeness) WaitRobots(rawurl string) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return
+	}
+	p.lock.Lock()
+	h := p.host(u)
+	wait := h.robotsExpire.Sub(p.now())
+	p.lock.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+func (p *Politeness) robots(u *url.URL) (*robotsRules, bool) {
+	p.lock.Lock()
+	h := p.host(u)
+	for {
+		if h.robots != nil && p.now().Before(h.robotsExpire) {
+			rules, ok := h.robots, !h.robotsFail
+			p.lock.Unlock()
+			return rules, ok
+		}
+		if h.robotsLoading == nil {
+			break
+		}
+		// someone is fetching it
+		loading := h.robotsLoading
+		p.lock.Unlock()
+		<-loading
+		p.lock.Lock()
+	}
+	loading := make(chan int)
+	h.robotsLoading = loading
+	p.stat.RobotsFetchNum++
+	p.lock.Unlock()
+
+	rules, ok := p.fetchRobots(u.Scheme + "://" + u.Host + "/robots.txt")
+
+	p.lock.Lock()
+	expire := p.config.RobotsExpire
+	if !ok {
+		p.stat.RobotsFailNum++
+		expire = p.config.RobotsRetry
+	}
+	h.robots = rules
+	h.robotsFail = !ok
+	h.robotsExpire = p.now().Add(time.Duration(expire) * time.Second)
+	h.robotsLoading = nil
+	close(loading)
+	p.lock.Unlock()
+	return rules, ok
+}
+
+// fetchRobots allow all if no robots.txt (4xx), and disallow all if it is unreachable (5xx or fail) as RFC 9309,
+// the result is cached for RobotsExpire, and the unreachable one for RobotsRetry
+func (p *Politeness) fetchRobots(robotsurl string) (*robotsRules, bool) {
+	req, err := http.NewRequest("GET", robotsurl, nil)
+	if err != nil {
+		return disallowAllRobots(), false
+	}
+	req.Header.Set("User-Agent", p.config.UserAgent)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		loggo.Info("spider robots fetch fail %v %v", robotsurl, err)
+		return disallowAllRobots(), false
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 && res.StatusCode < 500 {
+		return &robotsRules{}, true
+	}
+	if res.StatusCode != 200 {
+		loggo.Info("spider robots fetch StatusCode fail %v %v", robotsurl, res.StatusCode)
+		return disallowAllRobots(), false
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(res.Body, ROBOTS_MAX_SIZE))
+	if err != nil {
+		loggo.Info("spider robots read fail %v %v", robotsurl, err)
+		return disallowAllRobots(), false
+	}
+	return parseRobots(content, p.config.UserAgent), true
+}
+
+func disallowAllRobots() *robotsRules {
+	return &robotsRules{rules: []robotsRule{{allow: false, path: "/"}}}
+}
+
+// interval is the min time between two requests to a host
+func (p *Politeness) interval(h *hostState) time.Duration {
+	var d time.Duration
+	if p.config.HostQPS > 0 {
+		d = time.Duration(float64(time.Second) / p.config.HostQPS)
+	}
+	if h.robots != nil && h.robots.delay > d {
+		d = h.robots.delay
+	}
+	return d
+}
+
+// Acquire wait until the host can be requested, call Release after
+func (p *Politeness) Acquire(rawurl string) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return
+	}
+
+	b := p.now()
+	waited := false
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	h := p.host(u)
+	for {
+		now := p.now()
+		var wait time.Duration
+		if now.Before(h.blockUntil) {
+			wait = h.blockUntil.Sub(now)
+		} else if p.config.HostMaxInflight > 0 && h.inflight >= p.config.HostMaxInflight {
+			waited = true
+			p.cond.Wait()
+			continue
+		} else {
+			interval := p.interval(h)
+			if interval <= 0 {
+				break
+			}
+			h.tokens = math.Min(float64(p.config.HostBurst), h.tokens+float64(now.Sub(h.last))/float64(interval))
+			h.last = now
+			if h.tokens >= 1 {
+				h.tokens--
+				break
+			}
+			wait = time.Duration((1 - h.tokens) * float64(interval))
+		}
+
+		waited = true
+		p.lock.Unlock()
+		time.Sleep(wait)
+		p.lock.Lock()
+	}
+
+	h.inflight++
+	if waited {
+		p.stat.PoliteWaitNum++
+		p.stat.PoliteWaitTime += int64(p.now().Sub(b))
+	}
+}
+
+// Release record the response, block the host by 429 or 503, status 0 for no response
+func (p *Politeness) Release(rawurl string, status int, header http.Header) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	h := p.host(u)
+	h.inflight--
+	p.cond.Broadcast()
+
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		d, ok := parseRetryAfter(header, p.now())
+		if !ok {
+			if status != http.StatusTooManyRequests {
+				return
+			}
+			d = time.Second << uint(h.backoff)
+			if h.backoff < 16 {
+				h.backoff++
+			}
+		}
+		max := time.Duration(p.config.MaxRetryAfter) * time.Second
+		if d > max {
+			d = max
+		}
+		until := p.now().Add(d)
+		if until.After(h.blockUntil) {
+			h.blockUntil = until
+		}
+		p.stat.RetryAfterNum++
+		loggo.Info("spider host block %v %v %v", u.Host, status, d)
+	} else if status > 0 && status < 400 {
+		h.backoff = 0
+	}
+}
+
+// parseRetryAfter parse the seconds or the http date
+func parseRetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if header == nil {
+		return 0, false
+	}
+	v := strings.TrimSpace(header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	sec, err := strconv.Atoi(v)
+	if err == nil {
+		if sec < 0 {
+			sec = 0
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	d := t.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
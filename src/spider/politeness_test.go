@@ -0,0 +1,263 @@
+package spider
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test0001Robots(t *testing.T) {
+	content := []byte(`
+# comment
+User-agent: *
+Disallow: /
+
+User-agent: go-engine-spider
+User-agent: other
+Disallow: /private
+Allow: /private/ok
+Disallow: /*.pdf$
+Disallow: /a*b
+Crawl-delay: 1.5
+
+User-agent: go-engine-spider-pro
+Disallow:
+`)
+	r := parseRobots(content, DEFAULT_USER_AGENT)
+	fmt.Println(r)
+	cases := map[string]bool{
+		"/":                true,
+		"/private":         false,
+		"/private/a":       false,
+		"/private/ok":      true,
+		"/private/ok/a":    true,
+		"/x.pdf":           false,
+		"/x.pdf?a=1":       true,
+		"/a/b":             false,
+		"/ab":              false,
+		"/ba":              true,
+		"/robots.txt":      true,
+		"/public/index.ht": true,
+	}
+	for path, allow := range cases {
+		if r.Allowed(path) != allow {
+			t.Error("robots diff", path, allow)
+		}
+	}
+	if r.delay != time.Millisecond*1500 {
+		t.Error("crawl delay diff", r.delay)
+	}
+
+	if parseRobots(content, "some bot").Allowed("/a") {
+		t.Error("* group not used")
+	}
+	if !parseRobots(content, "go-engine-spider-pro").Allowed("/private") {
+		t.Error("longest agent not used")
+	}
+	if !parseRobots(nil, "bot").Allowed("/a") {
+		t.Error("empty robots should allow")
+	}
+}
+
+func Test0002Politeness(t *testing.T) {
+	var robotsnum int32
+	var ua atomic.Value
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			atomic.AddInt32(&robotsnum, 1)
+			ua.Store(r.Header.Get("User-Agent"))
+			time.Sleep(time.Millisecond * 100)
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\nCrawl-delay: 0.2\n")
+			return
+		}
+		w.WriteHeader(404)
+	}))
+	defer s.Close()
+
+	stat := &Stat{}
+	pl := NewPoliteness(Config{UserAgent: "test-bot"}, stat)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if pl.Allowed(s.URL+"/a") != ROBOTS_ALLOWED {
+				t.Error("should allow")
+			}
+		}()
+	}
+	wg.Wait()
+	if pl.Allowed(s.URL+"/private?a=1") != ROBOTS_DISALLOWED {
+		t.Error("should deny")
+	}
+	if robotsnum != 1 || stat.RobotsFetchNum != 1 || stat.RobotsDenyNum != 1 || ua.Load() != "test-bot" {
+		t.Error("robots fetch diff", robotsnum, stat, ua.Load())
+	}
+
+	// the crawl-delay of robots.txt
+	b := time.Now()
+	for i := 0; i < 3; i++ {
+		pl.Acquire(s.URL + "/a")
+		pl.Release(s.URL+"/a", 200, nil)
+	}
+	fmt.Println(time.Since(b))
+	if time.Since(b) < time.Millisecond*380 || stat.PoliteWaitNum != 2 {
+		t.Error("crawl delay not kept", time.Since(b), stat.PoliteWaitNum)
+	}
+
+	// robots.txt unreachable
+	pl = NewPoliteness(Config{}, stat)
+	if pl.Allowed("http://127.0.0.1:1/a") != ROBOTS_UNREACHABLE || stat.RobotsFailNum != 1 {
+		t.Error("robots fail should disallow", stat.RobotsFailNum)
+	}
+	pl = NewPoliteness(Config{IgnoreRobots: true}, stat)
+	if pl.Allowed(s.URL+"/private") != ROBOTS_ALLOWED || stat.RobotsFetchNum != 2 {
+		t.Error("ignore robots diff")
+	}
+}
+
+func Test0003Politeness(t *testing.T) {
+	stat := &Stat{}
+	pl := NewPoliteness(Config{IgnoreRobots: true, HostMaxInflight: 1, HostQPS: 100, MaxRetryAfter: 1}, stat)
+
+	pl.Acquire("http://a.com/1")
+	done := make(chan int)
+	go func() {
+		pl.Acquire("http://a.com/2")
+		close(done)
+	}()
+	// other hosts are not affected
+	pl.Acquire("http://b.com/1")
+	pl.Release("http://b.com/1", 200, nil)
+	select {
+	case <-done:
+		t.Error("max inflight not kept")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// the Retry-After is limited by MaxRetryAfter
+	header := http.Header{}
+	header.Set("Retry-After", "2")
+	b := time.Now()
+	pl.Release("http://a.com/1", 429, header)
+	<-done
+	pl.Release("http://a.com/2", 200, nil)
+	fmt.Println(time.Since(b))
+	if time.Since(b) < time.Millisecond*800 || time.Since(b) > time.Millisecond*1500 || stat.RetryAfterNum != 1 {
+		t.Error("retry after diff", time.Since(b), stat.RetryAfterNum)
+	}
+
+	// 503 without Retry-After does not block
+	pl.Acquire("http://a.com/3")
+	pl.Release("http://a.com/3", 503, nil)
+	b = time.Now()
+	pl.Acquire("http://a.com/4")
+	pl.Release("http://a.com/4", 200, nil)
+	if time.Since(b) > time.Millisecond*100 || stat.RetryAfterNum != 1 {
+		t.Error("503 diff", time.Since(b))
+	}
+
+	// 429 without Retry-After backs off
+	pl.Acquire("http://b.com/2")
+	pl.Release("http://b.com/2", 429, nil)
+	b = time.Now()
+	pl.Acquire("http://b.com/3")
+	pl.Release("http://b.com/3", 200, nil)
+	if time.Since(b) < time.Millisecond*800 || stat.RetryAfterNum != 2 {
+		t.Error("429 backoff diff", time.Since(b))
+	}
+
+	d, ok := parseRetryAfter(http.Header{"Retry-After": []string{"Wed, 21 Oct 2015 07:28:10 GMT"}},
+		time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC))
+	if !ok || d != time.Second*10 {
+		t.Error("retry after date diff", d)
+	}
+}
+
+func Test0004SimpleCrawl(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "test-bot" {
+			w.WriteHeader(403)
+			return
+		}
+		if r.URL.Path == "/busy" {
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(429)
+			return
+		}
+		fmt.Fprint(w, `<html><head><title>t</title></head><body><a href="/a">a</a></body></html>`)
+	}))
+	defer s.Close()
+
+	ctx := &Content{
+		Crawl: func(pg *PageInfo, doc *goquery.Document) *PageInfo {
+			return pg
+		},
+	}
+	pg, status, _ := simplecrawl(&URLInfo{s.URL + "/", 0}, 5, ctx, "test-bot")
+	if pg == nil || status != 200 || pg.Title != "t" || len(pg.Son) != 1 {
+		t.Error("crawl diff", pg, status)
+	}
+	pg, status, header := simplecrawl(&URLInfo{s.URL + "/busy", 0}, 5, ctx, "test-bot")
+	if pg != nil || status != 429 || header.Get("Retry-After") != "5" {
+		t.Error("crawl 429 diff", status)
+	}
+}
+
+func Test0005Politeness(t *testing.T) {
+	var status int32 = 503
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+			return
+		}
+	}))
+	defer s.Close()
+
+	stat := &Stat{}
+	pl := NewPoliteness(Config{RobotsExpire: 60, RobotsRetry: 10}, stat)
+	now := time.Now()
+	pl.now = func() time.Time {
+		return now
+	}
+
+	// 5xx is unreachable, disallow all until RobotsRetry
+	if pl.Allowed(s.URL+"/a") != ROBOTS_UNREACHABLE {
+		t.Error("5xx robots should be unreachable")
+	}
+	atomic.StoreInt32(&status, 404)
+	if pl.Allowed(s.URL+"/a") != ROBOTS_UNREACHABLE {
+		t.Error("5xx robots should be cached")
+	}
+	now = now.Add(time.Second * 11)
+	if pl.Allowed(s.URL+"/a") != ROBOTS_ALLOWED {
+		t.Error("4xx robots should allow after retry")
+	}
+	fmt.Println(stat.RobotsFetchNum, stat.RobotsFailNum)
+	if stat.RobotsFetchNum != 2 || stat.RobotsFailNum != 1 {
+		t.Error("robots fetch diff", stat.RobotsFetchNum, stat.RobotsFailNum)
+	}
+
+	// the idle hosts are dropped after the robots.txt expire
+	for i := 0; i < HOSTS_PRUNE_SIZE-1; i++ {
+		pl.Acquire("http://host" + strconv.Itoa(i) + "/a")
+		pl.Release("http://host"+strconv.Itoa(i)+"/a", 200, nil)
+	}
+	pl.Acquire(s.URL + "/a")
+	now = now.Add(time.Second * 61)
+	pl.Acquire("http://new/a")
+	pl.Release("http://new/a", 200, nil)
+	fmt.Println(len(pl.hosts))
+	if len(pl.hosts) != 2 || pl.hosts[strings.ToLower(s.URL)] == nil {
+		t.Error("hosts not pruned", len(pl.hosts))
+	}
+	pl.Release(s.URL+"/a", 200, nil)
+}
@@ -61,6 +61,13 @@ func puppeteercrawl(ui *URLInfo, crawlTimeout int, ctx *Content) *PageInfo {
 		}
 	})
 
+	doc.Find("link[rel=canonical]").Each(func(i int, s *goquery.Selection) {
+		href, ok := s.Attr("href")
+		if ok && pg.Canonical == "" {
+			pg.Canonical = strings.TrimSpace(href)
+		}
+	})
+
 	// Find the items
 	doc.Find("a").Each(func(i int, s *goquery.Selection) {
 		// For each item found, get the band and title
@@ -78,12 +85,14 @@ func puppeteercrawl(ui *URLInfo, crawlTimeout int, ctx *Content) *PageInfo {
 			//loggo.Info("puppeteer crawl link %v %v %v %v", i, pg.Title, name, href)
 
 			if len(href) > 0 {
-				pgl := PageLinkInfo{URLInfo{href, ui.Deps + 1}, name}
+				pgl := PageLinkInfo{UI: URLInfo{href, ui.Deps + 1}, Name: name}
 				pg.Son = append(pg.Son, pgl)
 			}
 		}
 	})
 
+	extract(pg, doc, ctx)
+
 	pg = ctx.Crawl(pg, doc)
 
 	//if len(pg.Son) == 0 {
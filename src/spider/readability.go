@@ -0,0 +1,134 @@
+package spider
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const TEXT_MIN_PARAGRAPH = 25 // the shorter paragraphs are not scored
+
+var gTextPositive = regexp.MustCompile(`(?i)article|content|main|post|text|body|entry|story`)
+var gTextNegative = regexp.MustCompile(`(?i)comment|sidebar|footer|menu|nav|share|related|banner|sponsor|\bad\b|ads`)
+
+var gTextBlock = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "pre": true, "blockquote": true, "tr": true, "section": true, "article": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// textExtractor find the main text like readability, the parents of the paragraphs are scored by the text length and
+// the commas, the best one is the main text
+type textExtractor struct {
+}
+
+func newTextExtractor(config *Config) (Extractor, error) {
+	return &textExtractor{}, nil
+}
+
+func (e *textExtractor) Extract(pg *PageInfo, doc *goquery.Document) error {
+	body := doc.Find("body").First()
+	if body.Length() == 0 {
+		body = doc.Selection
+	}
+	// do not change the doc, Content.Crawl use it later
+	body = body.Clone()
+	body.Find("script,style,noscript,iframe,nav,header,footer,aside,form,svg").Remove()
+
+	score := make(map[*html.Node]float64)
+	var best *html.Node
+	body.Find("p,pre,td,blockquote").Each(func(i int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		n := utf8.RuneCountInString(text)
+		if n < TEXT_MIN_PARAGRAPH {
+			return
+		}
+		sc := 1 + float64(strings.Count(text, ",")+strings.Count(text, "，")) + math.Min(float64(n/100), 3)
+
+		node := s.Nodes[0]
+		for level, div := 0, 1.0; level < 2 && node.Parent != nil; level, div = level+1, div*2 {
+			node = node.Parent
+			if _, ok := score[node]; !ok {
+				score[node] = classScore(node)
+			}
+			score[node] += sc / div
+		}
+	})
+
+	bestScore := 0.0
+	for node, sc := range score {
+		sc = sc * (1 - linkDensity(node))
+		if best == nil || sc > bestScore {
+			best = node
+			bestScore = sc
+		}
+	}
+
+	if best == nil {
+		if len(body.Nodes) == 0 {
+			return nil
+		}
+		best = body.Nodes[0]
+	}
+
+	var sb strings.Builder
+	nodeText(best, &sb)
+	var lines []string
+	for _, l := range strings.Split(sb.String(), "\n") {
+		l = strings.Join(strings.Fields(l), " ")
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	pg.Text = strings.Join(lines, "\n")
+	return nil
+}
+
+func classScore(node *html.Node) float64 {
+	sc := 0.0
+	for _, a := range node.Attr {
+		if a.Key != "class" && a.Key != "id" {
+			continue
+		}
+		if gTextNegative.MatchString(a.Val) {
+			sc -= 25
+		}
+		if gTextPositive.MatchString(a.Val) {
+			sc += 25
+		}
+	}
+	return sc
+}
+
+func linkDensity(node *html.Node) float64 {
+	s := goquery.NewDocumentFromNode(node).Selection
+	total := utf8.RuneCountInString(s.Text())
+	if total == 0 {
+		return 0
+	}
+	link := 0
+	s.Find("a").Each(func(i int, a *goquery.Selection) {
+		link += utf8.RuneCountInString(a.Text())
+	})
+	return float64(link) / float64(total)
+}
+
+// nodeText is the text of the node, a new line for each block
+func nodeText(node *html.Node, sb *strings.Builder) {
+	if node.Type == html.TextNode {
+		sb.WriteString(node.Data)
+		return
+	}
+	block := node.Type == html.ElementNode && gTextBlock[node.Data]
+	if block {
+		sb.WriteString("\n")
+	}
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		nodeText(c, sb)
+	}
+	if block {
+		sb.WriteString("\n")
+	}
+}
@@ -0,0 +1,143 @@
+package spider
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type robotsRule struct {
+	allow bool
+	path  string
+}
+
+// robotsRules is the group of robots.txt for our User-Agent
+type robotsRules struct {
+	rules []robotsRule
+	delay time.Duration
+}
+
+type robotsGroup struct {
+	agents []string
+	robotsRules
+}
+
+// parseRobots pick the group of the longest User-Agent matched, or the * group
+func parseRobots(content []byte, useragent string) *robotsRules {
+	useragent = strings.ToLower(useragent)
+
+	var groups []*robotsGroup
+	var cur *robotsGroup
+	lastAgent := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			// the continuous User-Agent lines share one group
+			if !lastAgent || cur == nil {
+				cur = &robotsGroup{}
+				groups = append(groups, cur)
+			}
+			cur.agents = append(cur.agents, strings.ToLower(value))
+			lastAgent = true
+			continue
+		case "allow", "disallow":
+			if cur != nil && (value != "" || key == "allow") {
+				cur.rules = append(cur.rules, robotsRule{allow: key == "allow", path: value})
+			}
+		case "crawl-delay":
+			if cur != nil {
+				f, err := strconv.ParseFloat(value, 64)
+				if err == nil && f > 0 {
+					cur.delay = time.Duration(f * float64(time.Second))
+				}
+			}
+		}
+		lastAgent = false
+	}
+
+	var star *robotsGroup
+	var best *robotsGroup
+	bestLen := 0
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if a == "*" {
+				if star == nil {
+					star = g
+				}
+			} else if a != "" && strings.Contains(useragent, a) && len(a) > bestLen {
+				best = g
+				bestLen = len(a)
+			}
+		}
+	}
+	if best == nil {
+		best = star
+	}
+	if best == nil {
+		return &robotsRules{}
+	}
+	return &best.robotsRules
+}
+
+// Allowed check the path with the query, the longest rule matched wins, Allow wins the tie
+func (r *robotsRules) Allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+
+	allow := true
+	matchLen := -1
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.path, path) {
+			continue
+		}
+		if len(rule.path) > matchLen || (len(rule.path) == matchLen && rule.allow) {
+			allow = rule.allow
+			matchLen = len(rule.path)
+		}
+	}
+	return allow
+}
+
+// robotsMatch match the prefix pattern, * for any chars and $ for the end
+func robotsMatch(pattern string, path string) bool {
+	end := strings.HasSuffix(pattern, "$")
+	if end {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	cur := len(parts[0])
+	for i := 1; i < len(parts); i++ {
+		if i == len(parts)-1 && end {
+			return strings.HasSuffix(path[cur:], parts[i])
+		}
+		j := strings.Index(path[cur:], parts[i])
+		if j < 0 {
+			return false
+		}
+		cur += j + len(parts[i])
+	}
+	return !end || cur == len(path)
+}
@@ -0,0 +1,181 @@
+package spider
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	SAVE_JSONL = "jsonl"
+	SAVE_CSV   = "csv"
+)
+
+// the columns of the PageInfo in csv, the map and the slice are in json
+var gPageInfoCSVHead = []string{"url", "deps", "title", "canonical", "text", "meta", "jsonld", "fields", "links"}
+
+// FileSaver write the results to a file, one line each, appended to the old one
+type FileSaver struct {
+	lock   sync.Mutex
+	file   *os.File
+	format string
+	csv    *csv.Writer
+	head   bool
+}
+
+// NewFileSaver open the file, format is SAVE_JSONL or SAVE_CSV, empty for the ext of the filename
+func NewFileSaver(filename string, format string) (*FileSaver, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+		if format == "json" {
+			format = SAVE_JSONL
+		}
+	}
+	format = strings.ToLower(format)
+	if format != SAVE_JSONL && format != SAVE_CSV {
+		return nil, errors.New("undefined save format " + format)
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	fs := &FileSaver{file: f, format: format}
+	if format == SAVE_CSV {
+		fs.csv = csv.NewWriter(f)
+		// append to the old file, the head is there
+		fs.head = fi.Size() > 0
+	}
+	return fs, nil
+}
+
+// Save write the result, the *PageInfo, the struct, the map[string]string or the []string for csv, any for jsonl
+func (fs *FileSaver) Save(result interface{}) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if fs.format == SAVE_JSONL {
+		b, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		_, err = fs.file.Write(append(b, '\n'))
+		return err
+	}
+
+	head, record, err := csvRecord(result)
+	if err != nil {
+		return err
+	}
+	if !fs.head && head != nil {
+		err = fs.csv.Write(head)
+		if err != nil {
+			return err
+		}
+	}
+	fs.head = true
+	err = fs.csv.Write(record)
+	if err != nil {
+		return err
+	}
+	fs.csv.Flush()
+	return fs.csv.Error()
+}
+
+func (fs *FileSaver) Close() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.file.Close()
+}
+
+// csvRecord return the head and the record, nil head for the []string
+func csvRecord(result interface{}) ([]string, []string, error) {
+	switch x := result.(type) {
+	case *PageInfo:
+		return gPageInfoCSVHead, pageInfoRecord(x), nil
+	case PageInfo:
+		return gPageInfoCSVHead, pageInfoRecord(&x), nil
+	case []string:
+		return nil, x, nil
+	case map[string]string:
+		var head []string
+		for k := range x {
+			head = append(head, k)
+		}
+		sort.Strings(head)
+		record := make([]string, len(head))
+		for i, k := range head {
+			record[i] = x[k]
+		}
+		return head, record, nil
+	}
+
+	v := reflect.ValueOf(result)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, nil, errors.New("can not save to csv " + v.Kind().String())
+	}
+	var head []string
+	var record []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		head = append(head, t.Field(i).Name)
+		record = append(record, csvValue(v.Field(i).Interface()))
+	}
+	return head, record, nil
+}
+
+func pageInfoRecord(pg *PageInfo) []string {
+	return []string{
+		pg.UI.Url,
+		strconv.Itoa(pg.UI.Deps),
+		pg.Title,
+		pg.Canonical,
+		pg.Text,
+		csvValue(pg.Meta),
+		csvValue(pg.JSONLD),
+		csvValue(pg.Fields),
+		csvValue(pg.Son),
+	}
+}
+
+func csvValue(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case fmt.Stringer:
+		return x.String()
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Ptr:
+		if rv.Kind() != reflect.Struct && rv.IsNil() {
+			return ""
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
@@ -1,16 +1,21 @@
 package spider
 
 import (
+	"bytes"
 	"crypto/tls"
 	"github.com/PuerkitoBio/goquery"
-	"github.com/axgle/mahonia"
 	"github.com/esrrhs/go-engine/src/loggo"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
 )
 
-func simplecrawl(ui *URLInfo, crawlTimeout int, ctx *Content) *PageInfo {
+const PAGE_MAX_SIZE = 10 * 1024 * 1024
+
+// simplecrawl return the status and the header for the politeness, status 0 for no response
+func simplecrawl(ui *URLInfo, crawlTimeout int, ctx *Content, useragent string) (*PageInfo, int, http.Header) {
 
 	url := ui.Url
 	loggo.Info("start simple crawl %v", url)
@@ -24,49 +29,62 @@ func simplecrawl(ui *URLInfo, crawlTimeout int, ctx *Content) *PageInfo {
 	}
 	defer client.CloseIdleConnections()
 
-	res, err := client.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		loggo.Info("simple crawl http NewRequest fail %v %v", url, err)
+		return nil, 0, nil
+	}
+	if useragent != "" {
+		req.Header.Set("User-Agent", useragent)
+	}
+
+	res, err := client.Do(req)
 	if err != nil {
 		loggo.Info("simple crawl http Get fail %v %v", url, err)
-		return nil
+		return nil, 0, nil
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
 		loggo.Info("simple crawl http StatusCode fail %v %v", url, res.StatusCode)
-		return nil
+		return nil, res.StatusCode, res.Header
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, PAGE_MAX_SIZE))
+	if err != nil {
+		loggo.Info("simple crawl http read body fail %v %v", url, err)
+		return nil, res.StatusCode, res.Header
+	}
+
+	body, cs := toUTF8(body, res.Header.Get("Content-Type"))
+	if cs != "utf-8" {
+		loggo.Info("simple crawl charset %v %v", url, cs)
 	}
 
 	// Load the HTML document
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
 		loggo.Info("simple crawl http NewDocumentFromReader fail %v %v", url, err)
-		return nil
+		return nil, res.StatusCode, res.Header
 	}
 
-	gb2312 := false
-	doc.Find("META").Each(func(i int, s *goquery.Selection) {
-		content, ok := s.Attr("content")
-		if ok {
-			if strings.Contains(content, "gb2312") {
-				gb2312 = true
-			}
-		}
-	})
-
 	pg := &PageInfo{}
 	pg.UI = *ui
 	doc.Find("title").Each(func(i int, s *goquery.Selection) {
 		if pg.Title == "" {
 			pg.Title = s.Text()
 			pg.Title = strings.TrimSpace(pg.Title)
-			if gb2312 {
-				enc := mahonia.NewDecoder("gbk")
-				pg.Title = enc.ConvertString(pg.Title)
-			}
 			//loggo.Info("simple simple crawl title %v", pg.Title)
 		}
 	})
 
+	doc.Find("link[rel=canonical]").Each(func(i int, s *goquery.Selection) {
+		href, ok := s.Attr("href")
+		if ok && pg.Canonical == "" {
+			pg.Canonical = strings.TrimSpace(href)
+		}
+	})
+
 	// Find the items
 	doc.Find("a").Each(func(i int, s *goquery.Selection) {
 		// For each item found, get the band and title
@@ -76,20 +94,17 @@ func simplecrawl(ui *URLInfo, crawlTimeout int, ctx *Content) *PageInfo {
 			href = strings.TrimSpace(href)
 			name = strings.TrimSpace(name)
 			name = strings.Replace(name, "\n", " ", -1)
-			if gb2312 {
-				enc := mahonia.NewDecoder("gbk")
-				href = enc.ConvertString(href)
-				name = enc.ConvertString(name)
-			}
 			//loggo.Info("simple simple crawl link %v %v %v %v", i, pg.Title, name, href)
 
 			if len(href) > 0 {
-				pgl := PageLinkInfo{URLInfo{href, ui.Deps + 1}, name}
+				pgl := PageLinkInfo{UI: URLInfo{href, ui.Deps + 1}, Name: name}
 				pg.Son = append(pg.Son, pgl)
 			}
 		}
 	})
 
+	extract(pg, doc, ctx)
+
 	pg = ctx.Crawl(pg, doc)
 
 	//if len(pg.Son) == 0 {
@@ -97,5 +112,5 @@ func simplecrawl(ui *URLInfo, crawlTimeout int, ctx *Content) *PageInfo {
 	//	loggo.Info("simple simple crawl no link %v html:\n%v", url, html)
 	//}
 
-	return pg
+	return pg, res.StatusCode, res.Header
 }
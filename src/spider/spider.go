@@ -6,6 +6,7 @@ import (
 	"github.com/esrrhs/go-engine/src/loggo"
 	"github.com/esrrhs/go-engine/src/shell"
 	"math"
+	"net/http"
 	"net/url"
 	"runtime"
 	"strings"
@@ -22,17 +23,45 @@ type Config struct {
 	Crawlfunc    string // simple,puppeteer
 	CrawlTimeout int
 	CrawlRetry   int
+
+	UserAgent       string  // for simple crawl and robots.txt, empty for DEFAULT_USER_AGENT
+	IgnoreRobots    bool    // do not fetch and follow robots.txt
+	RobotsExpire    int     // seconds to cache robots.txt, 0 for DEFAULT_ROBOTS_EXPIRE
+	RobotsRetry     int     // seconds to retry an unreachable robots.txt, 0 for DEFAULT_ROBOTS_RETRY, no more than RobotsExpire
+	HostQPS         float64 // requests per second to each host, 0 for no limit, the Crawl-delay of robots.txt is kept too
+	HostBurst       int     // the requests can be sent at once to each host after idle, 0 for 1
+	HostMaxInflight int     // max requests in flight to each host, 0 for no limit
+	MaxRetryAfter   int     // max seconds to stop a host by Retry-After or 429, 0 for DEFAULT_MAX_RETRY
+
+	StripParams    []string // query params dropped when normalizing the urls, * at the end for the prefix, nil for DEFAULT_STRIP_PARAMS
+	NoBloom        bool     // do not use the bloom filter in front of the job and done db
+	BloomCapacity  int      // the capacity of the first bloom filter, it grows when full, 0 for DEFAULT_BLOOM_CAPACITY
+	BloomFalseRate float64  // 0 for DEFAULT_BLOOM_FALSE_RATE
+
+	Extractors    []string       // run in order on each page, such as EXTRACT_META, see RegisterExtractor
+	SelectorRules []SelectorRule // for EXTRACT_SELECTOR
+	SelectorFile  string         // the json file of more []SelectorRule for EXTRACT_SELECTOR
+	SaveFile      string         // write the results of Content.Parse to the file too, empty for none
+	SaveFormat    string         // SAVE_JSONL or SAVE_CSV, empty for the ext of SaveFile
 }
 
 type PageLinkInfo struct {
-	UI   URLInfo
-	Name string
+	UI       URLInfo
+	Name     string
+	FileType string // by EXTRACT_FILETYPE, such as torrent and pdf, empty for the page
 }
 
 type PageInfo struct {
-	UI    URLInfo
-	Title string
-	Son   []PageLinkInfo
+	UI        URLInfo
+	Title     string
+	Son       []PageLinkInfo
+	Canonical string // the href of link rel=canonical, maybe relative
+
+	// filled by the Config.Extractors
+	Meta   map[string]string        `json:",omitempty"` // EXTRACT_META, the key is og:title, twitter:card, description and so on
+	JSONLD []map[string]interface{} `json:",omitempty"` // EXTRACT_META
+	Text   string                   `json:",omitempty"` // EXTRACT_TEXT
+	Fields map[string][]string      `json:",omitempty"` // EXTRACT_SELECTOR, the key is SelectorRule.Name
 }
 
 type URLInfo struct {
@@ -91,6 +120,36 @@ func getChrome() {
 	}
 }
 
+func (config *Config) stripParams() []string {
+	if config.StripParams == nil {
+		return DEFAULT_STRIP_PARAMS
+	}
+	return config.StripParams
+}
+
+// isNewJob check the url is neither in the job db nor the done db, the bloom filter saves the db round-trips
+func isNewJob(bf *BloomFilter, jbd *JobDB, dbd *DoneDB, url string, stat *Stat) bool {
+	if bf != nil && !bf.TestAndAdd(url) {
+		stat.BloomSkipNum++
+		return true
+	}
+	if hasDone(dbd, url, stat) || hasJob(jbd, url, stat) {
+		return false
+	}
+	if bf != nil {
+		stat.BloomFalseNum++
+	}
+	return true
+}
+
+func isDone(bf *BloomFilter, dbd *DoneDB, url string, stat *Stat) bool {
+	if bf != nil && !bf.Test(url) {
+		stat.BloomSkipNum++
+		return false
+	}
+	return hasDone(dbd, url, stat)
+}
+
 func startChrome() {
 	defer common.CrashLog()
 
@@ -114,6 +173,13 @@ type Stat struct {
 	CrawOKTotalTime int64
 	CrawOKAvgTime   int64
 
+	RobotsFetchNum int
+	RobotsFailNum  int
+	RobotsDenyNum  int
+	PoliteWaitNum  int
+	PoliteWaitTime int64
+	RetryAfterNum  int
+
 	ParseChannelNum int
 	ParseNum        int
 	ParseValidNum   int
@@ -121,9 +187,17 @@ type Stat struct {
 	ParseFinishNum  int
 	ParseTooDeepNum int
 	ParseJobNum     int
+	ParseBadURLNum  int
+	ParseDupNum     int // the pages skipped, for the rel=canonical is crawled
+	ParseFileNum    int // the links to the files, not crawled
+
+	BloomSkipNum  int // the db checks skipped by the bloom filter
+	BloomFalseNum int // the bloom filter said maybe, but the db said no
 
 	SaveChannelNum int
 	SaveNum        int
+	SaveFileNum    int
+	SaveFileFail   int
 
 	InsertNum       int64
 	InsertTotalTime int64
@@ -152,7 +226,9 @@ type Content struct {
 	Conn  int
 	Crawl func(pg *PageInfo, doc *goquery.Document) *PageInfo
 	Parse func(hosturl string, pg *PageInfo, save chan<- interface{}) bool
-	Save  func(result interface{})
+	Save  func(result interface{}) // nil for only the Config.SaveFile
+
+	extractors []Extractor
 }
 
 func Start(ctx *Content, config Config, url string, stat *Stat) {
@@ -172,10 +248,23 @@ func Start(ctx *Content, config Config, url string, stat *Stat) {
 		return
 	}
 
+	var bf *BloomFilter
+
 	old := getJobSize(jbd)
 	if old == 0 {
-		insertSpiderJob(jbd, url, 0, stat)
+		start := url
+		n, err := NormalizeURL(nil, url, config.stripParams())
+		if err == nil {
+			start = n
+		}
+		insertSpiderJob(jbd, start, 0, stat)
 		deleteSpiderDone(dbd)
+
+		// the urls left in the db are not in the bloom filter, so only use it when start from the beginning
+		if !config.NoBloom {
+			bf = NewBloomFilter(config.BloomCapacity, config.BloomFalseRate)
+			bf.Add(start)
+		}
 	}
 
 	old = getJobSize(jbd)
@@ -184,6 +273,23 @@ func Start(ctx *Content, config Config, url string, stat *Stat) {
 		return
 	}
 
+	exs, err := NewExtractors(&config)
+	if err != nil {
+		loggo.Error("Spider NewExtractors fail %v %v", url, err)
+		return
+	}
+	ctx.extractors = exs
+
+	var fs *FileSaver
+	if config.SaveFile != "" {
+		fs, err = NewFileSaver(config.SaveFile, config.SaveFormat)
+		if err != nil {
+			loggo.Error("Spider NewFileSaver fail %v %v", config.SaveFile, err)
+			return
+		}
+		defer fs.Close()
+	}
+
 	crawl := make(chan *URLInfo, config.Buffersize)
 	parse := make(chan *PageInfo, config.Buffersize)
 	save := make(chan interface{}, config.Buffersize)
@@ -204,12 +310,14 @@ func Start(ctx *Content, config Config, url string, stat *Stat) {
 	var wg sync.WaitGroup
 	var running int32
 
+	pl := NewPoliteness(config, stat)
+
 	for i := 0; i < config.Threadnum; i++ {
 		wg.Add(3)
 		go Crawler(&running, &wg, jbd, dbd, config, crawl, parse, &jobsCrawlerTotal, &jobsCrawlerFail,
-			config.Crawlfunc, config.CrawlTimeout, config.CrawlRetry, stat, ctx)
-		go Parser(&running, &wg, jbd, dbd, config, crawl, parse, save, url, stat, ctx)
-		go Saver(&running, &wg, save, stat, ctx)
+			config.Crawlfunc, config.CrawlTimeout, config.CrawlRetry, stat, ctx, pl)
+		go Parser(&running, &wg, jbd, dbd, config, crawl, parse, save, url, stat, ctx, bf)
+		go Saver(&running, &wg, save, stat, ctx, fs)
 	}
 
 	for {
@@ -235,9 +343,12 @@ func Start(ctx *Content, config Config, url string, stat *Stat) {
 
 	loggo.Info("Spider jobs done %v crawl %v, failed %v", url, jobsCrawlerTotal, jobsCrawlerFail)
 
-	crawl <- nil
-	parse <- nil
-	save <- nil
+	// one nil for each goroutine
+	for i := 0; i < config.Threadnum; i++ {
+		crawl <- nil
+		parse <- nil
+		save <- nil
+	}
 	wg.Wait()
 
 	close(crawl)
@@ -254,7 +365,8 @@ func Start(ctx *Content, config Config, url string, stat *Stat) {
 }
 
 func Crawler(running *int32, group *sync.WaitGroup, jbd *JobDB, dbd *DoneDB, config Config, crawl <-chan *URLInfo, parse chan<- *PageInfo,
-	jobsCrawlerTotal *int32, jobsCrawlerTotalFail *int32, crawlfunc string, crawlTimeout int, crawlRetry int, stat *Stat, ctx *Content) {
+	jobsCrawlerTotal *int32, jobsCrawlerTotalFail *int32, crawlfunc string, crawlTimeout int, crawlRetry int, stat *Stat, ctx *Content,
+	pl *Politeness) {
 	defer common.CrashLog()
 
 	defer group.Done()
@@ -271,8 +383,23 @@ func Crawler(running *int32, group *sync.WaitGroup, jbd *JobDB, dbd *DoneDB, con
 
 		ok := hasDone(dbd, job.Url, stat)
 		if !ok {
-			insertSpiderDone(dbd, job.Url, stat)
+			// wait for the unreachable robots.txt, and do not mark the url done if it is still unreachable, so it is not lost for the next run
+			allow := ROBOTS_DISALLOWED
 			if job.Deps < config.Deps {
+				allow = pl.Allowed(job.Url)
+				for t := 0; t < crawlRetry && allow == ROBOTS_UNREACHABLE; t++ {
+					pl.WaitRobots(job.Url)
+					allow = pl.Allowed(job.Url)
+				}
+			}
+			if allow == ROBOTS_UNREACHABLE {
+				stat.CrawFailNum++
+				atomic.AddInt32(jobsCrawlerTotalFail, 1)
+				loggo.Info("crawl job robots unreachable %v", job.Url)
+			} else {
+				insertSpiderDone(dbd, job.Url, stat)
+			}
+			if allow == ROBOTS_ALLOWED {
 				atomic.AddInt32(jobsCrawlerTotal, 1)
 				var pg *PageInfo
 				b := time.Now()
@@ -280,11 +407,15 @@ func Crawler(running *int32, group *sync.WaitGroup, jbd *JobDB, dbd *DoneDB, con
 				stat.CrawFunc = crawlfunc
 				for t := 0; t < crawlRetry; t++ {
 					stat.CrawRetrtyNum++
+					pl.Acquire(job.Url)
+					status := 0
+					var header http.Header
 					if crawlfunc == "simple" {
-						pg = simplecrawl(job, crawlTimeout, ctx)
+						pg, status, header = simplecrawl(job, crawlTimeout, ctx, pl.UserAgent())
 					} else if crawlfunc == "puppeteer" {
 						pg = puppeteercrawl(job, crawlTimeout, ctx)
 					}
+					pl.Release(job.Url, status, header)
 					if pg != nil {
 						break
 					}
@@ -307,7 +438,7 @@ func Crawler(running *int32, group *sync.WaitGroup, jbd *JobDB, dbd *DoneDB, con
 }
 
 func Parser(running *int32, group *sync.WaitGroup, jbd *JobDB, dbd *DoneDB, config Config, crawl chan<- *URLInfo, parse <-chan *PageInfo, save chan<- interface{},
-	hosturl string, stat *Stat, ctx *Content) {
+	hosturl string, stat *Stat, ctx *Content, bf *BloomFilter) {
 	defer common.CrashLog()
 
 	defer group.Done()
@@ -333,100 +464,71 @@ func Parser(running *int32, group *sync.WaitGroup, jbd *JobDB, dbd *DoneDB, conf
 
 		stat.ParseValidNum++
 
+		strip := config.stripParams()
+
+		if job.Canonical != "" {
+			canonical, err := NormalizeURL(srcURL, job.Canonical, strip)
+			if err == nil && canonical != job.UI.Url {
+				if isDone(bf, dbd, canonical, stat) {
+					loggo.Info("parse skip dup page %v %v", job.UI.Url, canonical)
+					stat.ParseDupNum++
+					atomic.AddInt32(running, -1)
+					continue
+				}
+				// so the canonical one is not crawled again
+				insertSpiderDone(dbd, canonical, stat)
+				if bf != nil {
+					bf.Add(canonical)
+				}
+			}
+		}
+
 		ok := ctx.Parse(hosturl, job, save)
 		if ok {
 			stat.ParseFinishNum++
 		}
 
 		for _, s := range job.Son {
-			sonurl := s.UI.Url
-
 			stat.ParseSpawnNum++
 
-			if strings.HasPrefix(sonurl, "#") {
-				continue
-			}
-
-			if sonurl == "/" {
-				continue
-			}
-
-			if strings.Contains(sonurl, "javascript:") {
+			if s.FileType != "" {
+				stat.ParseFileNum++
 				continue
 			}
 
-			ss := strings.ToLower(sonurl)
-
 			if s.UI.Deps >= config.Deps {
 				stat.ParseTooDeepNum++
 				continue
 			}
 
-			if strings.HasPrefix(ss, "http://") || strings.HasPrefix(ss, "https://") {
-
-			} else if strings.HasPrefix(ss, "/") {
-				sonurl = srcURL.Scheme + "://" + srcURL.Host + sonurl
-			} else {
-				dir := srcURL.Path
-
-				dirIndex := strings.LastIndex(dir, "/")
-				if dirIndex >= 0 {
-					dir = dir[0:dirIndex]
-				} else {
-					dir = ""
-				}
-				sonurl = srcURL.Scheme + "://" + srcURL.Host + dir + "/" + sonurl
-
-				mIndex := strings.Index(sonurl, "#")
-				if mIndex >= 0 {
-					sonurl = sonurl[0:mIndex]
-				}
-			}
-
-			for {
-				new := strings.Replace(sonurl, "/./", "/", -1)
-				if new == sonurl {
-					break
-				}
-				sonurl = new
-			}
-
-			_, err := url.Parse(sonurl)
+			sonurl, err := NormalizeURL(srcURL, s.UI.Url, strip)
 			if err != nil {
+				stat.ParseBadURLNum++
 				continue
 			}
 
-			var tmp *URLInfo
-
-			finded := hasDone(dbd, sonurl, stat)
-			if !finded {
-				if config.FocusSpider {
-					dstURL, dsterr := url.Parse(sonurl)
+			if config.FocusSpider {
+				dstURL, dsterr := url.Parse(sonurl)
+				if dsterr != nil {
+					continue
+				}
 
-					if dsterr == nil {
-						dstParams := strings.Split(dstURL.Host, ".")
-						srcParams := strings.Split(srcURL.Host, ".")
+				dstParams := strings.Split(dstURL.Hostname(), ".")
+				srcParams := strings.Split(srcURL.Hostname(), ".")
 
-						if len(dstParams) >= 2 && len(srcParams) >= 2 &&
-							dstParams[len(dstParams)-1] == srcParams[len(srcParams)-1] &&
-							dstParams[len(dstParams)-2] == srcParams[len(srcParams)-2] {
-							tmp = &URLInfo{sonurl, s.UI.Deps}
-						}
-					}
-				} else {
-					tmp = &URLInfo{sonurl, s.UI.Deps}
+				if len(dstParams) < 2 || len(srcParams) < 2 ||
+					dstParams[len(dstParams)-1] != srcParams[len(srcParams)-1] ||
+					dstParams[len(dstParams)-2] != srcParams[len(srcParams)-2] {
+					continue
 				}
 			}
 
-			if tmp != nil {
-				hasJob := hasJob(jbd, tmp.Url, stat)
-				if !hasJob {
-					stat.ParseJobNum++
+			if isNewJob(bf, jbd, dbd, sonurl, stat) {
+				stat.ParseJobNum++
 
-					insertSpiderJob(jbd, tmp.Url, tmp.Deps, stat)
+				insertSpiderJob(jbd, sonurl, s.UI.Deps, stat)
 
-					//loggo.Info("parse spawn job %v %v %v", job.UI.Url, sonurl, getJobSize(src))
-				}
+				//loggo.Info("parse spawn job %v %v %v", job.UI.Url, sonurl, getJobSize(src))
 			}
 		}
 		atomic.AddInt32(running, -1)
@@ -434,7 +536,7 @@ func Parser(running *int32, group *sync.WaitGroup, jbd *JobDB, dbd *DoneDB, conf
 	loggo.Info("Parser end")
 }
 
-func Saver(running *int32, group *sync.WaitGroup, save <-chan interface{}, stat *Stat, ctx *Content) {
+func Saver(running *int32, group *sync.WaitGroup, save <-chan interface{}, stat *Stat, ctx *Content, fs *FileSaver) {
 	defer common.CrashLog()
 
 	defer group.Done()
@@ -454,9 +556,21 @@ func Saver(running *int32, group *sync.WaitGroup, save <-chan interface{}, stat
 
 		stat.InsertNum++
 		b := time.Now()
-		ctx.Save(job)
+		if ctx.Save != nil {
+			ctx.Save(job)
+		}
 		stat.InsertTotalTime += int64(time.Since(b))
 
+		if fs != nil {
+			err := fs.Save(job)
+			if err != nil {
+				loggo.Error("Saver save file fail %v", err)
+				stat.SaveFileFail++
+			} else {
+				stat.SaveFileNum++
+			}
+		}
+
 		atomic.AddInt32(running, -1)
 	}
 
@@ -0,0 +1,478 @@
+package spider
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testStoreDSN(t *testing.T) ([]string, func()) {
+	dir, err := ioutil.TempDir("", "spider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []string{"mem://test", "sqlite3://" + filepath.Join(dir, "spider.db")}, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func Test0002Store(t *testing.T) {
+	dsns, clean := testStoreDSN(t)
+	defer clean()
+
+	for _, dsn := range dsns {
+		stat := &Stat{}
+		jbd := loadJob(dsn, 2, "http://a.com:80/")
+		if jbd == nil {
+			t.Fatal("loadJob fail", dsn)
+		}
+		other := loadJob(dsn, 2, "http://a.com:80/other")
+		insertSpiderJob(jbd, "http://a.com/1", 1, stat)
+		insertSpiderJob(jbd, "http://a.com/2", 2, stat)
+		insertSpiderJob(jbd, "http://a.com/2", 3, stat)
+		insertSpiderJob(other, "http://a.com/3", 1, stat)
+		if !hasJob(jbd, "http://a.com/1", stat) || hasJob(jbd, "http://a.com/3", stat) || getJobSize(jbd) != 2 {
+			t.Error("job diff", dsn, getJobSize(jbd))
+		}
+		urls, deps := popSpiderJob(jbd, 10, stat)
+		if len(urls) != 2 || deps[0]+deps[1] != 3 || getJobSize(jbd) != 0 || getJobSize(other) != 1 {
+			t.Error("pop diff", dsn, urls, deps)
+		}
+		dropJob(jbd)
+		closeJob(jbd)
+		closeJob(other)
+
+		dbd := loadDone(dsn, 2, "http://a.com:80/")
+		insertSpiderDone(dbd, "http://a.com/1", stat)
+		insertSpiderDone(dbd, "http://a.com/1", stat)
+		if !hasDone(dbd, "http://a.com/1", stat) || hasDone(dbd, "http://a.com/2", stat) || getDoneSize(dbd) != 1 {
+			t.Error("done diff", dsn)
+		}
+		deleteSpiderDone(dbd)
+		if getDoneSize(dbd) != 0 {
+			t.Error("done delete diff", dsn)
+		}
+		dropDone(dbd)
+		closeDone(dbd)
+
+		db := Load(dsn, 2, "spidertest", 7)
+		if db == nil {
+			t.Fatal("Load fail", dsn)
+		}
+		InsertSpider(db, "title1", "name1", "http://a.com/1")
+		InsertSpider(db, "title2", "Name2", "http://a.com/2")
+		InsertSpider(db, "title2", "name2", "http://a.com/2")
+		if GetSize(db) != 2 || len(Last(db, 10)) != 2 || len(Select(db, 1, 10)) != 1 {
+			t.Error("link diff", dsn, GetSize(db))
+		}
+		find := Find(db, "name2", 10)
+		if len(find) != 1 || find[0].URL != "http://a.com/2" {
+			t.Error("find diff", dsn, find)
+		}
+	}
+}
+
+func Test0003Start(t *testing.T) {
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := func(title string, links ...string) {
+			fmt.Fprintf(w, "<html><head><title>%s</title></head><body>", title)
+			for _, l := range links {
+				fmt.Fprintf(w, `<a href="%s">%s</a>`, l, l)
+			}
+			fmt.Fprint(w, "</body></html>")
+		}
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+		case "/":
+			page("root", "/a", "b", "/private/x", "#top", "javascript:void(0)")
+		case "/a":
+			page("a", "/c?utm_source=x#top", "http://other.example/", s.URL+"/b", "dup")
+		case "/b":
+			page("b", "./c", "/x/../c", "mailto:a@b.com", "/file.pdf")
+		case "/dup":
+			fmt.Fprint(w, `<html><head><title>dup</title><link rel="canonical" href="/a"></head></html>`)
+		case "/c":
+			page("c", "/")
+		case "/private/x":
+			page("private")
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer s.Close()
+
+	dsns, clean := testStoreDSN(t)
+	defer clean()
+
+	dir, err := ioutil.TempDir("", "spider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, dsn := range dsns {
+		savefile := filepath.Join(dir, fmt.Sprintf("save%d.jsonl", i))
+		var lock sync.Mutex
+		var titles []string
+		ctx := &Content{
+			Dsn:  dsn,
+			Conn: 2,
+			Crawl: func(pg *PageInfo, doc *goquery.Document) *PageInfo {
+				return pg
+			},
+			Parse: func(hosturl string, pg *PageInfo, save chan<- interface{}) bool {
+				save <- pg.Title
+				return true
+			},
+			Save: func(result interface{}) {
+				lock.Lock()
+				titles = append(titles, result.(string))
+				lock.Unlock()
+			},
+		}
+		config := Config{
+			Threadnum:    2,
+			Buffersize:   10,
+			Deps:         5,
+			FocusSpider:  true,
+			Crawlfunc:    "simple",
+			CrawlTimeout: 5,
+			CrawlRetry:   1,
+			Extractors:   []string{EXTRACT_FILETYPE},
+			SaveFile:     savefile,
+		}
+		stat := &Stat{}
+		Start(ctx, config, s.URL+"/", stat)
+
+		sort.Strings(titles)
+		fmt.Println(dsn, titles)
+		if fmt.Sprint(titles) != "[a b c root]" {
+			t.Error("crawl diff", dsn, titles)
+		}
+		if stat.RobotsDenyNum != 1 || stat.CrawOKNum != 5 || stat.ParseDupNum != 1 {
+			t.Error("stat diff", dsn, stat.RobotsDenyNum, stat.CrawOKNum, stat.ParseDupNum)
+		}
+		if stat.BloomSkipNum == 0 {
+			t.Error("bloom not used", dsn)
+		}
+		if stat.ParseFileNum != 1 {
+			t.Error("file link diff", dsn, stat.ParseFileNum)
+		}
+		b, _ := ioutil.ReadFile(savefile)
+		if strings.Count(string(b), "\n") != 4 || !strings.Contains(string(b), "\"root\"\n") {
+			t.Error("save file diff", dsn, string(b))
+		}
+	}
+}
+
+func Test0004NormalizeURL(t *testing.T) {
+	base, _ := url.Parse("http://a.com/b/c/d;p?q")
+	cases := [][]string{
+		// RFC 3986 5.4
+		{"g", "http://a.com/b/c/g"},
+		{"./g", "http://a.com/b/c/g"},
+		{"g/", "http://a.com/b/c/g/"},
+		{"/g", "http://a.com/g"},
+		{"//g", "http://g/"},
+		{"?y", "http://a.com/b/c/d;p?y"},
+		{"g?y", "http://a.com/b/c/g?y"},
+		{"#s", ""},
+		{"g#s", "http://a.com/b/c/g"},
+		{"..", "http://a.com/b/"},
+		{"../g", "http://a.com/b/g"},
+		{"../../../g", "http://a.com/g"},
+		{"g;x=1/../y", "http://a.com/b/c/y"},
+		// canonical
+		{"HTTP://WWW.A.COM:80/X", "http://www.a.com/X"},
+		{"https://a.com:443", "https://a.com/"},
+		{"https://a.com:8443/", "https://a.com:8443/"},
+		{"http://a.com./?b=2&a=1&utm_source=x&UTM_medium=y&gclid=z", "http://a.com/?a=1&b=2"},
+		{"http://a.com/%7ex%2fy?q=%2f", "http://a.com/%7Ex%2Fy?q=%2F"},
+		{"http://a.com/?", "http://a.com/"},
+		{"javascript:void(0)", ""},
+		{"mailto:a@b.com", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		u, err := NormalizeURL(base, c[0], DEFAULT_STRIP_PARAMS)
+		if u != c[1] || (c[1] == "") != (err != nil) {
+			t.Error("normalize diff", c[0], u, c[1], err)
+		}
+	}
+
+	u, _ := NormalizeURL(nil, "http://a.com/?id=1&sid=2", []string{"sid"})
+	if u != "http://a.com/?id=1" {
+		t.Error("strip diff", u)
+	}
+}
+
+func Test0005Bloom(t *testing.T) {
+	bf := NewBloomFilter(1000, 0.01)
+	n := 0
+	for i := 0; i < 20000; i++ {
+		// false positive, the test and add return true but not added
+		if !bf.TestAndAdd(fmt.Sprintf("http://a.com/%d", i)) {
+			n++
+		}
+	}
+	for i := 0; i < 20000; i++ {
+		if !bf.Test(fmt.Sprintf("http://a.com/%d", i)) {
+			t.Fatal("bloom lost", i)
+		}
+	}
+	fp := 0
+	for i := 0; i < 100000; i++ {
+		if bf.Test(fmt.Sprintf("http://b.com/%d", i)) {
+			fp++
+		}
+	}
+	fmt.Println("bloom", len(bf.filters), bf.Count(), fp)
+	if len(bf.filters) < 4 || bf.Count() != n {
+		t.Error("bloom not scale", len(bf.filters), bf.Count())
+	}
+	if float64(fp)/100000 > 0.01 {
+		t.Error("bloom false rate too high", fp)
+	}
+}
+
+const testExtractHTML = `<html><head><title>t</title>
+<meta property="og:title" content="OG Title">
+<meta name="twitter:card" content="summary">
+<meta name="description" content="desc">
+<meta name="viewport" content="width=device-width">
+<script type="application/ld+json">{"@context":"https://schema.org","@graph":[{"@type":"Article","headline":"h"},{"@type":"Person","name":"p"}]}</script>
+<script type="application/ld+json">[{"@type":"Product","name":"x"}]</script>
+</head><body>
+<div class="nav"><a href="/1">home</a> <a href="/2">news</a> <a href="/3">about us and the others</a></div>
+<div id="content" class="article">
+<h1>The Title</h1>
+<p>This is the first paragraph of the article, it is long enough, and it has some commas, to be scored.</p>
+<p>This is the second paragraph, which is long enough too, so the parent gets a good score.</p>
+<span class="price">12.5</span><span class="price">13</span>
+</div>
+<div class="comment"><p>a comment, which is long enough, but in the comment block.</p></div>
+<a href="/a.torrent">t</a><a href="/b.PDF?x=1">p</a><a href="magnet:?xt=urn:btih:1">m</a><a href="/page">page</a>
+</body></html>`
+
+func Test0006Extract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rulefile := filepath.Join(dir, "rules.json")
+	ioutil.WriteFile(rulefile, []byte(`[{"Name":"price","Selector":".price"},{"Name":"link","Selector":"a","Attr":"href","URL":"^http://a\\.com/"}]`), 0666)
+
+	config := &Config{
+		Extractors:    []string{EXTRACT_SELECTOR, EXTRACT_META, EXTRACT_TEXT, EXTRACT_FILETYPE},
+		SelectorRules: []SelectorRule{{Name: "h1", Selector: "h1"}},
+		SelectorFile:  rulefile,
+	}
+	exs, err := NewExtractors(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(testExtractHTML))
+	pg := &PageInfo{UI: URLInfo{"http://b.com/", 0}}
+	doc.Find("a").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		pg.Son = append(pg.Son, PageLinkInfo{UI: URLInfo{href, 1}})
+	})
+	extract(pg, doc, &Content{extractors: exs})
+
+	fmt.Println(pg.Fields, pg.Meta, pg.JSONLD)
+	fmt.Println(pg.Text)
+	if fmt.Sprint(pg.Fields) != "map[h1:[The Title] price:[12.5 13]]" {
+		t.Error("selector diff", pg.Fields)
+	}
+	if len(pg.Meta) != 3 || pg.Meta["og:title"] != "OG Title" || pg.Meta["twitter:card"] != "summary" || pg.Meta["description"] != "desc" {
+		t.Error("meta diff", pg.Meta)
+	}
+	if len(pg.JSONLD) != 3 || pg.JSONLD[0]["headline"] != "h" || pg.JSONLD[2]["name"] != "x" {
+		t.Error("jsonld diff", pg.JSONLD)
+	}
+	if !strings.HasPrefix(pg.Text, "The Title\nThis is the first paragraph") || strings.Contains(pg.Text, "comment") ||
+		strings.Contains(pg.Text, "home") {
+		t.Error("text diff", pg.Text)
+	}
+	if doc.Find("script").Length() != 2 {
+		t.Error("doc changed")
+	}
+	var types []string
+	for _, s := range pg.Son {
+		types = append(types, s.FileType)
+	}
+	if fmt.Sprint(types) != "[   torrent pdf magnet ]" {
+		t.Error("filetype diff", types)
+	}
+
+	pg = &PageInfo{UI: URLInfo{"http://a.com/", 0}}
+	exs[0].Extract(pg, doc)
+	if len(pg.Fields["link"]) != 7 {
+		t.Error("selector url diff", pg.Fields)
+	}
+
+	_, err = NewExtractors(&Config{Extractors: []string{"no-such"}})
+	if err == nil {
+		t.Error("undefined extractor should fail")
+	}
+	if RegisterExtractor("META", newMetaExtractor) == nil {
+		t.Error("register again should fail")
+	}
+}
+
+type testSaveData struct {
+	Name  string
+	Num   int
+	Tags  []string
+	inner int
+}
+
+func Test0007FileSaver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pg := &PageInfo{UI: URLInfo{"http://a.com/", 1}, Title: "a,\"b\"", Meta: map[string]string{"og:title": "x"}}
+
+	fs, err := NewFileSaver(filepath.Join(dir, "a.jsonl"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Save(pg)
+	fs.Save(&testSaveData{"n", 1, []string{"x"}, 2})
+	fs.Close()
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "a.jsonl"))
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	var pg1 PageInfo
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[0]), &pg1) != nil || pg1.Title != pg.Title || pg1.Meta["og:title"] != "x" ||
+		lines[1] != `{"Name":"n","Num":1,"Tags":["x"]}` {
+		t.Error("jsonl diff", string(b))
+	}
+
+	for i := 0; i < 2; i++ {
+		fs, err = NewFileSaver(filepath.Join(dir, "a.csv"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs.Save(&testSaveData{"n", i, []string{"x"}, 2})
+		fs.Close()
+	}
+	b, _ = ioutil.ReadFile(filepath.Join(dir, "a.csv"))
+	if string(b) != "Name,Num,Tags\nn,0,\"[\"\"x\"\"]\"\nn,1,\"[\"\"x\"\"]\"\n" {
+		t.Error("csv diff", string(b))
+	}
+
+	fs, _ = NewFileSaver(filepath.Join(dir, "b.csv"), "")
+	fs.Save(pg)
+	err = fs.Save(1)
+	fs.Close()
+	if err == nil {
+		t.Error("int to csv should fail")
+	}
+	r := csv.NewReader(bytes.NewReader(readFile(filepath.Join(dir, "b.csv"))))
+	records, err := r.ReadAll()
+	if err != nil || len(records) != 2 || records[1][0] != "http://a.com/" || records[1][2] != pg.Title || records[1][5] != `{"og:title":"x"}` {
+		t.Error("csv page diff", records, err)
+	}
+
+	_, err = NewFileSaver(filepath.Join(dir, "a.xml"), "")
+	if err == nil {
+		t.Error("xml should fail")
+	}
+}
+
+func readFile(filename string) []byte {
+	b, _ := ioutil.ReadFile(filename)
+	return b
+}
+
+func Test0008Charset(t *testing.T) {
+	fixtures := []struct {
+		file  string
+		label string
+		title string
+		link  string
+	}{
+		{"gbk.html", "gbk", "中文测试页面", "下一页"},
+		{"big5.html", "big5", "中文測試頁面", "下一頁"},
+		{"shift_jis.html", "shift_jis", "日本語のテスト", "次のページ"},
+		{"euc-kr.html", "euc-kr", "한국어 테스트", "다음 페이지"},
+		{"windows-1252.html", "windows-1252", "Café crème", "Suivant »"},
+		{"utf-8.html", "utf-8", "UTF-8 页面", "下一页"},
+		{"utf-8-bom.html", "", "UTF-8 BOM 页面", "下一页"},
+		{"utf-16le.html", "", "UTF-16 页面", "下一页"},
+	}
+
+	var body []byte
+	var contenttype string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contenttype)
+		w.Write(body)
+	}))
+	defer s.Close()
+
+	ctx := &Content{Crawl: func(pg *PageInfo, doc *goquery.Document) *PageInfo {
+		return pg
+	}}
+
+	for _, f := range fixtures {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "charset", f.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		modes := []string{"sniff"}
+		if f.label != "" {
+			modes = append(modes, "header", "meta")
+		}
+		for _, mode := range modes {
+			body = data
+			contenttype = "text/html"
+			switch mode {
+			case "header":
+				contenttype = "text/html; charset=" + f.label
+			case "meta":
+				body = bytes.Replace(data, []byte("<head>"),
+					[]byte(`<head><meta http-equiv="Content-Type" content="text/html; charset=`+f.label+`">`), 1)
+			}
+
+			pg, status, _ := simplecrawl(&URLInfo{s.URL + "/", 0}, 5, ctx, "")
+			if pg == nil || status != 200 {
+				t.Error("crawl fail", f.file, mode)
+				continue
+			}
+			fmt.Println(f.file, mode, pg.Title)
+			if pg.Title != f.title || len(pg.Son) != 1 || pg.Son[0].Name != f.link {
+				t.Error("charset diff", f.file, mode, pg.Title, pg.Son)
+			}
+		}
+	}
+
+	// the header wins the meta
+	_, cs := toUTF8([]byte(`<meta charset="big5">`), "text/html; charset=gbk")
+	if cs != "gb18030" {
+		t.Error("header not first", cs)
+	}
+	_, cs = toUTF8([]byte("\xef\xbb\xbf<meta charset=\"big5\">"), "text/html; charset=gbk")
+	if cs != "utf-8" {
+		t.Error("bom not first", cs)
+	}
+}
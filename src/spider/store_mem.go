@@ -0,0 +1,285 @@
+package spider
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the mem databases of the same name are shared in the process, like a database server
+var gMemDB = make(map[string]*memDB)
+var gMemDBLock sync.Mutex
+
+type memDB struct {
+	lock   sync.Mutex
+	tables map[string]*memTable
+}
+
+type memRow struct {
+	src   string
+	url   string
+	deps  int
+	title string
+	name  string
+	time  time.Time
+	seq   uint64
+}
+
+type memTable struct {
+	rows  map[string]*memRow
+	order []*memRow // by insert, the removed rows are skipped
+	seq   uint64
+}
+
+func getMemDB(name string) *memDB {
+	gMemDBLock.Lock()
+	defer gMemDBLock.Unlock()
+	db, ok := gMemDB[name]
+	if !ok {
+		db = &memDB{tables: make(map[string]*memTable)}
+		gMemDB[name] = db
+	}
+	return db
+}
+
+// table must be called with the lock
+func (db *memDB) table(name string) *memTable {
+	t, ok := db.tables[name]
+	if !ok {
+		t = &memTable{rows: make(map[string]*memRow)}
+		db.tables[name] = t
+	}
+	return t
+}
+
+func (t *memTable) insert(row *memRow) {
+	if _, ok := t.rows[row.url]; ok {
+		return
+	}
+	t.seq++
+	row.seq = t.seq
+	t.rows[row.url] = row
+	t.order = append(t.order, row)
+}
+
+func (t *memTable) remove(url string) {
+	delete(t.rows, url)
+	if len(t.order) > 64 && len(t.order) > len(t.rows)*2 {
+		order := make([]*memRow, 0, len(t.rows))
+		for _, row := range t.order {
+			if t.rows[row.url] == row {
+				order = append(order, row)
+			}
+		}
+		t.order = order
+	}
+}
+
+// each iterate the rows alive by insert
+func (t *memTable) each(f func(row *memRow) bool) {
+	for _, row := range t.order {
+		if t.rows[row.url] != row {
+			continue
+		}
+		if !f(row) {
+			return
+		}
+	}
+}
+
+type memJobStore struct {
+	db    *memDB
+	table string
+	src   string
+}
+
+func newMemJobStore(name string, host string, src string) *memJobStore {
+	return &memJobStore{db: getMemDB(name), table: "spiderjob." + host, src: src}
+}
+
+func (s *memJobStore) Insert(url string, deps int) error {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	s.db.table(s.table).insert(&memRow{src: s.src, url: url, deps: deps, time: time.Now()})
+	return nil
+}
+
+func (s *memJobStore) Pop(n int) ([]string, []int, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	t := s.db.table(s.table)
+	var urls []string
+	var deps []int
+	t.each(func(row *memRow) bool {
+		if len(urls) >= n {
+			return false
+		}
+		if row.src == s.src {
+			urls = append(urls, row.url)
+			deps = append(deps, row.deps)
+		}
+		return true
+	})
+	for _, url := range urls {
+		t.remove(url)
+	}
+	return urls, deps, nil
+}
+
+func (s *memJobStore) Has(url string) (bool, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	row, ok := s.db.table(s.table).rows[url]
+	return ok && row.src == s.src, nil
+}
+
+func (s *memJobStore) Size() (int, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	n := 0
+	for _, row := range s.db.table(s.table).rows {
+		if row.src == s.src {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memJobStore) Drop() error {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	delete(s.db.tables, s.table)
+	return nil
+}
+
+func (s *memJobStore) Close() error {
+	return nil
+}
+
+type memDoneStore struct {
+	memJobStore
+}
+
+func newMemDoneStore(name string, host string, src string) *memDoneStore {
+	return &memDoneStore{memJobStore{db: getMemDB(name), table: "spiderdone." + host, src: src}}
+}
+
+func (s *memDoneStore) Insert(url string) error {
+	return s.memJobStore.Insert(url, 0)
+}
+
+func (s *memDoneStore) Delete() error {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	t := s.db.table(s.table)
+	for url, row := range t.rows {
+		if row.src == s.src {
+			t.remove(url)
+		}
+	}
+	return nil
+}
+
+type memLinkStore struct {
+	db    *memDB
+	table string
+}
+
+func newMemLinkStore(name string, table string) *memLinkStore {
+	return &memLinkStore{db: getMemDB(name), table: table + ".link_info"}
+}
+
+func (s *memLinkStore) Insert(title string, name string, url string) error {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	s.db.table(s.table).insert(&memRow{url: url, title: title, name: name, time: time.Now()})
+	return nil
+}
+
+func (s *memLinkStore) Size() (int, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	return len(s.db.table(s.table).rows), nil
+}
+
+func (s *memLinkStore) sortByTime(rows []*memRow) []FindData {
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].time.Equal(rows[j].time) {
+			return rows[i].seq > rows[j].seq
+		}
+		return rows[i].time.After(rows[j].time)
+	})
+	var ret []FindData
+	for _, row := range rows {
+		ret = append(ret, FindData{row.title, row.name, row.url})
+	}
+	return ret
+}
+
+func (s *memLinkStore) Last(n int) ([]FindData, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	var rows []*memRow
+	s.db.table(s.table).each(func(row *memRow) bool {
+		rows = append(rows, row)
+		return true
+	})
+	ret := s.sortByTime(rows)
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret, nil
+}
+
+func (s *memLinkStore) Find(str string, max int) ([]FindData, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	str = strings.ToLower(str)
+	var rows []*memRow
+	s.db.table(s.table).each(func(row *memRow) bool {
+		if len(rows) >= max {
+			return false
+		}
+		if strings.Contains(strings.ToLower(row.name), str) || strings.Contains(strings.ToLower(row.title), str) {
+			rows = append(rows, row)
+		}
+		return true
+	})
+	return s.sortByTime(rows), nil
+}
+
+func (s *memLinkStore) Select(offset int, count int) ([]FindData, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	var ret []FindData
+	i := 0
+	s.db.table(s.table).each(func(row *memRow) bool {
+		if len(ret) >= count {
+			return false
+		}
+		if i >= offset {
+			ret = append(ret, FindData{row.title, row.name, row.url})
+		}
+		i++
+		return true
+	})
+	return ret, nil
+}
+
+func (s *memLinkStore) DeleteOld(expireday int) error {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	t := s.db.table(s.table)
+	expire := time.Now().Add(-time.Duration(expireday) * 24 * time.Hour)
+	for url, row := range t.rows {
+		if !row.time.After(expire) {
+			t.remove(url)
+		}
+	}
+	return nil
+}
+
+func (s *memLinkStore) Close() error {
+	return nil
+}
@@ -0,0 +1,421 @@
+package spider
+
+import (
+	"database/sql"
+	"github.com/esrrhs/go-engine/src/loggo"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+	"strconv"
+	"strings"
+)
+
+// sqlDialect is the sql differs between mysql and sqlite3
+type sqlDialect struct {
+	database     func(name string) string // CREATE DATABASE, empty for none
+	table        func(db string, table string) string
+	insertIgnore string
+	now          string
+	linkIndex    func(table string) string // in or after the CREATE TABLE
+	deleteOld    func(table string, expireday int) string
+}
+
+var gMysqlDialect = &sqlDialect{
+	database: func(name string) string {
+		return "CREATE DATABASE IF NOT EXISTS " + name
+	},
+	table: func(db string, table string) string {
+		return db + "." + table
+	},
+	insertIgnore: "insert IGNORE into ",
+	now:          "NOW()",
+	linkIndex: func(table string) string {
+		return ""
+	},
+	deleteOld: func(table string, expireday int) string {
+		return "delete from " + table + " where (TO_DAYS(NOW()) - TO_DAYS(time))>=" + strconv.Itoa(expireday)
+	},
+}
+
+var gSqliteDialect = &sqlDialect{
+	database: func(name string) string {
+		return ""
+	},
+	table: func(db string, table string) string {
+		return db + "_" + table
+	},
+	insertIgnore: "insert or ignore into ",
+	now:          "datetime('now')",
+	linkIndex: func(table string) string {
+		return "CREATE INDEX IF NOT EXISTS " + table + "_time ON " + table + "(time)"
+	},
+	deleteOld: func(table string, expireday int) string {
+		return "delete from " + table + " where julianday('now') - julianday(time) >= " + strconv.Itoa(expireday)
+	},
+}
+
+func openSql(typ string, addr string, conn int) (*sql.DB, *sqlDialect, error) {
+	dialect := gMysqlDialect
+	if typ == STORE_SQLITE {
+		dialect = gSqliteDialect
+		// one conn, so :memory: is the same database, the job and the done wait for each other by the busy timeout
+		conn = 1
+		if !strings.Contains(addr, "_busy_timeout") {
+			if strings.Contains(addr, "?") {
+				addr += "&_busy_timeout=10000"
+			} else {
+				addr += "?_busy_timeout=10000"
+			}
+		}
+	}
+
+	gdb, err := sql.Open(typ, addr)
+	if err != nil {
+		loggo.Error("open %v fail %v", typ, err)
+		return nil, nil, err
+	}
+
+	err = gdb.Ping()
+	if err != nil {
+		loggo.Error("open %v fail %v", typ, err)
+		gdb.Close()
+		return nil, nil, err
+	}
+
+	gdb.SetConnMaxLifetime(0)
+	gdb.SetMaxIdleConns(conn)
+	gdb.SetMaxOpenConns(conn)
+	return gdb, dialect, nil
+}
+
+// prepareAll prepare the sqls in order, close the db if fail
+func prepareAll(gdb *sql.DB, exec []string, sqls []string, stmts []**sql.Stmt) error {
+	for _, s := range exec {
+		if s == "" {
+			continue
+		}
+		_, err := gdb.Exec(s)
+		if err != nil {
+			loggo.Error("Exec fail %v %v", s, err)
+			gdb.Close()
+			return err
+		}
+	}
+	for i, s := range sqls {
+		stmt, err := gdb.Prepare(s)
+		if err != nil {
+			loggo.Error("Prepare fail %v %v", s, err)
+			gdb.Close()
+			return err
+		}
+		*stmts[i] = stmt
+	}
+	return nil
+}
+
+type sqlJobStore struct {
+	gdb            *sql.DB
+	src            string
+	gInsertJobStmt *sql.Stmt
+	gSizeJobStmt   *sql.Stmt
+	gPeekJobStmt   *sql.Stmt
+	gDeleteJobStmt *sql.Stmt
+	gHasJobStmt    *sql.Stmt
+	gDropStmt      *sql.Stmt
+}
+
+func newSqlJobStore(typ string, addr string, conn int, host string, src string) (*sqlJobStore, error) {
+	gdb, d, err := openSql(typ, addr, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &sqlJobStore{gdb: gdb, src: src}
+	table := d.table("spiderjob", host)
+	err = prepareAll(gdb, []string{
+		d.database("spiderjob"),
+		"CREATE TABLE  IF NOT EXISTS " + table + "(" +
+			"src TEXT NOT NULL," +
+			"url VARCHAR(200)  NOT NULL ," +
+			"deps INT NOT NULL," +
+			"time DATETIME NOT NULL," +
+			"PRIMARY KEY(url));",
+	}, []string{
+		d.insertIgnore + table + "(src, url, deps, time) values(?, ?, ?, " + d.now + ")",
+		"select count(*) from " + table + " where src = ?",
+		"select url, deps from " + table + " where src = ? limit 0, ?",
+		"delete from " + table + " where src = ? and url = ?",
+		"select url from " + table + " where src = ? and url = ?",
+		"drop table " + table + "",
+	}, []**sql.Stmt{
+		&ret.gInsertJobStmt,
+		&ret.gSizeJobStmt,
+		&ret.gPeekJobStmt,
+		&ret.gDeleteJobStmt,
+		&ret.gHasJobStmt,
+		&ret.gDropStmt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *sqlJobStore) Insert(url string, deps int) error {
+	_, err := s.gInsertJobStmt.Exec(s.src, url, deps)
+	return err
+}
+
+func (s *sqlJobStore) Pop(n int) ([]string, []int, error) {
+	var ret []string
+	var retdeps []int
+
+	rows, err := s.gPeekJobStmt.Query(s.src, n)
+	if err != nil {
+		return ret, retdeps, err
+	}
+
+	for rows.Next() {
+		var url string
+		var deps int
+		err = rows.Scan(&url, &deps)
+		if err != nil {
+			loggo.Error("popSpiderJob Scan fail %v %v", s.src, err)
+			continue
+		}
+		ret = append(ret, url)
+		retdeps = append(retdeps, deps)
+	}
+	rows.Close()
+
+	for _, url := range ret {
+		s.gDeleteJobStmt.Exec(s.src, url)
+	}
+	return ret, retdeps, nil
+}
+
+func (s *sqlJobStore) Has(url string) (bool, error) {
+	var surl string
+	err := s.gHasJobStmt.QueryRow(s.src, url).Scan(&surl)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *sqlJobStore) Size() (int, error) {
+	var ret int
+	err := s.gSizeJobStmt.QueryRow(s.src).Scan(&ret)
+	return ret, err
+}
+
+func (s *sqlJobStore) Drop() error {
+	_, err := s.gDropStmt.Exec()
+	return err
+}
+
+func (s *sqlJobStore) Close() error {
+	s.gInsertJobStmt.Close()
+	s.gSizeJobStmt.Close()
+	s.gPeekJobStmt.Close()
+	s.gDeleteJobStmt.Close()
+	s.gHasJobStmt.Close()
+	s.gDropStmt.Close()
+	return s.gdb.Close()
+}
+
+type sqlDoneStore struct {
+	gdb             *sql.DB
+	src             string
+	gInsertDoneStmt *sql.Stmt
+	gSizeDoneStmt   *sql.Stmt
+	gDeleteDoneStmt *sql.Stmt
+	gHasDoneStmt    *sql.Stmt
+	gDropStmt       *sql.Stmt
+}
+
+func newSqlDoneStore(typ string, addr string, conn int, host string, src string) (*sqlDoneStore, error) {
+	gdb, d, err := openSql(typ, addr, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &sqlDoneStore{gdb: gdb, src: src}
+	table := d.table("spiderdone", host)
+	err = prepareAll(gdb, []string{
+		d.database("spiderdone"),
+		"CREATE TABLE  IF NOT EXISTS " + table + "(" +
+			"src TEXT NOT NULL," +
+			"url VARCHAR(200)  NOT NULL," +
+			"time DATETIME NOT NULL," +
+			"PRIMARY KEY(url));",
+	}, []string{
+		d.insertIgnore + table + "(src, url, time) values(?, ?, " + d.now + ")",
+		"select count(*) from " + table + " where src = ?",
+		"delete from " + table + " where src = ?",
+		"select url from " + table + " where src = ? and url = ?",
+		"drop table " + table + "",
+	}, []**sql.Stmt{
+		&ret.gInsertDoneStmt,
+		&ret.gSizeDoneStmt,
+		&ret.gDeleteDoneStmt,
+		&ret.gHasDoneStmt,
+		&ret.gDropStmt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *sqlDoneStore) Insert(url string) error {
+	_, err := s.gInsertDoneStmt.Exec(s.src, url)
+	return err
+}
+
+func (s *sqlDoneStore) Has(url string) (bool, error) {
+	var surl string
+	err := s.gHasDoneStmt.QueryRow(s.src, url).Scan(&surl)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *sqlDoneStore) Size() (int, error) {
+	var ret int
+	err := s.gSizeDoneStmt.QueryRow(s.src).Scan(&ret)
+	return ret, err
+}
+
+func (s *sqlDoneStore) Delete() error {
+	_, err := s.gDeleteDoneStmt.Exec(s.src)
+	return err
+}
+
+func (s *sqlDoneStore) Drop() error {
+	_, err := s.gDropStmt.Exec()
+	return err
+}
+
+func (s *sqlDoneStore) Close() error {
+	s.gInsertDoneStmt.Close()
+	s.gSizeDoneStmt.Close()
+	s.gDeleteDoneStmt.Close()
+	s.gHasDoneStmt.Close()
+	s.gDropStmt.Close()
+	return s.gdb.Close()
+}
+
+type sqlLinkStore struct {
+	gdb         *sql.DB
+	dialect     *sqlDialect
+	table       string
+	gInsertStmt *sql.Stmt
+	gSizeStmt   *sql.Stmt
+	gLastStmt   *sql.Stmt
+	gFindStmt   *sql.Stmt
+	gSelectStmt *sql.Stmt
+}
+
+func newSqlLinkStore(typ string, addr string, conn int, name string) (*sqlLinkStore, error) {
+	gdb, d, err := openSql(typ, addr, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	table := d.table(name, "link_info")
+	ret := &sqlLinkStore{gdb: gdb, dialect: d, table: table}
+
+	index := ""
+	if d == gMysqlDialect {
+		index = "," + "INDEX `time`(`time`) USING BTREE"
+	}
+	err = prepareAll(gdb, []string{
+		d.database(name),
+		"CREATE TABLE  IF NOT EXISTS " + table + "(" +
+			"url VARCHAR(200)  NOT NULL ," +
+			"title VARCHAR(200) NOT NULL," +
+			"name VARCHAR(200) NOT NULL," +
+			"time DATETIME NOT NULL," +
+			"PRIMARY KEY(url)" +
+			index +
+			");",
+		d.linkIndex(table),
+	}, []string{
+		d.insertIgnore + table + "(title, name, url, time) values(?, ?, ?, " + d.now + ")",
+		"select count(*) as ret from " + table,
+		"select title,name,url from " + table + " order by time desc limit 0, ?",
+		"select title,name,url from (select title,name,url,time from " + table + " where name like ? or title like ?  limit 0,?) as A  order by time desc ",
+		"SELECT title,name,url FROM " + table + " LIMIT ?, ?",
+	}, []**sql.Stmt{
+		&ret.gInsertStmt,
+		&ret.gSizeStmt,
+		&ret.gLastStmt,
+		&ret.gFindStmt,
+		&ret.gSelectStmt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *sqlLinkStore) Insert(title string, name string, url string) error {
+	_, err := s.gInsertStmt.Exec(title, name, url)
+	return err
+}
+
+func (s *sqlLinkStore) Size() (int, error) {
+	var ret int
+	err := s.gSizeStmt.QueryRow().Scan(&ret)
+	return ret, err
+}
+
+func (s *sqlLinkStore) query(stmt *sql.Stmt, args ...interface{}) ([]FindData, error) {
+	var ret []FindData
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var title string
+		var name string
+		var url string
+		err = rows.Scan(&title, &name, &url)
+		if err != nil {
+			loggo.Error("Scan fail %v", err)
+			continue
+		}
+		ret = append(ret, FindData{title, name, url})
+	}
+	return ret, nil
+}
+
+func (s *sqlLinkStore) Last(n int) ([]FindData, error) {
+	return s.query(s.gLastStmt, n)
+}
+
+func (s *sqlLinkStore) Find(str string, max int) ([]FindData, error) {
+	return s.query(s.gFindStmt, "%"+str+"%", "%"+str+"%", max)
+}
+
+func (s *sqlLinkStore) Select(offset int, count int) ([]FindData, error) {
+	return s.query(s.gSelectStmt, offset, count)
+}
+
+func (s *sqlLinkStore) DeleteOld(expireday int) error {
+	_, err := s.gdb.Exec(s.dialect.deleteOld(s.table, expireday))
+	return err
+}
+
+func (s *sqlLinkStore) Close() error {
+	s.gInsertStmt.Close()
+	s.gSizeStmt.Close()
+	s.gLastStmt.Close()
+	s.gFindStmt.Close()
+	s.gSelectStmt.Close()
+	return s.gdb.Close()
+}
@@ -0,0 +1,129 @@
+package spider
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DEFAULT_STRIP_PARAMS are the tracking params dropped from the query, * at the end for the prefix
+var DEFAULT_STRIP_PARAMS = []string{"utm_*", "gclid", "fbclid", "msclkid", "yclid", "spm"}
+
+// NormalizeURL resolve the ref by the base as RFC 3986, and canonicalize it, so the same page get the same url:
+// lower case scheme and host, no default port, no fragment, no dot segments, upper case percent-encoding,
+// query params sorted and the stripParams dropped. only http and https are accepted
+func NormalizeURL(base *url.URL, ref string, stripParams []string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return "", errors.New("empty url")
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("not http url " + u.Scheme)
+	}
+	if u.Opaque != "" {
+		return "", errors.New("opaque url")
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", errors.New("no host")
+	}
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = upperPercent(u.RawPath)
+
+	u.RawQuery = normalizeQuery(u.RawQuery, stripParams)
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// normalizeQuery keep the raw params, so the meaning is not changed, only sorted and stripped
+func normalizeQuery(rawquery string, stripParams []string) string {
+	if rawquery == "" {
+		return ""
+	}
+	var params []string
+	for _, p := range strings.Split(rawquery, "&") {
+		if p == "" {
+			continue
+		}
+		key := p
+		if i := strings.Index(p, "="); i >= 0 {
+			key = p[:i]
+		}
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		if matchParam(key, stripParams) {
+			continue
+		}
+		params = append(params, upperPercent(p))
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func matchParam(key string, stripParams []string) bool {
+	key = strings.ToLower(key)
+	for _, s := range stripParams {
+		s = strings.ToLower(s)
+		if strings.HasSuffix(s, "*") {
+			if strings.HasPrefix(key, s[:len(s)-1]) {
+				return true
+			}
+		} else if key == s {
+			return true
+		}
+	}
+	return false
+}
+
+func upperPercent(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	b := []byte(s)
+	for i := 0; i+2 < len(b); i++ {
+		if b[i] == '%' {
+			b[i+1] = upperHex(b[i+1])
+			b[i+2] = upperHex(b[i+2])
+			i += 2
+		}
+	}
+	return string(b)
+}
+
+func upperHex(c byte) byte {
+	if c >= 'a' && c <= 'f' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
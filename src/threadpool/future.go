@@ -0,0 +1,44 @@
+package threadpool
+
+import (
+	"context"
+)
+
+// Future is the result of a job submitted to Pool
+type Future[R any] struct {
+	done   chan struct{}
+	result R
+	err    error
+}
+
+func newFuture[R any]() *Future[R] {
+	return &Future[R]{done: make(chan struct{})}
+}
+
+func (f *Future[R]) set(result R, err error) {
+	f.result = result
+	f.err = err
+	close(f.done)
+}
+
+// Done is closed when the result is ready
+func (f *Future[R]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get wait for the result
+func (f *Future[R]) Get() (R, error) {
+	<-f.done
+	return f.result, f.err
+}
+
+// GetContext wait for the result until the ctx is done, the job keeps going
+func (f *Future[R]) GetContext(ctx context.Context) (R, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		var r R
+		return r, ctx.Err()
+	}
+}
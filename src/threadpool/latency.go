@@ -0,0 +1,61 @@
+package threadpool
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const LATENCY_SAMPLE_NUM = 1024
+
+// latencyStat keeps the latency of the recent jobs, from pushed to processed
+type latencyStat struct {
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyStat() *latencyStat {
+	return &latencyStat{samples: make([]time.Duration, LATENCY_SAMPLE_NUM)}
+}
+
+func (l *latencyStat) add(d time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.samples[l.next] = d
+	l.next++
+	if l.next >= len(l.samples) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+func (l *latencyStat) reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.next = 0
+	l.full = false
+}
+
+func (l *latencyStat) fill(stat *ThreadPoolStat) {
+	l.lock.Lock()
+	n := l.next
+	if l.full {
+		n = len(l.samples)
+	}
+	tmp := make([]time.Duration, n)
+	copy(tmp, l.samples[:n])
+	l.lock.Unlock()
+
+	if n <= 0 {
+		return
+	}
+	sort.Slice(tmp, func(i, j int) bool {
+		return tmp[i] < tmp[j]
+	})
+	stat.LatencyP50 = tmp[(n-1)*50/100]
+	stat.LatencyP90 = tmp[(n-1)*90/100]
+	stat.LatencyP99 = tmp[(n-1)*99/100]
+	stat.LatencyMax = tmp[n-1]
+}
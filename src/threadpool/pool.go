@@ -0,0 +1,468 @@
+package threadpool
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"github.com/esrrhs/go-engine/src/common"
+	"sync"
+	"time"
+)
+
+const (
+	REJECT_BLOCK          = iota // wait until the queue has room, as ThreadPool.AddJob
+	REJECT_ABORT                 // fail the job with ErrRejected
+	REJECT_CALLER_RUNS           // run the job in the caller at once, the key order is not kept
+	REJECT_DISCARD_LOWEST        // drop the lowest priority waiting job for the new one, the oldest if the same
+)
+
+var ErrRejected = errors.New("threadpool job rejected")
+var ErrDiscarded = errors.New("threadpool job discarded")
+var ErrStopped = errors.New("threadpool stopped")
+var ErrTimeout = errors.New("threadpool job timeout")
+
+type PoolConfig struct {
+	Size     int           // worker num, change it by Resize
+	MaxQueue int           // the max jobs waiting, 0 for no limit
+	Reject   int           // REJECT_XXX when the queue is full
+	Affinity bool          // the Ordered jobs run on the worker Key % Size, as ThreadPool.AddJob
+	Timeout  time.Duration // the default timeout of the jobs, 0 for no timeout
+}
+
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		Size:     16,
+		MaxQueue: 1024,
+		Reject:   REJECT_BLOCK,
+	}
+}
+
+type JobOption struct {
+	Priority int           // the bigger runs first
+	Ordered  bool          // the Ordered jobs with the same Key run one by one in the submitted order
+	Key      int           // also picks the worker for Affinity
+	Timeout  time.Duration // from submitted to processed, 0 for PoolConfig.Timeout
+}
+
+type poolJob[T any, R any] struct {
+	v        T
+	opt      JobOption
+	seq      uint64
+	pushTime time.Time
+	deadline time.Time
+	future   *Future[R]
+}
+
+type jobHeap[T any, R any] []*poolJob[T, R]
+
+func (h jobHeap[T, R]) Len() int {
+	return len(h)
+}
+
+func (h jobHeap[T, R]) Less(i, j int) bool {
+	return jobBefore(h[i], h[j])
+}
+
+func (h jobHeap[T, R]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *jobHeap[T, R]) Push(x interface{}) {
+	*h = append(*h, x.(*poolJob[T, R]))
+}
+
+func (h *jobHeap[T, R]) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+func jobBefore[T any, R any](a *poolJob[T, R], b *poolJob[T, R]) bool {
+	if a.opt.Priority != b.opt.Priority {
+		return a.opt.Priority > b.opt.Priority
+	}
+	return a.seq < b.seq
+}
+
+type poolWorker struct {
+	slot int
+	quit bool
+}
+
+// Pool is the generic ThreadPool, the jobs are processed by exef and the results go to the futures
+type Pool[T any, R any] struct {
+	config  PoolConfig
+	exef    func(ctx context.Context, v T) (R, error)
+	lock    sync.Mutex
+	cond    *sync.Cond // the workers wait for jobs
+	notFull *sync.Cond // the submitters wait for room
+	ready   jobHeap[T, R]
+	slots   []jobHeap[T, R]          // the ready jobs of each worker, for Affinity
+	keys    map[int][]*poolJob[T, R] // the Ordered jobs waiting for the one of the same key ready or running
+	queued  int
+	workers []*poolWorker
+	seq     uint64
+	stop    bool
+	wg      sync.WaitGroup
+	stat    ThreadPoolStat
+	latency *latencyStat
+}
+
+func NewPool[T any, R any](config PoolConfig, exef func(ctx context.Context, v T) (R, error)) *Pool[T, R] {
+	p := &Pool[T, R]{
+		config:  config,
+		exef:    exef,
+		keys:    make(map[int][]*poolJob[T, R]),
+		latency: newLatencyStat(),
+	}
+	p.cond = sync.NewCond(&p.lock)
+	p.notFull = sync.NewCond(&p.lock)
+	p.Resize(config.Size)
+	return p
+}
+
+// Resize change the worker num, the removed workers exit after the current job
+func (p *Pool[T, R]) Resize(size int) {
+	if size <= 0 {
+		size = 1
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.stop {
+		return
+	}
+
+	old := len(p.workers)
+	for i := old; i < size; i++ {
+		w := &poolWorker{slot: i}
+		p.workers = append(p.workers, w)
+		p.slots = append(p.slots, nil)
+		p.stat.Datalen = append(p.stat.Datalen, 0)
+		p.stat.Pushnum = append(p.stat.Pushnum, 0)
+		p.stat.Processnum = append(p.stat.Processnum, 0)
+		p.wg.Add(1)
+		go p.run(w)
+	}
+
+	if size < old {
+		for _, w := range p.workers[size:] {
+			w.quit = true
+		}
+		p.workers = p.workers[:size]
+		moved := p.slots[size:]
+		p.slots = p.slots[:size]
+		p.stat.Datalen = p.stat.Datalen[:size]
+		p.stat.Pushnum = p.stat.Pushnum[:size]
+		p.stat.Processnum = p.stat.Processnum[:size]
+		for _, h := range moved {
+			for _, job := range h {
+				p.pushReady(job)
+			}
+		}
+		p.cond.Broadcast()
+	}
+	p.config.Size = size
+}
+
+func (p *Pool[T, R]) Size() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.workers)
+}
+
+func (p *Pool[T, R]) Submit(v T) *Future[R] {
+	return p.SubmitWith(v, JobOption{})
+}
+
+func (p *Pool[T, R]) SubmitWith(v T, opt JobOption) *Future[R] {
+	job := &poolJob[T, R]{
+		v:        v,
+		opt:      opt,
+		pushTime: time.Now(),
+		future:   newFuture[R](),
+	}
+	timeout := opt.Timeout
+	if timeout <= 0 {
+		timeout = p.config.Timeout
+	}
+	if timeout > 0 {
+		job.deadline = job.pushTime.Add(timeout)
+	}
+
+	p.lock.Lock()
+
+	for !p.stop && p.full() {
+		switch p.config.Reject {
+		case REJECT_ABORT:
+			p.stat.Rejectnum++
+			p.lock.Unlock()
+			p.fail(job, ErrRejected)
+			return job.future
+		case REJECT_CALLER_RUNS:
+			p.lock.Unlock()
+			gMetricPush.Inc()
+			p.process(job, -1)
+			return job.future
+		case REJECT_DISCARD_LOWEST:
+			if !p.discardLowest(job) {
+				p.stat.Rejectnum++
+				p.lock.Unlock()
+				p.fail(job, ErrRejected)
+				return job.future
+			}
+		default:
+			p.notFull.Wait()
+		}
+	}
+
+	if p.stop {
+		p.lock.Unlock()
+		p.fail(job, ErrStopped)
+		return job.future
+	}
+
+	p.seq++
+	job.seq = p.seq
+	p.queued++
+	gMetricPush.Inc()
+	gMetricQueue.Inc()
+
+	if opt.Ordered {
+		waiting, ok := p.keys[opt.Key]
+		p.keys[opt.Key] = append(waiting, job)
+		if ok {
+			// the former job of the key will push it
+			p.lock.Unlock()
+			return job.future
+		}
+	}
+	p.pushReady(job)
+	p.lock.Unlock()
+	return job.future
+}
+
+func (p *Pool[T, R]) full() bool {
+	return p.config.MaxQueue > 0 && p.queued >= p.config.MaxQueue
+}
+
+// pushReady must be called with the lock
+func (p *Pool[T, R]) pushReady(job *poolJob[T, R]) {
+	if p.config.Affinity && job.opt.Ordered {
+		slot := common.AbsInt(job.opt.Key) % len(p.slots)
+		heap.Push(&p.slots[slot], job)
+		p.stat.Pushnum[slot]++
+	} else {
+		heap.Push(&p.ready, job)
+	}
+	p.cond.Broadcast()
+}
+
+// pop must be called with the lock
+func (p *Pool[T, R]) pop(slot int) *poolJob[T, R] {
+	h := &p.ready
+	if p.config.Affinity && len(p.slots[slot]) > 0 {
+		if len(p.ready) <= 0 || jobBefore(p.slots[slot][0], p.ready[0]) {
+			h = &p.slots[slot]
+		}
+	}
+	if len(*h) <= 0 {
+		return nil
+	}
+	job := heap.Pop(h).(*poolJob[T, R])
+	if h == &p.ready {
+		p.stat.Pushnum[slot]++
+	}
+	p.dequeue()
+	return job
+}
+
+func (p *Pool[T, R]) dequeue() {
+	p.queued--
+	gMetricQueue.Dec()
+	p.notFull.Broadcast()
+}
+
+// next push the next Ordered job of the key, must be called with the lock
+func (p *Pool[T, R]) next(job *poolJob[T, R]) {
+	if !job.opt.Ordered {
+		return
+	}
+	waiting := p.keys[job.opt.Key]
+	if len(waiting) <= 1 {
+		delete(p.keys, job.opt.Key)
+		return
+	}
+	waiting[0] = nil
+	waiting = waiting[1:]
+	p.keys[job.opt.Key] = waiting
+	p.pushReady(waiting[0])
+}
+
+// discardLowest drop a ready job lower than the new one, must be called with the lock
+func (p *Pool[T, R]) discardLowest(job *poolJob[T, R]) bool {
+	var lowh *jobHeap[T, R]
+	lowi := -1
+	find := func(h *jobHeap[T, R]) {
+		for i, j := range *h {
+			if lowi < 0 || j.opt.Priority < (*lowh)[lowi].opt.Priority ||
+				(j.opt.Priority == (*lowh)[lowi].opt.Priority && j.seq < (*lowh)[lowi].seq) {
+				lowh = h
+				lowi = i
+			}
+		}
+	}
+	find(&p.ready)
+	for i := range p.slots {
+		find(&p.slots[i])
+	}
+	if lowi < 0 || (*lowh)[lowi].opt.Priority > job.opt.Priority {
+		return false
+	}
+
+	low := heap.Remove(lowh, lowi).(*poolJob[T, R])
+	p.dequeue()
+	p.next(low)
+	p.stat.Rejectnum++
+	p.fail(low, ErrDiscarded)
+	return true
+}
+
+func (p *Pool[T, R]) fail(job *poolJob[T, R], err error) {
+	var r R
+	job.future.set(r, err)
+}
+
+func (p *Pool[T, R]) run(w *poolWorker) {
+	defer common.CrashLog()
+	defer p.wg.Done()
+
+	p.lock.Lock()
+	for {
+		if w.quit {
+			break
+		}
+		job := p.pop(w.slot)
+		if job == nil {
+			if p.stop {
+				break
+			}
+			p.cond.Wait()
+			continue
+		}
+
+		p.stat.Runningnum++
+		p.lock.Unlock()
+
+		p.process(job, w.slot)
+
+		p.lock.Lock()
+		p.stat.Runningnum--
+		p.next(job)
+	}
+	p.lock.Unlock()
+}
+
+func (p *Pool[T, R]) process(job *poolJob[T, R], slot int) {
+	var r R
+	var err error
+	if !job.deadline.IsZero() && !time.Now().Before(job.deadline) {
+		err = ErrTimeout
+	} else {
+		ctx := context.Background()
+		if !job.deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, job.deadline)
+			defer cancel()
+		}
+		r, err = p.exef(ctx, job.v)
+		if ctx.Err() != nil {
+			var zero R
+			r, err = zero, ErrTimeout
+		}
+	}
+
+	p.latency.add(time.Since(job.pushTime))
+	gMetricProcess.Inc()
+
+	p.lock.Lock()
+	if slot >= 0 && slot < len(p.stat.Processnum) {
+		p.stat.Processnum[slot]++
+	}
+	if err == ErrTimeout {
+		p.stat.Timeoutnum++
+	} else if err != nil {
+		p.stat.Errornum++
+	}
+	p.lock.Unlock()
+
+	job.future.set(r, err)
+}
+
+// Stop fail the jobs waiting with ErrStopped, and wait for the running ones
+func (p *Pool[T, R]) Stop() {
+	p.lock.Lock()
+	if p.stop {
+		p.lock.Unlock()
+		p.wg.Wait()
+		return
+	}
+	p.stop = true
+
+	var jobs []*poolJob[T, R]
+	jobs = append(jobs, p.ready...)
+	p.ready = nil
+	for i := range p.slots {
+		jobs = append(jobs, p.slots[i]...)
+		p.slots[i] = nil
+	}
+	for _, waiting := range p.keys {
+		// the first one is ready or running
+		jobs = append(jobs, waiting[1:]...)
+	}
+	p.keys = make(map[int][]*poolJob[T, R])
+	gMetricQueue.Add(-float64(p.queued))
+	p.queued = 0
+
+	p.cond.Broadcast()
+	p.notFull.Broadcast()
+	p.lock.Unlock()
+
+	for _, job := range jobs {
+		p.fail(job, ErrStopped)
+	}
+	p.wg.Wait()
+}
+
+func (p *Pool[T, R]) GetStat() ThreadPoolStat {
+	p.lock.Lock()
+	stat := p.stat
+	stat.Datalen = make([]int, len(p.slots))
+	stat.Pushnum = append([]int(nil), p.stat.Pushnum...)
+	stat.Processnum = append([]int(nil), p.stat.Processnum...)
+	for i := range p.slots {
+		stat.Datalen[i] = len(p.slots[i])
+	}
+	stat.Queuelen = p.queued
+	p.lock.Unlock()
+
+	p.latency.fill(&stat)
+	return stat
+}
+
+func (p *Pool[T, R]) ResetStat() {
+	p.lock.Lock()
+	for i := range p.stat.Pushnum {
+		p.stat.Pushnum[i] = 0
+		p.stat.Processnum[i] = 0
+	}
+	p.stat.Rejectnum = 0
+	p.stat.Timeoutnum = 0
+	p.stat.Errornum = 0
+	p.lock.Unlock()
+	p.latency.reset()
+}
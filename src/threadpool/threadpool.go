@@ -2,30 +2,50 @@ package threadpool
 
 import (
 	"github.com/esrrhs/go-engine/src/common"
+	"github.com/esrrhs/go-engine/src/metrics"
 	"sync"
 	"time"
 )
 
+var gMetricPush = metrics.Default.Counter("threadpool_push_total", "jobs pushed to all thread pools")
+var gMetricProcess = metrics.Default.Counter("threadpool_process_total", "jobs processed by all thread pools")
+var gMetricQueue = metrics.Default.Gauge("threadpool_queue_length", "jobs waiting in all thread pools")
+
 type ThreadPool struct {
 	workResultLock sync.WaitGroup
 	max            int
 	exef           func(interface{})
-	ca             []chan interface{}
+	ca             []chan threadPoolJob
 	control        chan int
 	stat           ThreadPoolStat
+	latency        *latencyStat
+}
+
+type threadPoolJob struct {
+	v        interface{}
+	pushTime time.Time
 }
 
 type ThreadPoolStat struct {
 	Datalen    []int
 	Pushnum    []int
 	Processnum []int
+	Queuelen   int // all the jobs waiting
+	Runningnum int
+	Rejectnum  int
+	Timeoutnum int
+	Errornum   int
+	LatencyP50 time.Duration // from pushed to processed, of the recent LATENCY_SAMPLE_NUM jobs
+	LatencyP90 time.Duration
+	LatencyP99 time.Duration
+	LatencyMax time.Duration
 }
 
 func NewThreadPool(max int, buffer int, exef func(interface{})) *ThreadPool {
-	ca := make([]chan interface{}, max)
+	ca := make([]chan threadPoolJob, max)
 	control := make(chan int, max)
 	for index, _ := range ca {
-		ca[index] = make(chan interface{}, buffer)
+		ca[index] = make(chan threadPoolJob, buffer)
 	}
 
 	stat := ThreadPoolStat{}
@@ -33,7 +53,7 @@ func NewThreadPool(max int, buffer int, exef func(interface{})) *ThreadPool {
 	stat.Pushnum = make([]int, max)
 	stat.Processnum = make([]int, max)
 
-	tp := &ThreadPool{max: max, exef: exef, ca: ca, control: control, stat: stat}
+	tp := &ThreadPool{max: max, exef: exef, ca: ca, control: control, stat: stat, latency: newLatencyStat()}
 
 	for index, _ := range ca {
 		go tp.run(index)
@@ -43,14 +63,18 @@ func NewThreadPool(max int, buffer int, exef func(interface{})) *ThreadPool {
 }
 
 func (tp *ThreadPool) AddJob(hash int, v interface{}) {
-	tp.ca[common.AbsInt(hash)%len(tp.ca)] <- v
+	tp.ca[common.AbsInt(hash)%len(tp.ca)] <- threadPoolJob{v, time.Now()}
 	tp.stat.Pushnum[common.AbsInt(hash)%len(tp.ca)]++
+	gMetricPush.Inc()
+	gMetricQueue.Inc()
 }
 
 func (tp *ThreadPool) AddJobTimeout(hash int, v interface{}, timeoutms int) bool {
 	select {
-	case tp.ca[common.AbsInt(hash)%len(tp.ca)] <- v:
+	case tp.ca[common.AbsInt(hash)%len(tp.ca)] <- threadPoolJob{v, time.Now()}:
 		tp.stat.Pushnum[common.AbsInt(hash)%len(tp.ca)]++
+		gMetricPush.Inc()
+		gMetricQueue.Inc()
 		return true
 	case <-time.After(time.Duration(timeoutms) * time.Millisecond):
 		return false
@@ -62,6 +86,10 @@ func (tp *ThreadPool) Stop() {
 		tp.control <- i
 	}
 	tp.workResultLock.Wait()
+	// the jobs left are never processed
+	for index, _ := range tp.ca {
+		gMetricQueue.Add(-float64(len(tp.ca[index])))
+	}
 }
 
 func (tp *ThreadPool) run(index int) {
@@ -74,17 +102,23 @@ func (tp *ThreadPool) run(index int) {
 		select {
 		case <-tp.control:
 			return
-		case v := <-tp.ca[index]:
-			tp.exef(v)
+		case job := <-tp.ca[index]:
+			gMetricQueue.Dec()
+			tp.exef(job.v)
+			tp.latency.add(time.Since(job.pushTime))
+			gMetricProcess.Inc()
 			tp.stat.Processnum[index]++
 		}
 	}
 }
 
 func (tp *ThreadPool) GetStat() ThreadPoolStat {
+	tp.stat.Queuelen = 0
 	for index, _ := range tp.ca {
 		tp.stat.Datalen[index] = len(tp.ca[index])
+		tp.stat.Queuelen += tp.stat.Datalen[index]
 	}
+	tp.latency.fill(&tp.stat)
 	return tp.stat
 }
 
@@ -93,4 +127,5 @@ func (tp *ThreadPool) ResetStat() {
 		tp.stat.Pushnum[index] = 0
 		tp.stat.Processnum[index] = 0
 	}
+	tp.latency.reset()
 }
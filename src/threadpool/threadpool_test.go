@@ -1,7 +1,12 @@
 package threadpool
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -62,3 +67,255 @@ func Test3(t *testing.T) {
 	tp.Stop()
 	fmt.Println("Stop")
 }
+
+func Test4Pool(t *testing.T) {
+	p := NewPool(DefaultPoolConfig(), func(ctx context.Context, v int) (string, error) {
+		if v < 0 {
+			return "", errors.New("negative")
+		}
+		return strconv.Itoa(v * 2), nil
+	})
+	defer p.Stop()
+
+	var fs []*Future[string]
+	for i := 0; i < 100; i++ {
+		fs = append(fs, p.Submit(i))
+	}
+	for i, f := range fs {
+		r, err := f.Get()
+		if err != nil || r != strconv.Itoa(i*2) {
+			t.Error("result diff", i, r, err)
+		}
+	}
+	_, err := p.Submit(-1).Get()
+	if err == nil || err.Error() != "negative" {
+		t.Error("error diff", err)
+	}
+
+	stat := p.GetStat()
+	fmt.Println(stat)
+	if stat.Errornum != 1 || stat.LatencyMax <= 0 || stat.LatencyP50 > stat.LatencyP99 {
+		t.Error("stat diff", stat)
+	}
+}
+
+func testBlockPool(config PoolConfig, f func(v int)) (*Pool[int, int], chan int) {
+	block := make(chan int)
+	p := NewPool(config, func(ctx context.Context, v int) (int, error) {
+		if v == 0 {
+			<-block
+		}
+		f(v)
+		return v, nil
+	})
+	return p, block
+}
+
+func Test5Priority(t *testing.T) {
+	var lock sync.Mutex
+	var order []int
+	p, block := testBlockPool(PoolConfig{Size: 1}, func(v int) {
+		lock.Lock()
+		order = append(order, v)
+		lock.Unlock()
+	})
+	defer p.Stop()
+
+	p.Submit(0)
+	time.Sleep(time.Millisecond * 50)
+	p.SubmitWith(1, JobOption{Priority: 1})
+	p.SubmitWith(3, JobOption{Priority: 3})
+	f := p.SubmitWith(2, JobOption{Priority: 2})
+	p.SubmitWith(4, JobOption{Priority: 3})
+	close(block)
+	f.Get()
+	time.Sleep(time.Millisecond * 50)
+
+	lock.Lock()
+	defer lock.Unlock()
+	fmt.Println(order)
+	if fmt.Sprint(order) != "[0 3 4 2 1]" {
+		t.Error("priority order diff", order)
+	}
+}
+
+func Test6Ordered(t *testing.T) {
+	for _, affinity := range []bool{false, true} {
+		var lock sync.Mutex
+		last := make(map[int]int)
+		running := make(map[int]bool)
+		p := NewPool(PoolConfig{Size: 8, Affinity: affinity}, func(ctx context.Context, v int) (int, error) {
+			key := v % 3
+			lock.Lock()
+			if running[key] || last[key] > v {
+				t.Error("key order diff", affinity, key, v)
+			}
+			running[key] = true
+			last[key] = v
+			lock.Unlock()
+
+			time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+
+			lock.Lock()
+			running[key] = false
+			lock.Unlock()
+			return v, nil
+		})
+
+		var fs []*Future[int]
+		for i := 0; i < 300; i++ {
+			fs = append(fs, p.SubmitWith(i, JobOption{Ordered: true, Key: i % 3, Priority: rand.Intn(3)}))
+		}
+		for _, f := range fs {
+			f.Get()
+		}
+		stat := p.GetStat()
+		fmt.Println(affinity, stat.Pushnum)
+		if affinity && (stat.Pushnum[0] != 100 || stat.Pushnum[3] != 0) {
+			t.Error("affinity diff", stat.Pushnum)
+		}
+		p.Stop()
+	}
+}
+
+func Test7Reject(t *testing.T) {
+	p, block := testBlockPool(PoolConfig{Size: 1, MaxQueue: 1, Reject: REJECT_ABORT}, func(v int) {})
+	p.Submit(0)
+	time.Sleep(time.Millisecond * 50)
+	p.Submit(1)
+	_, err := p.Submit(2).Get()
+	if err != ErrRejected {
+		t.Error("abort diff", err)
+	}
+	close(block)
+	p.Stop()
+
+	p, block = testBlockPool(PoolConfig{Size: 1, MaxQueue: 1, Reject: REJECT_DISCARD_LOWEST}, func(v int) {})
+	p.Submit(0)
+	time.Sleep(time.Millisecond * 50)
+	low := p.SubmitWith(1, JobOption{Priority: 1})
+	high := p.SubmitWith(2, JobOption{Priority: 2})
+	_, err = p.SubmitWith(3, JobOption{Priority: 1}).Get()
+	if err != ErrRejected {
+		t.Error("lower not rejected", err)
+	}
+	_, err = low.Get()
+	if err != ErrDiscarded {
+		t.Error("discard diff", err)
+	}
+	close(block)
+	r, err := high.Get()
+	if r != 2 || err != nil {
+		t.Error("high diff", r, err)
+	}
+	if p.GetStat().Rejectnum != 2 {
+		t.Error("reject num diff", p.GetStat().Rejectnum)
+	}
+	p.Stop()
+
+	p, block = testBlockPool(PoolConfig{Size: 1, MaxQueue: 1, Reject: REJECT_CALLER_RUNS}, func(v int) {})
+	p.Submit(0)
+	time.Sleep(time.Millisecond * 50)
+	p.Submit(1)
+	f := p.Submit(2)
+	select {
+	case <-f.Done():
+	default:
+		t.Error("not run by caller")
+	}
+	close(block)
+	p.Stop()
+}
+
+func Test8TimeoutResize(t *testing.T) {
+	var lock sync.Mutex
+	cur := 0
+	max := 0
+	p := NewPool(PoolConfig{Size: 1, Timeout: time.Millisecond * 200}, func(ctx context.Context, v int) (int, error) {
+		lock.Lock()
+		cur++
+		if cur > max {
+			max = cur
+		}
+		lock.Unlock()
+		defer func() {
+			lock.Lock()
+			cur--
+			lock.Unlock()
+		}()
+
+		if v == 0 {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		time.Sleep(time.Millisecond * 20)
+		return v, nil
+	})
+
+	f0 := p.Submit(0)
+	f1 := p.SubmitWith(1, JobOption{Timeout: time.Millisecond * 50})
+	_, err := f0.Get()
+	if err != ErrTimeout {
+		t.Error("run timeout diff", err)
+	}
+	_, err = f1.Get()
+	if err != ErrTimeout {
+		t.Error("queue timeout diff", err)
+	}
+	if p.GetStat().Timeoutnum != 2 {
+		t.Error("timeout num diff", p.GetStat())
+	}
+
+	p.Resize(4)
+	var fs []*Future[int]
+	for i := 1; i <= 40; i++ {
+		fs = append(fs, p.Submit(i))
+	}
+	for _, f := range fs {
+		f.Get()
+	}
+	fmt.Println("max", max)
+	if max != 4 || p.Size() != 4 {
+		t.Error("grow diff", max)
+	}
+
+	p.Resize(1)
+	time.Sleep(time.Millisecond * 50)
+	max = 0
+	fs = nil
+	for i := 1; i <= 10; i++ {
+		fs = append(fs, p.Submit(i))
+	}
+	for _, f := range fs {
+		f.Get()
+	}
+	if max != 1 || len(p.GetStat().Processnum) != 1 {
+		t.Error("shrink diff", max)
+	}
+
+	block := make(chan int)
+	p = NewPool(PoolConfig{Size: 1}, func(ctx context.Context, v int) (int, error) {
+		<-block
+		return v, nil
+	})
+	running := p.Submit(1)
+	time.Sleep(time.Millisecond * 50)
+	waiting := p.Submit(2)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		close(block)
+	}()
+	p.Stop()
+	_, err = waiting.Get()
+	if err != ErrStopped {
+		t.Error("stop diff", err)
+	}
+	r, err := running.Get()
+	if r != 1 || err != nil {
+		t.Error("running job diff", r, err)
+	}
+	_, err = p.Submit(3).Get()
+	if err != ErrStopped {
+		t.Error("submit after stop diff", err)
+	}
+}